@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+// BlockRequest is the body of POST /api/block: programs loaded into the
+// shared instruction memory of one PIO block, and the state machines
+// that run them in lockstep.
+type BlockRequest struct {
+	Source   string         `json:"source"`
+	Target   string         `json:"target,omitempty"`
+	Programs []BlockProgram `json:"programs,omitempty"` // defaults to every program in the source
+	SMs      []BlockSM      `json:"state_machines"`
+	Pins     uint32         `json:"pins,omitempty"`   // external input levels
+	Cycles   int            `json:"cycles,omitempty"` // defaults to 100, at most 10000
+}
+
+// BlockProgram loads a program of the source at an offset. Without an
+// offset it goes at its .origin, or at the highest free address.
+type BlockProgram struct {
+	Name   string `json:"name"`
+	Offset *int   `json:"offset,omitempty"`
+}
+
+// BlockSM configures one state machine. Config fields that are left out
+// keep the defaults derived from its program.
+type BlockSM struct {
+	SM      int             `json:"sm"`
+	Program string          `json:"program,omitempty"` // may be left out when one program is loaded
+	Config  json.RawMessage `json:"config,omitempty"`
+	TX      []uint32        `json:"tx,omitempty"`       // words fed to the TX FIFO as it has room
+	DrainRX bool            `json:"drain_rx,omitempty"` // read the RX FIFO every cycle
+}
+
+// LoadedProgram is where a program was placed in instruction memory.
+type LoadedProgram struct {
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// BlockResult holds the state of the block after every cycle.
+type BlockResult struct {
+	Success  bool               `json:"success"`
+	Programs []LoadedProgram    `json:"programs,omitempty"`
+	Configs  map[int]sim.Config `json:"configs,omitempty"` // by state machine number
+	Cycles   []sim.BlockState   `json:"cycles,omitempty"`
+	RX       map[int][]uint32   `json:"rx,omitempty"` // words read from each RX FIFO with drain_rx
+	Errors   []string           `json:"errors,omitempty"`
+}
+
+func handleBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := simulateBlock(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// simulateBlock loads the requested programs into a PIO block, starts
+// its state machines and runs them together, feeding TX FIFOs and
+// draining RX FIFOs between cycles.
+func simulateBlock(req BlockRequest) BlockResult {
+	fail := func(err error) BlockResult {
+		return BlockResult{Success: false, Errors: errorStrings(err)}
+	}
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		return fail(err)
+	}
+	file, err := asm.Parse(req.Source)
+	if err != nil {
+		return fail(err)
+	}
+	progs, err := asm.AssembleAll(file, target)
+	if err != nil {
+		return fail(err)
+	}
+	if len(req.SMs) == 0 {
+		return fail(fmt.Errorf("no state machines configured"))
+	}
+	cycles := req.Cycles
+	if cycles <= 0 {
+		cycles = defaultSimCycles
+	}
+	if cycles > maxSimCycles {
+		return fail(fmt.Errorf("cycles %d exceeds the limit of %d", cycles, maxSimCycles))
+	}
+
+	byName := map[string]*asm.Program{}
+	var names []string
+	for _, prog := range progs {
+		byName[prog.Name] = prog
+		names = append(names, prog.Name)
+	}
+	loads := req.Programs
+	if loads == nil {
+		for _, prog := range progs {
+			loads = append(loads, BlockProgram{Name: prog.Name})
+		}
+	}
+	block := sim.NewBlock()
+	result := BlockResult{Success: true, Configs: map[int]sim.Config{}, RX: map[int][]uint32{}}
+	loaded := map[string]LoadedProgram{}
+	for _, l := range loads {
+		prog, ok := byName[l.Name]
+		if !ok {
+			return fail(fmt.Errorf("no program %q in source (have %s)", l.Name, strings.Join(names, ", ")))
+		}
+		if _, dup := loaded[l.Name]; dup {
+			return fail(fmt.Errorf("program %s is loaded twice", l.Name))
+		}
+		offset := -1
+		if l.Offset != nil {
+			offset = *l.Offset
+		}
+		if offset, err = block.AddProgram(prog, offset); err != nil {
+			return fail(err)
+		}
+		lp := LoadedProgram{Name: prog.Name, Offset: offset, Length: len(prog.Instructions)}
+		loaded[l.Name] = lp
+		result.Programs = append(result.Programs, lp)
+	}
+
+	for _, s := range req.SMs {
+		if s.SM < 0 || s.SM >= sim.NumStateMachines {
+			return fail(fmt.Errorf("state machine %d out of range 0-%d", s.SM, sim.NumStateMachines-1))
+		}
+		if _, dup := result.Configs[s.SM]; dup {
+			return fail(fmt.Errorf("state machine %d is configured twice", s.SM))
+		}
+		name := s.Program
+		if name == "" && len(loaded) == 1 {
+			name = result.Programs[0].Name
+		}
+		lp, ok := loaded[name]
+		if !ok {
+			if name == "" {
+				return fail(fmt.Errorf("state machine %d: %d programs are loaded; choose one", s.SM, len(loaded)))
+			}
+			return fail(fmt.Errorf("state machine %d: program %q is not loaded", s.SM, name))
+		}
+		cfg := sim.DefaultConfig(byName[name])
+		if len(s.Config) > 0 {
+			if err := json.Unmarshal(s.Config, &cfg); err != nil {
+				return fail(fmt.Errorf("state machine %d: invalid config: %v", s.SM, err))
+			}
+		}
+		if err := block.Start(s.SM, lp.Offset, cfg); err != nil {
+			return fail(err)
+		}
+		result.Configs[s.SM] = cfg
+	}
+
+	block.GPIO().Input = req.Pins
+	tx := make([][]uint32, len(req.SMs))
+	for i, s := range req.SMs {
+		tx[i] = s.TX
+	}
+	for range cycles {
+		for i, s := range req.SMs {
+			for len(tx[i]) > 0 && block.SM(s.SM).Put(tx[i][0]) {
+				tx[i] = tx[i][1:]
+			}
+		}
+		result.Cycles = append(result.Cycles, block.Step())
+		for _, s := range req.SMs {
+			for s.DrainRX {
+				w, ok := block.SM(s.SM).Get()
+				if !ok {
+					break
+				}
+				result.RX[s.SM] = append(result.RX[s.SM], w)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/disasm"
+	"github.com/joeblew999/plat-tinypio/internal/format"
+)
+
+// circuitPythonProgram renders assembled programs as CircuitPython. Each
+// program is a string literal assembled by adafruit_pioasm.assemble and a
+// dict of the rp2pio.StateMachine keyword arguments its directives set.
+// adafruit_pioasm takes neither expressions nor .define, so the literal
+// holds the resolved program: the assembled instructions decoded with
+// their labels. The warnings name settings rp2pio cannot express.
+func circuitPythonProgram(progs []*asm.Program) (string, []string) {
+	var b strings.Builder
+	var warnings []string
+	b.WriteString("# Code generated by tinypio; DO NOT EDIT.\n\n")
+	b.WriteString("import adafruit_pioasm\n")
+	for _, prog := range progs {
+		warnings = append(warnings, writeCircuitPythonProgram(&b, prog)...)
+	}
+	return b.String(), warnings
+}
+
+// writeCircuitPythonProgram writes the assembled program and keyword
+// arguments of a single program.
+func writeCircuitPythonProgram(b *strings.Builder, prog *asm.Program) []string {
+	name := prog.Name
+	if name == "" {
+		name = "program"
+	}
+	banner := strings.Repeat("-", len(name))
+	fmt.Fprintf(b, "\n# %s #\n# %s #\n# %s #\n\n", banner, name, banner)
+	fmt.Fprintf(b, "%s = adafruit_pioasm.assemble(\"\"\"\n%s\"\"\")\n\n",
+		name, strings.NewReplacer(`\`, `\\`, `"""`, `\"\"\"`).Replace(circuitPythonSource(prog, name)))
+
+	var kwargs [][2]string
+	kwarg := func(key string, value any) {
+		text := fmt.Sprint(value)
+		switch v := value.(type) {
+		case bool:
+			text = "False"
+			if v {
+				text = "True"
+			}
+		case string:
+			text = strconv.Quote(v)
+		}
+		kwargs = append(kwargs, [2]string{key, text})
+	}
+	if ss := prog.SideSet; ss.Bits > 0 {
+		kwarg("sideset_pin_count", ss.Bits)
+		if ss.Opt {
+			kwarg("sideset_enable", true)
+		}
+		if ss.PinDirs {
+			kwarg("sideset_pindirs", true)
+		}
+	}
+	if prog.WrapTarget != 0 || prog.Wrap != len(prog.Instructions)-1 {
+		kwarg("wrap_target", prog.WrapTarget)
+		kwarg("wrap", prog.Wrap)
+	}
+	if prog.Origin >= 0 {
+		kwarg("offset", prog.Origin)
+	}
+	if in := prog.In; in != nil {
+		if in.Count > 0 {
+			kwarg("in_pin_count", in.Count)
+		}
+		kwarg("in_shift_right", in.Right)
+		kwarg("auto_push", in.Auto)
+		kwarg("push_threshold", in.Threshold)
+	}
+	if out := prog.Out; out != nil {
+		if out.Count > 0 {
+			kwarg("out_pin_count", out.Count)
+		}
+		kwarg("out_shift_right", out.Right)
+		kwarg("auto_pull", out.Auto)
+		kwarg("pull_threshold", out.Threshold)
+	}
+	if prog.SetCount > 0 {
+		kwarg("set_pin_count", prog.SetCount)
+	}
+	if prog.Fifo != "" {
+		kwarg("fifo_type", prog.Fifo)
+	}
+	if f := strings.Fields(prog.MovStatus); len(f) > 0 {
+		n, _ := strconv.Atoi(f[len(f)-1])
+		switch {
+		case f[0] != "irq":
+		case f[1] == "prev":
+			n |= 0x08
+		case f[1] == "next":
+			n |= 0x10
+		}
+		kwarg("mov_status_type", f[0])
+		kwarg("mov_status_n", n)
+	}
+	var warnings []string
+	if prog.ClockDiv != 0 {
+		warnings = append(warnings, fmt.Sprintf("%s: rp2pio.StateMachine takes a frequency, so .clock_div %g is not applied", name, prog.ClockDiv))
+	}
+
+	fmt.Fprintf(b, "# %s_kwargs are the rp2pio.StateMachine arguments set by the directives.\n", name)
+	if len(kwargs) == 0 {
+		fmt.Fprintf(b, "%s_kwargs = {}\n", name)
+		return warnings
+	}
+	fmt.Fprintf(b, "%s_kwargs = {\n", name)
+	for _, kv := range kwargs {
+		fmt.Fprintf(b, "    %q: %s,\n", kv[0], kv[1])
+	}
+	b.WriteString("}\n")
+	return warnings
+}
+
+// circuitPythonSource returns a program as adafruit_pioasm source: the
+// .program, .pio_version and .side_set directives, then the decoded
+// instructions with their labels and wrap. Jump targets without a label
+// get one.
+func circuitPythonSource(prog *asm.Program, name string) string {
+	labels := make(map[int][]string)
+	target := make(map[int]string)
+	for _, l := range prog.Labels {
+		labels[l.Address] = append(labels[l.Address], l.Name)
+		if _, ok := target[l.Address]; !ok {
+			target[l.Address] = l.Name
+		}
+	}
+	for _, w := range prog.Instructions {
+		if addr := int(w & 0x1f); w>>13 == 0 && target[addr] == "" {
+			target[addr] = fmt.Sprintf("label_%d", addr)
+			labels[addr] = append(labels[addr], target[addr])
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, ".program %s\n", name)
+	if prog.PIOVersion > 0 {
+		fmt.Fprintf(&b, ".pio_version %d\n", prog.PIOVersion)
+	}
+	if prog.SideSet.Bits > 0 {
+		fmt.Fprintf(&b, "%s\n", prog.SideSet)
+	}
+	wrap := prog.WrapTarget != 0 || prog.Wrap != len(prog.Instructions)-1
+	for i, w := range prog.Instructions {
+		if wrap && i == prog.WrapTarget {
+			b.WriteString(".wrap_target\n")
+		}
+		for _, l := range labels[i] {
+			fmt.Fprintf(&b, "%s:\n", l)
+		}
+		text, note := disasm.DecodeLabels(w, prog.SideSet, target)
+		switch {
+		case note != "":
+			text = fmt.Sprintf(".word 0x%04x ; %s", w, note)
+		case w>>13 == 0:
+			// adafruit_pioasm reads the condition and target as separate
+			// words.
+			text = strings.Replace(text, ", ", " ", 1)
+		}
+		fmt.Fprintf(&b, "    %s\n", text)
+		if wrap && i == prog.Wrap {
+			b.WriteString(".wrap\n")
+		}
+	}
+	for _, l := range labels[len(prog.Instructions)] {
+		fmt.Fprintf(&b, "%s:\n", l)
+	}
+	if out, err := format.Source(b.String()); err == nil {
+		return out
+	}
+	return b.String()
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/decode"
+	"github.com/joeblew999/plat-tinypio/internal/disasm"
+)
+
+// commands are the tinypio subcommands. Running tinypio without one
+// starts the web server.
+var commands = map[string]func(args []string) error{
+	"serve":    cmdServe,
+	"validate": cmdValidate,
+	"compile":  cmdCompile,
+	"sim":      cmdSim,
+	"waveform": cmdWaveform,
+	"decode":   cmdDecode,
+	"verify":   cmdVerify,
+	"fmt":      cmdFmt,
+	"scaffold": cmdScaffold,
+	"import":   cmdImport,
+}
+
+// runCommand runs the subcommand named by args[0] and returns the exit
+// status.
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tinypio: unknown command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "usage: tinypio [serve|validate|compile|sim|waveform|decode|verify|fmt|scaffold|import] [flags]")
+		return 2
+	}
+	if err := cmd(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "tinypio %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func cmdServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	port := fs.String("port", defaultPort(), "port to listen on (default from TINYPIO_PORT)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return serve(*port)
+}
+
+func cmdValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio validate [flags] file.pio ...")
+		fmt.Fprintln(fs.Output(), "Files may be glob patterns; - reads standard input.")
+		fs.PrintDefaults()
+	}
+	target := fs.String("target", "", "rp2040 or rp2350")
+	asJSON := fs.Bool("json", false, "write the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	t, err := asm.ParseTarget(*target)
+	if err != nil {
+		return err
+	}
+	paths, err := expandArgs(fs)
+	if err != nil {
+		return err
+	}
+
+	type fileResult struct {
+		File string `json:"file"`
+		ValidateResult
+	}
+	var results []fileResult
+	invalid := 0
+	for _, path := range paths {
+		source, err := readSource(path)
+		if err != nil {
+			return err
+		}
+		result := validatePIO(source, t)
+		if !result.Valid {
+			invalid++
+		}
+		results = append(results, fileResult{File: path, ValidateResult: result})
+		if *asJSON {
+			continue
+		}
+		for _, w := range result.Warnings {
+			fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, w)
+		}
+		if result.Valid {
+			fmt.Printf("%s: ok (%s, %d instructions)\n", path, plural(len(result.Programs), "program"), len(result.Instructions))
+			continue
+		}
+		if len(result.Diagnostics) == 0 {
+			for _, e := range result.Errors {
+				fmt.Printf("%s: %s\n", path, e)
+			}
+		}
+		for _, d := range result.Diagnostics {
+			fmt.Printf("%s:%d:%d: %s\n", path, d.Line, d.Col, d.Msg)
+		}
+	}
+	if *asJSON {
+		if err := writeIndented(os.Stdout, results); err != nil {
+			return err
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d files are invalid", invalid, len(paths))
+	}
+	return nil
+}
+
+// outputExtensions are the file extensions of the compile formats.
+var outputExtensions = map[string]string{"hex": ".hex", "go": ".go", "c": ".pio.h", "python": ".py", "rust": ".rs", "circuitpython": ".py"}
+
+func cmdCompile(args []string) error {
+	fs := flag.NewFlagSet("compile", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio compile [flags] file.pio ...")
+		fmt.Fprintln(fs.Output(), "Files may be glob patterns; - reads standard input.")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "hex", "output format: hex, go, c, python, rust or circuitpython")
+	target := fs.String("target", "", "rp2040 or rp2350")
+	output := fs.String("o", "", "output file, or directory when compiling several files (default stdout)")
+	asJSON := fs.Bool("json", false, "write the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ext, ok := outputExtensions[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q (want hex, go, c, python, rust, circuitpython)", *format)
+	}
+	t, err := asm.ParseTarget(*target)
+	if err != nil {
+		return err
+	}
+	paths, err := expandArgs(fs)
+	if err != nil {
+		return err
+	}
+	toDir := *output != "" && len(paths) > 1
+	if toDir {
+		if info, err := os.Stat(*output); err != nil || !info.IsDir() {
+			return fmt.Errorf("-o %s must be a directory when compiling %d files", *output, len(paths))
+		}
+	}
+
+	type fileResult struct {
+		File string `json:"file"`
+		CompileResult
+	}
+	var results []fileResult
+	failed := 0
+	for _, path := range paths {
+		source, err := readSource(path)
+		if err != nil {
+			return err
+		}
+		result := compilePIO(source, *format, t)
+		results = append(results, fileResult{File: path, CompileResult: result})
+		for _, w := range result.Warnings {
+			fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, w)
+		}
+		if !result.Success {
+			failed++
+			for _, e := range result.Errors {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, e)
+			}
+			continue
+		}
+		if *asJSON {
+			continue
+		}
+		text := map[string]string{"hex": result.Hex, "go": result.Go, "c": result.C, "python": result.Python, "rust": result.Rust, "circuitpython": result.CircuitPython}[*format]
+		switch {
+		case toDir:
+			name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ext
+			err = os.WriteFile(filepath.Join(*output, name), []byte(text), 0o644)
+		case *output != "":
+			err = os.WriteFile(*output, []byte(text), 0o644)
+		case len(paths) > 1 && (*format == "python" || *format == "circuitpython"):
+			fmt.Printf("# %s\n%s", path, text)
+		case len(paths) > 1:
+			fmt.Printf("// %s\n%s", path, text)
+		default:
+			fmt.Print(text)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if *asJSON {
+		if err := writeIndented(os.Stdout, results); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to compile", failed, len(paths))
+	}
+	return nil
+}
+
+func cmdSim(args []string) error {
+	fs := flag.NewFlagSet("sim", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio sim [flags] file.pio")
+		fmt.Fprintln(fs.Output(), "Runs a program and prints the state after every cycle.")
+		fs.PrintDefaults()
+	}
+	var req SimulateRequest
+	sf := addSimFlags(fs, &req, defaultSimCycles)
+	fs.BoolVar(&req.DrainRX, "drain-rx", false, "read the RX FIFO every cycle")
+	asJSON := fs.Bool("json", false, "write the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one source file")
+	}
+	if err := sf.apply(fs, &req, fs.Arg(0)); err != nil {
+		return err
+	}
+
+	result := simulatePIO(req)
+	if *asJSON {
+		if err := writeIndented(os.Stdout, result); err != nil {
+			return err
+		}
+	}
+	if !result.Success {
+		return errors.New(strings.Join(result.Errors, "\n"))
+	}
+	if *asJSON {
+		return nil
+	}
+	ss := result.Config.SideSet
+	fmt.Println(" cycle  pc  instruction               x         y         isr       osr       pins")
+	for _, st := range result.Cycles {
+		var flags string
+		switch {
+		case st.Stalled:
+			flags = "  stall"
+		case st.Delay:
+			flags = "  delay"
+		}
+		fmt.Printf("%6d  %2d  %-24s  %08x  %08x  %08x  %08x  %08x%s\n", st.Cycle, st.PC,
+			disasm.Decode(st.Instruction, ss), st.X, st.Y, st.ISR, st.OSR, st.Pins, flags)
+	}
+	if len(result.RX) > 0 {
+		words := make([]string, len(result.RX))
+		for i, w := range result.RX {
+			words[i] = fmt.Sprintf("0x%08x", w)
+		}
+		fmt.Printf("rx: %s\n", strings.Join(words, ", "))
+	}
+	return nil
+}
+
+// expandArgs returns the files named by the arguments, expanding glob
+// patterns for shells that do not. A pattern matching nothing is an
+// error, as is an empty argument list.
+func expandArgs(fs *flag.FlagSet) ([]string, error) {
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, errors.New("expected source files")
+	}
+	var paths []string
+	for _, arg := range fs.Args() {
+		if !strings.ContainsAny(arg, "*?[") {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", arg)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// writeIndented writes v as indented JSON.
+func writeIndented(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// plural formats a count with its noun, adding an s when needed.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// configFlags maps simulator config fields to command line flags.
+var configFlags = []struct {
+	name, field, usage string
+}{
+	{"in-base", "in_base", "first input pin"},
+	{"out-base", "out_base", "first out pin"},
+	{"out-count", "out_count", "number of out pins"},
+	{"set-base", "set_base", "first set pin"},
+	{"set-count", "set_count", "number of set pins"},
+	{"sideset-base", "sideset_base", "first side-set pin"},
+	{"jmp-pin", "jmp_pin", "pin tested by jmp pin"},
+}
+
+// simFlags are the flags of the commands that run a program on the
+// simulator.
+type simFlags struct {
+	tx, pins, config *string
+	clkdiv           *float64
+	mapping          map[string]*int
+}
+
+// addSimFlags defines the program, input and pin mapping flags of req.
+func addSimFlags(fs *flag.FlagSet, req *SimulateRequest, cycles int) *simFlags {
+	fs.StringVar(&req.Program, "program", "", "program to run when the file holds several")
+	fs.StringVar(&req.Target, "target", "", "rp2040 or rp2350")
+	fs.IntVar(&req.Cycles, "cycles", cycles, "state machine cycles to run")
+	f := &simFlags{
+		clkdiv:  fs.Float64("clkdiv", 0, "clock divider (default from .clock_div or 1)"),
+		tx:      fs.String("tx", "", "comma-separated words fed to the TX FIFO"),
+		pins:    fs.String("pins", "0", "input pin levels as a bit mask"),
+		config:  fs.String("config", "", "simulator config as JSON"),
+		mapping: map[string]*int{},
+	}
+	for _, cf := range configFlags {
+		f.mapping[cf.name] = fs.Int(cf.name, 0, cf.usage)
+	}
+	return f
+}
+
+// apply reads the source file and the parsed flags into req.
+func (f *simFlags) apply(fs *flag.FlagSet, req *SimulateRequest, path string) error {
+	source, err := readSource(path)
+	if err != nil {
+		return err
+	}
+	req.Source = source
+	if req.TX, err = parseWords(*f.tx); err != nil {
+		return fmt.Errorf("-tx: %v", err)
+	}
+	in, err := strconv.ParseUint(*f.pins, 0, 32)
+	if err != nil {
+		return fmt.Errorf("-pins: %v", err)
+	}
+	req.Pins = uint32(in)
+
+	// Only flags given on the command line override the program's
+	// default config.
+	fields := map[string]any{}
+	if *f.config != "" {
+		if err := json.Unmarshal([]byte(*f.config), &fields); err != nil {
+			return fmt.Errorf("-config: %v", err)
+		}
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == "clkdiv" {
+			fields["clock_div"] = *f.clkdiv
+		}
+		for _, cf := range configFlags {
+			if cf.name == fl.Name {
+				fields[cf.field] = *f.mapping[fl.Name]
+			}
+		}
+	})
+	req.Config, err = json.Marshal(fields)
+	return err
+}
+
+func cmdWaveform(args []string) error {
+	fs := flag.NewFlagSet("waveform", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio waveform [flags] file.pio")
+		fs.PrintDefaults()
+	}
+	var req WaveformRequest
+	sf := addSimFlags(fs, &req.SimulateRequest, 1000)
+	fs.Float64Var(&req.SysClock, "sysclk", 0, "system clock in Hz (default 125 MHz, 150 MHz for RP2350)")
+	format := fs.String("format", "vcd", "output format: vcd or json")
+	output := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one source file")
+	}
+	if *format != "vcd" && *format != "json" {
+		return fmt.Errorf("unknown format %q (want vcd, json)", *format)
+	}
+	if err := sf.apply(fs, &req.SimulateRequest, fs.Arg(0)); err != nil {
+		return err
+	}
+
+	result := waveformPIO(req)
+	if !result.Success {
+		return errors.New(strings.Join(result.Errors, "\n"))
+	}
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if *format == "json" {
+		return writeIndented(out, result.Waveform)
+	}
+	_, err := io.WriteString(out, result.VCD)
+	return err
+}
+
+func cmdDecode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio decode -protocol name -ch role=signal [flags] trace.vcd|trace.csv")
+		fs.PrintDefaults()
+	}
+	var req DecodeRequest
+	req.Channels = map[string]string{}
+	fs.StringVar(&req.Protocol, "protocol", "", "protocol: "+strings.Join(decode.Protocols(), ", "))
+	fs.StringVar(&req.Format, "format", "", "trace format: vcd or csv (default from the file extension)")
+	fs.Float64Var(&req.SampleRate, "samplerate", 0, "CSV sample rate in Hz")
+	fs.Func("ch", "map a decoder channel to a trace signal, as role=signal (repeatable)", func(s string) error {
+		role, name, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("want role=signal, got %q", s)
+		}
+		req.Channels[role] = name
+		return nil
+	})
+	fs.Float64Var(&req.Baud, "baud", 0, "uart baud rate")
+	fs.IntVar(&req.DataBits, "databits", 0, "uart data bits (default 8)")
+	fs.StringVar(&req.Parity, "parity", "", "uart parity: none, even or odd")
+	fs.IntVar(&req.CPOL, "cpol", 0, "spi clock polarity")
+	fs.IntVar(&req.CPHA, "cpha", 0, "spi clock phase")
+	fs.IntVar(&req.Bits, "bits", 0, "spi word size or i2s sample size")
+	fs.BoolVar(&req.LSBFirst, "lsb-first", false, "spi shifts the least significant bit first")
+	fs.Float64Var(&req.Tolerance, "tolerance", 0, "ws2812 timing tolerance in ns (default 150)")
+	asJSON := fs.Bool("json", false, "write frames as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one trace file")
+	}
+	if req.Format == "" && strings.HasSuffix(strings.ToLower(fs.Arg(0)), ".csv") {
+		req.Format = "csv"
+	}
+	trace, err := readSource(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	req.Trace = trace
+
+	result := decodeTrace(req)
+	if !result.Success {
+		return errors.New(strings.Join(result.Errors, "\n"))
+	}
+	if *asJSON {
+		return writeIndented(os.Stdout, result.Frames)
+	}
+	for _, f := range result.Frames {
+		line := fmt.Sprintf("%12.3f us  %-14s 0x%02x", float64(f.Start)/1e6, f.Type, f.Value)
+		if f.Channel != "" {
+			line += "  " + f.Channel
+		}
+		if f.Error != "" {
+			line += "  ! " + f.Error
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// readSource reads a source file, or standard input for "-".
+func readSource(path string) (string, error) {
+	if path == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		return string(b), err
+	}
+	b, err := os.ReadFile(path)
+	return string(b), err
+}
+
+// parseWords parses a comma-separated list of 32-bit words in any Go
+// integer syntax.
+func parseWords(s string) ([]uint32, error) {
+	var words []uint32
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		w, err := strconv.ParseUint(f, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, uint32(w))
+	}
+	return words, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/debug"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+const (
+	debugSessionTTL    = 15 * time.Minute // idle time before a session expires
+	maxDebugSessions   = 64
+	defaultDebugLimit  = maxSimCycles // cycles continue runs before giving up
+	defaultDebugCycles = 1
+)
+
+// DebugRequest is the body of POST /api/debug. Action create starts a
+// session from source and config and returns its ID; the other actions
+// name the session:
+//
+//	state     return the current state
+//	step      run cycles cycles (default 1)
+//	continue  run until a breakpoint, at most cycles cycles (default 10000)
+//	reset     restart the state machine, keeping breakpoints and pins
+//	set       change registers, FIFOs, input pins and breakpoints
+//	close     end the session
+//
+// Breakpoints, registers and pins may also be given with create, step
+// and continue, and are applied before running.
+type DebugRequest struct {
+	Action      string             `json:"action"`
+	Session     string             `json:"session,omitempty"`
+	Source      string             `json:"source,omitempty"`
+	Target      string             `json:"target,omitempty"`
+	Program     string             `json:"program,omitempty"`
+	Config      json.RawMessage    `json:"config,omitempty"`
+	Cycles      int                `json:"cycles,omitempty"`
+	Breakpoints []debug.Breakpoint `json:"breakpoints,omitempty"` // replaces the breakpoints; [] clears them
+	Registers   *sim.Registers     `json:"registers,omitempty"`
+	Pins        *uint32            `json:"pins,omitempty"` // external input levels
+}
+
+// DebugResult is the state of a debug session after an action.
+type DebugResult struct {
+	Success     bool               `json:"success"`
+	Session     string             `json:"session,omitempty"`
+	Program     string             `json:"program,omitempty"`
+	Offset      int                `json:"offset"`          // load address of the program
+	Lines       []int              `json:"lines,omitempty"` // source line of each instruction
+	State       *sim.State         `json:"state,omitempty"`
+	NextPC      int                `json:"next_pc"` // -1 during a delay, stall or exec
+	Pins        uint32             `json:"pins"`    // external input levels
+	Breakpoints []debug.Breakpoint `json:"breakpoints,omitempty"`
+	Cycles      []sim.State        `json:"cycles,omitempty"` // every state of a step
+	Stop        *debug.Stop        `json:"stop,omitempty"`   // why continue returned
+	Errors      []string           `json:"errors,omitempty"`
+}
+
+// debugSessions holds the server's debug sessions. Sessions idle for
+// longer than the TTL are dropped whenever the store is used. The store
+// lock only guards the map and the used times; each session has its own
+// lock, so a long continue on one session does not hold up the others.
+type debugSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*debugEntry
+	now      func() time.Time
+}
+
+type debugEntry struct {
+	mu      sync.Mutex // serialises actions on the session
+	session *debug.Session
+	used    time.Time
+}
+
+var sessions = &debugSessions{sessions: map[string]*debugEntry{}, now: time.Now}
+
+// add stores a session and returns its new ID.
+func (d *debugSessions) add(s *debug.Session) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+	if len(d.sessions) >= maxDebugSessions {
+		return "", fmt.Errorf("too many debug sessions (limit %d); close one or wait for it to expire", maxDebugSessions)
+	}
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+	d.sessions[id] = &debugEntry{session: s, used: d.now()}
+	return id, nil
+}
+
+// with runs fn on the session called id while holding that session's
+// lock, and marks it used.
+func (d *debugSessions) with(id string, fn func(*debug.Session) error) error {
+	d.mu.Lock()
+	d.expire()
+	e, ok := d.sessions[id]
+	if ok {
+		e.used = d.now()
+	}
+	d.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no debug session %q; it may have expired", id)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return fn(e.session)
+}
+
+func (d *debugSessions) remove(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+	_, ok := d.sessions[id]
+	delete(d.sessions, id)
+	return ok
+}
+
+func (d *debugSessions) expire() {
+	now := d.now()
+	for id, e := range d.sessions {
+		if now.Sub(e.used) > debugSessionTTL {
+			delete(d.sessions, id)
+		}
+	}
+}
+
+func handleDebug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DebugRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := debugPIO(sessions, req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// debugPIO runs one debugger action.
+func debugPIO(store *debugSessions, req DebugRequest) DebugResult {
+	fail := func(err error) DebugResult {
+		return DebugResult{Success: false, Session: req.Session, Errors: errorStrings(err)}
+	}
+	switch req.Action {
+	case "create":
+		s, err := newDebugSession(req)
+		if err != nil {
+			return fail(err)
+		}
+		id, err := store.add(s)
+		if err != nil {
+			return fail(err)
+		}
+		result := debugState(s)
+		result.Session = id
+		for _, stmt := range s.Program().Source {
+			result.Lines = append(result.Lines, stmt.Span.Start.Line)
+		}
+		return result
+	case "close":
+		if !store.remove(req.Session) {
+			return fail(fmt.Errorf("no debug session %q; it may have expired", req.Session))
+		}
+		return DebugResult{Success: true, Session: req.Session}
+	case "state", "step", "continue", "reset", "set":
+	default:
+		return fail(fmt.Errorf("unknown action %q (want create, state, step, continue, reset, set, close)", req.Action))
+	}
+
+	var result DebugResult
+	err := store.with(req.Session, func(s *debug.Session) error {
+		if err := applyDebugChanges(s, req); err != nil {
+			return err
+		}
+		var cycles []sim.State
+		var stop *debug.Stop
+		switch req.Action {
+		case "step":
+			n := req.Cycles
+			if n <= 0 {
+				n = defaultDebugCycles
+			}
+			if n > maxSimCycles {
+				return fmt.Errorf("cycles %d exceeds the limit of %d", n, maxSimCycles)
+			}
+			cycles = s.Step(n)
+		case "continue":
+			limit := req.Cycles
+			if limit <= 0 || limit > defaultDebugLimit {
+				limit = defaultDebugLimit
+			}
+			_, st := s.Continue(limit)
+			stop = &st
+		case "reset":
+			if err := s.Reset(); err != nil {
+				return err
+			}
+		}
+		result = debugState(s)
+		result.Cycles, result.Stop = cycles, stop
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+	result.Session = req.Session
+	return result
+}
+
+// newDebugSession assembles the program of a create request and starts
+// a session on it.
+func newDebugSession(req DebugRequest) (*debug.Session, error) {
+	if req.Source == "" {
+		return nil, errors.New("create needs source")
+	}
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := selectProgram(req.Source, req.Program, target)
+	if err != nil {
+		return nil, err
+	}
+	cfg := sim.DefaultConfig(prog)
+	if len(req.Config) > 0 {
+		if err := json.Unmarshal(req.Config, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+	}
+	s, err := debug.New(prog, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyDebugChanges(s, req); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// applyDebugChanges sets the breakpoints, registers and pins given in a
+// request.
+func applyDebugChanges(s *debug.Session, req DebugRequest) error {
+	if req.Breakpoints != nil {
+		if err := s.SetBreakpoints(req.Breakpoints); err != nil {
+			return err
+		}
+	}
+	if req.Registers != nil {
+		if err := s.SetRegisters(*req.Registers); err != nil {
+			return err
+		}
+	}
+	if req.Pins != nil {
+		s.SetPins(*req.Pins)
+	}
+	return nil
+}
+
+func debugState(s *debug.Session) DebugResult {
+	st := s.State()
+	return DebugResult{
+		Success:     true,
+		Program:     s.Program().Name,
+		Offset:      s.Offset(),
+		State:       &st,
+		NextPC:      s.NextPC(),
+		Pins:        s.Pins(),
+		Breakpoints: s.Breakpoints(),
+	}
+}
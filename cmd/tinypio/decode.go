@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/decode"
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// DecodeRequest is the body of POST /api/decode: a pin trace, its format
+// and the protocol to decode. Decoder options such as channels and baud
+// sit alongside.
+type DecodeRequest struct {
+	Protocol   string  `json:"protocol"`
+	Format     string  `json:"format,omitempty"` // vcd (default) or csv
+	Trace      string  `json:"trace"`
+	SampleRate float64 `json:"sample_rate_hz,omitempty"` // csv without a time column or Samplerate comment
+	decode.Options
+}
+
+// DecodeResult holds the frames decoded from a trace.
+type DecodeResult struct {
+	Success  bool           `json:"success"`
+	Protocol string         `json:"protocol,omitempty"`
+	Signals  []string       `json:"signals,omitempty"` // signal names found in the trace
+	Frames   []decode.Frame `json:"frames,omitempty"`
+	Errors   []string       `json:"errors,omitempty"`
+}
+
+func handleDecode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DecodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := decodeTrace(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// decodeTrace reads the trace of a decode request and runs the protocol
+// decoder over it.
+func decodeTrace(req DecodeRequest) DecodeResult {
+	wf, err := readTrace(req.Trace, req.Format, req.SampleRate)
+	if err != nil {
+		return DecodeResult{Success: false, Errors: errorStrings(err)}
+	}
+	var names []string
+	for _, s := range wf.Signals {
+		names = append(names, s.Name)
+	}
+	frames, err := decode.Decode(wf, req.Protocol, req.Options)
+	if err != nil {
+		return DecodeResult{Success: false, Signals: names, Errors: errorStrings(err)}
+	}
+	return DecodeResult{Success: true, Protocol: strings.ToLower(req.Protocol), Signals: names, Frames: frames}
+}
+
+// readTrace parses a VCD or sigrok CSV trace.
+func readTrace(trace, format string, sampleRate float64) (*wave.Waveform, error) {
+	switch strings.ToLower(format) {
+	case "", "vcd":
+		return wave.ReadVCD(strings.NewReader(trace))
+	case "csv":
+		return wave.ReadCSV(strings.NewReader(trace), sampleRate)
+	}
+	return nil, fmt.Errorf("unknown trace format %q (want vcd, csv)", format)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/joeblew999/plat-tinypio/internal/disasm"
+	"github.com/joeblew999/plat-tinypio/internal/extract"
+)
+
+// DisassembleRequest is the body of POST /api/disassemble: machine code
+// as hex text or words, with the side-set and wrap configuration it was
+// assembled with.
+type DisassembleRequest struct {
+	Hex   string   `json:"hex,omitempty"` // pioasm hex output, a C array or whitespace separated words
+	Words []uint16 `json:"words,omitempty"`
+	disasm.Options
+}
+
+// DisassembleResult holds the source recovered from machine code.
+type DisassembleResult struct {
+	Success      bool                 `json:"success"`
+	Source       string               `json:"source,omitempty"`
+	Instructions []disasm.Instruction `json:"instructions,omitempty"`
+	PIOVersion   int                  `json:"pio_version"`
+	Errors       []string             `json:"errors,omitempty"`
+}
+
+func handleDisassemble(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DisassembleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := disassemblePIO(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// disassemblePIO reads the words of a disassemble request and decodes
+// them.
+func disassemblePIO(req DisassembleRequest) DisassembleResult {
+	words := req.Words
+	switch {
+	case req.Hex != "" && len(words) > 0:
+		return DisassembleResult{Success: false, Errors: []string{"give either hex or words, not both"}}
+	case req.Hex != "":
+		var err error
+		if words, err = extract.Words(req.Hex); err != nil {
+			return DisassembleResult{Success: false, Errors: errorStrings(err)}
+		}
+	}
+	l, err := disasm.Disassemble(words, req.Options)
+	if err != nil {
+		return DisassembleResult{Success: false, Errors: errorStrings(err)}
+	}
+	return DisassembleResult{Success: true, Source: l.Source, Instructions: l.Instructions, PIOVersion: l.PIOVersion}
+}
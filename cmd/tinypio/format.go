@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/format"
+)
+
+// FormatRequest is the body of POST /api/format.
+type FormatRequest struct {
+	Source string `json:"source"`
+}
+
+// FormatResult holds the canonical layout of a source.
+type FormatResult struct {
+	Success bool     `json:"success"`
+	Source  string   `json:"source,omitempty"`
+	Changed bool     `json:"changed"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func handleFormat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FormatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := formatPIO(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func formatPIO(req FormatRequest) FormatResult {
+	if strings.TrimSpace(req.Source) == "" {
+		return FormatResult{Success: false, Errors: []string{"empty source"}}
+	}
+	out, err := format.Source(req.Source)
+	if err != nil {
+		return FormatResult{Success: false, Errors: errorStrings(err)}
+	}
+	return FormatResult{Success: true, Source: out, Changed: out != req.Source}
+}
+
+func cmdFmt(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio fmt [flags] [file.pio ...]")
+		fmt.Fprintln(fs.Output(), "Formats PIO sources, or standard input without files.")
+		fs.PrintDefaults()
+	}
+	write := fs.Bool("w", false, "write the result to the file instead of standard output")
+	diff := fs.Bool("d", false, "print a diff instead of the formatted source")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		if *write {
+			return errors.New("-w needs file arguments")
+		}
+		return formatFile("-", false, *diff)
+	}
+	failed := 0
+	for _, path := range fs.Args() {
+		if err := formatFile(path, *write, *diff); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be formatted", failed, fs.NArg())
+	}
+	return nil
+}
+
+// formatFile formats one file, or standard input for "-", and prints,
+// diffs or rewrites it.
+func formatFile(path string, write, diff bool) error {
+	src, err := readSource(path)
+	if err != nil {
+		return err
+	}
+	out, err := format.Source(src)
+	if err != nil {
+		return err
+	}
+	if diff {
+		name := path
+		if name == "-" {
+			name = "<stdin>"
+		}
+		fmt.Print(unifiedDiff(name, src, out))
+	}
+	if write && out != src {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(out), info.Mode().Perm())
+	}
+	if !write && !diff {
+		fmt.Print(out)
+	}
+	return nil
+}
+
+// unifiedDiff returns a unified diff from a to b with three lines of
+// context, or "" when they are equal.
+func unifiedDiff(name, a, b string) string {
+	if a == b {
+		return ""
+	}
+	x, y := splitLines(a), splitLines(b)
+	ops := diffLines(x, y)
+
+	const context = 3
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s.orig\n+++ %s\n", name, name)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk runs from context lines before the first change to
+		// context lines after the last change closer than 2*context.
+		start := max(0, i-context)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		end = min(len(ops), end+context)
+		ax, ay := ops[start].x, ops[start].y
+		var nx, ny int
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			body.WriteString(string(op.kind) + op.text + "\n")
+			if op.kind != '+' {
+				nx++
+			}
+			if op.kind != '-' {
+				ny++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n%s", hunkRange(ax, nx), hunkRange(ay, ny), body.String())
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the start and length of a hunk side.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+func splitLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOp is one line of a diff: ' ' kept, '-' removed or '+' added, at
+// line x of the old text and line y of the new.
+type diffOp struct {
+	kind rune
+	text string
+	x, y int
+}
+
+// diffLines returns the edit script from x to y by longest common
+// subsequence, which is quick enough for source files.
+func diffLines(x, y []string) []diffOp {
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, diffOp{' ', x[i], i, j})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', x[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', y[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/disasm"
+)
+
+// pioImport is the import of the tinygo-org/pio package that generated
+// Go source builds on.
+const pioImport = `pio "github.com/tinygo-org/pio/rp2-pio"`
+
+// Names of the tinygo-org/pio constants, indexed by their encoding. An
+// empty name has no constant and is written as a literal word.
+var (
+	goJmpConds    = [8]string{"JmpAlways", "JmpXZero", "JmpXNZeroDec", "JmpYZero", "JmpYNZeroDec", "JmpXNotEqualY", "JmpPinInput", "JmpOSRNotEmpty"}
+	goInSrcs      = [8]string{"InSrcPins", "InSrcX", "InSrcY", "InSrcNull", "", "", "InSrcISR", "InSrcOSR"}
+	goOutDests    = [8]string{"OutDestPins", "OutDestX", "OutDestY", "OutDestNull", "OutDestPindirs", "OutDestPC", "OutDestISR", "OutDestExec"}
+	goMovDests    = [8]string{"MovDestPins", "MovDestX", "MovDestY", "", "MovDestExec", "MovDestPC", "MovDestISR", "MovDestOSR"}
+	goMovSrcs     = [8]string{"MovSrcPins", "MovSrcX", "MovSrcY", "MovSrcNull", "", "MovSrcStatus", "MovSrcISR", "MovSrcOSR"}
+	goMovOps      = [4]string{"Mov", "MovInvert", "MovReverse", ""}
+	goSetDests    = [8]string{"SetDestPins", "SetDestX", "SetDestY", "", "SetDestPindirs", "", "", ""}
+	goWaitSources = [2]string{"WaitGPIO", "WaitPin"}
+)
+
+// goProgram renders assembled programs as a Go file for TinyGo. Each
+// program gets exported wrap, origin and public define and label
+// constants, an instruction slice built with pio.AssemblerV0 calls and a
+// default config function, followed by its % go code blocks. The package
+// clause and imports of the code blocks are merged into the file's.
+func goProgram(file *asm.File, progs []*asm.Program) string {
+	pkg, imports := "main", []string{pioImport}
+	var body strings.Builder
+	units, _ := asm.Split(file)
+	for i, prog := range progs {
+		writeGoProgram(&body, prog)
+		if i >= len(units) {
+			continue
+		}
+		for _, stmt := range units[i].Statements {
+			cb, ok := stmt.(*asm.CodeBlockStmt)
+			if !ok || cb.Lang != "go" {
+				continue
+			}
+			blockPkg, blockImports, rest := splitGoBlock(cb.Body)
+			if blockPkg != "" {
+				pkg = blockPkg
+			}
+			for _, imp := range blockImports {
+				if !slices.Contains(imports, imp) {
+					imports = append(imports, imp)
+				}
+			}
+			if rest = strings.TrimSpace(rest); rest != "" {
+				body.WriteString("\n" + rest + "\n")
+			}
+		}
+	}
+
+	build := "rp2040 || rp2350"
+	for _, prog := range progs {
+		if prog.PIOVersion > 0 {
+			build = "rp2350"
+		}
+	}
+	var b strings.Builder
+	b.WriteString("// Code generated by tinypio; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "//go:build %s\n\n", build)
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString("import (\n")
+	for _, imp := range imports {
+		b.WriteString("\t" + imp + "\n")
+	}
+	b.WriteString(")\n")
+	b.WriteString(body.String())
+
+	// Code blocks are copied as written, so only a well-formed file is
+	// tidied by gofmt.
+	if out, err := format.Source([]byte(b.String())); err == nil {
+		return string(out)
+	}
+	return b.String()
+}
+
+// writeGoProgram writes the declarations for a single program.
+func writeGoProgram(b *strings.Builder, prog *asm.Program) {
+	name := prog.Name
+	if name == "" {
+		name = "program"
+	}
+	id := goName(name)
+	fmt.Fprintf(b, "\n// %s\n\n", name)
+	b.WriteString("const (\n")
+	fmt.Fprintf(b, "\t%sWrapTarget = %d\n", id, prog.WrapTarget)
+	fmt.Fprintf(b, "\t%sWrap = %d\n", id, prog.Wrap)
+	fmt.Fprintf(b, "\t%sOrigin = %d\n", id, prog.Origin)
+	for _, l := range prog.Labels {
+		if l.Public {
+			fmt.Fprintf(b, "\t%sOffset%s = %d\n", id, goName(l.Name), l.Address)
+		}
+	}
+	for _, d := range prog.Defines {
+		if d.Public {
+			fmt.Fprintf(b, "\t%s%s = %d\n", id, goName(d.Name), d.Value)
+		}
+	}
+	b.WriteString(")\n\n")
+
+	assembler := strings.ToLower(id[:1]) + id[1:] + "Assembler"
+	var elems strings.Builder
+	calls := false
+	for i, w := range prog.Instructions {
+		if i == prog.WrapTarget {
+			elems.WriteString("\t// .wrap_target\n")
+		}
+		elem := fmt.Sprintf("0x%04x", w)
+		if call := goInstruction(w, prog.SideSet); call != "" {
+			elem, calls = assembler+"."+call, true
+		}
+		fmt.Fprintf(&elems, "\t%s, // %2d: %s\n", elem, i, disasm.Decode(w, prog.SideSet))
+		if i == prog.Wrap {
+			elems.WriteString("\t// .wrap\n")
+		}
+	}
+	if calls {
+		if bits := prog.SideSet.TotalBits(); bits > 0 {
+			fmt.Fprintf(b, "var %s = pio.AssemblerV0{SidesetBits: %d}\n\n", assembler, bits)
+		} else {
+			fmt.Fprintf(b, "var %s = pio.AssemblerV0{}\n\n", assembler)
+		}
+	}
+	fmt.Fprintf(b, "var %sInstructions = []uint16{\n%s}\n\n", id, elems.String())
+
+	fmt.Fprintf(b, "// %sProgramDefaultConfig returns the config for %s at offset.\n", id, name)
+	fmt.Fprintf(b, "func %sProgramDefaultConfig(offset uint8) pio.StateMachineConfig {\n", id)
+	b.WriteString("\tcfg := pio.DefaultStateMachineConfig()\n")
+	fmt.Fprintf(b, "\tcfg.SetWrap(offset+%sWrapTarget, offset+%sWrap)\n", id, id)
+	if ss := prog.SideSet; ss.Bits > 0 {
+		fmt.Fprintf(b, "\tcfg.SetSidesetParams(%d, %t, %t)\n", ss.TotalBits(), ss.Opt, ss.PinDirs)
+	}
+	b.WriteString("\treturn cfg\n}\n")
+}
+
+// goInstruction returns the pio.AssemblerV0 method chain that encodes w,
+// for an assembler whose SidesetBits is the total side-set width, or ""
+// when the assembler cannot express the word: PIO version 1
+// instructions, irq wait and reserved encodings.
+func goInstruction(w uint16, ss asm.SideSet) string {
+	arg1, arg2 := w>>5&7, w&0x1f
+	var call string
+	switch w >> 13 {
+	case 0: // jmp
+		call = fmt.Sprintf("Jmp(pio.%s, %d)", goJmpConds[arg1], arg2)
+	case 1: // wait
+		pol := arg1&4 != 0
+		switch src := arg1 & 3; {
+		case src < 2:
+			call = fmt.Sprintf("%s(%t, %d)", goWaitSources[src], pol, arg2)
+		case src == 2 && arg2&0x08 == 0:
+			call = fmt.Sprintf("WaitIRQ(%t, %t, %d)", pol, arg2&0x10 != 0, arg2&7)
+		}
+	case 2: // in
+		if src := goInSrcs[arg1]; src != "" {
+			call = fmt.Sprintf("In(pio.%s, %d)", src, goBitCount(arg2))
+		}
+	case 3: // out
+		call = fmt.Sprintf("Out(pio.%s, %d)", goOutDests[arg1], goBitCount(arg2))
+	case 4: // push, pull
+		if arg2 != 0 {
+			break // mov rxfifo or reserved bits
+		}
+		op := "Push"
+		if arg1&4 != 0 {
+			op = "Pull"
+		}
+		call = fmt.Sprintf("%s(%t, %t)", op, arg1&2 != 0, arg1&1 != 0)
+	case 5: // mov
+		dst, op, src := goMovDests[arg1], goMovOps[arg2>>3&3], goMovSrcs[arg2&7]
+		switch {
+		case w&0xff == 0x42:
+			call = "Nop()"
+		case dst != "" && op != "" && src != "":
+			call = fmt.Sprintf("%s(pio.%s, pio.%s)", op, dst, src)
+		}
+	case 6: // irq
+		if w&0x80 != 0 || arg2&0x08 != 0 {
+			break // reserved, or irq prev and next
+		}
+		switch arg1 {
+		case 0:
+			call = "IRQSet"
+		case 2:
+			call = "IRQClear"
+		default:
+			return "" // irq wait
+		}
+		call += fmt.Sprintf("(%t, %d)", arg2&0x10 != 0, arg2&7)
+	case 7: // set
+		if dst := goSetDests[arg1]; dst != "" {
+			call = fmt.Sprintf("Set(pio.%s, %d)", dst, arg2)
+		}
+	}
+	if call == "" {
+		return ""
+	}
+
+	field := int(w >> 8 & 0x1f)
+	if ss.Bits > 0 {
+		value := field >> ss.DelayBits() & (1<<ss.Bits - 1)
+		switch {
+		case !ss.Opt:
+			call += fmt.Sprintf(".Side(%d)", value)
+		case field&0x10 != 0:
+			// The top side-set bit enables an optional side-set.
+			call += fmt.Sprintf(".Side(0b1_%0*b)", ss.Bits, value)
+		}
+	}
+	if delay := field & ss.MaxDelay(); delay > 0 {
+		call += fmt.Sprintf(".Delay(%d)", delay)
+	}
+	return call + ".Encode()"
+}
+
+// goBitCount returns the in and out bit count of an encoded count field,
+// where 0 means 32.
+func goBitCount(n uint16) uint16 {
+	if n == 0 {
+		return 32
+	}
+	return n
+}
+
+// goName turns a PIO identifier into an exported Go name, so uart_tx
+// becomes UartTx.
+func goName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if r == '_' || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 || !unicode.IsLetter([]rune(b.String())[0]) {
+		return "P" + b.String()
+	}
+	return b.String()
+}
+
+// splitGoBlock separates the package name and import specs of a % go
+// code block, as pioasm's go output expects blocks to carry them, from
+// the rest of its code. A block that does not parse is returned whole.
+func splitGoBlock(body string) (pkg string, imports []string, rest string) {
+	src, offset := body, 0
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		// Without a package clause the block is only code and imports.
+		const clause = "package p;"
+		src, offset = clause+body, len(clause)
+		if f, err = parser.ParseFile(fset, "", src, parser.ImportsOnly); err != nil {
+			return "", nil, body
+		}
+	}
+	if offset == 0 {
+		pkg = f.Name.Name
+	}
+	end := fset.Position(f.Name.End()).Offset
+	for _, imp := range f.Imports {
+		text := imp.Path.Value
+		if imp.Name != nil {
+			text = imp.Name.Name + " " + text
+		}
+		imports = append(imports, text)
+	}
+	if len(f.Decls) > 0 {
+		end = fset.Position(f.Decls[len(f.Decls)-1].End()).Offset
+	}
+	return pkg, imports, src[max(end, offset):]
+}
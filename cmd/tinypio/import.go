@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/extract"
+	"github.com/joeblew999/plat-tinypio/internal/micropython"
+)
+
+// ImportRequest is the body of POST /api/import.
+type ImportRequest struct {
+	Source string `json:"source"`           // MicroPython, a C header, Go source or a hex dump
+	Format string `json:"format,omitempty"` // "python", "c", "go" or "hex"; detected when empty
+	Target string `json:"target"`           // "rp2040" or "rp2350"
+}
+
+// ImportResult holds the PIO source of an imported program and its
+// validation.
+type ImportResult struct {
+	Success    bool            `json:"success"`
+	Format     string          `json:"format,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Validation *ValidateResult `json:"validation,omitempty"`
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+var (
+	importPython = regexp.MustCompile(`@(rp2\.)?asm_pio\b`)
+	importC      = regexp.MustCompile(`\buint16_t\s+\w+\s*\[`)
+	importGo     = regexp.MustCompile(`\[[0-9.]*\]uint16\s*\{`)
+)
+
+// importFormat guesses the format of an import source: MicroPython when
+// it has an @rp2.asm_pio decorator, a C header when it declares a
+// uint16_t array, Go when it has a uint16 slice or array literal and
+// otherwise a hex dump.
+func importFormat(source string) string {
+	switch {
+	case importPython.MatchString(source):
+		return "python"
+	case importC.MatchString(source):
+		return "c"
+	case importGo.MatchString(source):
+		return "go"
+	}
+	return "hex"
+}
+
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := importPIO(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// importPIO converts the programs of a MicroPython source, or the machine
+// code of a C header, Go source or hex dump, to PIO source and validates
+// it. Success means the source was converted; whether it assembles is in
+// the validation.
+func importPIO(req ImportRequest) ImportResult {
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		return ImportResult{Success: false, Errors: errorStrings(err)}
+	}
+	format := req.Format
+	if format == "" {
+		format = importFormat(req.Source)
+	}
+	var source string
+	var progs []*extract.Program
+	switch format {
+	case "python":
+		source, err = micropython.Import(req.Source, target)
+	case "c":
+		progs, err = extract.C(req.Source)
+	case "go":
+		progs, err = extract.Go(req.Source)
+	case "hex":
+		progs, err = extract.Hex(req.Source)
+	default:
+		return ImportResult{Success: false, Errors: []string{fmt.Sprintf("unknown format %q (want python, c, go or hex)", format)}}
+	}
+	if err == nil && progs != nil {
+		source, err = extract.Source(progs)
+	}
+	if err != nil {
+		return ImportResult{Success: false, Format: format, Errors: errorStrings(err)}
+	}
+	validation := validatePIO(source, target)
+	return ImportResult{Success: true, Format: format, Source: source, Validation: &validation}
+}
+
+func cmdImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio import [flags] file")
+		fmt.Fprintln(fs.Output(), "Converts MicroPython @rp2.asm_pio functions, or the machine code of a C header, Go []uint16 literal or hex dump, to PIO source and validates it; - reads standard input.")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "", "source format: python, c, go or hex (default from the contents)")
+	target := fs.String("target", "", "rp2040 or rp2350")
+	output := fs.String("o", "", "write the PIO source to this file")
+	asJSON := fs.Bool("json", false, "write the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one source file")
+	}
+	path := fs.Arg(0)
+	source, err := readSource(path)
+	if err != nil {
+		return err
+	}
+
+	result := importPIO(ImportRequest{Source: source, Format: *format, Target: *target})
+	if *asJSON {
+		if err := writeIndented(os.Stdout, result); err != nil {
+			return err
+		}
+	}
+	if !result.Success {
+		if *asJSON {
+			return errors.New("import failed")
+		}
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, e)
+		}
+		return fmt.Errorf("%s could not be imported", path)
+	}
+	if !*asJSON {
+		for _, w := range result.Validation.Warnings {
+			fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, w)
+		}
+		for _, e := range result.Validation.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, e)
+		}
+		if *output == "" {
+			fmt.Print(result.Source)
+		} else if err := os.WriteFile(*output, []byte(result.Source), 0o644); err != nil {
+			return err
+		}
+	}
+	if !result.Validation.Valid {
+		return fmt.Errorf("%s does not assemble", path)
+	}
+	return nil
+}
@@ -4,28 +4,42 @@ import (
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/disasm"
 )
 
 // PIOProgram represents a PIO assembly program.
 type PIOProgram struct {
-	Name        string   `json:"name"`
-	Source      string   `json:"source"`
-	Description string   `json:"description,omitempty"`
+	Name         string   `json:"name"`
+	Source       string   `json:"source"`
+	Description  string   `json:"description,omitempty"`
 	Instructions []string `json:"instructions,omitempty"`
 }
 
-// CompileResult holds the result of compiling a PIO program with pioasm.
+// CompileResult holds the result of compiling a PIO source file. Binary
+// is only set when the source holds a single program; Programs lists
+// every assembled program.
 type CompileResult struct {
-	Success bool     `json:"success"`
-	Binary  []uint16 `json:"binary,omitempty"`
-	Hex     string   `json:"hex,omitempty"`
-	Go      string   `json:"go,omitempty"`
-	Errors  []string `json:"errors,omitempty"`
+	Success       bool           `json:"success"`
+	Binary        []uint16       `json:"binary,omitempty"`
+	Hex           string         `json:"hex,omitempty"`
+	Go            string         `json:"go,omitempty"`
+	C             string         `json:"c,omitempty"`
+	Python        string         `json:"python,omitempty"`
+	Rust          string         `json:"rust,omitempty"`
+	CircuitPython string         `json:"circuitpython,omitempty"`
+	Programs      []*asm.Program `json:"programs,omitempty"`
+	Errors        []string       `json:"errors,omitempty"`
+	Warnings      []string       `json:"warnings,omitempty"`
 }
 
 // Driver represents a ready-to-use PIO driver from tinygo-org/pio.
@@ -39,23 +53,41 @@ type Driver struct {
 // PIOInstruction represents a parsed PIO instruction.
 type PIOInstruction struct {
 	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Program string `json:"program,omitempty"`
+	Address int    `json:"address"`
 	Op      string `json:"op"`
 	Args    string `json:"args,omitempty"`
 	Comment string `json:"comment,omitempty"`
 }
 
-// ValidateResult holds the result of validating a PIO program.
+// ValidateResult holds the result of validating a PIO source file, with
+// one entry in Programs per .program block.
 type ValidateResult struct {
 	Valid        bool             `json:"valid"`
 	Instructions []PIOInstruction `json:"instructions"`
 	Errors       []string         `json:"errors,omitempty"`
+	Warnings     []string         `json:"warnings,omitempty"`
+	Diagnostics  []*asm.Error     `json:"diagnostics,omitempty"`
+	Programs     []ProgramResult  `json:"programs,omitempty"`
 }
 
-// Known PIO opcodes (RP2040 PIO instruction set).
-var validOpcodes = map[string]bool{
-	"jmp": true, "wait": true, "in": true, "out": true,
-	"push": true, "pull": true, "mov": true, "irq": true, "set": true,
-	"nop": true,
+// ProgramResult holds the validation result of a single program.
+type ProgramResult struct {
+	Name         string           `json:"name,omitempty"`
+	Valid        bool             `json:"valid"`
+	Instructions []PIOInstruction `json:"instructions"`
+	Errors       []string         `json:"errors,omitempty"`
+	Warnings     []string         `json:"warnings,omitempty"`
+	Diagnostics  []*asm.Error     `json:"diagnostics,omitempty"`
+	Labels       []*asm.Label     `json:"labels,omitempty"`
+	Defines      []*asm.Define    `json:"defines,omitempty"`
+	SideSet      asm.SideSet      `json:"side_set"`
+	DelayBits    int              `json:"delay_bits"`
+	PIOVersion   int              `json:"pio_version"`
+	Origin       int              `json:"origin"`
+	WrapTarget   int              `json:"wrap_target"`
+	Wrap         int              `json:"wrap"`
 }
 
 // Available drivers from tinygo-org/pio/rp2-pio/piolib.
@@ -108,13 +140,15 @@ again:
 		Name:        "ws2812",
 		Description: "WS2812 (Neopixel) LED driver",
 		Source: `.program ws2812
+; Each bit takes 10 cycles: 3 low, then 7 high for a 1 or 2 high and 5
+; low for a 0. Run the state machine at 8 MHz for 800 kHz.
 .side_set 1
 bitloop:
-    out x, 1       side 0 [2]  ; Shift 1 bit, drive low
-    jmp !x, do_zero side 1 [1] ; Branch on bit value
-    jmp bitloop    side 1 [4]  ; Bit is 1: long pulse
+    out x, 1       side 0 [2]  ; Shift 1 bit, drive low for 3 cycles
+    jmp !x, do_zero side 1 [1] ; Branch on bit value, drive high for 2
+    jmp bitloop    side 1 [4]  ; Bit is 1: stay high for 5 more
 do_zero:
-    nop            side 0 [4]  ; Bit is 0: short pulse`,
+    nop            side 0 [4]  ; Bit is 0: drive low for 5`,
 	},
 	{
 		Name:        "spi_tx",
@@ -128,13 +162,15 @@ do_zero:
 		Name:        "uart_tx",
 		Description: "UART transmit (8N1)",
 		Source: `.program uart_tx
+; Each bit takes 8 cycles, so run the state machine at 8 times the baud
+; rate. The delays pad the start bit and each data bit to 8 cycles.
 .side_set 1 opt
     pull       side 1 [7]  ; Wait for data, line idle high
     set x, 7   side 0 [7]  ; Start bit, init bit counter
 bitloop:
     out pins, 1            ; Shift out data bit
-    jmp x--, bitloop [6]   ; Loop 8 times
-    nop        side 1 [6]  ; Stop bit`,
+    jmp x--, bitloop [6]   ; Loop 8 times, 1 + 7 cycles a bit
+    nop        side 1 [6]  ; Stop bit, 7 cycles plus the 8 of the pull`,
 	},
 	{
 		Name:        "pwm",
@@ -205,26 +241,48 @@ bitloop:
 }
 
 func main() {
-	port := os.Getenv("TINYPIO_PORT")
-	if port == "" {
-		port = "8090"
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	if err := serve(defaultPort()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
+}
+
+// defaultPort returns TINYPIO_PORT, or 8090 when it is not set.
+func defaultPort() string {
+	if port := os.Getenv("TINYPIO_PORT"); port != "" {
+		return port
+	}
+	return "8090"
+}
 
+// serve runs the web interface and API on port.
+func serve(port string) error {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/health", handleHealth)
 	mux.HandleFunc("/api/examples", handleExamples)
 	mux.HandleFunc("/api/validate", handleValidate)
 	mux.HandleFunc("/api/compile", handleCompile)
+	mux.HandleFunc("/api/format", handleFormat)
+	mux.HandleFunc("/api/scaffold", handleScaffold)
+	mux.HandleFunc("/api/import", handleImport)
+	mux.HandleFunc("/api/disassemble", handleDisassemble)
+	mux.HandleFunc("/api/timing", handleTiming)
+	mux.HandleFunc("/api/simulate", handleSimulate)
+	mux.HandleFunc("/api/waveform", handleWaveform)
+	mux.HandleFunc("/api/block", handleBlock)
+	mux.HandleFunc("/api/debug", handleDebug)
+	mux.HandleFunc("/api/stream", handleStream)
+	mux.HandleFunc("/api/decode", handleDecode)
 	mux.HandleFunc("/api/drivers", handleDrivers)
 	mux.HandleFunc("/api/status", handleStatus)
 	mux.HandleFunc("/", handleIndex)
 
 	fmt.Printf("tinypio listening on :%s\n", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	return http.ListenAndServe(":"+port, mux)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -245,13 +303,19 @@ func handleValidate(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Source string `json:"source"`
+		Target string `json:"target"` // "rp2040" or "rp2350"
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	result := validatePIO(req.Source)
+	result := validatePIO(req.Source, target)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
@@ -264,14 +328,20 @@ func handleCompile(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Source string `json:"source"`
-		Format string `json:"format"` // "hex", "go", or "binary" (default)
+		Format string `json:"format"` // "hex" (default), "go" or "c"
+		Target string `json:"target"` // "rp2040" or "rp2350"
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	result := compilePIO(req.Source, req.Format)
+	result := compilePIO(req.Source, req.Format, target)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
@@ -284,12 +354,13 @@ func handleDrivers(w http.ResponseWriter, r *http.Request) {
 func handleStatus(w http.ResponseWriter, r *http.Request) {
 	pioasmPath := findPioasm()
 	status := map[string]interface{}{
-		"validator":   true,
-		"pioasm":      pioasmPath != "",
-		"pioasm_path": pioasmPath,
-		"drivers":       len(drivers),
-		"examples":      len(examples),
-		"upstream":      "github.com/tinygo-org/pio",
+		"validator":        true,
+		"assembler":        "native",
+		"pioasm":           pioasmPath != "",
+		"pioasm_path":      pioasmPath,
+		"drivers":          len(drivers),
+		"examples":         len(examples),
+		"upstream":         "github.com/tinygo-org/pio",
 		"max_instructions": 32,
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -307,169 +378,421 @@ func findPioasm() string {
 	return path
 }
 
-func compilePIO(source, format string) CompileResult {
-	// Check if pioasm is available
-	pioasmPath := findPioasm()
-	if pioasmPath == "" {
-		return CompileResult{
-			Success: false,
-			Errors:  []string{"pioasm not found. Run: xplat task pioasm:build"},
+// compilePIO assembles source with the native assembler. When pioasm is
+// installed its output is used as a cross-check and any mismatch is
+// reported as a warning.
+func compilePIO(source, format string, target asm.Target) CompileResult {
+	switch format {
+	case "":
+		format = "hex"
+	case "go", "hex", "c", "python", "rust", "circuitpython":
+	default:
+		return CompileResult{Success: false, Errors: []string{fmt.Sprintf("unknown format %q (want hex, go, c, python, rust or circuitpython)", format)}}
+	}
+
+	file, err := asm.Parse(source)
+	if err != nil {
+		return CompileResult{Success: false, Errors: errorStrings(err)}
+	}
+	progs, err := asm.AssembleAll(file, target)
+	if err != nil {
+		return CompileResult{Success: false, Errors: errorStrings(err)}
+	}
+
+	result := CompileResult{Success: true, Programs: progs}
+	for _, prog := range progs {
+		for _, w := range prog.Warnings {
+			result.Warnings = append(result.Warnings, w.Error())
+		}
+	}
+	switch format {
+	case "go":
+		result.Go = goProgram(file, progs)
+	case "c":
+		result.C = cProgram(file, progs)
+	case "python":
+		var warnings []string
+		result.Python, warnings = pythonProgram(file, progs)
+		result.Warnings = append(result.Warnings, warnings...)
+	case "rust":
+		var warnings []string
+		result.Rust, warnings = rustProgram(source, progs)
+		result.Warnings = append(result.Warnings, warnings...)
+	case "circuitpython":
+		var warnings []string
+		result.CircuitPython, warnings = circuitPythonProgram(progs)
+		result.Warnings = append(result.Warnings, warnings...)
+	case "hex":
+		result.Hex = hexPrograms(progs)
+		if len(progs) == 1 {
+			result.Binary = progs[0].Instructions
 		}
 	}
 
+	// pioasm's hex output only supports a single program, and it only
+	// assembles PIO version 1 when the source asks for it.
+	if pioasmPath := findPioasm(); pioasmPath != "" && len(progs) == 1 && progs[0].PIOVersion == 0 {
+		if warning := crossCheckPioasm(pioasmPath, source, progs[0].Instructions); warning != "" {
+			result.Warnings = append(result.Warnings, warning)
+		}
+	}
+	return result
+}
+
+// hexPrograms renders programs as pioasm hex output. When there is more
+// than one program each is preceded by a comment naming it.
+func hexPrograms(progs []*asm.Program) string {
+	if len(progs) == 1 {
+		return progs[0].Hex()
+	}
+	var b strings.Builder
+	for _, prog := range progs {
+		fmt.Fprintf(&b, "// %s\n", prog.Name)
+		b.WriteString(prog.Hex())
+	}
+	return b.String()
+}
+
+// errorStrings flattens an assembler error into one message per problem.
+func errorStrings(err error) []string {
+	var list asm.ErrorList
+	if !errors.As(err, &list) {
+		return []string{err.Error()}
+	}
+	msgs := make([]string, len(list))
+	for i, e := range list {
+		msgs[i] = e.Error()
+	}
+	return msgs
+}
+
+// crossCheckPioasm compiles source with pioasm and compares the result
+// against the native assembler output.
+func crossCheckPioasm(pioasmPath, source string, words []uint16) string {
+	output, err := runPioasm(pioasmPath, source, "hex")
+	if err != nil {
+		return "pioasm cross-check failed: " + err.Error()
+	}
+	want, err := parseHexProgram(output)
+	if err != nil {
+		return "pioasm cross-check failed: " + err.Error()
+	}
+	if !slices.Equal(words, want) {
+		return fmt.Sprintf("pioasm cross-check mismatch: native %04x, pioasm %04x", words, want)
+	}
+	return ""
+}
+
+// runPioasm runs pioasm on source and returns the generated output.
+func runPioasm(pioasmPath, source, format string) (string, error) {
 	// Write source to temp file
 	tmpFile, err := os.CreateTemp("", "pio-*.pio")
 	if err != nil {
-		return CompileResult{Success: false, Errors: []string{err.Error()}}
+		return "", err
 	}
 	defer os.Remove(tmpFile.Name())
 
 	if _, err := tmpFile.WriteString(source); err != nil {
-		return CompileResult{Success: false, Errors: []string{err.Error()}}
+		return "", err
 	}
 	tmpFile.Close()
 
-	// Determine output format flag
-	formatFlag := "-o"
-	switch format {
-	case "go":
-		formatFlag = "-o"
-	case "hex":
-		formatFlag = "-o"
-	default:
-		format = "hex"
-		formatFlag = "-o"
-	}
-
-	// Run pioasm
 	outFile, err := os.CreateTemp("", "pio-out-*")
 	if err != nil {
-		return CompileResult{Success: false, Errors: []string{err.Error()}}
+		return "", err
 	}
 	outFile.Close()
 	defer os.Remove(outFile.Name())
 
 	var stderr bytes.Buffer
-	cmd := exec.Command(pioasmPath, formatFlag, format, tmpFile.Name(), outFile.Name())
+	cmd := exec.Command(pioasmPath, "-o", format, tmpFile.Name(), outFile.Name())
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		errMsg := stderr.String()
-		if errMsg == "" {
-			errMsg = err.Error()
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
 		}
-		return CompileResult{Success: false, Errors: []string{errMsg}}
+		return "", err
 	}
 
-	// Read output
 	output, err := os.ReadFile(outFile.Name())
 	if err != nil {
-		return CompileResult{Success: false, Errors: []string{err.Error()}}
+		return "", err
 	}
-
-	result := CompileResult{Success: true}
-	switch format {
-	case "go":
-		result.Go = string(output)
-	case "hex":
-		result.Hex = string(output)
-		// Parse hex to binary
-		result.Binary = parseHexProgram(string(output))
-	}
-
-	return result
+	return string(output), nil
 }
 
-func parseHexProgram(hexOutput string) []uint16 {
-	var binary []uint16
-	lines := strings.Split(hexOutput, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
-			continue
+// cProgram renders assembled programs in the layout of pioasm's c-sdk
+// output: a header with the instructions, a pio_program and a default
+// config per program, followed by the program's % c-sdk code blocks.
+func cProgram(file *asm.File, progs []*asm.Program) string {
+	var b strings.Builder
+	b.WriteString("// Code generated by tinypio; DO NOT EDIT.\n\n")
+	b.WriteString("#pragma once\n\n")
+	b.WriteString("#if !PICO_NO_HARDWARE\n#include \"hardware/pio.h\"\n#endif\n")
+	// Public defines before the first .program are written once,
+	// without a program prefix.
+	first := firstProgramLine(file)
+	global := false
+	if len(progs) > 0 {
+		for _, d := range progs[0].Defines {
+			if d.Public && d.Line < first {
+				if !global {
+					b.WriteString("\n")
+					global = true
+				}
+				fmt.Fprintf(&b, "#define %s %d\n", d.Name, d.Value)
+			}
 		}
-		// Try to parse as hex value (0xNNNN format)
-		line = strings.TrimPrefix(line, "0x")
-		line = strings.TrimSuffix(line, ",")
-		if len(line) >= 4 {
-			b, err := hex.DecodeString(line[:4])
-			if err == nil && len(b) >= 2 {
-				binary = append(binary, uint16(b[0])<<8|uint16(b[1]))
+	}
+	units, _ := asm.Split(file)
+	for i, prog := range progs {
+		var blocks []string
+		if i < len(units) {
+			for _, stmt := range units[i].Statements {
+				if cb, ok := stmt.(*asm.CodeBlockStmt); ok && cb.Lang == "c-sdk" {
+					blocks = append(blocks, cb.Body)
+				}
 			}
 		}
+		writeCProgram(&b, prog, blocks, first)
 	}
-	return binary
+	return b.String()
 }
 
-func validatePIO(source string) ValidateResult {
-	var instructions []PIOInstruction
-	var errors []string
-
-	lines := strings.Split(source, "\n")
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, ".") {
-			continue
+// firstProgramLine returns the line of the first .program directive, or
+// 0 when the file has none.
+func firstProgramLine(file *asm.File) int {
+	for _, stmt := range file.Statements {
+		if d, ok := stmt.(*asm.DirectiveStmt); ok && d.Name == ".program" {
+			return d.Span.Start.Line
 		}
+	}
+	return 0
+}
 
-		// Strip inline comment FIRST (before label check, since comments can contain colons)
-		comment := ""
-		if idx := strings.Index(trimmed, ";"); idx >= 0 {
-			comment = strings.TrimSpace(trimmed[idx+1:])
-			trimmed = strings.TrimSpace(trimmed[:idx])
+// writeCProgram writes the declarations for a single program. Defines
+// before line first are global and not repeated.
+func writeCProgram(b *strings.Builder, prog *asm.Program, blocks []string, first int) {
+	name := prog.Name
+	if name == "" {
+		name = "program"
+	}
+	rule := strings.Repeat("-", len(name))
+	fmt.Fprintf(b, "\n// %s //\n// %s //\n// %s //\n\n", rule, name, rule)
+	fmt.Fprintf(b, "#define %s_wrap_target %d\n", name, prog.WrapTarget)
+	fmt.Fprintf(b, "#define %s_wrap %d\n", name, prog.Wrap)
+	fmt.Fprintf(b, "#define %s_pio_version %d\n\n", name, prog.PIOVersion)
+	public := false
+	for _, l := range prog.Labels {
+		if l.Public {
+			fmt.Fprintf(b, "#define %s_offset_%s %du\n", name, l.Name, l.Address)
+			public = true
 		}
-
-		// Strip label prefix (e.g., "again:")
-		if idx := strings.Index(trimmed, ":"); idx >= 0 {
-			rest := strings.TrimSpace(trimmed[idx+1:])
-			if rest == "" {
-				continue
-			}
-			trimmed = rest
+	}
+	for _, d := range prog.Defines {
+		if d.Public && d.Line >= first {
+			fmt.Fprintf(b, "#define %s_%s %d\n", name, d.Name, d.Value)
+			public = true
+		}
+	}
+	if public {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(b, "static const uint16_t %s_program_instructions[] = {\n", name)
+	for i, w := range prog.Instructions {
+		if i == prog.WrapTarget {
+			b.WriteString("            //     .wrap_target\n")
+		}
+		fmt.Fprintf(b, "    0x%04x, // %2d: %s\n", w, i, disasm.Decode(w, prog.SideSet))
+		if i == prog.Wrap {
+			b.WriteString("            //     .wrap\n")
 		}
+	}
+	b.WriteString("};\n\n")
+	b.WriteString("#if !PICO_NO_HARDWARE\n")
+	fmt.Fprintf(b, "static const struct pio_program %s_program = {\n", name)
+	fmt.Fprintf(b, "    .instructions = %s_program_instructions,\n", name)
+	fmt.Fprintf(b, "    .length = %d,\n", len(prog.Instructions))
+	fmt.Fprintf(b, "    .origin = %d,\n", prog.Origin)
+	fmt.Fprintf(b, "    .pio_version = %s_pio_version,\n", name)
+	b.WriteString("};\n\n")
+	fmt.Fprintf(b, "static inline pio_sm_config %s_program_get_default_config(uint offset) {\n", name)
+	b.WriteString("    pio_sm_config c = pio_get_default_sm_config();\n")
+	fmt.Fprintf(b, "    sm_config_set_wrap(&c, offset + %s_wrap_target, offset + %s_wrap);\n", name, name)
+	if ss := prog.SideSet; ss.Bits > 0 {
+		fmt.Fprintf(b, "    sm_config_set_sideset(&c, %d, %t, %t);\n", ss.TotalBits(), ss.Opt, ss.PinDirs)
+	}
+	writeCConfig(b, prog)
+	b.WriteString("    return c;\n}\n")
+	for _, body := range blocks {
+		b.WriteString("\n" + strings.TrimRight(body, " \t\n") + "\n")
+	}
+	b.WriteString("#endif\n")
+}
 
-		// Strip side_set and delay annotations
-		if idx := strings.Index(trimmed, "side"); idx >= 0 {
-			trimmed = strings.TrimSpace(trimmed[:idx])
+// writeCConfig writes the settings of the PIO version 1 directives to
+// the default config.
+func writeCConfig(b *strings.Builder, prog *asm.Program) {
+	if prog.Fifo != "" && prog.Fifo != "txrx" {
+		fmt.Fprintf(b, "    sm_config_set_fifo_join(&c, PIO_FIFO_JOIN_%s);\n", strings.ToUpper(prog.Fifo))
+	}
+	if f := strings.Fields(prog.MovStatus); len(f) > 0 {
+		n, _ := strconv.Atoi(f[len(f)-1])
+		status := "STATUS_IRQ_SET"
+		switch {
+		case f[0] == "txfifo":
+			status = "STATUS_TX_LESSTHAN"
+		case f[0] == "rxfifo":
+			status = "STATUS_RX_LESSTHAN"
+		case f[1] == "prev":
+			n |= 0x08
+		case f[1] == "next":
+			n |= 0x10
 		}
-		// Strip delay [N]
-		if idx := strings.Index(trimmed, "["); idx >= 0 {
-			trimmed = strings.TrimSpace(trimmed[:idx])
+		fmt.Fprintf(b, "    sm_config_set_mov_status(&c, %s, %d);\n", status, n)
+	}
+	if s := prog.In; s != nil {
+		fmt.Fprintf(b, "    sm_config_set_in_pin_count(&c, %d);\n", s.Count)
+		fmt.Fprintf(b, "    sm_config_set_in_shift(&c, %t, %t, %d);\n", s.Right, s.Auto, s.Threshold)
+	}
+	if s := prog.Out; s != nil {
+		fmt.Fprintf(b, "    sm_config_set_out_pin_count(&c, %d);\n", s.Count)
+		fmt.Fprintf(b, "    sm_config_set_out_shift(&c, %t, %t, %d);\n", s.Right, s.Auto, s.Threshold)
+	}
+	if prog.SetCount > 0 {
+		fmt.Fprintf(b, "    sm_config_set_set_pin_count(&c, %d);\n", prog.SetCount)
+	}
+	if prog.ClockDiv > 0 {
+		div := strconv.FormatFloat(prog.ClockDiv, 'f', -1, 64)
+		if !strings.Contains(div, ".") {
+			div += ".0"
 		}
+		fmt.Fprintf(b, "    sm_config_set_clkdiv(&c, %sf);\n", div)
+	}
+}
 
-		parts := strings.Fields(trimmed)
-		if len(parts) == 0 {
+// parseHexProgram reads pioasm hex output: one four digit word per line,
+// optionally as a 0x literal with a trailing comma. Blank lines and
+// comments are skipped; any other line is an error rather than being
+// dropped, so a change in pioasm's output format is noticed.
+func parseHexProgram(hexOutput string) ([]uint16, error) {
+	var binary []uint16
+	lines := strings.Split(hexOutput, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
 			continue
 		}
-
-		op := strings.ToLower(parts[0])
-		args := ""
-		if len(parts) > 1 {
-			args = strings.Join(parts[1:], " ")
+		word := strings.TrimSuffix(strings.TrimPrefix(line, "0x"), ",")
+		b, err := hex.DecodeString(word)
+		if err != nil || len(b) != 2 {
+			return nil, fmt.Errorf("hex line %d: cannot decode %q as an instruction word", i+1, line)
 		}
+		binary = append(binary, uint16(b[0])<<8|uint16(b[1]))
+	}
+	return binary, nil
+}
 
-		inst := PIOInstruction{
-			Line:    i + 1,
-			Op:      op,
-			Args:    args,
-			Comment: comment,
-		}
-		instructions = append(instructions, inst)
+// validatePIO parses and checks source, reporting every instruction with
+// its program address along with syntax errors, per-operand problems, the
+// resolved labels and the directive settings of each program.
+func validatePIO(source string, target asm.Target) ValidateResult {
+	var result ValidateResult
 
-		if !validOpcodes[op] {
-			errors = append(errors, fmt.Sprintf("line %d: unknown opcode '%s'", i+1, op))
+	file, err := asm.Parse(source)
+	if err != nil {
+		// Without a complete parse, list what was recognised in source
+		// order.
+		for addr, inst := range instructionStmts(file) {
+			result.Instructions = append(result.Instructions, pioInstruction("", addr, inst))
 		}
+		result.addErrors(err)
+		return result
+	}
+
+	units, err := asm.Split(file)
+	result.addErrors(err)
+	for _, unit := range units {
+		pr := validateProgram(unit, target)
+		result.Programs = append(result.Programs, pr)
+		result.Instructions = append(result.Instructions, pr.Instructions...)
+		result.Warnings = append(result.Warnings, pr.Warnings...)
+		result.Errors = append(result.Errors, pr.Errors...)
+		result.Diagnostics = append(result.Diagnostics, pr.Diagnostics...)
+	}
+	result.Valid = len(result.Errors) == 0
+	return result
+}
+
+// validateProgram assembles a single program unit.
+func validateProgram(unit *asm.File, target asm.Target) ProgramResult {
+	prog, err := asm.AssembleFile(unit, target)
+	pr := ProgramResult{
+		Name:       prog.Name,
+		Valid:      err == nil,
+		Labels:     prog.Labels,
+		Defines:    prog.Defines,
+		SideSet:    prog.SideSet,
+		DelayBits:  prog.SideSet.DelayBits(),
+		PIOVersion: prog.PIOVersion,
+		Origin:     prog.Origin,
+		WrapTarget: prog.WrapTarget,
+		Wrap:       prog.Wrap,
+	}
+	for addr, inst := range prog.Source {
+		pr.Instructions = append(pr.Instructions, pioInstruction(prog.Name, addr, inst))
+	}
+	for _, w := range prog.Warnings {
+		pr.Warnings = append(pr.Warnings, w.Error())
+	}
+	if err != nil {
+		pr.Errors = errorStrings(err)
+		pr.Diagnostics = diagnostics(err)
+	}
+	return pr
+}
+
+// addErrors records err, keeping its positioned diagnostics.
+func (r *ValidateResult) addErrors(err error) {
+	if err != nil {
+		r.Errors = append(r.Errors, errorStrings(err)...)
+		r.Diagnostics = append(r.Diagnostics, diagnostics(err)...)
 	}
+}
 
-	if len(instructions) > 32 {
-		errors = append(errors, fmt.Sprintf("program has %d instructions, max is 32", len(instructions)))
+// diagnostics returns the positioned errors in err.
+func diagnostics(err error) []*asm.Error {
+	var list asm.ErrorList
+	if errors.As(err, &list) {
+		return list
 	}
+	return nil
+}
 
-	return ValidateResult{
-		Valid:        len(errors) == 0,
-		Instructions: instructions,
-		Errors:       errors,
+func pioInstruction(program string, addr int, inst *asm.InstructionStmt) PIOInstruction {
+	pos := inst.OpSpan.Start
+	return PIOInstruction{
+		Line:    pos.Line,
+		Col:     pos.Col,
+		Program: program,
+		Address: addr,
+		Op:      inst.Op,
+		Args:    inst.OperandText,
+		Comment: inst.Comment,
+	}
+}
+
+// instructionStmts returns the instructions of a parsed file in source
+// order.
+func instructionStmts(file *asm.File) []*asm.InstructionStmt {
+	var insts []*asm.InstructionStmt
+	for _, stmt := range file.Statements {
+		if inst, ok := stmt.(*asm.InstructionStmt); ok {
+			insts = append(insts, inst)
+		}
 	}
+	return insts
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -532,14 +855,27 @@ Powered by <a href="https://github.com/tinygo-org/pio">TinyGo PIO</a>.</p>
 <textarea id="source" placeholder="Paste PIO assembly here..."></textarea>
 
 <div class="actions">
+  <select id="target">
+    <option value="rp2040">RP2040 (PIO v0)</option>
+    <option value="rp2350">RP2350 (PIO v1)</option>
+  </select>
   <button class="primary" onclick="validate()">Validate</button>
+  <button onclick="formatSource()">Format</button>
   <button onclick="compile('hex')">Compile (Hex)</button>
   <button onclick="compile('go')">Compile (Go)</button>
+  <button onclick="compile('c')">Compile (C)</button>
+  <button onclick="simulate()">Simulate</button>
+  <button onclick="downloadVCD()">Download VCD</button>
+  <button onclick="debugStart()">Debug</button>
+  <button onclick="liveStart()">Live</button>
 </div>
 
 <div class="tabs">
   <button class="active" onclick="showTab('validation')">Validation</button>
   <button onclick="showTab('compiled')">Compiled Output</button>
+  <button onclick="showTab('simulation')">Simulation</button>
+  <button onclick="showTab('debugger')">Debugger</button>
+  <button onclick="showTab('live')">Live</button>
   <button onclick="showTab('drivers')">Drivers</button>
 </div>
 
@@ -551,6 +887,43 @@ Powered by <a href="https://github.com/tinygo-org/pio">TinyGo PIO</a>.</p>
   <div id="compile-result"></div>
 </div>
 
+<div id="simulation" class="tab-content">
+  <div id="simulate-result"></div>
+</div>
+
+<div id="debugger" class="tab-content">
+  <div class="actions">
+    <label>Breakpoints <input id="debug-breakpoints" placeholder="3, bitloop, pin:2, irq:0, tx_empty" size="32"></label>
+    <label>Input pins <input id="debug-pins" value="0x0" size="10"></label>
+  </div>
+  <div class="actions">
+    <button onclick="debugAction('step')">Step</button>
+    <button onclick="debugAction('step', {cycles: 10})">Step 10</button>
+    <button onclick="debugAction('continue')">Continue</button>
+    <button onclick="debugAction('reset')">Reset</button>
+  </div>
+  <div id="debug-result"><p>Click <b>Debug</b> to start a session on the program in the editor.</p></div>
+</div>
+
+<div id="live" class="tab-content">
+  <div class="actions">
+    <label>Cycles/s <input id="live-rate" value="20" size="6" onchange="liveSend({type: 'rate', rate: +this.value})"></label>
+    <button onclick="liveSend({type: 'pause'})">Pause</button>
+    <button onclick="liveSend({type: 'resume'})">Resume</button>
+    <button onclick="liveSend({type: 'step'})">Step</button>
+    <button onclick="liveSend({type: 'stop'})">Stop</button>
+  </div>
+  <div class="actions">
+    <label>TX word <input id="live-tx" value="0x0" size="10"></label>
+    <button onclick="liveSend({type: 'tx', word: parseInt(document.getElementById('live-tx').value || '0') >>> 0})">Push</button>
+    <label>Input pin <input id="live-pin" value="0" size="3"></label>
+    <button onclick="liveSend({type: 'pin', pin: +document.getElementById('live-pin').value, level: 1})">High</button>
+    <button onclick="liveSend({type: 'pin', pin: +document.getElementById('live-pin').value, level: 0})">Low</button>
+  </div>
+  <canvas id="live-wave" width="860" height="200" style="border: 1px solid #ddd"></canvas>
+  <pre id="live-log">Click <b>Live</b> to stream the program in the editor.</pre>
+</div>
+
 <div id="drivers" class="tab-content">
   <p>Ready-to-use PIO drivers from <code>github.com/tinygo-org/pio/rp2-pio/piolib</code>:</p>
   <div id="driver-list" class="driver-list"></div>
@@ -572,17 +945,27 @@ async function validate() {
   const resp = await fetch('/api/validate', {
     method: 'POST',
     headers: {'Content-Type': 'application/json'},
-    body: JSON.stringify({source})
+    body: JSON.stringify({source, target: document.getElementById('target').value})
   });
   const data = await resp.json();
   let html = '';
+  const programs = data.programs || [];
   if (data.valid) {
-    html += '<p class="valid">✓ Valid PIO program (' + data.instructions.length + '/32 instructions)</p>';
+    html += '<p class="valid">✓ Valid PIO source (' + programs.length + ' program' + (programs.length === 1 ? '' : 's') + ')</p>';
   } else {
     html += '<p class="error">✗ Invalid:</p><ul>';
-    data.errors.forEach(e => html += '<li class="error">' + e + '</li>');
+    data.errors.forEach(e => html += '<li class="error">' + escapeHtml(e) + '</li>');
     html += '</ul>';
   }
+  (data.warnings || []).forEach(w => html += '<p class="warning">' + escapeHtml(w) + '</p>');
+  programs.forEach(p => {
+    html += '<h4>' + escapeHtml(p.name || '(unnamed)') + ': ' + p.instructions.length + '/32 instructions, wrap ' + p.wrap_target + '..' + p.wrap + '</h4>';
+    if (p.labels && p.labels.length > 0) {
+      html += '<pre>';
+      p.labels.forEach(l => html += escapeHtml(l.name) + ' = ' + l.address + (l.public ? ' (public)' : '') + '\n');
+      html += '</pre>';
+    }
+  });
   if (data.instructions && data.instructions.length > 0) {
     html += '<h4>Parsed Instructions:</h4>';
     html += '<pre>' + JSON.stringify(data.instructions, null, 2) + '</pre>';
@@ -590,28 +973,51 @@ async function validate() {
   document.getElementById('result').innerHTML = html;
 }
 
+async function formatSource() {
+  const editor = document.getElementById('source');
+  const resp = await fetch('/api/format', {
+    method: 'POST',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify({source: editor.value})
+  });
+  const data = await resp.json();
+  if (data.success) {
+    editor.value = data.source;
+    return;
+  }
+  showTab('validation');
+  let html = '<p class="error">✗ Cannot format:</p><ul>';
+  data.errors.forEach(e => html += '<li class="error">' + escapeHtml(e) + '</li>');
+  html += '</ul>';
+  document.getElementById('result').innerHTML = html;
+}
+
 async function compile(format) {
   showTab('compiled');
   const source = document.getElementById('source').value;
   const resp = await fetch('/api/compile', {
     method: 'POST',
     headers: {'Content-Type': 'application/json'},
-    body: JSON.stringify({source, format})
+    body: JSON.stringify({source, format, target: document.getElementById('target').value})
   });
   const data = await resp.json();
   let html = '';
   if (data.success) {
     html += '<p class="valid">✓ Compilation successful</p>';
+    (data.warnings || []).forEach(w => html += '<p class="warning">' + escapeHtml(w) + '</p>');
     if (data.go) {
       html += '<h4>Go Output:</h4><pre>' + escapeHtml(data.go) + '</pre>';
     }
+    if (data.c) {
+      html += '<h4>C Output:</h4><pre>' + escapeHtml(data.c) + '</pre>';
+    }
     if (data.hex) {
       html += '<h4>Hex Output:</h4><pre>' + escapeHtml(data.hex) + '</pre>';
     }
-    if (data.binary && data.binary.length > 0) {
-      html += '<h4>Binary (' + data.binary.length + ' instructions):</h4>';
-      html += '<pre>' + data.binary.map(b => '0x' + b.toString(16).padStart(4, '0')).join(', ') + '</pre>';
-    }
+    (data.programs || []).forEach(p => {
+      html += '<h4>' + escapeHtml(p.name || '(unnamed)') + ' (' + p.instructions.length + ' instructions):</h4>';
+      html += '<pre>' + p.instructions.map(b => '0x' + b.toString(16).padStart(4, '0')).join(', ') + '</pre>';
+    });
   } else {
     html += '<p class="error">✗ Compilation failed:</p><ul>';
     data.errors.forEach(e => html += '<li class="error">' + e + '</li>');
@@ -620,6 +1026,193 @@ async function compile(format) {
   document.getElementById('compile-result').innerHTML = html;
 }
 
+async function simulate() {
+  showTab('simulation');
+  const source = document.getElementById('source').value;
+  const resp = await fetch('/api/simulate', {
+    method: 'POST',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify({source, target: document.getElementById('target').value, cycles: 64})
+  });
+  const data = await resp.json();
+  let html = '';
+  if (!data.success) {
+    html += '<p class="error">✗ Simulation failed:</p><ul>';
+    data.errors.forEach(e => html += '<li class="error">' + escapeHtml(e) + '</li>');
+    html += '</ul>';
+  } else {
+    const hex = (v, n) => v.toString(16).padStart(n, '0');
+    html += '<pre>cycle  pc  instr  x         y         isr       osr       pins      tx/rx\n';
+    data.cycles.forEach(c => {
+      html += String(c.cycle).padStart(5) + '  ' + String(c.pc).padStart(2) + '  ' + hex(c.instruction, 4) + '   ' +
+        hex(c.x, 8) + '  ' + hex(c.y, 8) + '  ' + hex(c.isr, 8) + '  ' + hex(c.osr, 8) + '  ' + hex(c.pins, 8) + '  ' +
+        c.tx.length + '/' + c.rx.length + (c.stalled ? '  stall' : '') + (c.delay ? '  delay' : '') + '\n';
+    });
+    html += '</pre>';
+  }
+  document.getElementById('simulate-result').innerHTML = html;
+}
+
+async function downloadVCD() {
+  const source = document.getElementById('source').value;
+  const resp = await fetch('/api/waveform', {
+    method: 'POST',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify({source, target: document.getElementById('target').value, cycles: 1000})
+  });
+  const data = await resp.json();
+  if (!data.success) {
+    showTab('simulation');
+    let html = '<p class="error">✗ Waveform failed:</p><ul>';
+    data.errors.forEach(e => html += '<li class="error">' + escapeHtml(e) + '</li>');
+    document.getElementById('simulate-result').innerHTML = html + '</ul>';
+    return;
+  }
+  const a = document.createElement('a');
+  a.href = URL.createObjectURL(new Blob([data.vcd], {type: 'text/plain'}));
+  a.download = (data.program || 'pio') + '.vcd';
+  a.click();
+  URL.revokeObjectURL(a.href);
+}
+
+let debugSession = null, debugLines = [];
+
+// parseBreakpoints reads "3, bitloop, pin:2, irq:0, tx_empty": addresses,
+// labels, pins, irq flags and FIFO conditions.
+function parseBreakpoints(text) {
+  return text.split(',').map(s => s.trim()).filter(s => s).map(s => {
+    if (/^\d+$/.test(s)) return {kind: 'address', address: +s};
+    const m = s.match(/^(pin|irq):(\d+)$/);
+    if (m) return m[1] === 'pin' ? {kind: 'pin', pin: +m[2]} : {kind: 'irq', irq: +m[2]};
+    if (/^(tx|rx)_(empty|full)$/.test(s)) return {kind: s};
+    return {kind: 'label', label: s};
+  });
+}
+
+async function debugStart() {
+  if (debugSession) {
+    await fetch('/api/debug', {method: 'POST', headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify({action: 'close', session: debugSession})});
+  }
+  debugSession = null;
+  await debugAction('create', {source: document.getElementById('source').value, target: document.getElementById('target').value});
+}
+
+async function debugAction(action, extra) {
+  showTab('debugger');
+  if (!debugSession && action !== 'create') {
+    return debugStart();
+  }
+  const req = Object.assign({action, session: debugSession || undefined,
+    breakpoints: parseBreakpoints(document.getElementById('debug-breakpoints').value),
+    pins: parseInt(document.getElementById('debug-pins').value || '0') >>> 0}, extra || {});
+  const resp = await fetch('/api/debug', {
+    method: 'POST',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify(req)
+  });
+  const data = await resp.json();
+  if (!data.success) {
+    if (action !== 'create' && data.errors.some(e => e.includes('expired'))) debugSession = null;
+    let html = '<p class="error">✗ Debugger:</p><ul>';
+    data.errors.forEach(e => html += '<li class="error">' + escapeHtml(e) + '</li>');
+    document.getElementById('debug-result').innerHTML = html + '</ul>';
+    return;
+  }
+  if (action === 'create') {
+    debugSession = data.session;
+    debugLines = data.lines || [];
+  }
+  const st = data.state, hex = (v, n) => v.toString(16).padStart(n, '0');
+  const current = data.next_pc >= 0 ? debugLines[data.next_pc - data.offset] : debugLines[st.pc - data.offset];
+  let html = '<pre>';
+  document.getElementById('source').value.split('\n').forEach((line, i) => {
+    html += (i + 1 === current ? '▶ ' : '  ') + escapeHtml(line) + '\n';
+  });
+  html += '</pre><pre>';
+  html += 'cycle ' + st.cycle + '  pc ' + st.pc + '  next ' + (data.next_pc >= 0 ? data.next_pc : '-') +
+    (st.stalled ? '  stalled' : '') + (st.delay ? '  delay' : '') + '\n';
+  html += 'x   ' + hex(st.x, 8) + '  y   ' + hex(st.y, 8) + '\n';
+  html += 'isr ' + hex(st.isr, 8) + ' (' + st.isr_count + ')  osr ' + hex(st.osr, 8) + ' (' + st.osr_count + ')\n';
+  html += 'tx  [' + st.tx.map(w => hex(w, 8)).join(' ') + ']  rx [' + st.rx.map(w => hex(w, 8)).join(' ') + ']\n';
+  html += 'pins ' + hex(st.pins, 8) + '  pindirs ' + hex(st.pindirs, 8) + '  irq ' + hex(st.irq, 2) + '\n';
+  if (data.stop) {
+    const bp = data.stop.breakpoint;
+    html += '\nran ' + data.stop.cycles + ' cycles, ' + (bp ? 'stopped at ' + escapeHtml(JSON.stringify(bp)) : 'no breakpoint hit') + '\n';
+  }
+  document.getElementById('debug-result').innerHTML = html + '</pre>';
+}
+
+let liveSocket = null, livePins = [], liveCycle = 0, liveLog = [];
+const liveWindow = 200; // cycles shown in the waveform
+
+function liveStart() {
+  showTab('live');
+  if (liveSocket) liveSocket.close();
+  livePins = [], liveCycle = 0, liveLog = [];
+  const ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/api/stream');
+  liveSocket = ws;
+  ws.onopen = () => ws.send(JSON.stringify({source: document.getElementById('source').value,
+    target: document.getElementById('target').value, rate: +document.getElementById('live-rate').value || 20}));
+  ws.onmessage = msg => {
+    const data = JSON.parse(msg.data);
+    if (!Array.isArray(data)) {
+      liveLine(data.success ? 'streaming ' + (data.program || 'program') : 'error: ' + data.errors.join('; '));
+      return;
+    }
+    data.forEach(e => {
+      liveCycle = Math.max(liveCycle, e.cycle);
+      if (e.type === 'pins') livePins.push({cycle: e.cycle, pins: e.pins || 0, dirs: e.pindirs || 0});
+      else if (e.type === 'push' || e.type === 'pull') liveLine(e.cycle + ' ' + e.type + ' 0x' + (e.word || 0).toString(16));
+      else if (e.type === 'stall') liveLine(e.cycle + ' stall at ' + e.pc);
+      else if (e.type === 'status') liveLine(e.cycle + ' ' + e.state);
+      else if (e.type === 'dropped') liveLine(e.cycle + ' dropped ' + e.count + ' events');
+      else if (e.type === 'error') liveLine('error: ' + e.error);
+    });
+    while (livePins.length > 1 && livePins[1].cycle < liveCycle - liveWindow) livePins.shift();
+    liveDraw();
+  };
+  ws.onclose = () => { if (liveSocket === ws) liveSocket = null; };
+}
+
+function liveSend(control) {
+  if (liveSocket && liveSocket.readyState === WebSocket.OPEN) liveSocket.send(JSON.stringify(control));
+}
+
+function liveLine(text) {
+  liveLog.push(text);
+  if (liveLog.length > 20) liveLog.shift();
+  document.getElementById('live-log').textContent = liveLog.join('\n');
+}
+
+// liveDraw plots the pins that have been outputs over the last
+// liveWindow cycles, one row per pin.
+function liveDraw() {
+  const canvas = document.getElementById('live-wave'), ctx = canvas.getContext('2d');
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  let dirs = 0;
+  livePins.forEach(p => dirs |= p.dirs | p.pins);
+  const pins = [];
+  for (let i = 0; i < 32 && pins.length < 8; i++) if (dirs >>> i & 1) pins.push(i);
+  const start = liveCycle - liveWindow, x = c => 40 + (c - start) * (canvas.width - 50) / liveWindow;
+  const row = canvas.height / Math.max(pins.length, 1);
+  ctx.font = '12px monospace';
+  pins.forEach((pin, r) => {
+    const hi = r * row + 6, lo = (r + 1) * row - 6;
+    ctx.fillStyle = '#333';
+    ctx.fillText('p' + pin, 4, lo);
+    ctx.strokeStyle = '#0066cc';
+    ctx.beginPath();
+    livePins.forEach((p, i) => {
+      const y = p.pins >>> pin & 1 ? hi : lo, x0 = x(Math.max(p.cycle, start));
+      const x1 = x(i + 1 < livePins.length ? livePins[i + 1].cycle : liveCycle);
+      if (i === 0) ctx.moveTo(x0, y); else ctx.lineTo(x0, y);
+      ctx.lineTo(x1, y);
+    });
+    ctx.stroke();
+  });
+}
+
 function escapeHtml(text) {
   const div = document.createElement('div');
   div.textContent = text;
@@ -655,11 +1248,12 @@ async function loadStatus() {
   const s = await resp.json();
   let html = '<strong>Status:</strong> ';
   html += 'Validator <span class="ok">✓</span> | ';
-  html += 'pioasm ' + (s.pioasm ? '<span class="ok">✓</span>' : '<span class="missing">✗ not installed</span>') + ' | ';
+  html += 'Assembler <span class="ok">✓ ' + s.assembler + '</span> | ';
+  html += 'pioasm cross-check ' + (s.pioasm ? '<span class="ok">✓</span>' : '<span class="missing">✗ not installed</span>') + ' | ';
   html += s.drivers + ' drivers | ' + s.examples + ' examples | ';
   html += 'Max ' + s.max_instructions + ' instructions';
   if (!s.pioasm) {
-    html += '<br><small>Install pioasm: <code>git clone pico-sdk && cd tools/pioasm && cmake . && make && sudo make install</code></small>';
+    html += '<br><small>Optional, to cross-check compiled output: <code>git clone pico-sdk && cd tools/pioasm && cmake . && make && sudo make install</code></small>';
   }
   document.getElementById('status').innerHTML = html;
 }
@@ -1,11 +1,30 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/debug"
+	"github.com/joeblew999/plat-tinypio/internal/decode"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+	"github.com/joeblew999/plat-tinypio/internal/stream"
 )
 
 func TestHealthEndpoint(t *testing.T) {
@@ -34,8 +53,54 @@ func TestExamplesEndpoint(t *testing.T) {
 	if err := json.NewDecoder(w.Body).Decode(&programs); err != nil {
 		t.Fatalf("failed to decode: %v", err)
 	}
-	if len(programs) != 3 {
-		t.Fatalf("expected 3 examples, got %d", len(programs))
+	if len(programs) != len(examples) {
+		t.Fatalf("expected %d examples, got %d", len(examples), len(programs))
+	}
+}
+
+func TestCompilePIO_Native(t *testing.T) {
+	source := `.program squarewave
+again:
+    set pins, 1 [1]
+    set pins, 0
+    jmp again`
+
+	result := compilePIO(source, "hex", "")
+	if !result.Success {
+		t.Fatalf("expected success, got errors: %v", result.Errors)
+	}
+	want := []uint16{0xe101, 0xe000, 0x0000}
+	if len(result.Binary) != len(want) {
+		t.Fatalf("expected %d words, got %d", len(want), len(result.Binary))
+	}
+	for i := range want {
+		if result.Binary[i] != want[i] {
+			t.Fatalf("word %d: expected %04x, got %04x", i, want[i], result.Binary[i])
+		}
+	}
+	if got, err := parseHexProgram(result.Hex); err != nil || len(got) != len(want) {
+		t.Fatalf("hex output does not round-trip: %q %v", result.Hex, err)
+	}
+	if _, err := parseHexProgram("e081\npioasm: warning\n"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected undecodable hex lines to be reported, got %v", err)
+	}
+}
+
+func TestCompilePIO_Examples(t *testing.T) {
+	for _, ex := range examples {
+		if result := compilePIO(ex.Source, "hex", ""); !result.Success {
+			t.Errorf("%s: expected success, got errors: %v", ex.Name, result.Errors)
+		}
+	}
+}
+
+func TestCompilePIO_Errors(t *testing.T) {
+	result := compilePIO("    jmp missing", "hex", "")
+	if result.Success {
+		t.Fatal("expected failure for undefined label")
+	}
+	if len(result.Errors) != 1 {
+		t.Fatalf("expected 1 error, got %v", result.Errors)
 	}
 }
 
@@ -46,7 +111,7 @@ again:
     set pins, 0
     jmp again`
 
-	result := validatePIO(source)
+	result := validatePIO(source, "")
 	if !result.Valid {
 		t.Fatalf("expected valid, got errors: %v", result.Errors)
 	}
@@ -58,7 +123,7 @@ again:
 func TestValidatePIO_InvalidOpcode(t *testing.T) {
 	source := `    badop pins, 1`
 
-	result := validatePIO(source)
+	result := validatePIO(source, "")
 	if result.Valid {
 		t.Fatal("expected invalid program")
 	}
@@ -73,17 +138,18 @@ func TestValidatePIO_TooManyInstructions(t *testing.T) {
 		lines += "    nop\n"
 	}
 
-	result := validatePIO(lines)
+	result := validatePIO(lines, "")
 	if result.Valid {
 		t.Fatal("expected invalid for >32 instructions")
 	}
 }
 
 func TestValidatePIO_SideSetAndDelay(t *testing.T) {
-	source := `    out pins, 1  side 0 [1]
+	source := `.side_set 1
+    out pins, 1  side 0 [1]
     nop          side 1 [2]`
 
-	result := validatePIO(source)
+	result := validatePIO(source, "")
 	if !result.Valid {
 		t.Fatalf("expected valid, got errors: %v", result.Errors)
 	}
@@ -92,6 +158,260 @@ func TestValidatePIO_SideSetAndDelay(t *testing.T) {
 	}
 }
 
+func TestValidatePIO_OperandErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{"    set foo, 99", []string{"1:9: invalid set destination", "1:14: value 99 out of range 0-31"}},
+		{"lbl:\n    jmp x!!y, lbl", []string{"2:9: invalid jmp condition \"x!!y\""}},
+		{"    in bogus, 40", []string{"1:8: invalid in source", "1:15: bit count 40 out of range 1-32"}},
+		{"    wait 2 gpio 0", []string{"1:10: value 2 out of range 0-1"}},
+		{"    wait 1 irq 9", []string{"1:16: value 9 out of range 0-7"}},
+		{"    irq flip 1", []string{"1:9: invalid irq mode \"flip\""}},
+		{"    pull iffull", []string{"1:10: unexpected pull operand \"iffull\""}},
+		{"    mov x, !bogus", []string{"1:12: invalid mov source"}},
+	}
+	for _, tt := range tests {
+		result := validatePIO(tt.source, "")
+		if result.Valid {
+			t.Errorf("%q: expected invalid", tt.source)
+			continue
+		}
+		if len(result.Errors) != len(tt.want) {
+			t.Errorf("%q: expected %d errors, got %v", tt.source, len(tt.want), result.Errors)
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.Contains(result.Errors[i], want) {
+				t.Errorf("%q: expected error containing %q, got %q", tt.source, want, result.Errors[i])
+			}
+		}
+	}
+}
+
+func TestValidatePIO_SideSetBudget(t *testing.T) {
+	result := validatePIO(`.side_set 2 opt
+    nop side 3 [3]`, "")
+	if !result.Valid {
+		t.Fatalf("expected valid, got errors: %v", result.Errors)
+	}
+	prog := result.Programs[0]
+	if prog.SideSet.Bits != 2 || !prog.SideSet.Opt || prog.DelayBits != 2 {
+		t.Fatalf("unexpected side-set %+v with %d delay bits", prog.SideSet, prog.DelayBits)
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{".side_set 2 opt\n    nop [4]", "2:10: delay 4 exceeds the 2 delay bits left by .side_set 2 opt (max 3)"},
+		{".side_set 1\n    nop side 2", "2:14: side-set value 2 does not fit in 1 bits"},
+		{".side_set 1\n    nop [1]", "2:12: missing side-set value required by .side_set 1"},
+		{"    nop side 1", "1:14: side-set used without .side_set directive"},
+		{".side_set 5 opt", "1:11: side-set count 5 out of range 0-4"},
+		{".side_set 1 optional", "1:13: unexpected .side_set option \"optional\""},
+		{"    nop\n.side_set 1", "2:1: .side_set must appear before the first instruction"},
+		{"    nop [32]", "1:10: delay 32 out of range 0-31"},
+	}
+	for _, tt := range tests {
+		result := validatePIO(tt.source, "")
+		if result.Valid {
+			t.Errorf("%q: expected invalid", tt.source)
+			continue
+		}
+		if !strings.Contains(result.Errors[0], tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.source, tt.want, result.Errors)
+		}
+	}
+}
+
+func TestValidatePIO_Labels(t *testing.T) {
+	result := validatePIO(`.program blink
+public start:
+    set pins, 1
+loop:
+    jmp x-- loop
+unused:
+    jmp start`, "")
+	if !result.Valid {
+		t.Fatalf("expected valid, got errors: %v", result.Errors)
+	}
+	labels := result.Programs[0].Labels
+	want := map[string]int{"start": 0, "loop": 1, "unused": 2}
+	if len(labels) != len(want) {
+		t.Fatalf("expected %d labels, got %d", len(want), len(labels))
+	}
+	for _, l := range labels {
+		if want[l.Name] != l.Address {
+			t.Errorf("label %s: expected address %d, got %d", l.Name, want[l.Name], l.Address)
+		}
+	}
+	if !labels[0].Public {
+		t.Error("expected start to be public")
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], `label "unused" is never used`) {
+		t.Fatalf("unexpected warnings: %v", result.Warnings)
+	}
+	for i, inst := range result.Instructions {
+		if inst.Address != i {
+			t.Errorf("instruction %d: expected address %d, got %d", i, i, inst.Address)
+		}
+	}
+}
+
+func TestValidatePIO_LabelErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"    jmp doesnt_exist", `1:9: undefined label "doesnt_exist"`},
+		{"a:\n    nop\na:\n    jmp a", `3:1: duplicate label "a" (first defined at line 1)`},
+		{"    nop\n    jmp 5", "2:9: jmp target 5 is outside the program (addresses 0-1)"},
+	}
+	for _, tt := range tests {
+		result := validatePIO(tt.source, "")
+		if result.Valid {
+			t.Errorf("%q: expected invalid", tt.source)
+			continue
+		}
+		if !strings.Contains(result.Errors[0], tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.source, tt.want, result.Errors)
+		}
+	}
+}
+
+func TestValidatePIO_Directives(t *testing.T) {
+	result := validatePIO(`.program words
+.origin 28
+    nop
+.wrap_target
+    .word 0xa042
+    nop
+.wrap`, "")
+	if !result.Valid {
+		t.Fatalf("expected valid, got errors: %v", result.Errors)
+	}
+	prog := result.Programs[0]
+	if prog.Origin != 28 || prog.WrapTarget != 1 || prog.Wrap != 2 {
+		t.Fatalf("unexpected origin %d, wrap %d..%d", prog.Origin, prog.WrapTarget, prog.Wrap)
+	}
+	if len(result.Instructions) != 3 || result.Instructions[1].Op != ".word" {
+		t.Fatalf("expected .word to count as an instruction, got %+v", result.Instructions)
+	}
+
+	result = validatePIO(".origin 30\n    nop\n    .word 0\n    nop\n.wrapp", "")
+	if result.Valid || len(result.Errors) != 2 {
+		t.Fatalf("expected unknown directive and origin errors, got %v", result.Errors)
+	}
+}
+
+const spiPair = `.define public BITS 8
+
+.program spi_tx
+.side_set 1
+.wrap_target
+    out pins, 1 side 0
+    jmp !osre, 0 side 1
+.wrap
+
+.program spi_rx
+.side_set 1
+.wrap_target
+loop:
+    in pins, 1 side 1
+    jmp loop side 0
+.wrap`
+
+func TestValidatePIO_MultiplePrograms(t *testing.T) {
+	result := validatePIO(spiPair, "")
+	if !result.Valid {
+		t.Fatalf("expected valid, got errors: %v", result.Errors)
+	}
+	if len(result.Programs) != 2 {
+		t.Fatalf("expected 2 programs, got %d", len(result.Programs))
+	}
+	for i, name := range []string{"spi_tx", "spi_rx"} {
+		prog := result.Programs[i]
+		if prog.Name != name || len(prog.Instructions) != 2 || prog.WrapTarget != 0 || prog.Wrap != 1 {
+			t.Errorf("program %d: unexpected result %+v", i, prog)
+		}
+		if prog.Instructions[0].Address != 0 || prog.Instructions[0].Program != name {
+			t.Errorf("program %d: expected addresses to restart at 0, got %+v", i, prog.Instructions[0])
+		}
+	}
+	if len(result.Instructions) != 4 {
+		t.Fatalf("expected 4 instructions in total, got %d", len(result.Instructions))
+	}
+
+	// Each program has its own 32-instruction budget.
+	var src strings.Builder
+	for _, name := range []string{"a", "b"} {
+		src.WriteString(".program " + name + "\n")
+		for range 20 {
+			src.WriteString("    nop\n")
+		}
+	}
+	if result := validatePIO(src.String(), ""); !result.Valid {
+		t.Fatalf("expected two 20-instruction programs to be valid, got %v", result.Errors)
+	}
+
+	result = validatePIO(".program a\n    nop\n.program b\n    jmp nowhere", "")
+	if result.Valid || !result.Programs[0].Valid || result.Programs[1].Valid {
+		t.Fatalf("expected only program b to fail, got %+v", result.Programs)
+	}
+}
+
+func TestCompilePIO_MultiplePrograms(t *testing.T) {
+	result := compilePIO(spiPair, "hex", "")
+	if !result.Success {
+		t.Fatalf("expected success, got errors: %v", result.Errors)
+	}
+	if len(result.Programs) != 2 || result.Binary != nil {
+		t.Fatalf("expected 2 programs and no combined binary, got %+v", result)
+	}
+	if result.Programs[1].Name != "spi_rx" || result.Programs[1].Instructions[0] != 0x5001 {
+		t.Fatalf("unexpected spi_rx program %+v", result.Programs[1])
+	}
+	if !strings.Contains(result.Hex, "// spi_rx\n") {
+		t.Fatalf("expected per-program hex sections, got %q", result.Hex)
+	}
+
+	result = compilePIO(spiPair, "go", "")
+	for _, want := range []string{"var SpiTxInstructions", "var SpiRxInstructions"} {
+		if !strings.Contains(result.Go, want) {
+			t.Errorf("expected Go output to contain %q", want)
+		}
+	}
+}
+
+func TestValidatePIO_SymbolsContainingSide(t *testing.T) {
+	source := `sidecar:
+    jmp sidecar side_x  ; see: the manual`
+
+	result := validatePIO(source, "")
+	if len(result.Instructions) != 1 {
+		t.Fatalf("expected 1 instruction, got %d", len(result.Instructions))
+	}
+	inst := result.Instructions[0]
+	if inst.Op != "jmp" || inst.Args != "sidecar side_x" {
+		t.Fatalf("unexpected instruction %+v", inst)
+	}
+	if inst.Comment != "see: the manual" {
+		t.Fatalf("unexpected comment %q", inst.Comment)
+	}
+}
+
+func TestValidatePIO_SyntaxError(t *testing.T) {
+	result := validatePIO("    set pins, 1 [2", "")
+	if result.Valid {
+		t.Fatal("expected invalid program")
+	}
+	if len(result.Errors) != 1 || result.Errors[0] != "line 1:19: expected ']' after delay" {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+}
+
 func TestValidateEndpoint(t *testing.T) {
 	body, _ := json.Marshal(map[string]string{
 		"source": "    set pins, 1\n    jmp 0",
@@ -123,3 +443,1505 @@ func TestValidateEndpoint_MethodNotAllowed(t *testing.T) {
 		t.Fatalf("expected 405, got %d", w.Code)
 	}
 }
+
+func TestValidateEndpoint_Target(t *testing.T) {
+	source := "    mov rxfifo[0], isr\n    jmp 0"
+	for _, tt := range []struct {
+		target string
+		code   int
+		valid  bool
+	}{
+		{"rp2350", http.StatusOK, true},
+		{"rp2040", http.StatusOK, false},
+		{"esp32", http.StatusBadRequest, false},
+	} {
+		body, _ := json.Marshal(map[string]string{"source": source, "target": tt.target})
+		req := httptest.NewRequest("POST", "/api/validate", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handleValidate(w, req)
+
+		if w.Code != tt.code {
+			t.Fatalf("%s: expected %d, got %d", tt.target, tt.code, w.Code)
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var result ValidateResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode: %v", err)
+		}
+		if result.Valid != tt.valid {
+			t.Fatalf("%s: expected valid=%t, got errors: %v", tt.target, tt.valid, result.Errors)
+		}
+		if !tt.valid && !strings.Contains(result.Errors[0], "target rp2040 only supports PIO version 0") {
+			t.Fatalf("%s: expected a PIO version error, got %v", tt.target, result.Errors)
+		}
+	}
+}
+
+func TestSimulatePIO(t *testing.T) {
+	result := simulatePIO(SimulateRequest{
+		Source: "    out pins, 8",
+		Config: json.RawMessage(`{"autopull": true, "out_count": 8, "pull_threshold": 16}`),
+		TX:     []uint32{0xbeef},
+		Cycles: 3,
+	})
+	if !result.Success {
+		t.Fatalf("expected success, got errors: %v", result.Errors)
+	}
+	if len(result.Cycles) != 3 {
+		t.Fatalf("expected 3 cycles, got %d", len(result.Cycles))
+	}
+	if result.Cycles[0].Pins != 0xef || result.Cycles[1].Pins != 0xbe || !result.Cycles[2].Stalled {
+		t.Fatalf("unexpected trace %+v", result.Cycles)
+	}
+
+	drained := simulatePIO(SimulateRequest{Source: "    in null, 32\n    push", Cycles: 20, DrainRX: true})
+	if len(drained.RX) != 10 {
+		t.Fatalf("expected 10 words read from RX, got %v", drained.RX)
+	}
+}
+
+func TestSimulatePIO_Errors(t *testing.T) {
+	tests := []struct {
+		req  SimulateRequest
+		want string
+	}{
+		{SimulateRequest{Source: "    jmp nowhere"}, `undefined label "nowhere"`},
+		{SimulateRequest{Source: spiPair}, "choose one of spi_tx, spi_rx"},
+		{SimulateRequest{Source: spiPair, Program: "spi"}, `no program "spi"`},
+		{SimulateRequest{Source: "    nop", Cycles: maxSimCycles + 1}, "exceeds the limit"},
+		{SimulateRequest{Source: "    nop", Config: json.RawMessage(`{"autopull": 1}`)}, "invalid config"},
+	}
+	for _, tt := range tests {
+		result := simulatePIO(tt.req)
+		if result.Success || !strings.Contains(strings.Join(result.Errors, "\n"), tt.want) {
+			t.Errorf("expected error containing %q, got %v", tt.want, result.Errors)
+		}
+	}
+	if result := simulatePIO(SimulateRequest{Source: spiPair, Program: "spi_rx", Cycles: 4}); !result.Success {
+		t.Fatalf("expected spi_rx to run, got %v", result.Errors)
+	}
+}
+
+const squarewave = `.program squarewave
+    set pindirs, 1
+again:
+    set pins, 1 [1]
+    set pins, 0
+    jmp again`
+
+func TestWaveformPIO(t *testing.T) {
+	result := waveformPIO(WaveformRequest{
+		SimulateRequest: SimulateRequest{
+			Source: squarewave,
+			Config: json.RawMessage(`{"out_count": 0, "set_count": 1, "clock_div": 2.5}`),
+			Cycles: 10,
+		},
+	})
+	if !result.Success {
+		t.Fatalf("expected success, got errors: %v", result.Errors)
+	}
+	if result.Program != "squarewave" || result.Waveform.ClockDiv != 2.5 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	pin := result.Waveform.Signals[0]
+	if pin.Name != "pin0" || len(pin.Changes) < 2 || pin.Changes[1] != [2]int64{40000, 1} {
+		t.Fatalf("unexpected pin0 signal %+v", pin)
+	}
+	if !strings.Contains(result.VCD, "$timescale 1ps $end") || !strings.Contains(result.VCD, "#40000\n1!") {
+		t.Fatalf("unexpected VCD:\n%s", result.VCD)
+	}
+	if result.Waveform.SysClock != 125e6 {
+		t.Fatalf("expected the RP2040 default clock, got %v", result.Waveform.SysClock)
+	}
+
+	// The RP2350 target defaults to its 150 MHz system clock.
+	result = waveformPIO(WaveformRequest{
+		SimulateRequest: SimulateRequest{Source: squarewave, Target: "rp2350", Cycles: 10},
+	})
+	if !result.Success || result.Waveform.SysClock != 150e6 {
+		t.Fatalf("expected a 150 MHz clock, got %+v", result)
+	}
+}
+
+func TestWaveformCommand(t *testing.T) {
+	dir := t.TempDir()
+	src := dir + "/squarewave.pio"
+	if err := os.WriteFile(src, []byte(squarewave), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := dir + "/out.json"
+	err := cmdWaveform([]string{"-cycles", "10", "-out-count", "0", "-set-count", "1", "-clkdiv", "2", "-format", "json", "-o", out, src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wf struct {
+		ClockDiv float64 `json:"clock_div"`
+		Duration int64   `json:"duration_ps"`
+	}
+	if err := json.Unmarshal(data, &wf); err != nil {
+		t.Fatal(err)
+	}
+	if wf.ClockDiv != 2 || wf.Duration != 160000 {
+		t.Fatalf("unexpected waveform %s", data)
+	}
+
+	if err := cmdWaveform([]string{"-format", "svg", src}); err == nil || !strings.Contains(err.Error(), "unknown format") {
+		t.Fatalf("expected unknown format error, got %v", err)
+	}
+}
+
+func exampleSource(t *testing.T, name string) string {
+	t.Helper()
+	for _, ex := range examples {
+		if ex.Name == name {
+			return ex.Source
+		}
+	}
+	t.Fatalf("no example %q", name)
+	return ""
+}
+
+func TestDecodeTrace_UARTExample(t *testing.T) {
+	// At 8 MHz each 8-cycle bit of uart_tx lasts 1 µs.
+	wf := waveformPIO(WaveformRequest{
+		SimulateRequest: SimulateRequest{
+			Source: exampleSource(t, "uart_tx"),
+			Config: json.RawMessage(`{"out_base": 0, "out_count": 1, "set_count": 0, "sideset_base": 0}`),
+			TX:     []uint32{'H', 'i'},
+			Cycles: 200,
+		},
+		SysClock: 8e6,
+	})
+	if !wf.Success {
+		t.Fatalf("waveform failed: %v", wf.Errors)
+	}
+	result := decodeTrace(DecodeRequest{
+		Protocol: "UART",
+		Trace:    wf.VCD,
+		Options:  decode.Options{Channels: map[string]string{"data": "pin0"}, Baud: 1e6},
+	})
+	if !result.Success {
+		t.Fatalf("decode failed: %v", result.Errors)
+	}
+	if len(result.Frames) != 2 || result.Frames[0].Value != 'H' || result.Frames[1].Value != 'i' || result.Frames[0].Error != "" {
+		t.Fatalf("unexpected frames %+v", result.Frames)
+	}
+}
+
+func TestDecodeTrace_Errors(t *testing.T) {
+	result := decodeTrace(DecodeRequest{Protocol: "uart", Format: "sr", Trace: "x"})
+	if result.Success || !strings.Contains(result.Errors[0], "unknown trace format") {
+		t.Fatalf("expected unknown format error, got %+v", result)
+	}
+	result = decodeTrace(DecodeRequest{Protocol: "uart", Format: "csv", Trace: "D0\n1\n", SampleRate: 1e6, Options: decode.Options{Baud: 9600}})
+	if result.Success || len(result.Signals) != 1 || !strings.Contains(result.Errors[0], "not mapped") {
+		t.Fatalf("expected unmapped channel error, got %+v", result)
+	}
+}
+
+func TestHandleDecode(t *testing.T) {
+	body := `{"protocol": "uart", "format": "csv", "sample_rate_hz": 1000000, "baud": 1000000, "channels": {"data": "D0"},
+		"trace": "D0\n1\n0\n1\n0\n0\n0\n0\n1\n0\n1\n1\n1\n"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/decode", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handleDecode(w, req)
+
+	var result DecodeResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || len(result.Frames) != 1 || result.Frames[0].Value != 0xA1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+const handshake = `.program tick
+    irq set 0 [3]
+.program follow
+    wait 1 irq 0
+    set pins, 1
+    set pins, 0`
+
+func TestSimulateBlock(t *testing.T) {
+	zero := 0
+	result := simulateBlock(BlockRequest{
+		Source:   handshake,
+		Programs: []BlockProgram{{Name: "tick", Offset: &zero}, {Name: "follow"}},
+		SMs: []BlockSM{
+			{SM: 0, Program: "tick"},
+			{SM: 3, Program: "follow", Config: json.RawMessage(`{"set_base": 2, "set_count": 1}`)},
+		},
+		Cycles: 9,
+	})
+	if !result.Success {
+		t.Fatalf("expected success, got errors: %v", result.Errors)
+	}
+	if len(result.Programs) != 2 || result.Programs[0].Offset != 0 || result.Programs[1].Offset != 29 {
+		t.Fatalf("unexpected program offsets %+v", result.Programs)
+	}
+	if result.Configs[3].SetBase != 2 || result.Cycles[0].SMs[1] != nil {
+		t.Fatalf("unexpected configs %+v", result.Configs)
+	}
+	// The follower sees flag 0 the cycle after it is raised and pulses
+	// pin 2 once per tick.
+	var high []int
+	for _, st := range result.Cycles {
+		if st.Pins&(1<<2) != 0 {
+			high = append(high, st.Cycle)
+		}
+	}
+	if len(high) != 2 || high[0] != 3 || high[1] != 7 {
+		t.Fatalf("expected pin 2 high in cycles 3 and 7, got %v", high)
+	}
+	if st := result.Cycles[1].SMs[3]; st.PC != 29 || st.Stalled {
+		t.Fatalf("expected the follower to finish waiting at 29 in cycle 2, got %+v", st)
+	}
+}
+
+func TestSimulateBlock_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		req  BlockRequest
+		want string
+	}{
+		{"no state machines", BlockRequest{Source: handshake}, "no state machines"},
+		{"unknown program", BlockRequest{Source: handshake, Programs: []BlockProgram{{Name: "nope"}}, SMs: []BlockSM{{}}}, `no program "nope"`},
+		{"ambiguous program", BlockRequest{Source: handshake, SMs: []BlockSM{{SM: 1}}}, "choose one"},
+		{"bad state machine", BlockRequest{Source: handshake, SMs: []BlockSM{{SM: 4, Program: "tick"}}}, "out of range"},
+		{"duplicate state machine", BlockRequest{Source: handshake, SMs: []BlockSM{{Program: "tick"}, {Program: "follow"}}}, "configured twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := simulateBlock(tt.req)
+			if result.Success || len(result.Errors) == 0 || !strings.Contains(result.Errors[0], tt.want) {
+				t.Fatalf("expected error containing %q, got %+v", tt.want, result.Errors)
+			}
+		})
+	}
+}
+
+func TestDebugPIO_Session(t *testing.T) {
+	store := &debugSessions{sessions: map[string]*debugEntry{}, now: time.Now}
+	created := debugPIO(store, DebugRequest{
+		Action:      "create",
+		Source:      squarewave,
+		Config:      json.RawMessage(`{"out_count": 0, "set_count": 1}`),
+		Breakpoints: []debug.Breakpoint{{Kind: "label", Label: "again"}},
+	})
+	if !created.Success || created.Session == "" || created.Program != "squarewave" {
+		t.Fatalf("expected a session, got %+v", created)
+	}
+	if len(created.Lines) != 4 || created.Lines[1] != 4 || created.NextPC != 0 {
+		t.Fatalf("unexpected lines %v or next pc %d", created.Lines, created.NextPC)
+	}
+	id := created.Session
+
+	step := debugPIO(store, DebugRequest{Action: "step", Session: id, Cycles: 3})
+	if !step.Success || len(step.Cycles) != 3 || step.State.Cycle != 3 || step.State.Pins != 1 {
+		t.Fatalf("unexpected step result %+v", step)
+	}
+	cont := debugPIO(store, DebugRequest{Action: "continue", Session: id})
+	if !cont.Success || cont.Stop == nil || cont.Stop.Breakpoint == nil || cont.NextPC != 1 || cont.Stop.Cycles != 2 {
+		t.Fatalf("expected to stop at again, got %+v", cont)
+	}
+	set := debugPIO(store, DebugRequest{Action: "set", Session: id, Registers: &sim.Registers{TX: []uint32{7}}, Breakpoints: []debug.Breakpoint{}})
+	if !set.Success || len(set.State.TX) != 1 || len(set.Breakpoints) != 0 {
+		t.Fatalf("unexpected set result %+v", set)
+	}
+	reset := debugPIO(store, DebugRequest{Action: "reset", Session: id})
+	if !reset.Success || reset.State.Cycle != 0 || len(reset.State.TX) != 0 {
+		t.Fatalf("unexpected reset result %+v", reset)
+	}
+	if r := debugPIO(store, DebugRequest{Action: "close", Session: id}); !r.Success {
+		t.Fatalf("close failed: %v", r.Errors)
+	}
+	if r := debugPIO(store, DebugRequest{Action: "state", Session: id}); r.Success || !strings.Contains(r.Errors[0], "no debug session") {
+		t.Fatalf("expected closed session to be gone, got %+v", r)
+	}
+}
+
+func TestDebugPIO_Expiry(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := &debugSessions{sessions: map[string]*debugEntry{}, now: func() time.Time { return now }}
+	id := debugPIO(store, DebugRequest{Action: "create", Source: squarewave}).Session
+	now = now.Add(debugSessionTTL - time.Second)
+	if r := debugPIO(store, DebugRequest{Action: "state", Session: id}); !r.Success {
+		t.Fatalf("expected session to be alive, got %v", r.Errors)
+	}
+	now = now.Add(debugSessionTTL + time.Second)
+	if r := debugPIO(store, DebugRequest{Action: "step", Session: id}); r.Success || !strings.Contains(r.Errors[0], "expired") {
+		t.Fatalf("expected session to expire, got %+v", r)
+	}
+	if len(store.sessions) != 0 {
+		t.Fatalf("expected expired sessions to be dropped, have %d", len(store.sessions))
+	}
+}
+
+func TestDebugSessions_LockPerSession(t *testing.T) {
+	// A session busy in one request does not hold up another session.
+	store := &debugSessions{sessions: map[string]*debugEntry{}, now: time.Now}
+	busy := debugPIO(store, DebugRequest{Action: "create", Source: squarewave}).Session
+	idle := debugPIO(store, DebugRequest{Action: "create", Source: squarewave}).Session
+	started, release := make(chan struct{}), make(chan struct{})
+	go store.with(busy, func(*debug.Session) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	done := make(chan DebugResult)
+	go func() { done <- debugPIO(store, DebugRequest{Action: "step", Session: idle}) }()
+	select {
+	case r := <-done:
+		if !r.Success {
+			t.Fatalf("expected the idle session to step, got %v", r.Errors)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the idle session waited for the busy one")
+	}
+	close(release)
+}
+
+func TestDebugPIO_Errors(t *testing.T) {
+	store := &debugSessions{sessions: map[string]*debugEntry{}, now: time.Now}
+	for _, tt := range []struct {
+		req  DebugRequest
+		want string
+	}{
+		{DebugRequest{Action: "pause"}, "unknown action"},
+		{DebugRequest{Action: "create"}, "needs source"},
+		{DebugRequest{Action: "create", Source: squarewave, Breakpoints: []debug.Breakpoint{{Kind: "label", Label: "loop"}}}, `no label "loop"`},
+		{DebugRequest{Action: "step", Session: "nope"}, "no debug session"},
+	} {
+		if r := debugPIO(store, tt.req); r.Success || !strings.Contains(r.Errors[0], tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %+v", tt.req, tt.want, r)
+		}
+	}
+	if len(store.sessions) != 0 {
+		t.Fatalf("expected failed creates to leave no sessions, have %d", len(store.sessions))
+	}
+}
+
+// wsClient is just enough of a WebSocket client to talk to /api/stream.
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialStream(t *testing.T, url string) *wsClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	io.WriteString(conn, "GET /api/stream HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake failed: %v %v", resp, err)
+	}
+	return &wsClient{conn: conn, r: r}
+}
+
+func (c *wsClient) send(t *testing.T, v any) {
+	t.Helper()
+	b, _ := json.Marshal(v)
+	frame := []byte{0x81, 0x80 | 126}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(b)))
+	frame = append(frame, 0, 0, 0, 0) // a zero mask leaves the payload as is
+	c.conn.Write(append(frame, b...))
+}
+
+func (c *wsClient) recv(t *testing.T, v any) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := int(head[1])
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		t.Fatalf("%v: %s", err, payload)
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleStream))
+	defer srv.Close()
+
+	c := dialStream(t, srv.URL)
+	c.send(t, map[string]any{"source": squarewave, "rate": 1000, "interval_ms": 10, "paused": true, "events": []string{"pins", "status"}})
+	var start StreamStart
+	if c.recv(t, &start); !start.Success || start.Program != "squarewave" {
+		t.Fatalf("expected stream to start, got %+v", start)
+	}
+	var events []stream.Event
+	if c.recv(t, &events); len(events) != 2 || events[0].State != "paused" {
+		t.Fatalf("expected paused status and pins, got %+v", events)
+	}
+	c.send(t, stream.Control{Type: "step", Count: 4})
+	if c.recv(t, &events); len(events) == 0 || events[0].Type != "pins" {
+		t.Fatalf("expected pin changes from stepping, got %+v", events)
+	}
+	c.send(t, stream.Control{Type: "warp"})
+	if c.recv(t, &events); len(events) != 1 || !strings.Contains(events[0].Error, "unknown control") {
+		t.Fatalf("expected an error event, got %+v", events)
+	}
+	c.send(t, stream.Control{Type: "stop"})
+	if c.recv(t, &events); len(events) != 1 || events[0].State != "done" {
+		t.Fatalf("expected done status, got %+v", events)
+	}
+}
+
+func TestHandleStream_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleStream))
+	defer srv.Close()
+	for _, tt := range []struct {
+		req  map[string]any
+		want string
+	}{
+		{map[string]any{"source": "jmp nowhere"}, "nowhere"},
+		{map[string]any{"source": squarewave, "rate": -5}, "rate"},
+		{map[string]any{"source": squarewave, "events": []string{"beep"}}, "unknown event type"},
+	} {
+		c := dialStream(t, srv.URL)
+		c.send(t, tt.req)
+		var start StreamStart
+		if c.recv(t, &start); start.Success || !strings.Contains(start.Errors[0], tt.want) {
+			t.Errorf("%v: expected error containing %q, got %+v", tt.req, tt.want, start)
+		}
+	}
+}
+
+func TestHostileConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleStream))
+	defer srv.Close()
+	store := &debugSessions{sessions: map[string]*debugEntry{}, now: time.Now}
+	for _, tt := range []struct {
+		config, want string
+	}{
+		{`{"side_set": {"bits": 10}}`, "does not fit in 5 bits"},
+		{`{"out_count": 1000000000}`, "out_count 1000000000 out of range 0-32"},
+		{`{"in_base": -4}`, "in_base -4 out of range 0-31"},
+		{`{"wrap": 40}`, "wrap 40 out of range 0-31"},
+	} {
+		config := json.RawMessage(tt.config)
+		errs := map[string][]string{
+			"simulate": simulatePIO(SimulateRequest{Source: squarewave, Config: config}).Errors,
+			"waveform": waveformPIO(WaveformRequest{SimulateRequest: SimulateRequest{Source: squarewave, Config: config}}).Errors,
+			"block":    simulateBlock(BlockRequest{Source: squarewave, SMs: []BlockSM{{Config: config}}}).Errors,
+			"debug":    debugPIO(store, DebugRequest{Action: "create", Source: squarewave, Config: config}).Errors,
+		}
+		c := dialStream(t, srv.URL)
+		c.send(t, map[string]any{"source": squarewave, "config": config})
+		var start StreamStart
+		c.recv(t, &start)
+		errs["stream"] = start.Errors
+		for endpoint, e := range errs {
+			if len(e) == 0 || !strings.Contains(e[0], tt.want) {
+				t.Errorf("%s %s: expected error containing %q, got %q", endpoint, tt.config, tt.want, e)
+			}
+		}
+	}
+	if len(store.sessions) != 0 {
+		t.Fatalf("expected hostile configs to leave no debug sessions, have %d", len(store.sessions))
+	}
+}
+
+func TestDisassemblePIO_CHeader(t *testing.T) {
+	header := `static const uint16_t ws2812_program_instructions[] = {
+            //     .wrap_target
+    0x6221, //  0: out    x, 1            side 0 [2]
+    0x1123, //  1: jmp    !x, 3           side 1 [1]
+    0x1400, //  2: jmp    0               side 1 [4]
+    0xa442, //  3: nop                    side 0 [4]
+            //     .wrap
+};`
+	wt, wrap := 0, 3
+	req := DisassembleRequest{Hex: header}
+	req.Name, req.SideSet.Bits, req.WrapTarget, req.Wrap = "ws2812", 1, &wt, &wrap
+	result := disassemblePIO(req)
+	if !result.Success || len(result.Instructions) != 4 {
+		t.Fatalf("expected 4 instructions, got %+v", result)
+	}
+	compiled := compilePIO(result.Source, "hex", "")
+	if !compiled.Success || compiled.Hex != "6221\n1123\n1400\na442\n" {
+		t.Fatalf("expected the source to reassemble to the same words, got %+v\n%s", compiled, result.Source)
+	}
+}
+
+func TestDisassemblePIO_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		req  DisassembleRequest
+		want string
+	}{
+		{DisassembleRequest{Hex: "e081", Words: []uint16{0xe081}}, "not both"},
+		{DisassembleRequest{Hex: "e081\nzz"}, `invalid instruction word "zz"`},
+		{DisassembleRequest{Hex: "0x12345"}, "invalid instruction word"},
+		{DisassembleRequest{}, "no instructions"},
+	} {
+		if r := disassemblePIO(tt.req); r.Success || !strings.Contains(r.Errors[0], tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %+v", tt.req, tt.want, r)
+		}
+	}
+	if r := disassemblePIO(DisassembleRequest{Hex: "e081\n0001\n"}); !r.Success || !strings.Contains(r.Source, "jmp label_1") {
+		t.Fatalf("expected pioasm hex output to disassemble, got %+v", r)
+	}
+}
+
+func TestVerify_Golden(t *testing.T) {
+	sources, err := verifyCorpus("testdata/corpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) <= len(examples) {
+		t.Fatalf("expected corpus files besides the %d examples, got %d sources", len(examples), len(sources))
+	}
+	results, err := verifySources(sources, "testdata/golden", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if len(r.Diffs) > 0 {
+			t.Errorf("%s differs from its golden file (run go run ./cmd/tinypio verify -update if intended):\n%s",
+				r.Name, strings.Join(r.Diffs, "\n"))
+		}
+	}
+}
+
+func TestVerify_Diff(t *testing.T) {
+	dir := t.TempDir()
+	golden := `program ws2812
+side_set 1 opt
+origin -1
+wrap 0 2
+ 0: 6221
+ 1: 1124
+ 2: 1400
+ 3: a442
+`
+	if err := os.WriteFile(dir+"/ws2812.golden", []byte(golden), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sources := []verifySource{{Name: "ws2812", Source: examples[1].Source}, {Name: "squarewave", Source: examples[0].Source}}
+	results, err := verifySources(sources, dir, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(results[0].Diffs, "\n")
+	for _, want := range []string{
+		"ws2812 side-set: golden .side_set 1 opt, native .side_set 1",
+		"ws2812 wrap: golden 0..2, native 0..3",
+		"ws2812 word 1:\n    golden 1124  jmp !x, 4 side 0 [1]\n    native 1123  jmp !x, 3 side 1 [1]",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected diff to contain %q, got:\n%s", want, got)
+		}
+	}
+	if len(results[1].Diffs) != 1 || !strings.Contains(results[1].Diffs[0], "no golden file") {
+		t.Fatalf("expected a missing golden file to be reported, got %v", results[1].Diffs)
+	}
+
+	if _, err := verifySources(sources, dir, "", true); err != nil {
+		t.Fatal(err)
+	}
+	results, _ = verifySources(sources, dir, "", false)
+	if len(results[0].Diffs)+len(results[1].Diffs) != 0 {
+		t.Fatalf("expected update to rewrite the golden files, got %+v", results)
+	}
+}
+
+func TestParsePioasmCSDK(t *testing.T) {
+	out := `// -------------------------------------------------- //
+// This file is autogenerated by pioasm; do not edit! //
+// -------------------------------------------------- //
+
+#pragma once
+
+// ------ //
+// ws2812 //
+// ------ //
+
+#define ws2812_wrap_target 0
+#define ws2812_wrap 3
+#define ws2812_pio_version 0
+
+static const uint16_t ws2812_program_instructions[] = {
+            //     .wrap_target
+    0x6221, //  0: out    x, 1            side 0 [2]
+    0x1123, //  1: jmp    !x, 3           side 1 [1]
+    0x1400, //  2: jmp    0               side 1 [4]
+    0xa442, //  3: nop                    side 0 [4]
+            //     .wrap
+};
+
+#if !PICO_NO_HARDWARE
+static const struct pio_program ws2812_program = {
+    .instructions = ws2812_program_instructions,
+    .length = 4,
+    .origin = -1,
+    .pio_version = ws2812_pio_version,
+};
+
+static inline pio_sm_config ws2812_program_get_default_config(uint offset) {
+    pio_sm_config c = pio_get_default_sm_config();
+    sm_config_set_wrap(&c, offset + ws2812_wrap_target, offset + ws2812_wrap);
+    sm_config_set_sideset(&c, 2, true, false);
+    return c;
+}
+#endif
+`
+	progs, err := parsePioasmCSDK(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goldenProgram{Name: "ws2812", SideSet: asm.SideSet{Bits: 1, Opt: true}, Origin: -1, WrapTarget: 0, Wrap: 3,
+		Words: []uint16{0x6221, 0x1123, 0x1400, 0xa442}}
+	if diffs := diffGolden("pioasm", []goldenProgram{want}, progs); len(progs) != 1 || len(diffs) != 0 {
+		t.Fatalf("unexpected programs %+v: %v", progs, diffs)
+	}
+}
+
+func TestFormatPIO_Corpus(t *testing.T) {
+	corpusRoundTrip(t, "formatting", func(s verifySource) (string, bool) {
+		result := formatPIO(FormatRequest{Source: s.Source})
+		if !result.Success {
+			t.Errorf("%s: %v", s.Name, result.Errors)
+			return "", false
+		}
+		if again := formatPIO(FormatRequest{Source: result.Source}); again.Changed {
+			t.Errorf("%s: formatting is not idempotent:\n%s", s.Name, again.Source)
+		}
+		return result.Source, true
+	})
+}
+
+// corpusRoundTrip converts each corpus source and all_ops back to PIO
+// source with convert, and checks that the result compiles for rp2350 to
+// the same hex output as the original. convert reports its own errors
+// and returns false to skip the comparison.
+func corpusRoundTrip(t *testing.T, what string, convert func(verifySource) (string, bool)) {
+	t.Helper()
+	sources, err := verifyCorpus("testdata/corpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources = append(sources, verifySource{Name: "all_ops", Source: allOps})
+	for _, s := range sources {
+		want := compilePIO(s.Source, "hex", "rp2350")
+		if !want.Success {
+			t.Errorf("%s: does not compile: %v", s.Name, want.Errors)
+			continue
+		}
+		source, ok := convert(s)
+		if !ok {
+			continue
+		}
+		if got := compilePIO(source, "hex", "rp2350"); !got.Success || got.Hex != want.Hex {
+			t.Errorf("%s: %s changed the hex output from\n%s to\n%s%v\nsource:\n%s", s.Name, what, want.Hex, got.Hex, got.Errors, source)
+		}
+	}
+}
+
+func TestHandleFormat(t *testing.T) {
+	body := `{"source": ".program p\nSET pins,1 [1]\n"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/format", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handleFormat(w, req)
+
+	var result FormatResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || !result.Changed || result.Source != ".program p\n    set pins, 1 [1]\n" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if r := formatPIO(FormatRequest{Source: "set x, (1"}); r.Success || !strings.Contains(r.Errors[0], "line 1") {
+		t.Fatalf("expected a syntax error, got %+v", r)
+	}
+}
+
+func TestFmtCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/blink.pio"
+	if err := os.WriteFile(path, []byte(".program blink\nset pins,1\nset pins,0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	diff := unifiedDiff(path, ".program blink\nset pins,1\nset pins,0\n", ".program blink\n    set pins, 1\n    set pins, 0\n")
+	want := "--- " + path + ".orig\n+++ " + path + "\n@@ -1,3 +1,3 @@\n .program blink\n-set pins,1\n-set pins,0\n+    set pins, 1\n+    set pins, 0\n"
+	if diff != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", diff, want)
+	}
+	if err := cmdFmt([]string{"-w", path}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != ".program blink\n    set pins, 1\n    set pins, 0\n" {
+		t.Fatalf("unexpected formatted file:\n%s", data)
+	}
+	if err := cmdFmt([]string{"-w"}); err == nil {
+		t.Fatal("expected -w without files to fail")
+	}
+}
+
+func TestCompilePIO_C(t *testing.T) {
+	src := exampleSource(t, "ws2812") + "\n% c-sdk {\nstatic inline void ws2812_init(void) {}\n%}\n"
+	result := compilePIO(src, "c", "")
+	if !result.Success {
+		t.Fatalf("compile failed: %v", result.Errors)
+	}
+	progs, err := parsePioasmCSDK(result.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []goldenProgram{goldenOf(result.Programs[0])}
+	if diffs := diffGolden("c", want, progs); len(diffs) > 0 {
+		t.Fatalf("C output does not match the program:\n%s\n%s", strings.Join(diffs, "\n"), result.C)
+	}
+	for _, s := range []string{"#define ws2812_wrap 3", "0x6221, //  0: out x, 1 side 0 [2]", "static inline void ws2812_init(void) {}\n#endif"} {
+		if !strings.Contains(result.C, s) {
+			t.Errorf("expected C output to contain %q:\n%s", s, result.C)
+		}
+	}
+}
+
+func TestCompilePIO_CConfig(t *testing.T) {
+	src := `.define public GLOBAL 7
+.program par
+.pio_version 1
+.define public LOCAL 3
+.out 8 left auto 8
+.in 2
+.fifo txput
+.mov_status irq next set 2
+.clock_div 2
+    out pins, 8
+`
+	result := compilePIO(src, "c", "rp2350")
+	if !result.Success {
+		t.Fatalf("compile failed: %v", result.Errors)
+	}
+	for _, s := range []string{
+		"#endif\n\n#define GLOBAL 7\n",
+		"#define par_LOCAL 3\n",
+		"sm_config_set_fifo_join(&c, PIO_FIFO_JOIN_TXPUT);",
+		"sm_config_set_mov_status(&c, STATUS_IRQ_SET, 18);",
+		"sm_config_set_in_pin_count(&c, 2);\n    sm_config_set_in_shift(&c, true, false, 32);",
+		"sm_config_set_out_shift(&c, false, true, 8);",
+		"sm_config_set_clkdiv(&c, 2.0f);",
+	} {
+		if !strings.Contains(result.C, s) {
+			t.Errorf("expected C output to contain %q:\n%s", s, result.C)
+		}
+	}
+	if strings.Contains(result.C, "par_GLOBAL") {
+		t.Errorf("expected the global define once, without a prefix:\n%s", result.C)
+	}
+}
+
+func TestCompilePIO_UnknownFormat(t *testing.T) {
+	result := compilePIO(squarewave, "java", "")
+	if result.Success || len(result.Errors) != 1 || !strings.Contains(result.Errors[0], `unknown format "java"`) {
+		t.Fatalf("expected an unknown format error, got %+v", result)
+	}
+	if result := compilePIO(squarewave, "", ""); !result.Success || result.Hex == "" {
+		t.Fatalf("expected hex by default, got %+v", result)
+	}
+}
+
+const allOps = `.program all_ops
+.side_set 2 opt pindirs
+.define public DEPTH 4
+    wait 0 gpio 5 side 3
+    wait 1 pin 2
+    wait 1 irq 3 rel
+public entry:
+    in isr, 32
+    in null, 5 side 1
+    out exec, 16
+    push iffull noblock
+    pull ifempty
+    mov pins, !x [1]
+    mov isr, ::osr
+    mov exec, status
+    irq wait 1 rel
+    irq clear 7
+    irq set 0 rel
+    set pindirs, 31 side 0 [3]
+    jmp x!=y, 2
+    jmp !osre, 0
+    nop
+`
+
+func TestCompilePIO_Go(t *testing.T) {
+	src := allOps + `% go {
+package drivers
+
+import (
+	"machine"
+
+	pio "github.com/tinygo-org/pio/rp2-pio"
+)
+
+func allOpsPin() machine.Pin { return machine.NoPin }
+%}
+.program v1
+.pio_version 1
+    mov rxfifo[y], isr
+    irq next set 1
+    set x, 1
+`
+	result := compilePIO(src, "go", "rp2350")
+	if !result.Success {
+		t.Fatalf("compile failed: %v", result.Errors)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "gen.go", result.Go, 0); err != nil {
+		t.Fatalf("generated Go does not parse: %v\n%s", err, result.Go)
+	}
+	for _, s := range []string{
+		"//go:build rp2350\n\npackage drivers\n",
+		"\t\"machine\"\n",
+		"AllOpsOrigin      = -1",
+		"AllOpsOffsetEntry = 3",
+		"AllOpsDEPTH       = 4",
+		"var allOpsAssembler = pio.AssemblerV0{SidesetBits: 3}",
+		"func AllOpsProgramDefaultConfig(offset uint8) pio.StateMachineConfig {",
+		"cfg.SetSidesetParams(3, true, true)",
+		"0x8010, //  0: mov rxfifo[y], isr",
+		"v1Assembler.Set(pio.SetDestX, 1).Encode(), //  2: set x, 1",
+		"func allOpsPin() machine.Pin",
+	} {
+		if !strings.Contains(result.Go, s) {
+			t.Errorf("expected Go output to contain %q:\n%s", s, result.Go)
+		}
+	}
+	if strings.Count(result.Go, "rp2-pio") != 1 {
+		t.Errorf("expected the pio import once:\n%s", result.Go)
+	}
+
+	// Each AssemblerV0 call must encode the instruction word it replaces.
+	words := append(result.Programs[0].Instructions, result.Programs[1].Instructions...)
+	elems := regexp.MustCompile(`(?m)^\t(\w+Assembler\..*?\.Encode\(\)|0x[0-9a-f]{4}),`).FindAllStringSubmatch(result.Go, -1)
+	if len(elems) != len(words) {
+		t.Fatalf("expected %d instructions, got %d:\n%s", len(words), len(elems), result.Go)
+	}
+	literals := 0
+	for i, m := range elems {
+		if strings.HasPrefix(m[1], "0x") {
+			literals++
+			continue
+		}
+		if got := encodeAssemblerV0(t, m[1], 3); got != words[i] {
+			t.Errorf("%s: expected %04x, got %04x", m[1], words[i], got)
+		}
+	}
+	// irq wait, mov rxfifo and irq next have no AssemblerV0 method.
+	if literals != 3 {
+		t.Errorf("expected 3 literal words, got %d:\n%s", literals, result.Go)
+	}
+}
+
+// encodeAssemblerV0 evaluates a generated pio.AssemblerV0 method chain
+// the way the upstream package encodes it.
+func encodeAssemblerV0(t *testing.T, call string, sidesetBits int) uint16 {
+	t.Helper()
+	consts := map[string]uint16{}
+	for _, names := range [][8]string{goJmpConds, goInSrcs, goOutDests, goMovDests, goMovSrcs, goSetDests} {
+		for v, name := range names {
+			if name != "" {
+				consts["pio."+name] = uint16(v)
+			}
+		}
+	}
+	var w uint16
+	for _, m := range regexp.MustCompile(`\.(\w+)\(([^)]*)\)`).FindAllStringSubmatch(call, -1) {
+		var a []uint16
+		for _, arg := range strings.Split(m[2], ", ") {
+			switch v, err := strconv.ParseUint(arg, 0, 8); {
+			case arg == "":
+			case arg == "true" || arg == "false":
+				a = append(a, map[bool]uint16{true: 1}[arg == "true"])
+			case consts[arg] != 0 || strings.HasPrefix(arg, "pio."):
+				a = append(a, consts[arg])
+			case err == nil:
+				a = append(a, uint16(v))
+			default:
+				t.Fatalf("%s: unknown argument %q", call, arg)
+			}
+		}
+		switch m[1] {
+		case "Jmp":
+			w = a[0]<<5 | a[1]&0x1f
+		case "WaitGPIO", "WaitPin":
+			w = 0x2000 | a[0]<<7 | map[string]uint16{"WaitPin": 1}[m[1]]<<5 | a[1]&0x1f
+		case "WaitIRQ":
+			w = 0x2000 | a[0]<<7 | 2<<5 | a[1]<<4 | a[2]&7
+		case "In", "Out", "Set":
+			w = map[string]uint16{"In": 0x4000, "Out": 0x6000, "Set": 0xe000}[m[1]] | a[0]<<5 | a[1]&0x1f
+		case "Push", "Pull":
+			w = map[string]uint16{"Push": 0x8000, "Pull": 0x8080}[m[1]] | a[0]<<6 | a[1]<<5
+		case "Mov", "MovInvert", "MovReverse":
+			w = 0xa000 | a[0]<<5 | map[string]uint16{"MovInvert": 1, "MovReverse": 2}[m[1]]<<3 | a[1]&7
+		case "Nop":
+			w = 0xa042
+		case "IRQSet", "IRQClear":
+			w = 0xc000 | map[string]uint16{"IRQClear": 2}[m[1]]<<5 | a[0]<<4 | a[1]&7
+		case "Side":
+			w |= a[0] << (13 - sidesetBits)
+		case "Delay":
+			w |= (a[0] & 0x1f) << 8
+		case "Encode":
+		default:
+			t.Fatalf("%s: unknown method %s", call, m[1])
+		}
+	}
+	return w
+}
+
+// captureStdout returns what fn writes to standard output.
+func TestCompilePIO_Python(t *testing.T) {
+	result := compilePIO(allOps, "python", "")
+	if !result.Success {
+		t.Fatal(result.Errors)
+	}
+	for _, want := range []string{
+		"all_ops_offset_entry = 3\nall_ops_DEPTH = 4\n",
+		"@rp2.asm_pio(sideset_init=(rp2.PIO.OUT_LOW,) * 2, side_pindir=True)\ndef all_ops():\n",
+		`    label("label_0")` + "\n    wait(0, gpio, 5)     .side(3)\n",
+		"    push(iffull, noblock)\n",
+		"    mov(pins, invert(x))          [1]\n",
+		"    irq(block, rel(1))\n",
+		`    jmp(x_not_y, "label_2")`,
+	} {
+		if !strings.Contains(result.Python, want) {
+			t.Errorf("expected %q in:\n%s", want, result.Python)
+		}
+	}
+
+	src := `.pio_version 1
+.program cfg
+.out 2 left auto 24
+.set 3
+.fifo tx
+.origin 4
+.lang_opt python out_init = rp2.PIO.OUT_HIGH
+    out pins, 2
+    set pins, 1
+    mov rxfifo[y], isr
+`
+	result = compilePIO(src, "python", "rp2350")
+	if !result.Success {
+		t.Fatal(result.Errors)
+	}
+	want := "@rp2.asm_pio(out_init=rp2.PIO.OUT_HIGH, set_init=(rp2.PIO.OUT_LOW,) * 3, out_shiftdir=rp2.PIO.SHIFT_LEFT, autopull=True, pull_thresh=24, fifo_join=rp2.PIO.JOIN_TX)"
+	if !strings.Contains(result.Python, want) || !strings.Contains(result.Python, "word(0x8010) # mov rxfifo[y], isr") {
+		t.Fatalf("unexpected python output:\n%s", result.Python)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], ".origin 4 is ignored") {
+		t.Fatalf("expected an origin warning, got %v", result.Warnings)
+	}
+}
+
+func TestCompilePIO_Rust(t *testing.T) {
+	src := `.pio_version 1
+.define public DEPTH 4
+; Shifts bytes out.
+.program shifter
+.out 8 right auto 24
+.fifo tx
+.clock_div 2.5
+public entry:
+    out pins, 8 ; "byte"
+    jmp entry
+% c-sdk {
+static inline void shifter_init() {}
+%}
+`
+	result := compilePIO(src, "rust", "rp2350")
+	if !result.Success {
+		t.Fatal(result.Errors)
+	}
+	for _, want := range []string{
+		"use rp235x_hal::pio::{Buffers, PIOBuilder, PIOExt, ShiftDirection};\n",
+		"pub const SHIFTER_OFFSET_ENTRY: u8 = 0;\npub const SHIFTER_DEPTH: i32 = 4;\n",
+		"pub fn shifter() -> pio::Program<{ pio::RP2040_MAX_PROGRAM_SIZE }> {\n    pio_proc::pio_asm!(\n",
+		`        ".define public DEPTH 4",` + "\n" + `        "; Shifts bytes out.",` + "\n",
+		`        "    out pins, 8 ; \"byte\"",` + "\n",
+		"    )\n    .program\n}\n",
+		"/// Applies the .out, .fifo and .clock_div settings of shifter to a state machine builder.\n",
+		"        .out_shift_direction(ShiftDirection::Right)\n        .autopull(true)\n        .pull_threshold(24)\n        .buffers(Buffers::OnlyTx)\n        .clock_divisor_fixed_point(2, 128)\n}\n",
+	} {
+		if !strings.Contains(result.Rust, want) {
+			t.Errorf("expected %q in:\n%s", want, result.Rust)
+		}
+	}
+	if strings.Contains(result.Rust, ".program shifter") || strings.Contains(result.Rust, "shifter_init") {
+		t.Errorf("expected no .program line or code block in:\n%s", result.Rust)
+	}
+
+	result = compilePIO(squarewave, "rust", "")
+	if !result.Success || strings.Contains(result.Rust, "use ") || strings.Contains(result.Rust, "_config") {
+		t.Fatalf("expected no builder config, got %+v", result)
+	}
+}
+
+func TestCompilePIO_CircuitPython(t *testing.T) {
+	result := compilePIO(allOps, "circuitpython", "")
+	if !result.Success {
+		t.Fatal(result.Errors)
+	}
+	for _, want := range []string{
+		"import adafruit_pioasm\n",
+		"all_ops = adafruit_pioasm.assemble(\"\"\"\n.program all_ops\n.side_set 2 opt pindirs\n",
+		"label_0:\n    wait 0 gpio 5",
+		"    jmp x!=y label_2",
+		"all_ops_kwargs = {\n    \"sideset_pin_count\": 2,\n    \"sideset_enable\": True,\n    \"sideset_pindirs\": True,\n",
+	} {
+		if !strings.Contains(result.CircuitPython, want) {
+			t.Errorf("expected %q in:\n%s", want, result.CircuitPython)
+		}
+	}
+
+	// The embedded listings assemble to the same machine code.
+	corpusRoundTrip(t, "circuitpython output", func(s verifySource) (string, bool) {
+		cp := compilePIO(s.Source, "circuitpython", "rp2350")
+		if !cp.Success {
+			t.Errorf("%s: %v", s.Name, cp.Errors)
+			return "", false
+		}
+		var listings strings.Builder
+		for _, prog := range cp.Programs {
+			_, rest, _ := strings.Cut(cp.CircuitPython, prog.Name+" = adafruit_pioasm.assemble(\"\"\"\n")
+			listing, _, _ := strings.Cut(rest, `""")`)
+			listings.WriteString(listing + "\n")
+		}
+		return listings.String(), true
+	})
+
+	src := `.pio_version 1
+.program cfg
+.in 4 left auto 16
+.fifo rx
+.mov_status irq prev set 3
+.clock_div 4
+    in pins, 4
+`
+	result = compilePIO(src, "circuitpython", "rp2350")
+	want := "cfg_kwargs = {\n    \"in_pin_count\": 4,\n    \"in_shift_right\": False,\n    \"auto_push\": True,\n    \"push_threshold\": 16,\n    \"fifo_type\": \"rx\",\n    \"mov_status_type\": \"irq\",\n    \"mov_status_n\": 11,\n}\n"
+	if !result.Success || !strings.Contains(result.CircuitPython, want) {
+		t.Fatalf("unexpected circuitpython output:\n%s", result.CircuitPython)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], ".clock_div 4 is not applied") {
+		t.Fatalf("expected a clock_div warning, got %v", result.Warnings)
+	}
+}
+
+func TestImportPIO_RoundTrip(t *testing.T) {
+	// Python output imports back to the same machine code and
+	// configuration.
+	corpusRoundTrip(t, "python import", func(s verifySource) (string, bool) {
+		py := compilePIO(s.Source, "python", "rp2350")
+		if !py.Success {
+			t.Errorf("%s: %v", s.Name, py.Errors)
+			return "", false
+		}
+		result := importPIO(ImportRequest{Source: py.Python, Target: "rp2350"})
+		if !result.Success || !result.Validation.Valid {
+			t.Errorf("%s: import failed: %+v\n%s", s.Name, result, py.Python)
+			return "", false
+		}
+		if want, got := pythonConfig(t, s.Source), pythonConfig(t, result.Source); want != got {
+			t.Errorf("%s: import changed the configuration from\n%s to\n%s\nsource:\n%s", s.Name, want, got, result.Source)
+		}
+		return result.Source, true
+	})
+}
+
+// pythonConfig describes the configuration of each RP2350 program of a
+// source that a MicroPython decorator carries. The .in pin count is left
+// out, since MicroPython has no setting for it.
+func pythonConfig(t *testing.T, source string) string {
+	t.Helper()
+	file, err := asm.Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progs, err := asm.AssembleAll(file, asm.TargetRP2350)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, p := range progs {
+		fmt.Fprintf(&b, "%s: %v wrap %d-%d set %d", p.Name, p.SideSet, p.WrapTarget, p.Wrap, p.SetCount)
+		if p.In != nil {
+			in := *p.In
+			in.Count = 0
+			fmt.Fprintf(&b, " in %+v", in)
+		}
+		if p.Out != nil {
+			fmt.Fprintf(&b, " out %+v", *p.Out)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestImportPIO(t *testing.T) {
+	result := importPIO(ImportRequest{Source: "@rp2.asm_pio()\ndef p():\n    set(x, 40)\n"})
+	if !result.Success || result.Validation.Valid || !strings.Contains(result.Source, "set x, 40") {
+		t.Fatalf("expected the source with a validation error, got %+v", result)
+	}
+	result = importPIO(ImportRequest{Source: "def p():\n    pass\n"})
+	if result.Success || len(result.Errors) != 1 {
+		t.Fatalf("expected an import error, got %+v", result)
+	}
+
+	dir := writeSources(t, map[string]string{"blink.py": "@rp2.asm_pio()\ndef blink():\n    set(pins, 1) [31]\n    set(pins, 0) [31]\n"})
+	var err error
+	out := captureStdout(t, func() { err = cmdImport([]string{dir + "/blink.py"}) })
+	if err != nil || out != ".program blink\n    set pins, 1 [31]\n    set pins, 0 [31]\n" {
+		t.Fatalf("unexpected import output (%v):\n%s", err, out)
+	}
+}
+
+func TestImportPIO_CHeader(t *testing.T) {
+	// C headers import back to the same machine code.
+	corpusRoundTrip(t, "c import", func(s verifySource) (string, bool) {
+		c := compilePIO(s.Source, "c", "rp2350")
+		if !c.Success {
+			t.Errorf("%s: %v", s.Name, c.Errors)
+			return "", false
+		}
+		result := importPIO(ImportRequest{Source: c.C, Target: "rp2350"})
+		if !result.Success || result.Format != "c" || !result.Validation.Valid {
+			t.Errorf("%s: import failed: %+v\n%s", s.Name, result, c.C)
+			return "", false
+		}
+		return result.Source, true
+	})
+}
+
+func TestImportPIO_Formats(t *testing.T) {
+	for _, tt := range []struct {
+		source, format, want string
+	}{
+		{"@rp2.asm_pio()\ndef p():\n    nop()\n", "python", ".program p\n    nop\n"},
+		{"var BlinkInstructions = []uint16{0xe001, 0x0000}\n", "go", ".program blink\n"},
+		{"00: e001\n01: 0000\n", "hex", "label_0:\n    set pins, 1"},
+	} {
+		result := importPIO(ImportRequest{Source: tt.source})
+		if !result.Success || result.Format != tt.format || !strings.Contains(result.Source, tt.want) {
+			t.Errorf("%q: expected %s source containing %q, got %+v", tt.source, tt.format, tt.want, result)
+		}
+	}
+	if result := importPIO(ImportRequest{Source: "e001", Format: "rust"}); result.Success || !strings.Contains(result.Errors[0], `unknown format "rust"`) {
+		t.Fatalf("expected an unknown format error, got %+v", result)
+	}
+	if result := importPIO(ImportRequest{Source: "e001", Format: "go"}); result.Success || result.Format != "go" {
+		t.Fatalf("expected the go format to fail on a hex dump, got %+v", result)
+	}
+
+	dir := writeSources(t, map[string]string{"dump.txt": "e001\n0000\n", "x.go": "var x = []uint16{0x0000, 0xa042}\n"})
+	var err error
+	out := captureStdout(t, func() { err = cmdImport([]string{"-format", "hex", dir + "/dump.txt"}) })
+	if err != nil || !strings.Contains(out, "    jmp label_0") {
+		t.Fatalf("unexpected import output (%v):\n%s", err, out)
+	}
+	out = captureStdout(t, func() { err = cmdImport([]string{dir + "/x.go"}) })
+	if err != nil || !strings.Contains(out, "label_0:\n    jmp label_0") || !strings.Contains(out, "    nop") {
+		t.Fatalf("unexpected import output for a plain []uint16 literal (%v):\n%s", err, out)
+	}
+}
+
+func TestTimingPIO(t *testing.T) {
+	// ws2812 takes 10 cycles per bit either way, so 800 kHz needs an
+	// 8 MHz state machine clock: 125 MHz / 15 160/256 exactly.
+	result := timingPIO(TimingRequest{Source: exampleSource(t, "ws2812"), Baud: 800e3})
+	if !result.Success || len(result.Loops) != 2 || result.Cycles != 10 || result.SysClock != 125e6 {
+		t.Fatalf("unexpected ws2812 timing %+v", result)
+	}
+	d := result.Divider
+	if d.Int != 15 || d.Frac != 160 || d.Frequency != 8e6 || d.Error != 0 || d.Jitter != 8 || result.BitRate != 800e3 {
+		t.Fatalf("unexpected divider %+v at %g bit/s", d, result.BitRate)
+	}
+	if result.IntegerDivider == nil || result.IntegerDivider.Int != 16 || result.Loops[0].Nanoseconds != 1250 {
+		t.Fatalf("expected an integer divider of 16 and 1.25 µs loops, got %+v %+v", result.IntegerDivider, result.Loops)
+	}
+
+	// uart_tx's shortest loop is the 8-cycle bit loop; 150 MHz on rp2350
+	// divides to 115200 baud with a fractional divider.
+	result = timingPIO(TimingRequest{Source: exampleSource(t, "uart_tx"), Target: "rp2350", Baud: 115200})
+	if !result.Success || result.Cycles != 8 || result.SysClock != 150e6 || len(result.Loops) != 2 || result.Loops[0].Cycles != 31 {
+		t.Fatalf("unexpected uart_tx timing %+v", result)
+	}
+	if d := result.Divider; d.Int != 162 || d.Frac != 195 || math.Abs(d.Error) > 0.001 || math.Abs(result.BitRate-115200) > 1 {
+		t.Fatalf("unexpected divider %+v at %g baud", d, result.BitRate)
+	}
+
+	// A state machine clock, or the program's own .clock_div.
+	result = timingPIO(TimingRequest{Source: squarewave, Frequency: 1e6, SysClock: 48e6})
+	if !result.Success || result.Divider.Int != 48 || result.Divider.Jitter != 0 || result.IntegerDivider != nil {
+		t.Fatalf("unexpected squarewave timing %+v", result)
+	}
+	result = timingPIO(TimingRequest{Source: ".program p\n.clock_div 2.5\n    nop [3]\n", Target: "rp2350"})
+	if !result.Success || result.Divider.Value != 2.5 || result.Divider.Frequency != 60e6 || result.Loops[0].Nanoseconds != 4/60e6*1e9 {
+		t.Fatalf("unexpected .clock_div timing %+v", result)
+	}
+	// Without a target, .pio_version 1 picks the RP2350 clock; the largest
+	// divider the assembler accepts is encoded as int 0.
+	result = timingPIO(TimingRequest{Source: ".program p\n.pio_version 1\n.clock_div 65536\n    nop\n"})
+	if !result.Success || result.SysClock != 150e6 || result.Divider.Int != 0 || result.Divider.Value != 65536 {
+		t.Fatalf("unexpected .clock_div 65536 timing %+v", result)
+	}
+
+	for _, tt := range []struct {
+		req  TimingRequest
+		want string
+	}{
+		{TimingRequest{Source: squarewave, Frequency: 1e6, Baud: 9600}, "not both"},
+		{TimingRequest{Source: squarewave, Frequency: 200e6}, "above the 1.25e+08 Hz system clock"},
+		{TimingRequest{Source: squarewave, Cycles: -1}, "cycles must be positive"},
+		{TimingRequest{Source: ".program p\n    out pc, 5\n", Baud: 9600}, "no loops to time"},
+		{TimingRequest{Source: squarewave, Target: "rp2060"}, "rp2060"},
+	} {
+		if result := timingPIO(tt.req); result.Success || !strings.Contains(strings.Join(result.Errors, "\n"), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %+v", tt.req, tt.want, result)
+		}
+	}
+}
+
+func TestHandleTiming(t *testing.T) {
+	body := `{"source": ".program p\nloop:\n    jmp loop [9]\n", "frequency": 1000000}`
+	req := httptest.NewRequest(http.MethodPost, "/api/timing", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handleTiming(w, req)
+	var result TimingResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || result.Divider.Int != 125 || result.Loops[0].Label != "loop" || result.Loops[0].Nanoseconds != 10000 || result.BitRate != 1e5 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	w = httptest.NewRecorder()
+	handleTiming(w, httptest.NewRequest(http.MethodGet, "/api/timing", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+func writeSources(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(dir+"/"+name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestValidateCommand(t *testing.T) {
+	dir := writeSources(t, map[string]string{"good.pio": squarewave, "bad.pio": ".program bad\nset y, 99\n"})
+	var err error
+	out := captureStdout(t, func() { err = cmdValidate([]string{dir + "/*.pio"}) })
+	if err == nil || err.Error() != "1 of 2 files are invalid" {
+		t.Fatalf("expected one invalid file, got %v", err)
+	}
+	for _, want := range []string{dir + "/bad.pio:2:8: value 99 out of range 0-31\n", dir + "/good.pio: ok (1 program, "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	out = captureStdout(t, func() { err = cmdValidate([]string{"-json", dir + "/good.pio"}) })
+	var results []struct {
+		File  string `json:"file"`
+		Valid bool   `json:"valid"`
+	}
+	if err != nil || json.Unmarshal([]byte(out), &results) != nil || len(results) != 1 || !results[0].Valid {
+		t.Fatalf("unexpected JSON output (%v):\n%s", err, out)
+	}
+	if err := cmdValidate([]string{dir + "/*.asm"}); err == nil || !strings.Contains(err.Error(), "no files match") {
+		t.Fatalf("expected an empty glob to fail, got %v", err)
+	}
+}
+
+func TestCompileCommand(t *testing.T) {
+	dir := writeSources(t, map[string]string{"a.pio": squarewave, "b.pio": exampleSource(t, "ws2812")})
+	out := t.TempDir()
+	if err := cmdCompile([]string{"-format", "go", "-o", out, dir + "/*.pio"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.go", "b.go"} {
+		data, err := os.ReadFile(out + "/" + name)
+		if err != nil || !strings.Contains(string(data), "package main") {
+			t.Fatalf("expected %s to hold Go output (%v):\n%s", name, err, data)
+		}
+	}
+	var err error
+	hex := captureStdout(t, func() { err = cmdCompile([]string{dir + "/b.pio"}) })
+	if err != nil || hex != "6221\n1123\n1400\na442\n" {
+		t.Fatalf("unexpected hex output (%v):\n%s", err, hex)
+	}
+	if err := cmdCompile([]string{"-o", dir + "/a.pio", dir + "/*.pio"}); err == nil || !strings.Contains(err.Error(), "must be a directory") {
+		t.Fatalf("expected -o to need a directory, got %v", err)
+	}
+	if err := cmdCompile([]string{"-format", "java", dir + "/a.pio"}); err == nil {
+		t.Fatal("expected an unknown format to fail")
+	}
+}
+
+func TestSimCommand(t *testing.T) {
+	dir := writeSources(t, map[string]string{"sq.pio": squarewave})
+	var err error
+	out := captureStdout(t, func() { err = cmdSim([]string{"-cycles", "3", "-set-count", "1", dir + "/sq.pio"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 4 || !strings.Contains(lines[1], "set pindirs, 1") {
+		t.Fatalf("expected a header and 3 cycles, got:\n%s", out)
+	}
+	out = captureStdout(t, func() { err = cmdSim([]string{"-json", "-cycles", "2", dir + "/sq.pio"}) })
+	var result SimulateResult
+	if err != nil || json.Unmarshal([]byte(out), &result) != nil || len(result.Cycles) != 2 {
+		t.Fatalf("unexpected JSON output (%v):\n%s", err, out)
+	}
+	if err := cmdSim([]string{"-cycles", "100000", dir + "/sq.pio"}); err == nil {
+		t.Fatal("expected too many cycles to fail")
+	}
+}
+
+func TestScaffoldPIO(t *testing.T) {
+	result := scaffoldPIO(ScaffoldRequest{
+		Source:    exampleSource(t, "uart_tx"),
+		Name:      "UART",
+		Package:   "drivers",
+		Pins:      []ScaffoldPin{{Name: "tx", Role: "sideset"}, {Name: "tx", Role: "out"}},
+		Frequency: 8 * 115200,
+		DMA:       true,
+	})
+	if !result.Success || len(result.Warnings) > 0 {
+		t.Fatalf("scaffold failed: %v %v", result.Errors, result.Warnings)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "uart.go", result.Go, 0); err != nil {
+		t.Fatalf("generated Go does not parse: %v\n%s", err, result.Go)
+	}
+	for _, s := range []string{
+		"package drivers\n",
+		"var UartTxInstructions = []uint16{",
+		"func NewUART(sm pio.StateMachine, tx machine.Pin) (*UART, error) {",
+		"pio.ClkDivFromFrequency(921600, machine.CPUFrequency())",
+		"offset, err := Pio.AddProgram(UartTxInstructions, UartTxOrigin)",
+		"sm.SetPindirsConsecutive(tx, 1, true)",
+		"cfg.SetOutPins(tx, 1)\n\tcfg.SetSidesetPins(tx)\n",
+		"cfg.SetFIFOJoin(pio.FifoJoinTx)",
+		"func (d *UART) Put(v uint32) {",
+		"return d.dma.Push32(&d.sm.TxReg().Reg, buf, d.dreq())",
+	} {
+		if !strings.Contains(result.Go, s) {
+			t.Errorf("expected the driver to contain %q:\n%s", s, result.Go)
+		}
+	}
+	if strings.Count(result.Go, ".Configure(") != 1 || strings.Contains(result.Go, "Get()") {
+		t.Errorf("expected one pin setup and no RX helpers:\n%s", result.Go)
+	}
+
+	// The program's directives give pin counts, the shift setup, the
+	// FIFO join and the clock divider.
+	result = scaffoldPIO(ScaffoldRequest{
+		Source: ".program par\n.pio_version 1\n.out 8 left auto 8\n.fifo txput\n.clock_div 2.5\n    out pins, 8\n",
+		Target: "rp2350",
+		Pins:   []ScaffoldPin{{Name: "data", Role: "out"}},
+	})
+	for _, s := range []string{
+		"//go:build rp2350\n",
+		"(data + i).Configure",
+		"cfg.SetOutPins(data, 8)",
+		"cfg.SetOutShift(false, true, 8)",
+		"cfg.SetFIFOJoin(pio.FifoJoinRxPut)",
+		"cfg.SetClkDivIntFrac(2, 128)",
+	} {
+		if !strings.Contains(result.Go, s) {
+			t.Errorf("expected the driver to contain %q:\n%s", s, result.Go)
+		}
+	}
+}
+
+func TestScaffoldPIO_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		req  ScaffoldRequest
+		want string
+	}{
+		{ScaffoldRequest{Source: squarewave, Name: "lower"}, "not an exported Go identifier"},
+		{ScaffoldRequest{Source: squarewave, Pins: []ScaffoldPin{{Name: "cfg", Role: "set"}}}, "not a usable Go identifier"},
+		{ScaffoldRequest{Source: squarewave, Pins: []ScaffoldPin{{Name: "a", Role: "clock"}}}, "unknown role"},
+		{ScaffoldRequest{Source: squarewave, Pins: []ScaffoldPin{{Name: "a", Role: "set"}, {Name: "b", Role: "set"}}}, "more than one pin"},
+		{ScaffoldRequest{Source: squarewave, Pins: []ScaffoldPin{{Name: "a", Role: "sideset"}}}, "no .side_set"},
+		{ScaffoldRequest{Source: "    jmp nowhere"}, "undefined"},
+	} {
+		result := scaffoldPIO(tt.req)
+		if result.Success || !strings.Contains(strings.Join(result.Errors, "\n"), tt.want) {
+			t.Errorf("%+v: expected an error containing %q, got %v", tt.req.Pins, tt.want, result.Errors)
+		}
+	}
+
+	result := scaffoldPIO(ScaffoldRequest{Source: squarewave, Pins: []ScaffoldPin{{Name: "clk", Role: "jmp"}}, DMA: true})
+	if !result.Success || len(result.Warnings) != 3 {
+		t.Fatalf("expected warnings for the set pins, the jmp pin and DMA, got %v %v", result.Errors, result.Warnings)
+	}
+}
+
+func TestScaffoldCommand(t *testing.T) {
+	dir := writeSources(t, map[string]string{"sq.pio": squarewave})
+	var err error
+	out := captureStdout(t, func() { err = cmdScaffold([]string{"-pin", "led=set", "-freq", "2000", dir + "/sq.pio"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "func NewSquarewave(sm pio.StateMachine, led machine.Pin) (*Squarewave, error) {") {
+		t.Fatalf("unexpected driver:\n%s", out)
+	}
+	if err := cmdScaffold([]string{"-pin", "led", dir + "/sq.pio"}); err == nil {
+		t.Fatal("expected a pin without a role to fail")
+	}
+}
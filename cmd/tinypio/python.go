@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/disasm"
+)
+
+// Names of MicroPython's rp2.asm_pio operands, indexed by their encoding.
+// An empty name has no operand and the instruction is written as a word.
+var (
+	pyJmpConds = [8]string{"", "not_x", "x_dec", "not_y", "y_dec", "x_not_y", "pin", "not_osre"}
+	pyInSrcs   = [8]string{"pins", "x", "y", "null", "", "", "isr", "osr"}
+	pyOutDests = [8]string{"pins", "x", "y", "null", "pindirs", "pc", "isr", "exec"}
+	pyMovDests = [8]string{"pins", "x", "y", "", "exec", "pc", "isr", "osr"}
+	pyMovSrcs  = [8]string{"pins", "x", "y", "null", "", "status", "isr", "osr"}
+	pyMovOps   = [4]string{"%s", "invert(%s)", "reverse(%s)", ""}
+	pySetDests = [8]string{"pins", "x", "y", "", "pindirs", "", "", ""}
+	pyWaitSrcs = [4]string{"gpio", "pin", "irq", ""}
+	pyFifoJoin = map[string]string{"tx": "rp2.PIO.JOIN_TX", "rx": "rp2.PIO.JOIN_RX"}
+)
+
+// pythonProgram renders assembled programs as MicroPython @rp2.asm_pio
+// functions, in the layout of pioasm's python output. Public defines and
+// labels become module constants, the decorator arguments are derived
+// from the directives and overridden by .lang_opt python options, and
+// % python code blocks follow their program. The warnings name settings
+// MicroPython cannot express.
+func pythonProgram(file *asm.File, progs []*asm.Program) (string, []string) {
+	var b strings.Builder
+	var warnings []string
+	b.WriteString("# Code generated by tinypio; DO NOT EDIT.\n\n")
+	b.WriteString("import rp2\n")
+	first := firstProgramLine(file)
+	if len(progs) > 0 {
+		global := false
+		for _, d := range progs[0].Defines {
+			if d.Public && d.Line < first {
+				if !global {
+					b.WriteString("\n")
+					global = true
+				}
+				fmt.Fprintf(&b, "%s = %d\n", d.Name, d.Value)
+			}
+		}
+	}
+	units, _ := asm.Split(file)
+	for i, prog := range progs {
+		warnings = append(warnings, writePythonProgram(&b, prog, first)...)
+		if i >= len(units) {
+			continue
+		}
+		for _, stmt := range units[i].Statements {
+			if cb, ok := stmt.(*asm.CodeBlockStmt); ok && cb.Lang == "python" {
+				b.WriteString("\n" + cb.Body)
+			}
+		}
+	}
+	return b.String(), warnings
+}
+
+// writePythonProgram writes the constants and decorated function of a
+// single program. Defines before line first are global and not repeated.
+func writePythonProgram(b *strings.Builder, prog *asm.Program, first int) []string {
+	name := prog.Name
+	if name == "" {
+		name = "program"
+	}
+	banner := strings.Repeat("-", len(name))
+	fmt.Fprintf(b, "\n# %s #\n# %s #\n# %s #\n\n", banner, name, banner)
+	consts := false
+	for _, l := range prog.Labels {
+		if l.Public {
+			fmt.Fprintf(b, "%s_offset_%s = %d\n", name, l.Name, l.Address)
+			consts = true
+		}
+	}
+	for _, d := range prog.Defines {
+		if d.Public && d.Line >= first {
+			fmt.Fprintf(b, "%s_%s = %d\n", name, d.Name, d.Value)
+			consts = true
+		}
+	}
+	if consts {
+		b.WriteString("\n")
+	}
+
+	kwargs, warnings := pythonKwargs(prog)
+	fmt.Fprintf(b, "@rp2.asm_pio(%s)\ndef %s():\n", strings.Join(kwargs, ", "), name)
+
+	// MicroPython jumps to labels only, so targets without one get a
+	// label named after their address.
+	labels := make(map[int][]string)
+	target := make(map[int]string)
+	for _, l := range prog.Labels {
+		labels[l.Address] = append(labels[l.Address], l.Name)
+		if _, ok := target[l.Address]; !ok {
+			target[l.Address] = l.Name
+		}
+	}
+	for _, w := range prog.Instructions {
+		if addr := int(w & 0x1f); w>>13 == 0 && target[addr] == "" {
+			target[addr] = fmt.Sprintf("label_%d", addr)
+			labels[addr] = append(labels[addr], target[addr])
+		}
+	}
+
+	ss := prog.SideSet
+	wrap := prog.WrapTarget != 0 || prog.Wrap != len(prog.Instructions)-1
+	var lines [][]string // code, side and delay cells, or a single line
+	allSide := true
+	for i, w := range prog.Instructions {
+		if wrap && i == prog.WrapTarget {
+			lines = append(lines, []string{"wrap_target()"})
+		}
+		for _, l := range labels[i] {
+			lines = append(lines, []string{fmt.Sprintf("label(%q)", l)})
+		}
+		// The side-set and delay bits are written as .side() and a
+		// delay, as MicroPython counts the instructions that set side to
+		// decide whether side-set is optional.
+		cells := make([]string, 3)
+		field := int(w >> 8 & 0x1f)
+		cells[0] = pythonInstruction(w&^0x1f00, target)
+		if cells[0] == "" {
+			cells[0] = fmt.Sprintf("word(0x%04x)", w&^0x1f00)
+		}
+		switch {
+		case ss.Bits == 0:
+		case !ss.Opt || field&0x10 != 0:
+			cells[1] = fmt.Sprintf(".side(%d)", field>>ss.DelayBits()&(1<<ss.Bits-1))
+		default:
+			allSide = false
+		}
+		if delay := field & ss.MaxDelay(); delay > 0 {
+			cells[2] = fmt.Sprintf("[%d]", delay)
+		}
+		if strings.HasPrefix(cells[0], "word(") {
+			cells = append(cells, "# "+disasm.Decode(w, ss))
+		}
+		lines = append(lines, cells)
+		if wrap && i == prog.Wrap {
+			lines = append(lines, []string{"wrap()"})
+		}
+	}
+	for _, l := range labels[len(prog.Instructions)] {
+		lines = append(lines, []string{fmt.Sprintf("label(%q)", l)})
+	}
+	if len(lines) == 0 {
+		b.WriteString("    pass\n")
+	}
+	writePythonLines(b, lines)
+
+	if ss.Opt && allSide && len(prog.Instructions) > 0 {
+		warnings = append(warnings, fmt.Sprintf("%s: MicroPython only makes side-set optional when an instruction has no side, so every instruction will be assembled without the enable bit", name))
+	}
+	return warnings
+}
+
+// writePythonLines writes function body lines, aligning the side and
+// delay cells of the instructions that have later cells.
+func writePythonLines(b *strings.Builder, lines [][]string) {
+	last := func(cells []string) int {
+		n := 0
+		for j, c := range cells {
+			if c != "" {
+				n = j
+			}
+		}
+		return n
+	}
+	var widths [2]int
+	for _, cells := range lines {
+		for j := 0; j < last(cells) && j < len(widths); j++ {
+			widths[j] = max(widths[j], len(cells[j]))
+		}
+	}
+	for _, cells := range lines {
+		b.WriteString("    ")
+		n := last(cells)
+		for j := 0; j < n; j++ {
+			switch {
+			case j < len(widths) && widths[j] > 0:
+				fmt.Fprintf(b, "%-*s ", widths[j], cells[j])
+			case cells[j] != "":
+				b.WriteString(cells[j] + " ")
+			}
+		}
+		b.WriteString(cells[n] + "\n")
+	}
+}
+
+// pythonKwargs returns the rp2.asm_pio arguments of a program and
+// warnings for directives MicroPython cannot express.
+func pythonKwargs(prog *asm.Program) ([]string, []string) {
+	var names []string
+	values := make(map[string]string)
+	set := func(name, value string) {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = value
+	}
+	pins := func(n int) string {
+		if n == 1 {
+			return "rp2.PIO.OUT_LOW"
+		}
+		return fmt.Sprintf("(rp2.PIO.OUT_LOW,) * %d", n)
+	}
+	shift := func(right bool) string {
+		if right {
+			return "rp2.PIO.SHIFT_RIGHT"
+		}
+		return "rp2.PIO.SHIFT_LEFT"
+	}
+
+	if ss := prog.SideSet; ss.Bits > 0 {
+		set("sideset_init", pins(ss.Bits))
+		if ss.PinDirs {
+			set("side_pindir", "True")
+		}
+	}
+	if prog.Out != nil && prog.Out.Count > 0 {
+		set("out_init", pins(prog.Out.Count))
+	}
+	if prog.SetCount > 0 {
+		set("set_init", pins(prog.SetCount))
+	}
+	// MicroPython shifts left by default where the SDK shifts right.
+	if in := prog.In; in != nil {
+		set("in_shiftdir", shift(in.Right))
+		if in.Auto {
+			set("autopush", "True")
+		}
+		if in.Threshold != 32 {
+			set("push_thresh", fmt.Sprint(in.Threshold))
+		}
+	}
+	if out := prog.Out; out != nil {
+		set("out_shiftdir", shift(out.Right))
+		if out.Auto {
+			set("autopull", "True")
+		}
+		if out.Threshold != 32 {
+			set("pull_thresh", fmt.Sprint(out.Threshold))
+		}
+	}
+
+	name := prog.Name
+	if name == "" {
+		name = "program"
+	}
+	var warnings []string
+	switch join, ok := pyFifoJoin[prog.Fifo]; {
+	case ok:
+		set("fifo_join", join)
+	case prog.Fifo != "" && prog.Fifo != "txrx":
+		warnings = append(warnings, fmt.Sprintf("%s: MicroPython has no FIFO join for .fifo %s", name, prog.Fifo))
+	}
+	if in := prog.In; in != nil && in.Count != 32 {
+		warnings = append(warnings, fmt.Sprintf("%s: MicroPython has no setting for the .in pin count %d", name, in.Count))
+	}
+	if prog.MovStatus != "" {
+		warnings = append(warnings, fmt.Sprintf("%s: MicroPython has no setting for .mov_status %s", name, prog.MovStatus))
+	}
+	if prog.ClockDiv != 0 {
+		warnings = append(warnings, fmt.Sprintf("%s: .clock_div %g is not part of a MicroPython program; pass the frequency to rp2.StateMachine", name, prog.ClockDiv))
+	}
+	if prog.Origin >= 0 {
+		warnings = append(warnings, fmt.Sprintf("%s: MicroPython places programs itself, so .origin %d is ignored", name, prog.Origin))
+	}
+
+	for _, opt := range prog.LangOpts {
+		if opt.Lang == "python" {
+			set(opt.Name, opt.Value)
+		}
+	}
+	kwargs := make([]string, len(names))
+	for i, n := range names {
+		kwargs[i] = n + "=" + values[n]
+	}
+	return kwargs, warnings
+}
+
+// pythonInstruction returns the rp2.asm_pio call that encodes w without
+// its side-set and delay bits, or "" when MicroPython cannot express the
+// word: PIO version 1 instructions and reserved encodings. Jumps use the
+// label names in target.
+func pythonInstruction(w uint16, target map[int]string) string {
+	arg1, arg2 := w>>5&7, w&0x1f
+	switch w >> 13 {
+	case 0: // jmp
+		if cond := pyJmpConds[arg1]; cond != "" {
+			return fmt.Sprintf("jmp(%s, %q)", cond, target[int(arg2)])
+		}
+		return fmt.Sprintf("jmp(%q)", target[int(arg2)])
+	case 1: // wait
+		src := pyWaitSrcs[arg1&3]
+		index := fmt.Sprint(arg2)
+		if src == "irq" {
+			if arg2&0x08 != 0 {
+				return "" // irq prev and next
+			}
+			if index = fmt.Sprint(arg2 & 7); arg2&0x10 != 0 {
+				index = "rel(" + index + ")"
+			}
+		}
+		if src != "" {
+			return fmt.Sprintf("wait(%d, %s, %s)", arg1>>2, src, index)
+		}
+	case 2: // in
+		if src := pyInSrcs[arg1]; src != "" {
+			return fmt.Sprintf("in_(%s, %d)", src, goBitCount(arg2))
+		}
+	case 3: // out
+		return fmt.Sprintf("out(%s, %d)", pyOutDests[arg1], goBitCount(arg2))
+	case 4: // push, pull
+		if arg2 != 0 {
+			return "" // mov rxfifo or reserved bits
+		}
+		op, cond := "push", "iffull"
+		if arg1&4 != 0 {
+			op, cond = "pull", "ifempty"
+		}
+		var args []string
+		if arg1&2 != 0 {
+			args = append(args, cond)
+		}
+		if arg1&1 == 0 {
+			args = append(args, "noblock")
+		}
+		return op + "(" + strings.Join(args, ", ") + ")"
+	case 5: // mov
+		dst, op, src := pyMovDests[arg1], pyMovOps[arg2>>3&3], pyMovSrcs[arg2&7]
+		switch {
+		case w == 0xa042:
+			return "nop()"
+		case dst != "" && op != "" && src != "":
+			return fmt.Sprintf("mov(%s, "+op+")", dst, src)
+		}
+	case 6: // irq
+		if w&0x80 != 0 || arg2&0x08 != 0 {
+			return "" // reserved, or irq prev and next
+		}
+		index := fmt.Sprint(arg2 & 7)
+		if arg2&0x10 != 0 {
+			index = "rel(" + index + ")"
+		}
+		switch arg1 {
+		case 0:
+			return "irq(" + index + ")"
+		case 1:
+			return "irq(block, " + index + ")"
+		case 2:
+			return "irq(clear, " + index + ")"
+		}
+	case 7: // set
+		if dst := pySetDests[arg1]; dst != "" {
+			return fmt.Sprintf("set(%s, %d)", dst, arg2)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/format"
+)
+
+// rustProgram renders assembled programs as Rust for the pio-rs crates.
+// Each program's source is embedded in a pio_proc::pio_asm! macro, one
+// string literal per line, in a function returning the pio::Program.
+// Public labels and defines become constants, and the .fifo, .in, .out
+// and .clock_div settings, which a pio::Program does not carry, are
+// applied to an rp2040-hal PIOBuilder by a config function. The warnings
+// name settings the builder cannot express.
+func rustProgram(source string, progs []*asm.Program) (string, []string) {
+	sources := programSources(source)
+	hal := "rp2040_hal"
+	for _, prog := range progs {
+		if prog.PIOVersion > 0 {
+			hal = "rp235x_hal"
+		}
+	}
+
+	var body strings.Builder
+	var warnings []string
+	uses := map[string]bool{}
+	for i, prog := range progs {
+		src := ""
+		if i < len(sources) {
+			src = sources[i]
+		}
+		warnings = append(warnings, writeRustProgram(&body, prog, src, uses)...)
+	}
+
+	var b strings.Builder
+	b.WriteString("// Code generated by tinypio; DO NOT EDIT.\n")
+	if len(uses) > 0 {
+		var names []string
+		for _, n := range []string{"Buffers", "PIOBuilder", "PIOExt", "ShiftDirection"} {
+			if uses[n] {
+				names = append(names, n)
+			}
+		}
+		fmt.Fprintf(&b, "\nuse %s::pio::{%s};\n", hal, strings.Join(names, ", "))
+	}
+	b.WriteString(body.String())
+	return b.String(), warnings
+}
+
+// writeRustProgram writes the constants, program function and config
+// function of a single program, recording the builder types it uses.
+func writeRustProgram(b *strings.Builder, prog *asm.Program, src string, uses map[string]bool) []string {
+	name := prog.Name
+	if name == "" {
+		name = "program"
+	}
+	prefix := strings.ToUpper(name)
+	consts := false
+	for _, l := range prog.Labels {
+		if l.Public {
+			if !consts {
+				b.WriteString("\n")
+				consts = true
+			}
+			fmt.Fprintf(b, "pub const %s_OFFSET_%s: u8 = %d;\n", prefix, strings.ToUpper(l.Name), l.Address)
+		}
+	}
+	for _, d := range prog.Defines {
+		if d.Public {
+			if !consts {
+				b.WriteString("\n")
+				consts = true
+			}
+			fmt.Fprintf(b, "pub const %s_%s: i32 = %d;\n", prefix, strings.ToUpper(d.Name), d.Value)
+		}
+	}
+
+	// Both chips have 32 instruction slots.
+	fmt.Fprintf(b, "\n/// Returns the %s program, assembled by pio-rs.\n", name)
+	fmt.Fprintf(b, "pub fn %s() -> pio::Program<{ pio::RP2040_MAX_PROGRAM_SIZE }> {\n", name)
+	b.WriteString("    pio_proc::pio_asm!(\n")
+	for _, line := range strings.Split(src, "\n") {
+		if d, _, _ := strings.Cut(strings.TrimSpace(line), " "); d == ".program" {
+			continue // pio_asm! takes a single unnamed program
+		}
+		fmt.Fprintf(b, "        %s,\n", rustString(line))
+	}
+	b.WriteString("    )\n    .program\n}\n")
+
+	var calls, settings []string
+	var warnings []string
+	shift := func(dir string, sc *asm.ShiftConfig, auto, threshold string) {
+		settings = append(settings, "."+dir)
+		calls = append(calls, fmt.Sprintf(".%s_shift_direction(ShiftDirection::%s)", dir, rustShift(sc.Right)))
+		if sc.Auto {
+			calls = append(calls, fmt.Sprintf(".%s(true)", auto))
+		}
+		if sc.Threshold != 32 {
+			calls = append(calls, fmt.Sprintf(".%s(%d)", threshold, sc.Threshold))
+		}
+		uses["ShiftDirection"] = true
+	}
+	if prog.In != nil {
+		shift("in", prog.In, "autopush", "push_threshold")
+	}
+	if prog.Out != nil {
+		shift("out", prog.Out, "autopull", "pull_threshold")
+	}
+	switch prog.Fifo {
+	case "", "txrx":
+	case "tx", "rx":
+		settings = append(settings, ".fifo")
+		calls = append(calls, fmt.Sprintf(".buffers(Buffers::Only%s)", strings.ToUpper(prog.Fifo[:1])+prog.Fifo[1:]))
+		uses["Buffers"] = true
+	default:
+		warnings = append(warnings, fmt.Sprintf("%s: the rp2040-hal PIOBuilder has no FIFO join for .fifo %s", name, prog.Fifo))
+	}
+	if prog.ClockDiv != 0 {
+		settings = append(settings, ".clock_div")
+		whole, frac := math.Modf(prog.ClockDiv)
+		calls = append(calls, fmt.Sprintf(".clock_divisor_fixed_point(%d, %d)", int(whole), int(math.Round(frac*256))))
+	}
+	if prog.MovStatus != "" {
+		warnings = append(warnings, fmt.Sprintf("%s: the rp2040-hal PIOBuilder has no setting for .mov_status %s", name, prog.MovStatus))
+	}
+	if len(calls) == 0 {
+		return warnings
+	}
+	uses["PIOBuilder"], uses["PIOExt"] = true, true
+	list := strings.Join(settings, ", ")
+	if n := len(settings); n > 1 {
+		list = strings.Join(settings[:n-1], ", ") + " and " + settings[n-1]
+	}
+	fmt.Fprintf(b, "\n/// Applies the %s settings of %s to a state machine builder.\n", list, name)
+	fmt.Fprintf(b, "pub fn %s_config<P: PIOExt>(builder: PIOBuilder<P>) -> PIOBuilder<P> {\n    builder\n", name)
+	for _, c := range calls {
+		fmt.Fprintf(b, "        %s\n", c)
+	}
+	b.WriteString("}\n")
+	return warnings
+}
+
+func rustShift(right bool) string {
+	if right {
+		return "Right"
+	}
+	return "Left"
+}
+
+// rustString quotes s as a Rust string literal.
+func rustString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\t", `\t`).Replace(s) + `"`
+}
+
+// programSources returns the source of each program of a file in the
+// canonical layout, for formats that embed it for another assembler.
+// Each starts with the statements before the first .program, comments
+// just before a .program belong to that program, and code blocks are
+// left out.
+func programSources(source string) []string {
+	if out, err := format.Source(source); err == nil {
+		source = out
+	}
+	file, err := asm.Parse(source)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(source, "\n"), "\n")
+	skip := make([]bool, len(lines)+2)
+	var starts []int
+	for _, stmt := range file.Statements {
+		switch s := stmt.(type) {
+		case *asm.CodeBlockStmt:
+			for l := s.Span.Start.Line; l <= s.Span.End.Line; l++ {
+				skip[l] = true
+			}
+		case *asm.DirectiveStmt:
+			if s.Name == ".program" {
+				starts = append(starts, s.Span.Start.Line)
+			}
+		}
+	}
+	if len(starts) == 0 {
+		starts = []int{1}
+	}
+	isComment := func(l int) bool {
+		t := strings.TrimSpace(lines[l-1])
+		return strings.HasPrefix(t, ";") || strings.HasPrefix(t, "//")
+	}
+	for i := 1; i < len(starts); i++ {
+		for starts[i]-1 > starts[i-1] && isComment(starts[i]-1) {
+			starts[i]--
+		}
+	}
+
+	// text returns lines [from, to) without code blocks or runs of blank
+	// lines.
+	text := func(from, to int) []string {
+		var out []string
+		for l := from; l < to; l++ {
+			if skip[l] || lines[l-1] == "" && (len(out) == 0 || out[len(out)-1] == "") {
+				continue
+			}
+			out = append(out, lines[l-1])
+		}
+		return out
+	}
+	global := text(1, starts[0])
+	sources := make([]string, len(starts))
+	for i, start := range starts {
+		end := len(lines) + 1
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		prog := append(append([]string(nil), global...), text(start, end)...)
+		for len(prog) > 0 && prog[len(prog)-1] == "" {
+			prog = prog[:len(prog)-1]
+		}
+		sources[i] = strings.Join(prog, "\n")
+	}
+	return sources
+}
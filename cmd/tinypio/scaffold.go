@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// ScaffoldRequest is the body of POST /api/scaffold.
+type ScaffoldRequest struct {
+	Source    string        `json:"source"`
+	Target    string        `json:"target,omitempty"`
+	Program   string        `json:"program,omitempty"` // which program of a multi-program source
+	Name      string        `json:"name,omitempty"`    // driver type, by default from the program name
+	Package   string        `json:"package,omitempty"` // defaults to main
+	Pins      []ScaffoldPin `json:"pins,omitempty"`
+	Frequency uint32        `json:"frequency,omitempty"` // state machine clock in Hz, 0 runs at the system clock
+	DMA       bool          `json:"dma,omitempty"`       // add a DMA hook to Write and Read
+}
+
+// ScaffoldPin assigns a pin role to a constructor argument. Roles are
+// out, set, sideset, in and jmp; Count is the number of consecutive
+// pins, by default from the program's directives or 1.
+type ScaffoldPin struct {
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Count int    `json:"count,omitempty"`
+}
+
+// ScaffoldResult holds a generated driver.
+type ScaffoldResult struct {
+	Success  bool     `json:"success"`
+	Driver   string   `json:"driver,omitempty"` // the driver type
+	Go       string   `json:"go,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// pinRoles are the pin roles in the order they are configured.
+var pinRoles = []string{"out", "set", "sideset", "in", "jmp"}
+
+// fifoJoins maps .fifo modes to the tinygo-org/pio FIFO joins.
+var fifoJoins = map[string]string{
+	"tx":     "FifoJoinTx",
+	"rx":     "FifoJoinRx",
+	"txput":  "FifoJoinRxPut",
+	"txget":  "FifoJoinRxGet",
+	"putget": "FifoJoinRxPutGet",
+}
+
+// scaffoldLocals are the names the generated constructor uses itself.
+var scaffoldLocals = []string{"sm", "Pio", "offset", "cfg", "err", "whole", "frac", "machine", "pio", "runtime"}
+
+func handleScaffold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ScaffoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := scaffoldPIO(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// scaffoldPIO generates a piolib-style TinyGo driver for a program: the
+// program's Go declarations, a driver type with a constructor that loads
+// the program and configures its pins, clock divider, shift registers
+// and FIFOs, and helpers for the FIFOs the program uses.
+func scaffoldPIO(req ScaffoldRequest) ScaffoldResult {
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		return ScaffoldResult{Success: false, Errors: errorStrings(err)}
+	}
+	prog, err := selectProgram(req.Source, req.Program, target)
+	if err != nil {
+		return ScaffoldResult{Success: false, Errors: errorStrings(err)}
+	}
+	d, err := newDriver(req, prog)
+	if err != nil {
+		return ScaffoldResult{Success: false, Errors: errorStrings(err)}
+	}
+	return ScaffoldResult{Success: true, Driver: d.name, Go: d.source(), Warnings: d.warnings}
+}
+
+// driver is a checked scaffold request.
+type driver struct {
+	prog   *asm.Program
+	id     string // prefix of the program declarations
+	name   string
+	pkg    string
+	pins   []ScaffoldPin // with roles in pinRoles order and counts set
+	args   []string      // constructor pin arguments
+	freq   uint32
+	dma    bool
+	tx, rx bool // whether the program uses the TX and RX FIFOs
+	// Whether the program has pull, push, out and in instructions.
+	pull, push, shiftOut, shiftIn bool
+	warnings                      []string
+}
+
+func newDriver(req ScaffoldRequest, prog *asm.Program) (*driver, error) {
+	d := &driver{prog: prog, id: goName(prog.Name), name: req.Name, pkg: req.Package, freq: req.Frequency}
+	if prog.Name == "" {
+		d.id = "Program"
+	}
+	if d.name == "" {
+		d.name = d.id
+	}
+	if d.pkg == "" {
+		d.pkg = "main"
+	}
+	if !token.IsIdentifier(d.name) || !token.IsExported(d.name) {
+		return nil, fmt.Errorf("driver name %q is not an exported Go identifier", d.name)
+	}
+	if !token.IsIdentifier(d.pkg) {
+		return nil, fmt.Errorf("package %q is not a Go identifier", d.pkg)
+	}
+
+	var errs []string
+	roles := map[string]bool{}
+	for _, p := range req.Pins {
+		switch {
+		case !token.IsIdentifier(p.Name) || slices.Contains(scaffoldLocals, p.Name):
+			errs = append(errs, fmt.Sprintf("pin name %q is not a usable Go identifier", p.Name))
+			continue
+		case !slices.Contains(pinRoles, p.Role):
+			errs = append(errs, fmt.Sprintf("pin %s: unknown role %q (want %s)", p.Name, p.Role, strings.Join(pinRoles, ", ")))
+			continue
+		case roles[p.Role]:
+			errs = append(errs, fmt.Sprintf("pin %s: more than one pin has the %s role", p.Name, p.Role))
+			continue
+		case p.Count < 0 || p.Count > 32:
+			errs = append(errs, fmt.Sprintf("pin %s: count %d out of range 1-32", p.Name, p.Count))
+			continue
+		case p.Role == "sideset" && prog.SideSet.Bits == 0:
+			errs = append(errs, fmt.Sprintf("pin %s: the program has no .side_set", p.Name))
+			continue
+		case p.Role == "sideset" && p.Count != 0 && p.Count != prog.SideSet.Bits:
+			errs = append(errs, fmt.Sprintf("pin %s: the program side-sets %d pins, not %d", p.Name, prog.SideSet.Bits, p.Count))
+			continue
+		}
+		roles[p.Role] = true
+		if p.Count == 0 {
+			p.Count = d.defaultCount(p.Role)
+		}
+		d.pins = append(d.pins, p)
+		if !slices.Contains(d.args, p.Name) {
+			d.args = append(d.args, p.Name)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	slices.SortStableFunc(d.pins, func(a, b ScaffoldPin) int {
+		return slices.Index(pinRoles, a.Role) - slices.Index(pinRoles, b.Role)
+	})
+
+	used := d.scan()
+	for _, role := range pinRoles {
+		switch {
+		case used[role] && !roles[role]:
+			d.warnings = append(d.warnings, fmt.Sprintf("the program uses the %s pins but no pin has the %s role", role, role))
+		case roles[role] && !used[role]:
+			d.warnings = append(d.warnings, fmt.Sprintf("no instruction uses the %s pins", role))
+		}
+	}
+	if req.DMA && !d.tx && !d.rx {
+		d.warnings = append(d.warnings, "the program uses neither FIFO, so no DMA hook is generated")
+	}
+	d.dma = req.DMA && (d.tx || d.rx)
+	return d, nil
+}
+
+// defaultCount returns the pin count a role gets from the program.
+func (d *driver) defaultCount(role string) int {
+	switch {
+	case role == "sideset":
+		return d.prog.SideSet.Bits
+	case role == "out" && d.prog.Out != nil && d.prog.Out.Count > 0:
+		return d.prog.Out.Count
+	case role == "in" && d.prog.In != nil && d.prog.In.Count > 0:
+		return d.prog.In.Count
+	case role == "set" && d.prog.SetCount > 0:
+		return d.prog.SetCount
+	}
+	return 1
+}
+
+// scan records which FIFOs the program uses and returns the pin roles
+// its instructions need.
+func (d *driver) scan() map[string]bool {
+	prog := d.prog
+	used := map[string]bool{"sideset": prog.SideSet.Bits > 0}
+	for _, w := range prog.Instructions {
+		arg1, arg2 := w>>5&7, w&0x1f
+		switch w >> 13 {
+		case 0: // jmp pin
+			used["jmp"] = used["jmp"] || arg1 == 6
+		case 1: // wait pin
+			used["in"] = used["in"] || arg1&3 == 1
+		case 2: // in pins
+			used["in"] = used["in"] || arg1 == 0
+			d.shiftIn = true
+		case 3: // out pins, pindirs
+			used["out"] = used["out"] || arg1 == 0 || arg1 == 4
+			d.shiftOut = true
+		case 4: // push, pull
+			if w&0x10 == 0 {
+				d.pull = d.pull || arg1&4 != 0
+				d.push = d.push || arg1&4 == 0
+			}
+		case 5: // mov pins
+			used["out"] = used["out"] || arg1 == 0 || arg1 == 3
+			used["in"] = used["in"] || arg2&7 == 0
+		case 7: // set pins, pindirs
+			used["set"] = used["set"] || arg1 == 0 || arg1 == 4
+		}
+	}
+	// Data shifted out comes from the TX FIFO and data shifted in goes
+	// to the RX FIFO, by pull and push or by autopull and autopush.
+	d.tx = d.pull || d.shiftOut
+	d.rx = d.push || d.shiftIn
+	return used
+}
+
+// source returns the driver file.
+func (d *driver) source() string {
+	imports := []string{}
+	if len(d.pins) > 0 || d.freq > 0 {
+		imports = append(imports, `"machine"`)
+	}
+	if d.tx || d.rx {
+		imports = append(imports, `"runtime"`)
+	}
+	imports = append(imports, "", pioImport)
+
+	var b strings.Builder
+	b.WriteString("// Code generated by tinypio scaffold. Edit as needed.\n\n")
+	build := "rp2040 || rp2350"
+	if d.prog.PIOVersion > 0 {
+		build = "rp2350"
+	}
+	fmt.Fprintf(&b, "//go:build %s\n\n", build)
+	fmt.Fprintf(&b, "package %s\n\nimport (\n", d.pkg)
+	for _, imp := range imports {
+		b.WriteString("\t" + imp + "\n")
+	}
+	b.WriteString(")\n")
+	writeGoProgram(&b, d.prog)
+	d.writeType(&b)
+	d.writeConstructor(&b)
+	d.writeMethods(&b)
+
+	out, err := format.Source([]byte(b.String()))
+	if err != nil {
+		// The generator's own output always parses; keep it readable
+		// for a bug report if it does not.
+		return b.String()
+	}
+	return string(out)
+}
+
+func (d *driver) writeType(b *strings.Builder) {
+	fmt.Fprintf(b, "\n// %s drives the %s PIO program on a state machine.\n", d.name, d.prog.Name)
+	fmt.Fprintf(b, "type %s struct {\n\tsm     pio.StateMachine\n\toffset uint8\n", d.name)
+	if d.dma {
+		fmt.Fprintf(b, "\tdma    %sDMA\n", d.name)
+	}
+	b.WriteString("}\n")
+}
+
+func (d *driver) writeConstructor(b *strings.Builder) {
+	params := "sm pio.StateMachine"
+	if len(d.args) > 0 {
+		params += ", " + strings.Join(d.args, ", ") + " machine.Pin"
+	}
+	fmt.Fprintf(b, "\n// New%s loads %s into the PIO block of sm, sets up its pins and\n", d.name, d.prog.Name)
+	b.WriteString("// starts it.\n")
+	fmt.Fprintf(b, "func New%s(%s) (*%s, error) {\n", d.name, params, d.name)
+	b.WriteString("\tsm.TryClaim() // SM should be claimed beforehand, we just guarantee it's claimed.\n")
+	b.WriteString("\tPio := sm.PIO()\n")
+	if d.freq > 0 {
+		fmt.Fprintf(b, "\twhole, frac, err := pio.ClkDivFromFrequency(%d, machine.CPUFrequency())\n", d.freq)
+		b.WriteString("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	}
+	fmt.Fprintf(b, "\toffset, err := Pio.AddProgram(%sInstructions, %sOrigin)\n", d.id, d.id)
+	b.WriteString("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+
+	// Pins are handed to the PIO block once each, as outputs when any
+	// of their roles drives them.
+	for _, arg := range d.args {
+		count, out := 0, false
+		for _, p := range d.pins {
+			if p.Name == arg {
+				count = max(count, p.Count)
+				out = out || p.Role != "in" && p.Role != "jmp"
+			}
+		}
+		if count == 1 {
+			fmt.Fprintf(b, "\t%s.Configure(machine.PinConfig{Mode: Pio.PinMode()})\n", arg)
+		} else {
+			fmt.Fprintf(b, "\tfor i := machine.Pin(0); i < %d; i++ {\n", count)
+			fmt.Fprintf(b, "\t\t(%s + i).Configure(machine.PinConfig{Mode: Pio.PinMode()})\n\t}\n", arg)
+		}
+		fmt.Fprintf(b, "\tsm.SetPindirsConsecutive(%s, %d, %t)\n", arg, count, out)
+	}
+
+	fmt.Fprintf(b, "\tcfg := %sProgramDefaultConfig(offset)\n", d.id)
+	for _, p := range d.pins {
+		switch p.Role {
+		case "out":
+			fmt.Fprintf(b, "\tcfg.SetOutPins(%s, %d)\n", p.Name, p.Count)
+		case "set":
+			fmt.Fprintf(b, "\tcfg.SetSetPins(%s, %d)\n", p.Name, p.Count)
+		case "sideset":
+			fmt.Fprintf(b, "\tcfg.SetSidesetPins(%s)\n", p.Name)
+		case "in":
+			fmt.Fprintf(b, "\tcfg.SetInPins(%s, %d)\n", p.Name, p.Count)
+		case "jmp":
+			fmt.Fprintf(b, "\tcfg.SetJmpPin(%s)\n", p.Name)
+		}
+	}
+	prog := d.prog
+	if s := prog.Out; s != nil {
+		fmt.Fprintf(b, "\tcfg.SetOutShift(%t, %t, %d)\n", s.Right, s.Auto, s.Threshold)
+	} else if d.shiftOut && !d.pull {
+		b.WriteString("\t// TODO: out without pull needs autopull: cfg.SetOutShift(shiftRight, true, threshold)\n")
+	}
+	if s := prog.In; s != nil {
+		fmt.Fprintf(b, "\tcfg.SetInShift(%t, %t, %d)\n", s.Right, s.Auto, s.Threshold)
+	} else if d.shiftIn && !d.push {
+		b.WriteString("\t// TODO: in without push needs autopush: cfg.SetInShift(shiftRight, true, threshold)\n")
+	}
+	switch join := fifoJoins[prog.Fifo]; {
+	case join != "":
+		fmt.Fprintf(b, "\tcfg.SetFIFOJoin(pio.%s)\n", join)
+	case prog.Fifo != "":
+		// .fifo txrx keeps the FIFOs separate.
+	case d.tx && !d.rx:
+		b.WriteString("\t// Only the TX FIFO is used, so it gets all eight entries.\n")
+		b.WriteString("\tcfg.SetFIFOJoin(pio.FifoJoinTx)\n")
+	case d.rx && !d.tx:
+		b.WriteString("\t// Only the RX FIFO is used, so it gets all eight entries.\n")
+		b.WriteString("\tcfg.SetFIFOJoin(pio.FifoJoinRx)\n")
+	}
+	switch {
+	case d.freq > 0:
+		b.WriteString("\tcfg.SetClkDivIntFrac(whole, frac)\n")
+	case prog.ClockDiv > 0:
+		whole := math.Floor(prog.ClockDiv)
+		frac := math.Round((prog.ClockDiv - whole) * 256)
+		fmt.Fprintf(b, "\tcfg.SetClkDivIntFrac(%d, %d) // .clock_div %s\n", uint16(whole), uint8(min(frac, 255)), strconv.FormatFloat(prog.ClockDiv, 'g', -1, 64))
+	}
+	b.WriteString("\tsm.Init(offset, cfg)\n")
+	b.WriteString("\tsm.SetEnabled(true)\n")
+	fmt.Fprintf(b, "\treturn &%s{sm: sm, offset: offset}, nil\n}\n", d.name)
+}
+
+func (d *driver) writeMethods(b *strings.Builder) {
+	recv := "(d *" + d.name + ")"
+	fmt.Fprintf(b, "\n// Enable starts or stops the state machine.\nfunc %s Enable(enabled bool) {\n\td.sm.SetEnabled(enabled)\n}\n", recv)
+
+	if d.dma {
+		fmt.Fprintf(b, "\n// %sDMA moves buffers between memory and a FIFO register, paced by\n", d.name)
+		b.WriteString("// the DREQ of the state machine, for example with a DMA channel.\n")
+		fmt.Fprintf(b, "type %sDMA interface {\n", d.name)
+		if d.tx {
+			b.WriteString("\tPush32(dst *uint32, src []uint32, dreq uint32) error\n")
+		}
+		if d.rx {
+			b.WriteString("\tPull32(dst []uint32, src *uint32, dreq uint32) error\n")
+		}
+		b.WriteString("}\n")
+		fmt.Fprintf(b, "\n// SetDMA makes Write and Read use dma, or the FIFOs directly for nil.\n")
+		fmt.Fprintf(b, "func %s SetDMA(dma %sDMA) {\n\td.dma = dma\n}\n", recv, d.name)
+		b.WriteString("\n// dreq returns the TX DREQ of the state machine; the RX DREQ follows\n// four later.\n")
+		fmt.Fprintf(b, "func %s dreq() uint32 {\n", recv)
+		b.WriteString("\treturn uint32(d.sm.PIO().BlockIndex())*8 + uint32(d.sm.StateMachineIndex())\n}\n")
+	}
+	werr, rerr := "", ""
+	if d.dma {
+		werr, rerr = " error", " error"
+	}
+
+	if d.tx {
+		fmt.Fprintf(b, "\n// IsTxFull reports whether a Put would wait.\nfunc %s IsTxFull() bool {\n\treturn d.sm.IsTxFIFOFull()\n}\n", recv)
+		fmt.Fprintf(b, "\n// Put writes v to the TX FIFO, waiting while it is full.\nfunc %s Put(v uint32) {\n", recv)
+		b.WriteString("\tfor d.sm.IsTxFIFOFull() {\n\t\truntime.Gosched()\n\t}\n\td.sm.TxPut(v)\n}\n")
+		fmt.Fprintf(b, "\n// Write puts each word of buf to the TX FIFO.\nfunc %s Write(buf []uint32)%s {\n", recv, werr)
+		if d.dma {
+			b.WriteString("\tif d.dma != nil {\n\t\treturn d.dma.Push32(&d.sm.TxReg().Reg, buf, d.dreq())\n\t}\n")
+		}
+		b.WriteString("\tfor _, v := range buf {\n\t\td.Put(v)\n\t}\n")
+		if d.dma {
+			b.WriteString("\treturn nil\n")
+		}
+		b.WriteString("}\n")
+	}
+	if d.rx {
+		fmt.Fprintf(b, "\n// IsRxEmpty reports whether a Get would wait.\nfunc %s IsRxEmpty() bool {\n\treturn d.sm.IsRxFIFOEmpty()\n}\n", recv)
+		fmt.Fprintf(b, "\n// Get reads a word from the RX FIFO, waiting while it is empty.\nfunc %s Get() uint32 {\n", recv)
+		b.WriteString("\tfor d.sm.IsRxFIFOEmpty() {\n\t\truntime.Gosched()\n\t}\n\treturn d.sm.RxGet()\n}\n")
+		fmt.Fprintf(b, "\n// Read fills buf from the RX FIFO.\nfunc %s Read(buf []uint32)%s {\n", recv, rerr)
+		if d.dma {
+			b.WriteString("\tif d.dma != nil {\n\t\treturn d.dma.Pull32(buf, &d.sm.RxReg().Reg, d.dreq()+4)\n\t}\n")
+		}
+		b.WriteString("\tfor i := range buf {\n\t\tbuf[i] = d.Get()\n\t}\n")
+		if d.dma {
+			b.WriteString("\treturn nil\n")
+		}
+		b.WriteString("}\n")
+	}
+}
+
+func cmdScaffold(args []string) error {
+	fs := flag.NewFlagSet("scaffold", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio scaffold [flags] file.pio")
+		fmt.Fprintln(fs.Output(), "Generates a TinyGo driver for a PIO program.")
+		fs.PrintDefaults()
+	}
+	var req ScaffoldRequest
+	fs.StringVar(&req.Target, "target", "", "rp2040 or rp2350")
+	fs.StringVar(&req.Program, "program", "", "program to generate a driver for (default the only one)")
+	fs.StringVar(&req.Name, "name", "", "driver type name (default from the program name)")
+	fs.StringVar(&req.Package, "package", "main", "Go package of the driver")
+	fs.Func("pin", "a constructor pin, as name=role[:count] with role out, set, sideset, in or jmp (repeatable)", func(s string) error {
+		name, role, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("want name=role[:count], got %q", s)
+		}
+		pin := ScaffoldPin{Name: name, Role: role}
+		if role, count, ok := strings.Cut(role, ":"); ok {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				return fmt.Errorf("invalid pin count %q", count)
+			}
+			pin.Role, pin.Count = role, n
+		}
+		req.Pins = append(req.Pins, pin)
+		return nil
+	})
+	freq := fs.Uint("freq", 0, "state machine clock in Hz (default the system clock)")
+	fs.BoolVar(&req.DMA, "dma", false, "add a DMA hook to Write and Read")
+	output := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one source file")
+	}
+	if *freq > math.MaxUint32 {
+		return fmt.Errorf("-freq %d is out of range", *freq)
+	}
+	req.Frequency = uint32(*freq)
+	source, err := readSource(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	req.Source = source
+
+	result := scaffoldPIO(req)
+	for _, w := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if !result.Success {
+		return errors.New(strings.Join(result.Errors, "\n"))
+	}
+	if *output != "" {
+		return os.WriteFile(*output, []byte(result.Go), 0o644)
+	}
+	fmt.Print(result.Go)
+	return nil
+}
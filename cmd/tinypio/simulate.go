@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+const (
+	defaultSimCycles = 100
+	maxSimCycles     = 10000
+)
+
+// SimulateRequest is the body of POST /api/simulate. Config fields that
+// are left out keep the defaults derived from the program.
+type SimulateRequest struct {
+	Source  string          `json:"source"`
+	Target  string          `json:"target,omitempty"`
+	Program string          `json:"program,omitempty"` // which program of a multi-program source
+	Config  json.RawMessage `json:"config,omitempty"`
+	TX      []uint32        `json:"tx,omitempty"`       // words fed to the TX FIFO as it has room
+	Pins    uint32          `json:"pins,omitempty"`     // external input levels
+	Cycles  int             `json:"cycles,omitempty"`   // defaults to 100, at most 10000
+	DrainRX bool            `json:"drain_rx,omitempty"` // read the RX FIFO every cycle
+}
+
+// SimulateResult holds the state of the state machine after every cycle.
+type SimulateResult struct {
+	Success bool        `json:"success"`
+	Program string      `json:"program,omitempty"`
+	Config  *sim.Config `json:"config,omitempty"`
+	Cycles  []sim.State `json:"cycles,omitempty"`
+	RX      []uint32    `json:"rx,omitempty"` // words read from the RX FIFO with drain_rx
+	Errors  []string    `json:"errors,omitempty"`
+}
+
+func handleSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := simulatePIO(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// simulatePIO assembles the requested program and runs it on a single
+// state machine for the requested number of cycles.
+func simulatePIO(req SimulateRequest) SimulateResult {
+	run, err := runSimulation(req)
+	if err != nil {
+		return SimulateResult{Success: false, Errors: errorStrings(err)}
+	}
+	return SimulateResult{
+		Success: true,
+		Program: run.prog.Name,
+		Config:  &run.cfg,
+		Cycles:  run.trace,
+		RX:      run.rx,
+	}
+}
+
+// simulation is a finished simulator run.
+type simulation struct {
+	prog  *asm.Program
+	cfg   sim.Config
+	trace []sim.State
+	rx    []uint32
+}
+
+// runSimulation assembles and runs a simulate request, feeding the TX
+// FIFO and draining the RX FIFO between cycles.
+func runSimulation(req SimulateRequest) (*simulation, error) {
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := selectProgram(req.Source, req.Program, target)
+	if err != nil {
+		return nil, err
+	}
+	cfg := sim.DefaultConfig(prog)
+	if len(req.Config) > 0 {
+		if err := json.Unmarshal(req.Config, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+	}
+	cycles := req.Cycles
+	if cycles <= 0 {
+		cycles = defaultSimCycles
+	}
+	if cycles > maxSimCycles {
+		return nil, fmt.Errorf("cycles %d exceeds the limit of %d", cycles, maxSimCycles)
+	}
+
+	sm, err := sim.New(prog, cfg)
+	if err != nil {
+		return nil, err
+	}
+	sm.GPIO().Input = req.Pins
+	run := &simulation{prog: prog, cfg: cfg}
+	tx := req.TX
+	for range cycles {
+		for len(tx) > 0 && sm.Put(tx[0]) {
+			tx = tx[1:]
+		}
+		run.trace = append(run.trace, sm.Step())
+		for req.DrainRX {
+			w, ok := sm.Get()
+			if !ok {
+				break
+			}
+			run.rx = append(run.rx, w)
+		}
+	}
+	return run, nil
+}
+
+// selectProgram assembles source and returns the program called name.
+// The name may be left empty when the source holds a single program.
+func selectProgram(source, name string, target asm.Target) (*asm.Program, error) {
+	file, err := asm.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	progs, err := asm.AssembleAll(file, target)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, prog := range progs {
+		if prog.Name == name || (name == "" && len(progs) == 1) {
+			return prog, nil
+		}
+		names = append(names, prog.Name)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("source has %d programs; choose one of %s", len(progs), strings.Join(names, ", "))
+	}
+	return nil, fmt.Errorf("no program %q in source (have %s)", name, strings.Join(names, ", "))
+}
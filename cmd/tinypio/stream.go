@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+	"github.com/joeblew999/plat-tinypio/internal/stream"
+	"github.com/joeblew999/plat-tinypio/internal/ws"
+)
+
+// StreamRequest is the first message on GET /api/stream. It names the
+// program like a simulate request and sets the pace of the stream.
+// Every later message is a stream.Control.
+type StreamRequest struct {
+	Source  string          `json:"source"`
+	Target  string          `json:"target,omitempty"`
+	Program string          `json:"program,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"`
+	TX      []uint32        `json:"tx,omitempty"`   // words queued for the TX FIFO
+	Pins    uint32          `json:"pins,omitempty"` // external input levels
+	stream.Options
+}
+
+// StreamStart is the reply to a StreamRequest. Events follow as JSON
+// arrays of stream.Event, one message per batch.
+type StreamStart struct {
+	Success bool        `json:"success"`
+	Program string      `json:"program,omitempty"`
+	Config  *sim.Config `json:"config,omitempty"`
+	Errors  []string    `json:"errors,omitempty"`
+}
+
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := ws.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	// A client going away, with or without a close frame, is not an error.
+	if err := streamPIO(r.Context(), conn); err != nil && !errors.Is(err, ws.ErrClosed) && !errors.Is(err, io.EOF) {
+		fmt.Fprintf(os.Stderr, "tinypio stream: %v\n", err)
+	}
+}
+
+// streamPIO reads the start message, replies, and then streams events
+// while a reader goroutine feeds control messages to the streamer.
+func streamPIO(ctx context.Context, conn *ws.Conn) error {
+	msg, err := conn.Read()
+	if err != nil {
+		return err
+	}
+	var req StreamRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return writeJSON(conn, StreamStart{Success: false, Errors: []string{"invalid JSON"}})
+	}
+	prog, cfg, s, err := newStreamer(req)
+	if err != nil {
+		return writeJSON(conn, StreamStart{Success: false, Errors: errorStrings(err)})
+	}
+	if err := writeJSON(conn, StreamStart{Success: true, Program: prog.Name, Config: &cfg}); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	controls := make(chan stream.Control)
+	go func() {
+		defer close(controls)
+		for {
+			msg, err := conn.Read()
+			if err != nil {
+				cancel()
+				return
+			}
+			var c stream.Control
+			if err := json.Unmarshal(msg, &c); err != nil {
+				writeJSON(conn, []stream.Event{{Type: "error", Error: "invalid JSON"}})
+				continue
+			}
+			select {
+			case controls <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	err = stream.Run(ctx, s, controls, func(events []stream.Event) error {
+		return writeJSON(conn, events)
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// newStreamer assembles the program of a stream request and wraps a
+// state machine running it.
+func newStreamer(req StreamRequest) (*asm.Program, sim.Config, *stream.Streamer, error) {
+	var cfg sim.Config
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		return nil, cfg, nil, err
+	}
+	prog, err := selectProgram(req.Source, req.Program, target)
+	if err != nil {
+		return nil, cfg, nil, err
+	}
+	cfg = sim.DefaultConfig(prog)
+	if len(req.Config) > 0 {
+		if err := json.Unmarshal(req.Config, &cfg); err != nil {
+			return nil, cfg, nil, fmt.Errorf("invalid config: %v", err)
+		}
+	}
+	sm, err := sim.New(prog, cfg)
+	if err != nil {
+		return nil, cfg, nil, err
+	}
+	sm.GPIO().Input = req.Pins
+	s, err := stream.New(sm, req.Options)
+	if err != nil {
+		return nil, cfg, nil, err
+	}
+	for _, w := range req.TX {
+		s.Control(stream.Control{Type: "tx", Word: w})
+	}
+	return prog, cfg, s, nil
+}
+
+func writeJSON(conn *ws.Conn, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return conn.Write(b)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/timing"
+)
+
+// TimingRequest is the body of POST /api/timing. At most one of
+// Frequency and Baud is given; without either the divider is the
+// program's .clock_div, or 1.
+type TimingRequest struct {
+	Source    string  `json:"source"`
+	Target    string  `json:"target,omitempty"`
+	Program   string  `json:"program,omitempty"`      // which program of a multi-program source
+	SysClock  float64 `json:"sys_clock_hz,omitempty"` // defaults to 125 MHz, 150 MHz for PIO version 1 (RP2350)
+	Frequency float64 `json:"frequency,omitempty"`    // state machine clock in Hz
+	Baud      float64 `json:"baud,omitempty"`         // bits per second of Cycles cycles each
+	Cycles    int     `json:"cycles,omitempty"`       // cycles per bit, defaults to the shortest loop
+}
+
+// TimingResult holds the loops of a program, their cycle counts and the
+// clock divider that comes closest to the requested rate.
+type TimingResult struct {
+	Success        bool            `json:"success"`
+	Program        string          `json:"program,omitempty"`
+	SysClock       float64         `json:"sys_clock_hz,omitempty"`
+	Loops          []timing.Loop   `json:"loops,omitempty"`
+	Cycles         int             `json:"cycles,omitempty"`
+	Divider        *timing.Divider `json:"divider,omitempty"`
+	IntegerDivider *timing.Divider `json:"integer_divider,omitempty"` // the closest divider without jitter, when the best is fractional
+	BitRate        float64         `json:"bit_rate,omitempty"`        // state machine clock over Cycles
+	Warnings       []string        `json:"warnings,omitempty"`
+	Errors         []string        `json:"errors,omitempty"`
+}
+
+func handleTiming(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TimingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := timingPIO(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// timingPIO counts the cycles of every loop of the requested program and
+// picks the 16.8 divider whose state machine clock comes closest to the
+// requested frequency, or to the baud rate times the cycles per bit.
+func timingPIO(req TimingRequest) TimingResult {
+	target, err := asm.ParseTarget(req.Target)
+	if err != nil {
+		return TimingResult{Success: false, Errors: errorStrings(err)}
+	}
+	prog, err := selectProgram(req.Source, req.Program, target)
+	if err != nil {
+		return TimingResult{Success: false, Errors: errorStrings(err)}
+	}
+	sysClock := req.SysClock
+	if sysClock == 0 {
+		sysClock = 125e6
+		if prog.PIOVersion >= 1 {
+			sysClock = 150e6
+		}
+	}
+
+	loops, warnings := timing.Loops(prog)
+	cycles := req.Cycles
+	if cycles == 0 {
+		cycles = timing.Shortest(loops)
+	}
+	fail := func(err error) TimingResult {
+		return TimingResult{Success: false, Program: prog.Name, Loops: loops, Warnings: warnings, Errors: errorStrings(err)}
+	}
+	if cycles < 0 {
+		return fail(errors.New("cycles must be positive"))
+	}
+
+	rate := req.Frequency
+	switch {
+	case req.Frequency != 0 && req.Baud != 0:
+		return fail(errors.New("give a frequency or a baud rate, not both"))
+	case req.Baud != 0:
+		if cycles == 0 {
+			return fail(errors.New("the program has no loops to time; give the cycles per bit"))
+		}
+		rate = req.Baud * float64(cycles)
+	}
+	var d timing.Divider
+	if rate != 0 {
+		d, err = timing.Best(sysClock, rate)
+	} else {
+		d, err = timing.Fixed(sysClock, max(prog.ClockDiv, 1))
+	}
+	if err != nil {
+		return fail(err)
+	}
+	timing.Time(loops, d)
+
+	result := TimingResult{Success: true, Program: prog.Name, SysClock: sysClock, Loops: loops, Cycles: cycles, Divider: &d, Warnings: warnings}
+	if rate != 0 && d.Frac != 0 {
+		if i, err := timing.BestInteger(sysClock, rate); err == nil {
+			result.IntegerDivider = &i
+		}
+	}
+	if cycles > 0 {
+		result.BitRate = d.Frequency / float64(cycles)
+	}
+	return result
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/disasm"
+	"github.com/joeblew999/plat-tinypio/internal/extract"
+)
+
+// Default locations of the verify corpus and golden files, relative to
+// the repository root.
+const (
+	defaultGoldenDir = "cmd/tinypio/testdata/golden"
+	defaultCorpusDir = "cmd/tinypio/testdata/corpus"
+)
+
+// goldenProgram is what a golden file records about a compiled program:
+// its words and the configuration that travels with them.
+type goldenProgram struct {
+	Name       string
+	SideSet    asm.SideSet
+	Origin     int
+	WrapTarget int
+	Wrap       int
+	Words      []uint16
+}
+
+func goldenOf(prog *asm.Program) goldenProgram {
+	return goldenProgram{
+		Name:       prog.Name,
+		SideSet:    prog.SideSet,
+		Origin:     prog.Origin,
+		WrapTarget: prog.WrapTarget,
+		Wrap:       prog.Wrap,
+		Words:      prog.Instructions,
+	}
+}
+
+// formatGolden writes programs in the golden file format:
+//
+//	program ws2812
+//	side_set 1
+//	origin -1
+//	wrap 0 3
+//	 0: 6221
+//	 1: 1123
+//
+// with a blank line between programs.
+func formatGolden(progs []goldenProgram) string {
+	var b strings.Builder
+	b.WriteString("# Golden output of the PIO assembler; regenerate with tinypio verify -update.\n")
+	for _, p := range progs {
+		fmt.Fprintf(&b, "\n%s\n", strings.TrimSpace("program "+p.Name))
+		fmt.Fprintf(&b, "%s\n", strings.TrimPrefix(p.SideSet.String(), "."))
+		fmt.Fprintf(&b, "origin %d\n", p.Origin)
+		fmt.Fprintf(&b, "wrap %d %d\n", p.WrapTarget, p.Wrap)
+		for i, w := range p.Words {
+			fmt.Fprintf(&b, "%2d: %04x\n", i, w)
+		}
+	}
+	return b.String()
+}
+
+// parseGolden reads a golden file written by formatGolden.
+func parseGolden(text string) ([]goldenProgram, error) {
+	var progs []goldenProgram
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fail := func(format string, args ...any) ([]goldenProgram, error) {
+			return nil, fmt.Errorf("golden line %d: %s", n+1, fmt.Sprintf(format, args...))
+		}
+		key, rest, _ := strings.Cut(line, " ")
+		if key == "program" {
+			progs = append(progs, goldenProgram{Name: rest})
+			continue
+		}
+		if len(progs) == 0 {
+			return fail("%q before the first program", line)
+		}
+		p := &progs[len(progs)-1]
+		var err error
+		switch f := strings.Fields(rest); key {
+		case "side_set":
+			p.SideSet = asm.SideSet{}
+			if len(f) == 0 {
+				return fail("side_set needs a bit count")
+			}
+			p.SideSet.Bits, err = strconv.Atoi(f[0])
+			for _, opt := range f[1:] {
+				switch opt {
+				case "opt":
+					p.SideSet.Opt = true
+				case "pindirs":
+					p.SideSet.PinDirs = true
+				default:
+					return fail("unknown side_set option %q", opt)
+				}
+			}
+		case "origin":
+			p.Origin, err = strconv.Atoi(rest)
+		case "wrap":
+			_, err = fmt.Sscanf(rest, "%d %d", &p.WrapTarget, &p.Wrap)
+		default:
+			addr, word, ok := strings.Cut(line, ":")
+			if !ok {
+				return fail("unknown line %q", line)
+			}
+			if a, aerr := strconv.Atoi(addr); aerr != nil || a != len(p.Words) {
+				return fail("expected address %d, got %q", len(p.Words), addr)
+			}
+			var w uint64
+			w, err = strconv.ParseUint(strings.TrimSpace(word), 16, 16)
+			p.Words = append(p.Words, uint16(w))
+		}
+		if err != nil {
+			return fail("%v", err)
+		}
+	}
+	return progs, nil
+}
+
+// diffGolden compares programs built by an assembler against their
+// golden record, one line per difference. Differing words are shown
+// disassembled.
+func diffGolden(assembler string, want, got []goldenProgram) []string {
+	var diffs []string
+	if len(want) != len(got) {
+		names := func(progs []goldenProgram) string {
+			var s []string
+			for _, p := range progs {
+				s = append(s, p.Name)
+			}
+			return strings.Join(s, ", ")
+		}
+		return []string{fmt.Sprintf("programs: golden [%s], %s [%s]", names(want), assembler, names(got))}
+	}
+	for i, w := range want {
+		g := got[i]
+		name := w.Name
+		if name == "" {
+			name = "(unnamed)"
+		}
+		add := func(what string, wv, gv any) {
+			diffs = append(diffs, fmt.Sprintf("%s %s: golden %v, %s %v", name, what, wv, assembler, gv))
+		}
+		if w.Name != g.Name {
+			add("name", w.Name, g.Name)
+		}
+		if w.SideSet != g.SideSet {
+			add("side-set", w.SideSet, g.SideSet)
+		}
+		if w.Origin != g.Origin {
+			add("origin", w.Origin, g.Origin)
+		}
+		if w.WrapTarget != g.WrapTarget || w.Wrap != g.Wrap {
+			add("wrap", fmt.Sprintf("%d..%d", w.WrapTarget, w.Wrap), fmt.Sprintf("%d..%d", g.WrapTarget, g.Wrap))
+		}
+		if len(w.Words) != len(g.Words) {
+			add("length", len(w.Words), len(g.Words))
+		}
+		for addr := range max(len(w.Words), len(g.Words)) {
+			wt, gt := "(none)", "(none)"
+			if addr < len(w.Words) {
+				wt = fmt.Sprintf("%04x  %s", w.Words[addr], disasm.Decode(w.Words[addr], w.SideSet))
+			}
+			if addr < len(g.Words) {
+				gt = fmt.Sprintf("%04x  %s", g.Words[addr], disasm.Decode(g.Words[addr], g.SideSet))
+			}
+			if wt != gt {
+				diffs = append(diffs, fmt.Sprintf("%s word %d:\n    golden %s\n    %-6s %s", name, addr, wt, assembler, gt))
+			}
+		}
+	}
+	return diffs
+}
+
+// verifySource is a source file checked against its golden file.
+type verifySource struct {
+	Name   string
+	Source string
+}
+
+// verifyResult is the outcome of checking one source.
+type verifyResult struct {
+	Name    string
+	Checked []string // assemblers whose output was compared
+	Diffs   []string
+	Updated bool // the golden file was (re)written
+}
+
+// verifySources assembles each source with the native assembler, and
+// with pioasm when pioasmPath is set, and compares the programs against
+// goldenDir/<name>.golden. With update, the golden files are rewritten
+// from the native assembler instead.
+func verifySources(sources []verifySource, goldenDir, pioasmPath string, update bool) ([]verifyResult, error) {
+	var results []verifyResult
+	for _, src := range sources {
+		r := verifyResult{Name: src.Name}
+		path := filepath.Join(goldenDir, src.Name+".golden")
+		native, err := assembleGolden(src.Source)
+		if err != nil {
+			r.Diffs = append(r.Diffs, "native assembler: "+err.Error())
+			results = append(results, r)
+			continue
+		}
+		if update {
+			if err := os.WriteFile(path, []byte(formatGolden(native)), 0o644); err != nil {
+				return nil, err
+			}
+			r.Updated = true
+		}
+		text, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			r.Diffs = append(r.Diffs, "no golden file "+path+" (run tinypio verify -update)")
+			results = append(results, r)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		golden, err := parseGolden(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		r.Checked = append(r.Checked, "native")
+		r.Diffs = append(r.Diffs, diffGolden("native", golden, native)...)
+
+		if pioasmPath != "" {
+			r.Checked = append(r.Checked, "pioasm")
+			out, err := runPioasm(pioasmPath, src.Source, "c-sdk")
+			if err == nil {
+				var progs []goldenProgram
+				if progs, err = parsePioasmCSDK(out); err == nil {
+					r.Diffs = append(r.Diffs, diffGolden("pioasm", golden, progs)...)
+				}
+			}
+			if err != nil {
+				r.Diffs = append(r.Diffs, "pioasm: "+err.Error())
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// assembleGolden assembles source with the native assembler. The zero
+// target matches pioasm, which only assembles PIO version 1 when the
+// source declares it.
+func assembleGolden(source string) ([]goldenProgram, error) {
+	file, err := asm.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	progs, err := asm.AssembleAll(file, "")
+	if err != nil {
+		return nil, err
+	}
+	golden := make([]goldenProgram, len(progs))
+	for i, prog := range progs {
+		golden[i] = goldenOf(prog)
+	}
+	return golden, nil
+}
+
+var pioasmArray = regexp.MustCompile(`static const uint16_t (\w+)_program_instructions\[\] = \{([^}]*)\};`)
+
+// parsePioasmCSDK reads the programs of pioasm's c-sdk output: the
+// instruction array, the wrap defines, the origin and the side-set of
+// the default config.
+func parsePioasmCSDK(out string) ([]goldenProgram, error) {
+	arrays := pioasmArray.FindAllStringSubmatch(out, -1)
+	if arrays == nil {
+		return nil, errors.New("no program instruction arrays in pioasm output")
+	}
+	var progs []goldenProgram
+	for _, m := range arrays {
+		name := m[1]
+		q := regexp.QuoteMeta(name)
+		p := goldenProgram{Name: name, Origin: -1}
+		words, err := extract.Words(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("program %s: %v", name, err)
+		}
+		p.Words = words
+		for _, d := range []struct {
+			pattern string
+			dst     *int
+		}{
+			{`#define ` + q + `_wrap_target (\d+)`, &p.WrapTarget},
+			{`#define ` + q + `_wrap (\d+)`, &p.Wrap},
+			{`(?s)struct pio_program ` + q + `_program = \{.*?\.origin = (-?\d+)`, &p.Origin},
+		} {
+			if sub := regexp.MustCompile(d.pattern).FindStringSubmatch(out); sub != nil {
+				*d.dst, _ = strconv.Atoi(sub[1])
+			} else if d.dst != &p.Origin {
+				return nil, fmt.Errorf("program %s: no match for %s in pioasm output", name, d.pattern)
+			}
+		}
+		// sm_config_set_sideset counts the enable bit of an optional
+		// side-set in its bit count.
+		sideset := regexp.MustCompile(`(?s)` + q + `_program_get_default_config\(.*?sm_config_set_sideset\(&c, (\d+), (true|false), (true|false)\);.*?\n\}`)
+		if sub := sideset.FindStringSubmatch(out); sub != nil {
+			total, _ := strconv.Atoi(sub[1])
+			p.SideSet = asm.SideSet{Opt: sub[2] == "true", PinDirs: sub[3] == "true"}
+			p.SideSet.Bits = total
+			if p.SideSet.Opt {
+				p.SideSet.Bits--
+			}
+		}
+		progs = append(progs, p)
+	}
+	return progs, nil
+}
+
+// verifyCorpus lists the sources verify checks: the built-in examples and
+// every .pio file in corpusDir.
+func verifyCorpus(corpusDir string) ([]verifySource, error) {
+	var sources []verifySource
+	seen := map[string]bool{}
+	for _, ex := range examples {
+		sources = append(sources, verifySource{Name: ex.Name, Source: ex.Source})
+		seen[ex.Name] = true
+	}
+	if corpusDir == "" {
+		return sources, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(corpusDir, "*.pio"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .pio files in corpus %s", corpusDir)
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".pio")
+		if seen[name] {
+			return nil, fmt.Errorf("corpus file %s has the same name as an example", path)
+		}
+		seen[name] = true
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, verifySource{Name: name, Source: string(b)})
+	}
+	return sources, nil
+}
+
+func cmdVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tinypio verify [flags]")
+		fmt.Fprintln(fs.Output(), "Compiles the examples and corpus and compares them against golden files.")
+		fs.PrintDefaults()
+	}
+	goldenDir := fs.String("golden", defaultGoldenDir, "directory of golden files")
+	corpusDir := fs.String("corpus", defaultCorpusDir, "directory of .pio sources checked with the examples")
+	update := fs.Bool("update", false, "rewrite the golden files from the native assembler")
+	offline := fs.Bool("offline", false, "check the native assembler only, even when pioasm is installed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("unexpected arguments")
+	}
+
+	sources, err := verifyCorpus(*corpusDir)
+	if err != nil {
+		return err
+	}
+	pioasmPath := ""
+	if !*offline {
+		pioasmPath = findPioasm()
+	}
+	results, err := verifySources(sources, *goldenDir, pioasmPath, *update)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, r := range results {
+		status := "ok  "
+		if len(r.Diffs) > 0 {
+			status = "FAIL"
+			failed++
+		}
+		line := fmt.Sprintf("%s  %s", status, r.Name)
+		if len(r.Checked) > 0 {
+			line += " (" + strings.Join(r.Checked, ", ") + ")"
+		}
+		if r.Updated {
+			line += " updated"
+		}
+		fmt.Println(line)
+		for _, d := range r.Diffs {
+			fmt.Println("      " + strings.ReplaceAll(d, "\n", "\n      "))
+		}
+	}
+	if pioasmPath == "" {
+		fmt.Println("pioasm not used; checked the native assembler against the golden files")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sources differ from their golden files", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// WaveformRequest is the body of POST /api/waveform: a simulate request
+// plus the system clock used to turn cycles into time. The clock divider
+// is config.clock_div.
+type WaveformRequest struct {
+	SimulateRequest
+	SysClock float64 `json:"sys_clock_hz,omitempty"` // defaults to 125 MHz, 150 MHz for PIO version 1 (RP2350)
+}
+
+// WaveformResult holds the pin waveform of a simulated program, both as
+// compact JSON and as a VCD file.
+type WaveformResult struct {
+	Success  bool           `json:"success"`
+	Program  string         `json:"program,omitempty"`
+	Waveform *wave.Waveform `json:"waveform,omitempty"`
+	VCD      string         `json:"vcd,omitempty"`
+	Errors   []string       `json:"errors,omitempty"`
+}
+
+func handleWaveform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req WaveformRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result := waveformPIO(req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// waveformPIO simulates the requested program and records every change
+// of its mapped pins, their directions and the side-set value.
+func waveformPIO(req WaveformRequest) WaveformResult {
+	run, err := runSimulation(req.SimulateRequest)
+	if err != nil {
+		return WaveformResult{Success: false, Errors: errorStrings(err)}
+	}
+	sysClock := req.SysClock
+	if sysClock == 0 && run.prog.PIOVersion >= 1 {
+		sysClock = 150e6
+	}
+	wf := wave.Record(run.trace, run.cfg, wave.Pins(run.cfg), sysClock)
+	var vcd strings.Builder
+	if err := wf.WriteVCD(&vcd); err != nil {
+		return WaveformResult{Success: false, Errors: errorStrings(err)}
+	}
+	return WaveformResult{Success: true, Program: run.prog.Name, Waveform: wf, VCD: vcd.String()}
+}
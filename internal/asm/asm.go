@@ -0,0 +1,274 @@
+// Package asm is a native PIO assembler for RP2040/RP2350 state machines.
+// It encodes PIO assembly into the same 16-bit machine words pioasm emits,
+// so tinypio can compile programs without the pico-sdk toolchain.
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxInstructions is the size of a PIO block's instruction memory.
+const MaxInstructions = 32
+
+// SideSet describes the .side_set configuration of a program.
+type SideSet struct {
+	Bits    int  `json:"bits"`
+	Opt     bool `json:"opt,omitempty"`
+	PinDirs bool `json:"pindirs,omitempty"`
+}
+
+// TotalBits returns the number of delay/side-set bits used by side-set,
+// including the enable bit of an optional side-set.
+func (s SideSet) TotalBits() int {
+	if s.Opt {
+		return s.Bits + 1
+	}
+	return s.Bits
+}
+
+// DelayBits returns the number of bits left for instruction delays.
+func (s SideSet) DelayBits() int {
+	return 5 - s.TotalBits()
+}
+
+// MaxDelay returns the largest delay an instruction can encode.
+func (s SideSet) MaxDelay() int {
+	return 1<<s.DelayBits() - 1
+}
+
+// String returns the configuration as a .side_set directive.
+func (s SideSet) String() string {
+	d := fmt.Sprintf(".side_set %d", s.Bits)
+	if s.Opt {
+		d += " opt"
+	}
+	if s.PinDirs {
+		d += " pindirs"
+	}
+	return d
+}
+
+// Program is an assembled PIO program.
+type Program struct {
+	Name         string     `json:"name"`
+	Instructions []uint16   `json:"instructions"`
+	Origin       int        `json:"origin"`
+	WrapTarget   int        `json:"wrap_target"`
+	Wrap         int        `json:"wrap"`
+	SideSet      SideSet    `json:"side_set"`
+	PIOVersion   int        `json:"pio_version"`
+	Labels       []*Label   `json:"labels,omitempty"`
+	Defines      []*Define  `json:"defines,omitempty"`
+	LangOpts     []*LangOpt `json:"lang_opts,omitempty"`
+	Warnings     []*Error   `json:"warnings,omitempty"`
+
+	// State machine configuration set by the PIO version 1 directives.
+	Fifo      string       `json:"fifo,omitempty"`
+	MovStatus string       `json:"mov_status,omitempty"`
+	ClockDiv  float64      `json:"clock_div,omitempty"`
+	In        *ShiftConfig `json:"in,omitempty"`
+	Out       *ShiftConfig `json:"out,omitempty"`
+	SetCount  int          `json:"set_count,omitempty"`
+
+	// Source maps each instruction address to the statement it was
+	// assembled from.
+	Source []*InstructionStmt `json:"-"`
+}
+
+// ShiftConfig is the pin count and shift register setup given by an .in
+// or .out directive.
+type ShiftConfig struct {
+	Count     int  `json:"count"`
+	Right     bool `json:"right"`
+	Auto      bool `json:"auto,omitempty"`
+	Threshold int  `json:"threshold"`
+}
+
+// Label is a label resolved to its program address.
+type Label struct {
+	Name    string `json:"name"`
+	Address int    `json:"address"`
+	Public  bool   `json:"public,omitempty"`
+	Line    int    `json:"line"`
+}
+
+// Define is a symbol declared with .define.
+type Define struct {
+	Name   string `json:"name"`
+	Value  int    `json:"value"`
+	Public bool   `json:"public,omitempty"`
+	Line   int    `json:"line"`
+}
+
+// LangOpt is a .lang_opt directive passed through to an output language.
+type LangOpt struct {
+	Lang  string `json:"lang"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Label returns the label with the given name, or nil.
+func (p *Program) Label(name string) *Label {
+	for _, l := range p.Labels {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// Hex returns the program in pioasm's hex output format: one four digit
+// word per line.
+func (p *Program) Hex() string {
+	var b strings.Builder
+	for _, w := range p.Instructions {
+		fmt.Fprintf(&b, "%04x\n", w)
+	}
+	return b.String()
+}
+
+// Error is an assembly error tied to a source position. Col is zero for
+// errors that apply to a whole line, and Line is zero for errors that
+// apply to the whole program.
+type Error struct {
+	Line int    `json:"line,omitempty"`
+	Col  int    `json:"col,omitempty"`
+	Msg  string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Line == 0:
+		return e.Msg
+	case e.Col == 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("line %d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// ErrorList collects every error found while assembling a program.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0].Error(), len(l)-1)
+}
+
+// Assemble parses and encodes PIO assembly source holding a single
+// program into machine code. Use AssembleAll for multi-program sources.
+func Assemble(source string) (*Program, error) {
+	file, err := Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	progs, err := AssembleAll(file, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(progs) != 1 {
+		return nil, ErrorList{{Msg: fmt.Sprintf("source contains %d programs, expected 1", len(progs))}}
+	}
+	return progs[0], nil
+}
+
+// AssembleFile encodes a single parsed program for target into machine
+// code. The program is returned even when there are errors so callers
+// can report its configuration; instructions that failed to encode are
+// zero.
+func AssembleFile(file *File, target Target) (*Program, error) {
+	a := &assembler{
+		prog:    &Program{Origin: -1, WrapTarget: -1, Wrap: -1, PIOVersion: target.defaultVersion()},
+		chip:    target,
+		labels:  map[string]*Label{},
+		defines: map[string]*Define{},
+		used:    map[string]bool{},
+		seen:    map[string]*DirectiveStmt{},
+	}
+	a.collect(file)
+	if len(a.insts) > MaxInstructions {
+		a.errorf(Pos{}, "program has %d instructions, max is %d", len(a.insts), MaxInstructions)
+	}
+	a.checkDirectives()
+	a.encode()
+	a.checkVersion()
+	a.checkLabels()
+	p := a.prog
+	p.Source = a.insts
+	if p.WrapTarget < 0 {
+		p.WrapTarget = 0
+	}
+	if p.Wrap < 0 {
+		p.Wrap = len(p.Instructions) - 1
+	}
+	if len(a.errs) > 0 {
+		return p, a.errs
+	}
+	return p, nil
+}
+
+type assembler struct {
+	prog    *Program
+	chip    Target
+	insts   []*InstructionStmt
+	labels  map[string]*Label
+	defines map[string]*Define
+	used    map[string]bool
+	seen    map[string]*DirectiveStmt // first use of each directive
+	v1      []v1Use
+	errs    ErrorList
+}
+
+func (a *assembler) errorf(pos Pos, format string, args ...any) {
+	a.errs = append(a.errs, &Error{Line: pos.Line, Col: pos.Col, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (a *assembler) warnf(pos Pos, format string, args ...any) {
+	a.prog.Warnings = append(a.prog.Warnings, &Error{Line: pos.Line, Col: pos.Col, Msg: fmt.Sprintf(format, args...)})
+}
+
+// collect records labels and directives and queues every instruction
+// for encoding. .word directives are queued as raw instructions.
+func (a *assembler) collect(file *File) {
+	for _, stmt := range file.Statements {
+		switch s := stmt.(type) {
+		case *LabelStmt:
+			if prev, dup := a.labels[s.Name]; dup {
+				a.errorf(s.Span.Start, "duplicate label %q (first defined at line %d)", s.Name, prev.Line)
+				continue
+			}
+			if def, dup := a.defines[s.Name]; dup {
+				a.errorf(s.Span.Start, "label %q conflicts with .define at line %d", s.Name, def.Line)
+				continue
+			}
+			l := &Label{Name: s.Name, Address: len(a.insts), Public: s.Public, Line: s.Span.Start.Line}
+			a.labels[s.Name] = l
+			a.prog.Labels = append(a.prog.Labels, l)
+		case *InstructionStmt:
+			a.insts = append(a.insts, s)
+		case *DirectiveStmt:
+			a.directive(s)
+		}
+	}
+}
+
+// checkLabels reports labels that are never referenced and labels that
+// point past the last instruction. Public labels are exported for use by
+// the loading code, so they count as used.
+func (a *assembler) checkLabels() {
+	for _, l := range a.prog.Labels {
+		pos := Pos{Line: l.Line, Col: 1}
+		if l.Address >= len(a.insts) && !a.used[l.Name] && !l.Public {
+			a.warnf(pos, "label %q is at the end of the program and does not label an instruction", l.Name)
+			continue
+		}
+		if !a.used[l.Name] && !l.Public {
+			a.warnf(pos, "label %q is never used", l.Name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package asm
+
+import "testing"
+
+func assertWords(t *testing.T, got, want []uint16) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d words, got %d: %04x", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("word %d: expected %04x, got %04x", i, want[i], got[i])
+		}
+	}
+}
+
+func TestAssemble_Squarewave(t *testing.T) {
+	prog, err := Assemble(`.program squarewave
+    set pindirs, 1
+again:
+    set pins, 1 [1]
+    set pins, 0
+    jmp again`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertWords(t, prog.Instructions, []uint16{0xe081, 0xe101, 0xe000, 0x0001})
+	if prog.Name != "squarewave" {
+		t.Fatalf("expected name squarewave, got %q", prog.Name)
+	}
+}
+
+func TestAssemble_WS2812(t *testing.T) {
+	prog, err := Assemble(`.program ws2812
+.side_set 1
+.wrap_target
+bitloop:
+    out x, 1       side 0 [2]
+    jmp !x do_zero side 1 [1]
+do_one:
+    jmp  bitloop   side 1 [4]
+do_zero:
+    nop            side 0 [4]
+.wrap`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertWords(t, prog.Instructions, []uint16{0x6221, 0x1123, 0x1400, 0xa442})
+	if prog.WrapTarget != 0 || prog.Wrap != 3 {
+		t.Fatalf("expected wrap 0..3, got %d..%d", prog.WrapTarget, prog.Wrap)
+	}
+}
+
+func TestAssemble_OptionalSideSet(t *testing.T) {
+	prog, err := Assemble(`.program uart_tx
+.side_set 1 opt
+    pull       side 1 [7]
+    set x, 7   side 0 [7]
+bitloop:
+    out pins, 1
+    jmp x-- bitloop   [6]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertWords(t, prog.Instructions, []uint16{0x9fa0, 0xf727, 0x6001, 0x0642})
+}
+
+func TestAssemble_AllOpcodes(t *testing.T) {
+	prog, err := Assemble(`    wait 1 gpio 5
+    wait 0 irq 2 rel
+    in pins, 32
+    out pc, 5
+    push iffull noblock
+    pull ifempty block
+    mov x, !y
+    mov isr, ::osr
+    irq wait 3
+    irq clear 1 rel
+    set y, 31
+    .word 0x1234`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertWords(t, prog.Instructions, []uint16{
+		0x2085, 0x2052, 0x4000, 0x60a5, 0x8040, 0x80e0,
+		0xa02a, 0xa0d7, 0xc023, 0xc051, 0xe05f, 0x1234,
+	})
+}
+
+func TestAssemble_Errors(t *testing.T) {
+	tests := []string{
+		"    jmp nowhere",
+		"    set x, 32",
+		"    badop pins, 1",
+		"    nop side 1",
+		".side_set 1\n    nop",
+		".side_set 2 opt\n    nop side 1 [4]",
+		"    in pins, 33",
+	}
+	for _, src := range tests {
+		if _, err := Assemble(src); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
+
+func TestProgramHex(t *testing.T) {
+	prog := &Program{Instructions: []uint16{0xe081, 0x0001}}
+	if got := prog.Hex(); got != "e081\n0001\n" {
+		t.Fatalf("unexpected hex output %q", got)
+	}
+}
+
+func TestAssemble_ReportsEveryBadOperand(t *testing.T) {
+	_, err := Assemble("    in bogus, 40\n    set x, 1\n    out pc 0")
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList, got %v", err)
+	}
+	want := []struct{ line, col int }{{1, 8}, {1, 15}, {3, 12}}
+	if len(list) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), list)
+	}
+	for i, w := range want {
+		if list[i].Line != w.line || list[i].Col != w.col {
+			t.Errorf("error %d: expected %d:%d, got %v", i, w.line, w.col, list[i])
+		}
+	}
+}
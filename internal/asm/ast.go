@@ -0,0 +1,125 @@
+package asm
+
+import "strings"
+
+// File is a parsed PIO source file. Statements appear in source order.
+type File struct {
+	Statements []Statement
+}
+
+// Statement is a single top-level element of a PIO source file.
+type Statement interface {
+	Pos() Pos
+	statement()
+}
+
+// DirectiveStmt is a dot directive such as .program or .side_set. The
+// raw text after the name is kept for directives like .lang_opt whose
+// arguments are not expressions.
+type DirectiveStmt struct {
+	Span    Span
+	Name    string // lowercased, including the leading dot
+	Args    []*Operand
+	Raw     string
+	Comment string
+}
+
+// LabelStmt defines a label at the address of the next instruction.
+type LabelStmt struct {
+	Span   Span
+	Name   string
+	Public bool
+}
+
+// InstructionStmt is a single PIO instruction.
+type InstructionStmt struct {
+	Span        Span
+	Op          string // lowercased opcode
+	OpSpan      Span
+	Operands    []*Operand
+	OperandText string   // operands exactly as written in the source
+	Side        *Operand // nil when no side-set value is given
+	Delay       *Operand // nil when no delay is given
+	Comment     string
+}
+
+// CommentStmt is a comment on a line of its own.
+type CommentStmt struct {
+	Span Span
+	Text string
+}
+
+// CodeBlockStmt is a % lang { ... %} pass-through block.
+type CodeBlockStmt struct {
+	Span Span
+	Lang string
+	Body string
+}
+
+func (s *DirectiveStmt) Pos() Pos   { return s.Span.Start }
+func (s *LabelStmt) Pos() Pos       { return s.Span.Start }
+func (s *InstructionStmt) Pos() Pos { return s.Span.Start }
+func (s *CommentStmt) Pos() Pos     { return s.Span.Start }
+func (s *CodeBlockStmt) Pos() Pos   { return s.Span.Start }
+
+func (*DirectiveStmt) statement()   {}
+func (*LabelStmt) statement()       {}
+func (*InstructionStmt) statement() {}
+func (*CommentStmt) statement()     {}
+func (*CodeBlockStmt) statement()   {}
+
+// Operand is one operand of an instruction or directive along with the
+// exact source text it was parsed from.
+type Operand struct {
+	Span Span
+	Text string
+	Expr Expr
+}
+
+// Expr is an operand expression.
+type Expr interface {
+	expr()
+}
+
+// IdentExpr is a register, keyword, label or symbol name.
+type IdentExpr struct {
+	Name string
+}
+
+// IntExpr is an integer literal.
+type IntExpr struct {
+	Value int
+}
+
+// UnaryExpr is a prefix or postfix operator: -, !, ~, :: or --.
+type UnaryExpr struct {
+	Op      string
+	X       Expr
+	Postfix bool
+}
+
+// BinaryExpr is an infix operator: +, -, *, / or !=.
+type BinaryExpr struct {
+	Op   string
+	X, Y Expr
+}
+
+// IndexExpr is an indexed operand such as rxfifo[y].
+type IndexExpr struct {
+	X     Expr
+	Index Expr
+}
+
+func (*IdentExpr) expr()  {}
+func (*IntExpr) expr()    {}
+func (*UnaryExpr) expr()  {}
+func (*BinaryExpr) expr() {}
+func (*IndexExpr) expr()  {}
+
+// Ident returns the lowercased name of an identifier operand.
+func (o *Operand) Ident() (string, bool) {
+	if id, ok := o.Expr.(*IdentExpr); ok {
+		return strings.ToLower(id.Name), true
+	}
+	return "", false
+}
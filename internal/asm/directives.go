@@ -0,0 +1,421 @@
+package asm
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// directiveHandlers maps every supported directive to the function that
+// applies it.
+var directiveHandlers = map[string]func(*assembler, *DirectiveStmt){
+	".program":     (*assembler).program,
+	".side_set":    (*assembler).sideSet,
+	".origin":      (*assembler).origin,
+	".wrap_target": (*assembler).wrapTarget,
+	".wrap":        (*assembler).wrap,
+	".define":      (*assembler).define,
+	".word":        (*assembler).word,
+	".lang_opt":    (*assembler).langOpt,
+	".pio_version": (*assembler).pioVersion,
+	".fifo":        (*assembler).fifo,
+	".mov_status":  (*assembler).movStatus,
+	".clock_div":   (*assembler).clockDiv,
+	".in":          (*assembler).inConfig,
+	".out":         (*assembler).outConfig,
+	".set":         (*assembler).setConfig,
+}
+
+// uniqueDirectives may appear at most once per program.
+var uniqueDirectives = map[string]bool{
+	".side_set": true, ".origin": true, ".wrap_target": true, ".wrap": true,
+	".pio_version": true, ".fifo": true, ".mov_status": true, ".clock_div": true,
+	".in": true, ".out": true, ".set": true,
+}
+
+func (a *assembler) directive(d *DirectiveStmt) {
+	handler, ok := directiveHandlers[d.Name]
+	if !ok {
+		msg := fmt.Sprintf("unknown directive %q", d.Name)
+		if s := suggestDirective(d.Name); s != "" {
+			msg += fmt.Sprintf(" (did you mean %s?)", s)
+		}
+		a.errorf(d.Span.Start, "%s", msg)
+		return
+	}
+	if first, dup := a.seen[d.Name]; dup {
+		if uniqueDirectives[d.Name] {
+			a.errorf(d.Span.Start, "duplicate %s directive (first at line %d)", d.Name, first.Span.Start.Line)
+			return
+		}
+	} else {
+		a.seen[d.Name] = d
+	}
+	handler(a, d)
+}
+
+// directiveArgs checks that a directive has between min and max
+// arguments.
+func (a *assembler) directiveArgs(d *DirectiveStmt, min, max int, usage string) bool {
+	switch {
+	case len(d.Args) > max:
+		a.errorf(d.Args[max].Span.Start, "unexpected operand %q: %s", d.Args[max].Text, usage)
+		return false
+	case len(d.Args) < min:
+		a.errorf(d.Span.End, "missing operand: %s", usage)
+		return false
+	}
+	return true
+}
+
+// beforeInstructions reports directives that configure the whole program
+// but appear after its first instruction.
+func (a *assembler) beforeInstructions(d *DirectiveStmt) {
+	if len(a.insts) > 0 {
+		a.errorf(d.Span.Start, "%s must appear before the first instruction", d.Name)
+	}
+}
+
+func (a *assembler) program(d *DirectiveStmt) {
+	if !a.directiveArgs(d, 1, 1, ".program expects a name") {
+		return
+	}
+	if _, ok := d.Args[0].Expr.(*IdentExpr); !ok {
+		a.errorf(d.Args[0].Span.Start, "invalid program name %q", d.Args[0].Text)
+		return
+	}
+	a.prog.Name = d.Args[0].Text
+}
+
+// sideSet applies a .side_set N [opt] [pindirs] directive. The side-set
+// bits and the delay share the 5-bit field in bits 12:8 of every
+// instruction, so N plus the opt enable bit may use at most 5 bits.
+func (a *assembler) sideSet(d *DirectiveStmt) {
+	a.beforeInstructions(d)
+	if len(d.Args) == 0 {
+		a.errorf(d.Span.End, ".side_set requires a bit count")
+		return
+	}
+	n, ok := a.eval(d.Args[0])
+	if !ok {
+		return
+	}
+	ss := SideSet{Bits: n}
+	for _, arg := range d.Args[1:] {
+		switch name, _ := arg.Ident(); {
+		case name == "opt" && !ss.Opt:
+			ss.Opt = true
+		case name == "pindirs" && !ss.PinDirs:
+			ss.PinDirs = true
+		default:
+			a.errorf(arg.Span.Start, "unexpected .side_set option %q (want opt, pindirs)", arg.Text)
+		}
+	}
+	max := 5
+	if ss.Opt {
+		max = 4
+	}
+	if n < 0 || n > max {
+		a.errorf(d.Args[0].Span.Start, "side-set count %d out of range 0-%d for %s", n, max, ss)
+		return
+	}
+	a.prog.SideSet = ss
+}
+
+func (a *assembler) origin(d *DirectiveStmt) {
+	a.beforeInstructions(d)
+	if !a.directiveArgs(d, 1, 1, ".origin expects an address") {
+		return
+	}
+	if n, ok := a.value(d.Args[0], MaxInstructions-1); ok {
+		a.prog.Origin = n
+	}
+}
+
+func (a *assembler) wrapTarget(d *DirectiveStmt) {
+	if a.directiveArgs(d, 0, 0, ".wrap_target takes no operands") {
+		a.prog.WrapTarget = len(a.insts)
+	}
+}
+
+func (a *assembler) wrap(d *DirectiveStmt) {
+	if !a.directiveArgs(d, 0, 0, ".wrap takes no operands") {
+		return
+	}
+	if len(a.insts) == 0 {
+		a.errorf(d.Span.Start, ".wrap must follow an instruction")
+		return
+	}
+	a.prog.Wrap = len(a.insts) - 1
+}
+
+// define applies .define [PUBLIC] name value. Defines are evaluated in
+// source order, so a define may use any define above it.
+func (a *assembler) define(d *DirectiveStmt) {
+	const usage = ".define expects [PUBLIC] name value"
+	args := d.Args
+	public := false
+	if len(args) == 3 && strings.EqualFold(args[0].Text, "public") {
+		public, args = true, args[1:]
+	}
+	if len(args) != 2 {
+		a.directiveArgs(&DirectiveStmt{Span: d.Span, Args: args}, 2, 2, usage)
+		return
+	}
+	id, ok := args[0].Expr.(*IdentExpr)
+	if !ok {
+		a.errorf(args[0].Span.Start, "invalid symbol name %q", args[0].Text)
+		return
+	}
+	if prev, dup := a.defines[id.Name]; dup {
+		a.errorf(args[0].Span.Start, "duplicate .define %q (first defined at line %d)", id.Name, prev.Line)
+		return
+	}
+	if l, dup := a.labels[id.Name]; dup {
+		a.errorf(args[0].Span.Start, ".define %q conflicts with label at line %d", id.Name, l.Line)
+		return
+	}
+	v, ok := a.eval(args[1])
+	if !ok {
+		return
+	}
+	def := &Define{Name: id.Name, Value: v, Public: public, Line: d.Span.Start.Line}
+	a.defines[id.Name] = def
+	a.prog.Defines = append(a.prog.Defines, def)
+}
+
+// word queues a raw 16-bit instruction word.
+func (a *assembler) word(d *DirectiveStmt) {
+	if a.directiveArgs(d, 1, 1, ".word expects a value") {
+		a.insts = append(a.insts, &InstructionStmt{
+			Span:        d.Span,
+			OpSpan:      d.Span,
+			Op:          ".word",
+			Operands:    d.Args,
+			OperandText: d.Args[0].Text,
+			Comment:     d.Comment,
+		})
+	}
+}
+
+// langOpt applies .lang_opt <lang> <name> = <value>.
+func (a *assembler) langOpt(d *DirectiveStmt) {
+	lhs, value, ok := strings.Cut(d.Raw, "=")
+	fields := strings.Fields(lhs)
+	value = strings.TrimSpace(value)
+	if !ok || len(fields) != 2 || value == "" {
+		a.errorf(d.Span.Start, ".lang_opt expects <lang> <name> = <value>")
+		return
+	}
+	a.prog.LangOpts = append(a.prog.LangOpts, &LangOpt{Lang: fields[0], Name: fields[1], Value: value})
+}
+
+// pioVersion applies .pio_version 0|1.
+func (a *assembler) pioVersion(d *DirectiveStmt) {
+	a.beforeInstructions(d)
+	if !a.directiveArgs(d, 1, 1, ".pio_version expects 0 or 1") {
+		return
+	}
+	v, ok := a.value(d.Args[0], 1)
+	if !ok {
+		return
+	}
+	if v > a.chip.MaxVersion() {
+		a.errorf(d.Args[0].Span.Start, "PIO version %d requires RP2350; target %s only supports PIO version %d",
+			v, a.chip, a.chip.MaxVersion())
+		return
+	}
+	a.prog.PIOVersion = v
+}
+
+// fifoModes maps each .fifo mode to whether it needs PIO version 1. The
+// put/get modes let the state machine use the RX FIFO as storage.
+var fifoModes = map[string]bool{
+	"txrx": false, "tx": false, "rx": false, "txput": true, "txget": true, "putget": true,
+}
+
+func (a *assembler) fifo(d *DirectiveStmt) {
+	a.beforeInstructions(d)
+	if !a.directiveArgs(d, 1, 1, ".fifo expects txrx, tx, rx, txput, txget or putget") {
+		return
+	}
+	mode := canonical(d.Args[0])
+	v1, ok := fifoModes[mode]
+	if !ok {
+		a.errorf(d.Args[0].Span.Start, "invalid .fifo mode %q (want txrx, tx, rx, txput, txget, putget)", d.Args[0].Text)
+		return
+	}
+	if v1 {
+		a.requireV1(d.Args[0].Span.Start, ".fifo "+mode)
+	}
+	a.prog.Fifo = mode
+}
+
+// movStatus applies .mov_status txfifo|rxfifo < n or
+// .mov_status irq [prev|next] set n, which selects what mov x, status
+// reads.
+func (a *assembler) movStatus(d *DirectiveStmt) {
+	a.beforeInstructions(d)
+	a.requireV1(d.Span.Start, ".mov_status")
+	const usage = ".mov_status expects txfifo < n, rxfifo < n or irq [prev|next] set n"
+	f := strings.Fields(strings.ReplaceAll(strings.ToLower(d.Raw), "<", " < "))
+	var n string
+	max := 31
+	switch {
+	case len(f) == 3 && (f[0] == "txfifo" || f[0] == "rxfifo") && f[1] == "<":
+		n = f[2]
+	case len(f) == 3 && f[0] == "irq" && f[1] == "set":
+		n, max = f[2], 7
+	case len(f) == 4 && f[0] == "irq" && (f[1] == "prev" || f[1] == "next") && f[2] == "set":
+		n, max = f[3], 7
+	default:
+		a.errorf(d.Span.Start, "%s", usage)
+		return
+	}
+	v, err := parseInt(n)
+	if err != nil {
+		var ok bool
+		if v, ok = a.symbol(n); !ok {
+			a.errorf(d.Span.Start, "invalid .mov_status value %q", n)
+			return
+		}
+	}
+	if v < 0 || v > max {
+		a.errorf(d.Span.Start, ".mov_status value %d out of range 0-%d", v, max)
+		return
+	}
+	f[len(f)-1] = strconv.Itoa(v)
+	a.prog.MovStatus = strings.Join(f, " ")
+}
+
+// clockDiv applies .clock_div, the default state machine clock divider.
+func (a *assembler) clockDiv(d *DirectiveStmt) {
+	a.beforeInstructions(d)
+	a.requireV1(d.Span.Start, ".clock_div")
+	div, err := strconv.ParseFloat(d.Raw, 64)
+	if err != nil {
+		a.errorf(d.Span.Start, ".clock_div expects a number, got %q", d.Raw)
+		return
+	}
+	if div < 1 || div > 65536 {
+		a.errorf(d.Span.Start, ".clock_div %g out of range 1-65536", div)
+		return
+	}
+	a.prog.ClockDiv = div
+}
+
+func (a *assembler) inConfig(d *DirectiveStmt) {
+	a.prog.In = a.shiftConfig(d)
+}
+
+func (a *assembler) outConfig(d *DirectiveStmt) {
+	a.prog.Out = a.shiftConfig(d)
+}
+
+// shiftConfig parses .in/.out <count> [left|right] [auto|manual]
+// [threshold].
+func (a *assembler) shiftConfig(d *DirectiveStmt) *ShiftConfig {
+	a.beforeInstructions(d)
+	a.requireV1(d.Span.Start, d.Name)
+	if len(d.Args) == 0 {
+		a.errorf(d.Span.End, "%s expects a pin count", d.Name)
+		return nil
+	}
+	count, ok := a.value(d.Args[0], 32)
+	if !ok {
+		return nil
+	}
+	cfg := &ShiftConfig{Count: count, Right: true, Threshold: 32}
+	seen := map[string]bool{}
+	for _, arg := range d.Args[1:] {
+		name, _ := arg.Ident()
+		option := name
+		switch name {
+		case "left", "right":
+			option = "direction"
+			cfg.Right = name == "right"
+		case "auto", "manual":
+			option = "mode"
+			cfg.Auto = name == "auto"
+		default:
+			if id, isIdent := arg.Expr.(*IdentExpr); isIdent {
+				if _, defined := a.symbol(id.Name); !defined {
+					a.errorf(arg.Span.Start, "unexpected %s option %q (want left, right, auto, manual or a threshold)", d.Name, arg.Text)
+					continue
+				}
+			}
+			option = "threshold"
+			if n, ok := a.value(arg, 32); ok {
+				cfg.Threshold = n
+			}
+		}
+		if seen[option] {
+			a.errorf(arg.Span.Start, "duplicate %s %s %q", d.Name, option, arg.Text)
+		}
+		seen[option] = true
+	}
+	return cfg
+}
+
+func (a *assembler) setConfig(d *DirectiveStmt) {
+	a.beforeInstructions(d)
+	a.requireV1(d.Span.Start, ".set")
+	if !a.directiveArgs(d, 1, 1, ".set expects a pin count") {
+		return
+	}
+	if n, ok := a.value(d.Args[0], 5); ok {
+		a.prog.SetCount = n
+	}
+}
+
+// checkDirectives runs the directive checks that need the whole program.
+func (a *assembler) checkDirectives() {
+	if d := a.seen[".wrap_target"]; d != nil && a.prog.WrapTarget >= len(a.insts) {
+		a.errorf(d.Span.Start, ".wrap_target must be followed by an instruction")
+	}
+	if d := a.seen[".origin"]; d != nil && a.prog.Origin >= 0 {
+		if end := a.prog.Origin + len(a.insts) - 1; end >= MaxInstructions {
+			a.errorf(d.Span.Start, "program of %d instructions at .origin %d ends at address %d, past %d",
+				len(a.insts), a.prog.Origin, end, MaxInstructions-1)
+		}
+	}
+}
+
+// suggestDirective returns the known directive closest to name when it is
+// likely to be a typo.
+func suggestDirective(name string) string {
+	known := make([]string, 0, len(directiveHandlers))
+	for k := range directiveHandlers {
+		known = append(known, k)
+	}
+	slices.Sort(known)
+	best, bestDist := "", 3
+	for _, k := range known {
+		if d := editDistance(name, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,62 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDirectives(t *testing.T) {
+	prog, err := Assemble(`.program blink
+.origin 4
+.define PUBLIC DELAY 3
+.define T (DELAY + 1) * 2
+.lang_opt python out_init = pico.PIO.OUT_LOW
+    set pins, 1 [DELAY]
+.wrap_target
+loop:
+    set x, T
+    .word 0xa042
+    jmp loop
+.wrap
+    nop`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertWords(t, prog.Instructions, []uint16{0xe301, 0xe028, 0xa042, 0x0001, 0xa042})
+	if prog.Origin != 4 || prog.WrapTarget != 1 || prog.Wrap != 3 {
+		t.Fatalf("unexpected origin %d, wrap %d..%d", prog.Origin, prog.WrapTarget, prog.Wrap)
+	}
+	if len(prog.Defines) != 2 || !prog.Defines[0].Public || prog.Defines[1].Value != 8 {
+		t.Fatalf("unexpected defines %+v", prog.Defines)
+	}
+	if len(prog.LangOpts) != 1 || prog.LangOpts[0].Value != "pico.PIO.OUT_LOW" {
+		t.Fatalf("unexpected lang opts %+v", prog.LangOpts)
+	}
+}
+
+func TestDirectiveErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{".wrapp", `unknown directive ".wrapp" (did you mean .wrap?)`},
+		{".bogus_directive 1", `unknown directive ".bogus_directive"`},
+		{"    nop\n.wrap\n    nop\n.wrap", "duplicate .wrap directive (first at line 2)"},
+		{".wrap\n    nop", ".wrap must follow an instruction"},
+		{"    nop\n.wrap_target", ".wrap_target must be followed by an instruction"},
+		{".origin 30\n    nop\n    nop\n    nop", "program of 3 instructions at .origin 30 ends at address 32, past 31"},
+		{".origin 32\n    nop", "value 32 out of range 0-31"},
+		{"    nop\n.origin 1", ".origin must appear before the first instruction"},
+		{".pio_version 1\n    nop\n.clock_div 2", "3:1: .clock_div must appear before the first instruction"},
+		{".define X 1\n.define X 2", `duplicate .define "X"`},
+		{".word", "missing operand: .word expects a value"},
+		{".lang_opt python", ".lang_opt expects <lang> <name> = <value>"},
+		{".wrap_target 1\n    nop", `unexpected operand "1"`},
+	}
+	for _, tt := range tests {
+		_, err := Assemble(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.src, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,540 @@
+package asm
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Major opcodes, already shifted into bits 15:13.
+const (
+	opJmp  uint16 = 0x0000
+	opWait uint16 = 0x2000
+	opIn   uint16 = 0x4000
+	opOut  uint16 = 0x6000
+	opPush uint16 = 0x8000
+	opMov  uint16 = 0xa000
+	opIRQ  uint16 = 0xc000
+	opSet  uint16 = 0xe000
+)
+
+var jmpConditions = map[string]uint16{
+	"!x": 1, "x--": 2, "!y": 3, "y--": 4, "x!=y": 5, "pin": 6, "!osre": 7,
+}
+
+var waitSources = map[string]uint16{"gpio": 0, "pin": 1, "irq": 2, "jmppin": 3}
+
+// irqIndexModes select an irq flag relative to the state machine, in
+// bits 4:3 of the irq and wait irq index. prev and next address the
+// neighbouring PIO blocks on RP2350.
+var irqIndexModes = map[string]uint16{"prev": 0x08, "rel": 0x10, "next": 0x18}
+
+var inSources = map[string]uint16{
+	"pins": 0, "x": 1, "y": 2, "null": 3, "isr": 6, "osr": 7,
+}
+
+var outDestinations = map[string]uint16{
+	"pins": 0, "x": 1, "y": 2, "null": 3, "pindirs": 4, "pc": 5, "isr": 6, "exec": 7,
+}
+
+// mov pindirs is RP2350 only.
+var movDestinations = map[string]uint16{
+	"pins": 0, "x": 1, "y": 2, "pindirs": 3, "exec": 4, "pc": 5, "isr": 6, "osr": 7,
+}
+
+var movSources = map[string]uint16{
+	"pins": 0, "x": 1, "y": 2, "null": 3, "status": 5, "isr": 6, "osr": 7,
+}
+
+var setDestinations = map[string]uint16{"pins": 0, "x": 1, "y": 2, "pindirs": 4}
+
+// encode turns every queued instruction into a machine word.
+func (a *assembler) encode() {
+	for _, inst := range a.insts {
+		word, ok := a.encodeInstruction(inst)
+		if !ok {
+			word = 0
+		}
+		if inst.Op != ".word" {
+			word |= a.delaySide(inst)
+		}
+		a.prog.Instructions = append(a.prog.Instructions, word)
+	}
+}
+
+// delaySide packs the delay and side-set value into bits 12:8, checking
+// both against the bits the .side_set directive leaves for them.
+func (a *assembler) delaySide(inst *InstructionStmt) uint16 {
+	ss := a.prog.SideSet
+	field := uint16(0)
+	if inst.Delay != nil {
+		if delay, ok := a.eval(inst.Delay); ok {
+			switch {
+			case delay >= 0 && delay <= ss.MaxDelay():
+				field = uint16(delay)
+			case ss.Bits > 0:
+				a.errorf(inst.Delay.Span.Start, "delay %d exceeds the %d delay bits left by %s (max %d)",
+					delay, ss.DelayBits(), ss, ss.MaxDelay())
+			default:
+				a.errorf(inst.Delay.Span.Start, "delay %d out of range 0-%d", delay, ss.MaxDelay())
+			}
+		}
+	}
+	switch {
+	case inst.Side != nil && ss.Bits == 0:
+		a.errorf(inst.Side.Span.Start, "side-set used without .side_set directive")
+	case inst.Side != nil:
+		side, ok := a.eval(inst.Side)
+		if !ok {
+			break
+		}
+		if side < 0 || side >= 1<<ss.Bits {
+			a.errorf(inst.Side.Span.Start, "side-set value %d does not fit in %d bits of %s (max %d)",
+				side, ss.Bits, ss, 1<<ss.Bits-1)
+			break
+		}
+		field |= uint16(side) << ss.DelayBits()
+		if ss.Opt {
+			field |= 0x10
+		}
+	case ss.Bits > 0 && !ss.Opt:
+		a.errorf(inst.Span.End, "missing side-set value required by %s (add \"side N\" or use .side_set %d opt)",
+			ss, ss.Bits)
+	}
+	return field << 8
+}
+
+func (a *assembler) encodeInstruction(inst *InstructionStmt) (uint16, bool) {
+	args := inst.Operands
+	switch inst.Op {
+	case ".word":
+		v, ok := a.value(args[0], 0xffff)
+		return uint16(v), ok
+	case "nop":
+		if !a.arity(inst, args, 0, "nop takes no operands") {
+			return 0, false
+		}
+		return opMov | 2<<5 | 2, true // mov y, y
+	case "jmp":
+		return a.encodeJmp(inst)
+	case "wait":
+		return a.encodeWait(inst)
+	case "in":
+		return a.encodeShift(inst, opIn, "source", inSources)
+	case "out":
+		return a.encodeShift(inst, opOut, "destination", outDestinations)
+	case "push", "pull":
+		return a.encodePushPull(inst)
+	case "mov":
+		return a.encodeMov(inst)
+	case "irq":
+		return a.encodeIRQ(inst)
+	case "set":
+		if !a.arity(inst, args, 2, "set expects destination, value") {
+			return 0, false
+		}
+		dst, ok := a.lookup(args[0], "set destination", setDestinations)
+		v, vok := a.value(args[1], 31)
+		return opSet | dst<<5 | uint16(v), ok && vok
+	}
+	a.errorf(inst.OpSpan.Start, "unknown opcode '%s'", inst.Op)
+	return 0, false
+}
+
+// arity checks that exactly n operands remain. Surplus operands are
+// reported at their own position, missing ones at the end of the
+// instruction.
+func (a *assembler) arity(inst *InstructionStmt, args []*Operand, n int, usage string) bool {
+	switch {
+	case len(args) > n:
+		a.errorf(args[n].Span.Start, "unexpected operand %q: %s", args[n].Text, usage)
+		return false
+	case len(args) < n:
+		a.errorf(inst.Span.End, "missing operand: %s", usage)
+		return false
+	}
+	return true
+}
+
+// lookup resolves a keyword operand such as a register name.
+func (a *assembler) lookup(op *Operand, what string, table map[string]uint16) (uint16, bool) {
+	v, ok := table[canonical(op)]
+	if !ok {
+		a.errorf(op.Span.Start, "invalid %s %q (want %s)", what, op.Text, keywords(table))
+	}
+	return v, ok
+}
+
+// keywords lists the names in a keyword table in encoding order.
+func keywords(table map[string]uint16) string {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(x, y string) int {
+		if c := cmp.Compare(table[x], table[y]); c != 0 {
+			return c
+		}
+		return cmp.Compare(x, y)
+	})
+	return strings.Join(names, ", ")
+}
+
+func (a *assembler) encodeJmp(inst *InstructionStmt) (uint16, bool) {
+	const usage = "jmp expects [condition,] target"
+	args := inst.Operands
+	if len(args) == 0 {
+		a.arity(inst, args, 1, usage)
+		return 0, false
+	}
+	cond, ok := uint16(0), true
+	if n := len(args); n > 1 {
+		// A malformed condition such as "x!!y" parses as several
+		// operands, so report everything before the target as one.
+		text := args[0].Text
+		for _, op := range args[1 : n-1] {
+			text += op.Text
+		}
+		c, found := jmpConditions[strings.ToLower(strings.Join(strings.Fields(text), ""))]
+		if !found {
+			a.errorf(args[0].Span.Start, "invalid jmp condition %q (want %s)", text, keywords(jmpConditions))
+			ok = false
+		}
+		cond = c
+	}
+	addr, tok := a.target(args[len(args)-1])
+	return opJmp | cond<<5 | uint16(addr), ok && tok
+}
+
+func (a *assembler) encodeWait(inst *InstructionStmt) (uint16, bool) {
+	const usage = "wait expects [polarity,] gpio|pin|irq|jmppin, index"
+	args := inst.Operands
+	pol, ok := 1, true
+	if len(args) > 0 {
+		_, isSource := waitSources[canonical(args[0])]
+		if _, isJmpPin := jmpPinOffset(args[0]); !isSource && !isJmpPin {
+			v, vok := a.value(args[0], 1)
+			pol, ok, args = v, vok, args[1:]
+		}
+	}
+	if len(args) > 0 {
+		if offset, isJmpPin := jmpPinOffset(args[0]); isJmpPin {
+			// wait jmppin [+ offset] waits on a pin relative to the jmp pin.
+			a.requireV1(args[0].Span.Start, "wait jmppin")
+			idx, iok := 0, a.arity(inst, args, 1, "wait expects [polarity,] jmppin [+ offset]")
+			if offset != nil && iok {
+				idx, iok = a.value(offset, 3)
+			}
+			return opWait | uint16(pol)<<7 | 3<<5 | uint16(idx), ok && iok
+		}
+	}
+	if len(args) < 2 {
+		a.arity(inst, args, 2, usage)
+		return 0, false
+	}
+	src, sok := a.lookup(args[0], "wait source", waitSources)
+	if !sok {
+		return 0, false
+	}
+	if src == 2 {
+		idx, iok := a.irqIndex(inst, args[1:], usage)
+		return opWait | uint16(pol)<<7 | src<<5 | idx, ok && iok
+	}
+	idx, iok := a.value(args[1], 31)
+	ok = ok && iok && a.arity(inst, args, 2, usage)
+	return opWait | uint16(pol)<<7 | src<<5 | uint16(idx), ok
+}
+
+// jmpPinOffset reports whether op is "jmppin" or "jmppin + offset", and
+// returns the offset operand if there is one.
+func jmpPinOffset(op *Operand) (*Operand, bool) {
+	isJmpPin := func(x Expr) bool {
+		id, ok := x.(*IdentExpr)
+		return ok && strings.EqualFold(id.Name, "jmppin")
+	}
+	if isJmpPin(op.Expr) {
+		return nil, true
+	}
+	if b, ok := op.Expr.(*BinaryExpr); ok && b.Op == "+" && isJmpPin(b.X) {
+		return &Operand{Span: op.Span, Text: op.Text, Expr: b.Y}, true
+	}
+	return nil, false
+}
+
+func (a *assembler) encodeShift(inst *InstructionStmt, op uint16, what string, regs map[string]uint16) (uint16, bool) {
+	args := inst.Operands
+	if !a.arity(inst, args, 2, inst.Op+" expects "+what+", bit count") {
+		return 0, false
+	}
+	reg, ok := a.lookup(args[0], inst.Op+" "+what, regs)
+	n, nok := a.eval(args[1])
+	if nok && (n < 1 || n > 32) {
+		a.errorf(args[1].Span.Start, "bit count %d out of range 1-32", n)
+		nok = false
+	}
+	return op | reg<<5 | uint16(n&31), ok && nok
+}
+
+func (a *assembler) encodePushPull(inst *InstructionStmt) (uint16, bool) {
+	word := opPush | 0x20 // block is the default
+	cond := "iffull"
+	if inst.Op == "pull" {
+		word |= 0x80
+		cond = "ifempty"
+	}
+	ok := true
+	seen := map[string]bool{}
+	for _, arg := range inst.Operands {
+		name := canonical(arg)
+		switch {
+		case name == "block" || name == "noblock":
+			if seen["block"] {
+				a.errorf(arg.Span.Start, "conflicting %s blocking option %q", inst.Op, arg.Text)
+				ok = false
+			}
+			seen["block"] = true
+			if name == "noblock" {
+				word &^= 0x20
+			}
+		case name == cond:
+			if seen[cond] {
+				a.errorf(arg.Span.Start, "duplicate %s option %q", inst.Op, arg.Text)
+				ok = false
+			}
+			seen[cond] = true
+			word |= 0x40
+		default:
+			a.errorf(arg.Span.Start, "unexpected %s operand %q (want %s, block, noblock)", inst.Op, arg.Text, cond)
+			ok = false
+		}
+	}
+	return word, ok
+}
+
+func (a *assembler) encodeMov(inst *InstructionStmt) (uint16, bool) {
+	args := inst.Operands
+	if !a.arity(inst, args, 2, "mov expects destination, source") {
+		return 0, false
+	}
+	if _, isIndex := args[0].Expr.(*IndexExpr); isIndex {
+		return a.encodeMovRx(inst, args[0], args[1], 0, "isr")
+	}
+	if _, isIndex := args[1].Expr.(*IndexExpr); isIndex {
+		return a.encodeMovRx(inst, args[1], args[0], 0x80, "osr")
+	}
+	dst, ok := a.lookup(args[0], "mov destination", movDestinations)
+	if ok && dst == movDestinations["pindirs"] {
+		a.requireV1(args[0].Span.Start, "mov pindirs")
+	}
+	src, op := args[1].Expr, uint16(0)
+	if u, isUnary := src.(*UnaryExpr); isUnary && !u.Postfix {
+		switch u.Op {
+		case "!", "~":
+			src, op = u.X, 1
+		case "::":
+			src, op = u.X, 2
+		}
+	}
+	id, _ := src.(*IdentExpr)
+	s, sok := uint16(0), false
+	if id != nil {
+		s, sok = movSources[strings.ToLower(id.Name)]
+	}
+	if !sok {
+		a.errorf(args[1].Span.Start, "invalid mov source %q (want [!|~|::]%s)", args[1].Text, keywords(movSources))
+	}
+	return opMov | dst<<5 | op<<3 | s, ok && sok
+}
+
+// encodeMovRx encodes the RP2350 mov rxfifo[index], isr and
+// mov osr, rxfifo[index], which share the push/pull opcode. The index is
+// either y or a constant 0-3, and the other operand must be want.
+func (a *assembler) encodeMovRx(inst *InstructionStmt, rx, reg *Operand, dir uint16, want string) (uint16, bool) {
+	a.requireV1(inst.OpSpan.Start, "mov rxfifo[...]")
+	ok := true
+	if canonical(reg) != want {
+		a.errorf(reg.Span.Start, "mov with rxfifo[...] needs %s, got %q", want, reg.Text)
+		ok = false
+	}
+	index := rx.Expr.(*IndexExpr).Index
+	if id, isIdent := index.(*IdentExpr); isIdent && strings.EqualFold(id.Name, "y") {
+		return opPush | dir | 0x10, ok
+	}
+	idx, err := a.evalExpr(index)
+	switch {
+	case err != nil:
+		a.errorf(rx.Span.Start, "invalid rxfifo index: %v", err)
+		return 0, false
+	case idx < 0 || idx > 3:
+		a.errorf(rx.Span.Start, "rxfifo index %d out of range 0-3 (or y)", idx)
+		return 0, false
+	}
+	return opPush | dir | 0x18 | uint16(idx), ok
+}
+
+func (a *assembler) encodeIRQ(inst *InstructionStmt) (uint16, bool) {
+	const usage = "irq expects [prev|next] [set|nowait|wait|clear] index [rel]"
+	args := inst.Operands
+	word := opIRQ
+	var mode *Operand
+	if len(args) > 0 {
+		if m := canonical(args[0]); m == "prev" || m == "next" {
+			mode, args = args[0], args[1:]
+		}
+	}
+	if len(args) > 0 {
+		switch canonical(args[0]) {
+		case "set", "nowait":
+			args = args[1:]
+		case "wait":
+			word |= 0x20
+			args = args[1:]
+		case "clear":
+			word |= 0x40
+			args = args[1:]
+		default:
+			if id, ok := args[0].Expr.(*IdentExpr); ok && len(args) > 1 {
+				if _, defined := a.symbol(id.Name); !defined {
+					a.errorf(args[0].Span.Start, "invalid irq mode %q (want set, nowait, wait, clear)", args[0].Text)
+					return 0, false
+				}
+			}
+		}
+	}
+	if mode != nil {
+		args = append([]*Operand{mode}, args...)
+	}
+	idx, ok := a.irqIndex(inst, args, usage)
+	return word | idx, ok
+}
+
+// irqIndex encodes the "[prev|next] index [rel]" operands shared by irq
+// and wait irq.
+func (a *assembler) irqIndex(inst *InstructionStmt, args []*Operand, usage string) (uint16, bool) {
+	mode := uint16(0)
+	if len(args) > 0 {
+		if m := canonical(args[0]); m == "prev" || m == "next" {
+			a.requireV1(args[0].Span.Start, inst.Op+" "+m)
+			mode, args = irqIndexModes[m], args[1:]
+		}
+	}
+	if len(args) == 0 {
+		a.arity(inst, args, 1, usage)
+		return 0, false
+	}
+	idx, ok := a.value(args[0], 7)
+	if len(args) == 2 && canonical(args[1]) == "rel" {
+		if mode != 0 {
+			a.errorf(args[1].Span.Start, "rel cannot be combined with prev or next")
+			return 0, false
+		}
+		mode = irqIndexModes["rel"]
+	} else if !a.arity(inst, args, 1, usage) {
+		ok = false
+	}
+	return mode | uint16(idx), ok
+}
+
+// target resolves a jmp target, which is either a label or an address
+// within the program.
+func (a *assembler) target(op *Operand) (int, bool) {
+	if id, ok := op.Expr.(*IdentExpr); ok {
+		if _, defined := a.symbol(id.Name); !defined {
+			a.errorf(op.Span.Start, "undefined label %q", id.Name)
+			return 0, false
+		}
+	}
+	n, ok := a.eval(op)
+	if !ok {
+		return 0, false
+	}
+	if n < 0 || n >= len(a.insts) {
+		a.errorf(op.Span.Start, "jmp target %d is outside the program (addresses 0-%d)", n, len(a.insts)-1)
+		return 0, false
+	}
+	return n, true
+}
+
+// value evaluates an operand and checks it against 0..max.
+func (a *assembler) value(op *Operand, max int) (int, bool) {
+	n, ok := a.eval(op)
+	if !ok {
+		return 0, false
+	}
+	if n < 0 || n > max {
+		a.errorf(op.Span.Start, "value %d out of range 0-%d", n, max)
+		return 0, false
+	}
+	return n, true
+}
+
+// eval evaluates an operand expression. Labels may be used as values.
+func (a *assembler) eval(op *Operand) (int, bool) {
+	n, err := a.evalExpr(op.Expr)
+	if err != nil {
+		a.errorf(op.Span.Start, "%v", err)
+		return 0, false
+	}
+	return n, true
+}
+
+// symbol looks up the value of a .define or label and marks it as used.
+func (a *assembler) symbol(name string) (int, bool) {
+	if d, ok := a.defines[name]; ok {
+		return d.Value, true
+	}
+	l, ok := a.labels[name]
+	if !ok {
+		return 0, false
+	}
+	a.used[name] = true
+	return l.Address, true
+}
+
+func (a *assembler) evalExpr(x Expr) (int, error) {
+	switch x := x.(type) {
+	case *IntExpr:
+		return x.Value, nil
+	case *IdentExpr:
+		if n, ok := a.symbol(x.Name); ok {
+			return n, nil
+		}
+		return 0, fmt.Errorf("undefined symbol %q", x.Name)
+	case *UnaryExpr:
+		if x.Op != "-" {
+			break
+		}
+		n, err := a.evalExpr(x.X)
+		return -n, err
+	case *BinaryExpr:
+		l, err := a.evalExpr(x.X)
+		if err != nil {
+			return 0, err
+		}
+		r, err := a.evalExpr(x.Y)
+		if err != nil {
+			return 0, err
+		}
+		switch x.Op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			if r == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return l / r, nil
+		}
+	}
+	return 0, fmt.Errorf("expected a numeric value")
+}
+
+// canonical returns an operand's source text lowercased with whitespace
+// removed, so that "x --" and "X--" both read as "x--".
+func canonical(op *Operand) string {
+	return strings.ToLower(strings.Join(strings.Fields(op.Text), ""))
+}
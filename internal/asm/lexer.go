@@ -0,0 +1,207 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind identifies the lexical class of a token.
+type TokenKind int
+
+const (
+	EOF TokenKind = iota
+	Newline
+	Ident
+	Int
+	Directive // .name
+	Comment   // ; ..., // ... or /* ... */
+	CodeBlock // % lang { ... %}
+	Comma
+	Colon
+	DoubleColon
+	LBracket
+	RBracket
+	LParen
+	RParen
+	Plus
+	Minus
+	Decrement // --
+	Star
+	Slash
+	Bang
+	Tilde
+	NotEqual // !=
+	Less
+	Assign
+	Illegal
+)
+
+var tokenNames = [...]string{
+	EOF: "end of file", Newline: "end of line", Ident: "identifier", Int: "integer",
+	Directive: "directive", Comment: "comment", CodeBlock: "code block",
+	Comma: "','", Colon: "':'", DoubleColon: "'::'", LBracket: "'['", RBracket: "']'",
+	LParen: "'('", RParen: "')'", Plus: "'+'", Minus: "'-'", Decrement: "'--'",
+	Star: "'*'", Slash: "'/'", Bang: "'!'", Tilde: "'~'", NotEqual: "'!='",
+	Less: "'<'", Assign: "'='", Illegal: "illegal character",
+}
+
+var twoCharTokens = map[string]TokenKind{"::": DoubleColon, "--": Decrement, "!=": NotEqual}
+
+var oneCharTokens = map[byte]TokenKind{
+	',': Comma, ':': Colon, '[': LBracket, ']': RBracket, '(': LParen, ')': RParen,
+	'+': Plus, '-': Minus, '*': Star, '/': Slash, '!': Bang, '~': Tilde,
+	'<': Less, '=': Assign,
+}
+
+func (k TokenKind) String() string {
+	if int(k) < len(tokenNames) {
+		return tokenNames[k]
+	}
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+// Pos is a position in the source. Line and Col are 1-based; Offset is
+// the byte offset.
+type Pos struct {
+	Offset int `json:"-"`
+	Line   int `json:"line"`
+	Col    int `json:"col"`
+}
+
+// Span is the half-open source range [Start, End) covered by a node.
+type Span struct {
+	Start Pos `json:"start"`
+	End   Pos `json:"end"`
+}
+
+// Token is a lexical token.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Span Span
+}
+
+// Lex splits source into tokens. Illegal characters are returned as
+// Illegal tokens so the parser can report them with their position.
+func Lex(source string) []Token {
+	l := &lexer{src: source, line: 1, col: 1}
+	for {
+		tok := l.next()
+		l.toks = append(l.toks, tok)
+		if tok.Kind == EOF {
+			return l.toks
+		}
+	}
+}
+
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+	toks []Token
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Offset: l.off, Line: l.line, Col: l.col}
+}
+
+func (l *lexer) peek(n int) byte {
+	if l.off+n < len(l.src) {
+		return l.src[l.off+n]
+	}
+	return 0
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.off < len(l.src); i++ {
+		if l.src[l.off] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.off++
+	}
+}
+
+func (l *lexer) emit(kind TokenKind, start Pos) Token {
+	return Token{Kind: kind, Text: l.src[start.Offset:l.off], Span: Span{Start: start, End: l.pos()}}
+}
+
+func (l *lexer) next() Token {
+	for c := l.peek(0); c == ' ' || c == '\t' || c == '\r'; c = l.peek(0) {
+		l.advance(1)
+	}
+	start := l.pos()
+	if l.off >= len(l.src) {
+		return l.emit(EOF, start)
+	}
+	c := l.peek(0)
+	switch {
+	case c == '\n':
+		l.advance(1)
+		return l.emit(Newline, start)
+	case c == ';' || (c == '/' && l.peek(1) == '/'):
+		for l.off < len(l.src) && l.src[l.off] != '\n' {
+			l.advance(1)
+		}
+		return l.emit(Comment, start)
+	case c == '/' && l.peek(1) == '*':
+		end := strings.Index(l.src[l.off+2:], "*/")
+		if end < 0 {
+			l.advance(len(l.src) - l.off)
+			return l.emit(Illegal, start)
+		}
+		l.advance(end + 4)
+		return l.emit(Comment, start)
+	case c == '%':
+		end := strings.Index(l.src[l.off:], "%}")
+		if end < 0 {
+			l.advance(len(l.src) - l.off)
+			return l.emit(Illegal, start)
+		}
+		l.advance(end + 2)
+		return l.emit(CodeBlock, start)
+	case c == '.' && isIdentStart(l.peek(1)):
+		l.advance(1)
+		for isIdentChar(l.peek(0)) {
+			l.advance(1)
+		}
+		return l.emit(Directive, start)
+	case isIdentStart(c):
+		for isIdentChar(l.peek(0)) {
+			l.advance(1)
+		}
+		return l.emit(Ident, start)
+	case isDigit(c):
+		for isIdentChar(l.peek(0)) {
+			l.advance(1)
+		}
+		return l.emit(Int, start)
+	}
+
+	if l.off+1 < len(l.src) {
+		if kind, ok := twoCharTokens[l.src[l.off:l.off+2]]; ok {
+			l.advance(2)
+			return l.emit(kind, start)
+		}
+	}
+	l.advance(1)
+	if kind, ok := oneCharTokens[c]; ok {
+		return l.emit(kind, start)
+	}
+	return l.emit(Illegal, start)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
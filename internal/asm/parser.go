@@ -0,0 +1,386 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse parses PIO source into a File. Syntax errors are returned as an
+// ErrorList; the File holding every statement that did parse is always
+// returned so callers can still report on the rest of the program.
+func Parse(source string) (*File, error) {
+	p := &parser{src: source, toks: Lex(source), file: &File{}}
+	for p.peek().Kind != EOF {
+		p.parseLine()
+	}
+	if len(p.errs) > 0 {
+		return p.file, p.errs
+	}
+	return p.file, nil
+}
+
+type parser struct {
+	src  string
+	toks []Token
+	pos  int
+	file *File
+	errs ErrorList
+}
+
+func (p *parser) peek() Token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) peekAt(n int) Token {
+	if p.pos+n < len(p.toks) {
+		return p.toks[p.pos+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *parser) next() Token {
+	tok := p.toks[p.pos]
+	if tok.Kind != EOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorAt(pos Pos, format string, args ...any) {
+	p.errs = append(p.errs, &Error{Line: pos.Line, Col: pos.Col, Msg: fmt.Sprintf(format, args...)})
+}
+
+// skipLine discards the rest of the current line after a syntax error.
+func (p *parser) skipLine() {
+	for k := p.peek().Kind; k != Newline && k != EOF; k = p.peek().Kind {
+		p.next()
+	}
+}
+
+func (p *parser) add(s Statement) {
+	p.file.Statements = append(p.file.Statements, s)
+}
+
+// parseLine parses one source line: an optional label followed by an
+// optional directive or instruction and an optional trailing comment.
+func (p *parser) parseLine() {
+	tok := p.peek()
+	switch tok.Kind {
+	case Newline:
+		p.next()
+		return
+	case Comment:
+		p.next()
+		p.add(&CommentStmt{Span: tok.Span, Text: commentText(tok.Text)})
+		return
+	case CodeBlock:
+		p.next()
+		p.add(parseCodeBlock(tok))
+		return
+	case Illegal:
+		p.errorAt(tok.Span.Start, "illegal character %q", tok.Text)
+		p.skipLine()
+		return
+	}
+
+	if p.isLabel() {
+		p.add(p.parseLabel())
+		if k := p.peek().Kind; k == Newline || k == EOF || k == Comment {
+			p.endLine(nil)
+			return
+		}
+	}
+
+	switch tok := p.peek(); tok.Kind {
+	case Directive:
+		p.parseDirective()
+	case Ident:
+		p.parseInstruction()
+	default:
+		p.errorAt(tok.Span.Start, "unexpected %s", tok.Kind)
+		p.skipLine()
+	}
+}
+
+// endLine consumes an optional trailing comment and the line terminator.
+// The comment is stored in *comment when comment is non-nil.
+func (p *parser) endLine(comment *string) {
+	if tok := p.peek(); tok.Kind == Comment {
+		p.next()
+		if comment != nil {
+			*comment = commentText(tok.Text)
+		} else {
+			p.add(&CommentStmt{Span: tok.Span, Text: commentText(tok.Text)})
+		}
+	}
+	switch tok := p.peek(); tok.Kind {
+	case Newline:
+		p.next()
+	case EOF:
+	default:
+		p.errorAt(tok.Span.Start, "unexpected %s %q", tok.Kind, tok.Text)
+		p.skipLine()
+	}
+}
+
+func (p *parser) isLabel() bool {
+	if p.peek().Kind != Ident {
+		return false
+	}
+	if p.peekAt(1).Kind == Colon {
+		return true
+	}
+	return strings.EqualFold(p.peek().Text, "public") && p.peekAt(1).Kind == Ident && p.peekAt(2).Kind == Colon
+}
+
+func (p *parser) parseLabel() *LabelStmt {
+	start := p.peek().Span.Start
+	public := false
+	if p.peekAt(1).Kind != Colon {
+		p.next()
+		public = true
+	}
+	name := p.next().Text
+	end := p.next().Span.End
+	return &LabelStmt{Span: Span{Start: start, End: end}, Name: name, Public: public}
+}
+
+// rawDirectives have arguments that are not operand expressions, such
+// as the fractional .clock_div or the comparison in .mov_status.
+var rawDirectives = map[string]bool{".lang_opt": true, ".clock_div": true, ".mov_status": true}
+
+func (p *parser) parseDirective() {
+	tok := p.next()
+	d := &DirectiveStmt{Name: strings.ToLower(tok.Text)}
+	end := tok.Span.End
+	if rawDirectives[d.Name] {
+		// These take free-form values, so keep them as raw text.
+		for k := p.peek().Kind; k != Newline && k != EOF && k != Comment; k = p.peek().Kind {
+			end = p.next().Span.End
+		}
+		d.Raw = strings.TrimSpace(p.src[tok.Span.End.Offset:end.Offset])
+	} else {
+		d.Args = p.parseOperands()
+		if n := len(d.Args); n > 0 {
+			end = d.Args[n-1].Span.End
+		}
+	}
+	d.Span = Span{Start: tok.Span.Start, End: end}
+	p.add(d)
+	p.endLine(&d.Comment)
+}
+
+func (p *parser) parseInstruction() {
+	tok := p.next()
+	inst := &InstructionStmt{Op: strings.ToLower(tok.Text), OpSpan: tok.Span}
+	inst.Operands = p.parseOperands()
+	if n := len(inst.Operands); n > 0 {
+		first, last := inst.Operands[0].Span, inst.Operands[n-1].Span
+		inst.OperandText = p.src[first.Start.Offset:last.End.Offset]
+	}
+	end := p.toks[p.pos-1].Span.End
+	for {
+		tok := p.peek()
+		if tok.Kind == Ident && isSideKeyword(tok.Text) && inst.Side == nil {
+			p.next()
+			inst.Side = p.parseOperand()
+		} else if tok.Kind == LBracket && inst.Delay == nil {
+			p.next()
+			inst.Delay = p.parseOperand()
+			if p.peek().Kind != RBracket {
+				p.errorAt(p.peek().Span.Start, "expected ']' after delay")
+				p.skipLine()
+				p.add(inst)
+				return
+			}
+			p.next()
+		} else {
+			break
+		}
+		end = p.toks[p.pos-1].Span.End
+	}
+	inst.Span = Span{Start: tok.Span.Start, End: end}
+	p.add(inst)
+	p.endLine(&inst.Comment)
+}
+
+func isSideKeyword(s string) bool {
+	return strings.EqualFold(s, "side") || strings.EqualFold(s, "sideset")
+}
+
+// parseOperands parses a list of operands separated by optional commas,
+// stopping at side-set, delay, comments and the end of the line.
+func (p *parser) parseOperands() []*Operand {
+	var ops []*Operand
+	for {
+		tok := p.peek()
+		switch {
+		case tok.Kind == Comma && len(ops) > 0:
+			p.next()
+			continue
+		case tok.Kind == Newline, tok.Kind == EOF, tok.Kind == Comment, tok.Kind == LBracket:
+			return ops
+		case tok.Kind == Ident && isSideKeyword(tok.Text):
+			return ops
+		}
+		op := p.parseOperand()
+		if op == nil {
+			return ops
+		}
+		ops = append(ops, op)
+	}
+}
+
+func (p *parser) parseOperand() *Operand {
+	start := p.peek().Span.Start
+	expr := p.parseExpr()
+	if expr == nil {
+		p.skipLine()
+		return nil
+	}
+	end := p.toks[p.pos-1].Span.End
+	return &Operand{
+		Span: Span{Start: start, End: end},
+		Text: p.src[start.Offset:end.Offset],
+		Expr: expr,
+	}
+}
+
+func (p *parser) parseExpr() Expr {
+	x := p.parseAdditive()
+	if x != nil && p.peek().Kind == NotEqual {
+		p.next()
+		y := p.parseAdditive()
+		if y == nil {
+			return nil
+		}
+		x = &BinaryExpr{Op: "!=", X: x, Y: y}
+	}
+	return x
+}
+
+func (p *parser) parseAdditive() Expr {
+	x := p.parseMultiplicative()
+	for x != nil && (p.peek().Kind == Plus || p.peek().Kind == Minus) {
+		op := p.next().Text
+		y := p.parseMultiplicative()
+		if y == nil {
+			return nil
+		}
+		x = &BinaryExpr{Op: op, X: x, Y: y}
+	}
+	return x
+}
+
+func (p *parser) parseMultiplicative() Expr {
+	x := p.parseUnary()
+	for x != nil && (p.peek().Kind == Star || p.peek().Kind == Slash) {
+		op := p.next().Text
+		y := p.parseUnary()
+		if y == nil {
+			return nil
+		}
+		x = &BinaryExpr{Op: op, X: x, Y: y}
+	}
+	return x
+}
+
+func (p *parser) parseUnary() Expr {
+	switch p.peek().Kind {
+	case Minus, Bang, Tilde, DoubleColon:
+		op := p.next().Text
+		x := p.parseUnary()
+		if x == nil {
+			return nil
+		}
+		return &UnaryExpr{Op: op, X: x}
+	}
+	x := p.parsePrimary()
+	if x != nil && p.peek().Kind == Decrement {
+		p.next()
+		x = &UnaryExpr{Op: "--", X: x, Postfix: true}
+	}
+	return x
+}
+
+func (p *parser) parsePrimary() Expr {
+	tok := p.next()
+	switch tok.Kind {
+	case Int:
+		n, err := parseInt(tok.Text)
+		if err != nil {
+			p.errorAt(tok.Span.Start, "invalid integer %q", tok.Text)
+			return nil
+		}
+		return &IntExpr{Value: n}
+	case Ident:
+		id := &IdentExpr{Name: tok.Text}
+		// Only rxfifo takes an index; any other '[' starts a delay.
+		if strings.EqualFold(tok.Text, "rxfifo") && p.peek().Kind == LBracket {
+			p.next()
+			idx := p.parseExpr()
+			if idx == nil {
+				return nil
+			}
+			if p.peek().Kind != RBracket {
+				p.errorAt(p.peek().Span.Start, "expected ']' after rxfifo index")
+				return nil
+			}
+			p.next()
+			return &IndexExpr{X: id, Index: idx}
+		}
+		return id
+	case LParen:
+		x := p.parseExpr()
+		if x == nil {
+			return nil
+		}
+		if p.peek().Kind != RParen {
+			p.errorAt(p.peek().Span.Start, "expected ')'")
+			return nil
+		}
+		p.next()
+		return x
+	}
+	p.errorAt(tok.Span.Start, "unexpected %s in operand", tok.Kind)
+	return nil
+}
+
+// commentText strips the comment marker and surrounding whitespace.
+func commentText(s string) string {
+	switch {
+	case strings.HasPrefix(s, ";"):
+		s = s[1:]
+	case strings.HasPrefix(s, "//"):
+		s = s[2:]
+	case strings.HasPrefix(s, "/*"):
+		s = strings.TrimSuffix(s[2:], "*/")
+	}
+	return strings.TrimSpace(s)
+}
+
+// parseCodeBlock splits a "% lang {" ... "%}" token into its language
+// and body.
+func parseCodeBlock(tok Token) *CodeBlockStmt {
+	text := strings.TrimSuffix(strings.TrimPrefix(tok.Text, "%"), "%}")
+	header, body, _ := strings.Cut(text, "{")
+	return &CodeBlockStmt{
+		Span: tok.Span,
+		Lang: strings.TrimSpace(header),
+		Body: strings.TrimPrefix(body, "\n"),
+	}
+}
+
+// parseInt parses decimal, 0x hexadecimal and 0b binary integers.
+func parseInt(s string) (int, error) {
+	base, digits := 10, s
+	switch {
+	case len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X"):
+		base, digits = 16, s[2:]
+	case len(s) > 2 && (s[:2] == "0b" || s[:2] == "0B"):
+		base, digits = 2, s[2:]
+	}
+	n, err := strconv.ParseInt(digits, base, 64)
+	return int(n), err
+}
@@ -0,0 +1,120 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse_Statements(t *testing.T) {
+	file, err := Parse(`.program ws2812
+.side_set 1
+; standalone comment
+public bitloop: out x, 1 side 0 [2] ; shift one bit
+    jmp !x, do_zero side 1 [T1 - 1]
+% c-sdk {
+static inline void init() {}
+%}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(file.Statements) != 7 {
+		t.Fatalf("expected 7 statements, got %d", len(file.Statements))
+	}
+
+	d, ok := file.Statements[1].(*DirectiveStmt)
+	if !ok || d.Name != ".side_set" || len(d.Args) != 1 || d.Args[0].Text != "1" {
+		t.Fatalf("unexpected directive %+v", file.Statements[1])
+	}
+	if c, ok := file.Statements[2].(*CommentStmt); !ok || c.Text != "standalone comment" {
+		t.Fatalf("unexpected comment %+v", file.Statements[2])
+	}
+	if l, ok := file.Statements[3].(*LabelStmt); !ok || l.Name != "bitloop" || !l.Public {
+		t.Fatalf("unexpected label %+v", file.Statements[3])
+	}
+
+	out, ok := file.Statements[4].(*InstructionStmt)
+	if !ok {
+		t.Fatalf("expected instruction, got %T", file.Statements[4])
+	}
+	if out.Op != "out" || out.OperandText != "x, 1" || out.Comment != "shift one bit" {
+		t.Fatalf("unexpected instruction %+v", out)
+	}
+	if out.Side == nil || out.Side.Text != "0" || out.Delay == nil || out.Delay.Text != "2" {
+		t.Fatalf("unexpected side-set/delay on %+v", out)
+	}
+	if out.OpSpan.Start.Line != 4 || out.OpSpan.Start.Col != 17 {
+		t.Fatalf("unexpected op position %+v", out.OpSpan.Start)
+	}
+
+	jmp := file.Statements[5].(*InstructionStmt)
+	if len(jmp.Operands) != 2 {
+		t.Fatalf("expected 2 jmp operands, got %d", len(jmp.Operands))
+	}
+	if u, ok := jmp.Operands[0].Expr.(*UnaryExpr); !ok || u.Op != "!" {
+		t.Fatalf("expected !x condition, got %#v", jmp.Operands[0].Expr)
+	}
+	if b, ok := jmp.Delay.Expr.(*BinaryExpr); !ok || b.Op != "-" {
+		t.Fatalf("expected subtraction in delay, got %#v", jmp.Delay.Expr)
+	}
+
+	block, ok := file.Statements[6].(*CodeBlockStmt)
+	if !ok || block.Lang != "c-sdk" || block.Body != "static inline void init() {}\n" {
+		t.Fatalf("unexpected code block %+v", file.Statements[6])
+	}
+}
+
+func TestParse_Operands(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"jmp x-- loop", []string{"x--", "loop"}},
+		{"jmp x!=y, loop", []string{"x!=y", "loop"}},
+		{"wait 1 gpio 5", []string{"1", "gpio", "5"}},
+		{"mov x, ::osr", []string{"x", "::osr"}},
+		{"mov rxfifo[y], isr", []string{"rxfifo[y]", "isr"}},
+		{"set pins, (1 + 2) * 3", []string{"pins", "(1 + 2) * 3"}},
+		{"jmp sidecar", []string{"sidecar"}},
+	}
+	for _, tt := range tests {
+		file, err := Parse(tt.src)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.src, err)
+		}
+		inst := file.Statements[0].(*InstructionStmt)
+		if len(inst.Operands) != len(tt.want) {
+			t.Fatalf("%q: expected %d operands, got %d", tt.src, len(tt.want), len(inst.Operands))
+		}
+		for i, op := range inst.Operands {
+			if op.Text != tt.want[i] {
+				t.Errorf("%q: operand %d: expected %q, got %q", tt.src, i, tt.want[i], op.Text)
+			}
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		src      string
+		line     int
+		col      int
+		contains string
+	}{
+		{"set pins, 1 [2", 1, 15, "expected ']'"},
+		{"nop\n  set pins, $", 2, 13, "illegal character"},
+		{"set pins, (1", 1, 13, "expected ')'"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		list, ok := err.(ErrorList)
+		if !ok || len(list) == 0 {
+			t.Fatalf("%q: expected ErrorList, got %v", tt.src, err)
+		}
+		if list[0].Line != tt.line || list[0].Col != tt.col {
+			t.Errorf("%q: expected error at %d:%d, got %v", tt.src, tt.line, tt.col, list[0])
+		}
+		if !strings.Contains(list[0].Msg, tt.contains) {
+			t.Errorf("%q: expected error containing %q, got %q", tt.src, tt.contains, list[0].Msg)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package asm
+
+import "fmt"
+
+// Split divides a parsed file into one File per .program block, each
+// starting with its .program directive. Directives before the first
+// .program, such as global .define, are shared by every program. A file
+// without any .program directive is a single unnamed program.
+func Split(file *File) ([]*File, error) {
+	var (
+		global []Statement
+		units  []*File
+		errs   ErrorList
+		names  = map[string]Pos{}
+	)
+	for _, stmt := range file.Statements {
+		d, isDirective := stmt.(*DirectiveStmt)
+		if isDirective && d.Name == ".program" {
+			if len(d.Args) > 0 {
+				name := d.Args[0].Text
+				if first, dup := names[name]; dup {
+					errs = append(errs, &Error{Line: d.Span.Start.Line, Col: d.Args[0].Span.Start.Col,
+						Msg: fmt.Sprintf("duplicate program name %q (first at line %d)", name, first.Line)})
+				} else {
+					names[name] = d.Span.Start
+				}
+			}
+			unit := &File{Statements: append([]Statement(nil), global...)}
+			unit.Statements = append(unit.Statements, d)
+			units = append(units, unit)
+			continue
+		}
+		if len(units) > 0 {
+			last := units[len(units)-1]
+			last.Statements = append(last.Statements, stmt)
+			continue
+		}
+		global = append(global, stmt)
+	}
+
+	if len(units) == 0 {
+		return []*File{file}, nil
+	}
+	for _, stmt := range global {
+		switch s := stmt.(type) {
+		case *InstructionStmt, *LabelStmt:
+			errs = append(errs, &Error{Line: s.Pos().Line, Col: s.Pos().Col,
+				Msg: "instructions and labels must follow a .program directive"})
+		case *DirectiveStmt:
+			if !globalDirectives[s.Name] {
+				errs = append(errs, &Error{Line: s.Pos().Line, Col: s.Pos().Col,
+					Msg: fmt.Sprintf("%s must follow a .program directive", s.Name)})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return units, errs
+	}
+	return units, nil
+}
+
+// globalDirectives may appear before the first .program and then apply
+// to every program in the file.
+var globalDirectives = map[string]bool{".define": true, ".lang_opt": true, ".pio_version": true}
+
+// AssembleAll assembles every program in a parsed file for target. All
+// programs are returned, even when some fail, alongside the combined
+// errors.
+func AssembleAll(file *File, target Target) ([]*Program, error) {
+	units, err := Split(file)
+	var errs ErrorList
+	if list, ok := err.(ErrorList); ok {
+		errs = append(errs, list...)
+	}
+	progs := make([]*Program, 0, len(units))
+	for _, unit := range units {
+		prog, err := AssembleFile(unit, target)
+		if list, ok := err.(ErrorList); ok {
+			errs = append(errs, list...)
+		}
+		progs = append(progs, prog)
+	}
+	if len(errs) > 0 {
+		return progs, errs
+	}
+	return progs, nil
+}
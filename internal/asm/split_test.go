@@ -0,0 +1,57 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssembleAll_SharesGlobalDefines(t *testing.T) {
+	file, err := Parse(`.define public PIN 3
+.program tx
+    set pins, PIN
+.program rx
+    set x, PIN
+    jmp 0`)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	progs, err := AssembleAll(file, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(progs) != 2 || progs[0].Name != "tx" || progs[1].Name != "rx" {
+		t.Fatalf("unexpected programs %+v", progs)
+	}
+	assertWords(t, progs[0].Instructions, []uint16{0xe003})
+	assertWords(t, progs[1].Instructions, []uint16{0xe023, 0x0000})
+	if len(progs[1].Defines) != 1 || progs[1].Defines[0].Name != "PIN" {
+		t.Fatalf("expected global define in every program, got %+v", progs[1].Defines)
+	}
+}
+
+func TestSplit_Errors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{".program a\n    nop\n.program a\n    nop", `3:10: duplicate program name "a" (first at line 1)`},
+		{"    nop\n.program a\n    nop", "1:5: instructions and labels must follow a .program directive"},
+		{".side_set 1\n.program a\n    nop side 0", "1:1: .side_set must follow a .program directive"},
+	}
+	for _, tt := range tests {
+		file, err := Parse(tt.src)
+		if err != nil {
+			t.Fatalf("%q: unexpected parse error: %v", tt.src, err)
+		}
+		_, err = Split(file)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.src, tt.want, err)
+		}
+	}
+}
+
+func TestAssemble_RejectsMultiplePrograms(t *testing.T) {
+	if _, err := Assemble(".program a\n    nop\n.program b\n    nop"); err == nil {
+		t.Fatal("expected Assemble to reject a file with two programs")
+	}
+}
@@ -0,0 +1,75 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target is the chip a program is assembled for. RP2040 has PIO version
+// 0; RP2350 adds the version 1 instructions and configuration
+// directives. The zero Target behaves like pioasm: programs are version 0
+// unless they declare .pio_version 1.
+type Target string
+
+const (
+	TargetRP2040 Target = "rp2040"
+	TargetRP2350 Target = "rp2350"
+)
+
+// ParseTarget parses a target name. The empty string is the zero Target.
+func ParseTarget(s string) (Target, error) {
+	switch t := Target(strings.ToLower(s)); t {
+	case "", TargetRP2040, TargetRP2350:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown target %q (want %s, %s)", s, TargetRP2040, TargetRP2350)
+}
+
+// MaxVersion returns the newest PIO version the target supports.
+func (t Target) MaxVersion() int {
+	if t == TargetRP2040 {
+		return 0
+	}
+	return 1
+}
+
+// defaultVersion returns the PIO version of programs without a
+// .pio_version directive.
+func (t Target) defaultVersion() int {
+	if t == TargetRP2350 {
+		return 1
+	}
+	return 0
+}
+
+// v1Use records a PIO version 1 feature used by the program.
+type v1Use struct {
+	pos     Pos
+	feature string
+}
+
+// requireV1 notes that feature needs PIO version 1. The check runs once
+// the whole program has been read, since .pio_version may come later.
+func (a *assembler) requireV1(pos Pos, feature string) {
+	a.v1 = append(a.v1, v1Use{pos, feature})
+}
+
+// checkVersion reports every version 1 feature in a version 0 program,
+// explaining where the version 0 restriction comes from.
+func (a *assembler) checkVersion() {
+	if a.prog.PIOVersion >= 1 {
+		return
+	}
+	var why string
+	switch {
+	case a.chip == TargetRP2040:
+		why = "target rp2040 only supports PIO version 0"
+	case a.seen[".pio_version"] != nil:
+		why = "the program declares .pio_version 0"
+	default:
+		why = "add .pio_version 1 or target rp2350"
+	}
+	for _, u := range a.v1 {
+		a.errorf(u.pos, "%s requires PIO version 1 (RP2350); %s", u.feature, why)
+	}
+}
@@ -0,0 +1,101 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func assembleFor(t *testing.T, src string, target Target) (*Program, error) {
+	t.Helper()
+	file, err := Parse(src)
+	if err != nil {
+		t.Fatalf("%q: unexpected parse error: %v", src, err)
+	}
+	return AssembleFile(file, target)
+}
+
+func TestAssemble_Version1Instructions(t *testing.T) {
+	prog, err := assembleFor(t, `.pio_version 1
+    mov rxfifo[y], isr
+    mov rxfifo[2], isr
+    mov osr, rxfifo[y]
+    mov osr, rxfifo[1]
+    irq next set 3
+    irq prev wait 1
+    wait 1 jmppin
+    wait 0 jmppin + 2
+    wait 1 irq next 2
+    mov pindirs, ~x`, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertWords(t, prog.Instructions, []uint16{
+		0x8010, 0x801a, 0x8090, 0x8099, 0xc01b, 0xc029, 0x20e0, 0x2062, 0x20da, 0xa069,
+	})
+	if prog.PIOVersion != 1 {
+		t.Fatalf("expected PIO version 1, got %d", prog.PIOVersion)
+	}
+}
+
+func TestAssemble_Version1Directives(t *testing.T) {
+	prog, err := assembleFor(t, `.fifo putget
+.mov_status rxfifo<2
+.clock_div 2.5
+.in 4 left auto 8
+.out 2
+.set 3
+    nop`, TargetRP2350)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prog.Fifo != "putget" || prog.MovStatus != "rxfifo < 2" || prog.ClockDiv != 2.5 || prog.SetCount != 3 {
+		t.Fatalf("unexpected configuration %+v", prog)
+	}
+	if in := prog.In; in == nil || in.Count != 4 || in.Right || !in.Auto || in.Threshold != 8 {
+		t.Fatalf("unexpected .in configuration %+v", prog.In)
+	}
+	if out := prog.Out; out == nil || out.Count != 2 || !out.Right || out.Auto || out.Threshold != 32 {
+		t.Fatalf("unexpected .out configuration %+v", prog.Out)
+	}
+}
+
+func TestAssemble_Version1Errors(t *testing.T) {
+	tests := []struct {
+		src    string
+		target Target
+		want   string
+	}{
+		{"    irq next 1", TargetRP2040, "1:9: irq next requires PIO version 1 (RP2350); target rp2040 only supports PIO version 0"},
+		{".pio_version 0\n    wait 1 jmppin", TargetRP2350, "2:12: wait jmppin requires PIO version 1 (RP2350); the program declares .pio_version 0"},
+		{".fifo txget\n    nop", "", "1:7: .fifo txget requires PIO version 1 (RP2350); add .pio_version 1 or target rp2350"},
+		{".pio_version 1\n    nop", TargetRP2040, "1:14: PIO version 1 requires RP2350; target rp2040 only supports PIO version 0"},
+		{"    mov pindirs, x", TargetRP2040, "1:9: mov pindirs requires PIO version 1 (RP2350)"},
+		{"    mov rxfifo[4], isr", TargetRP2350, "1:9: rxfifo index 4 out of range 0-3 (or y)"},
+		{"    mov rxfifo[y], x", TargetRP2350, "1:20: mov with rxfifo[...] needs isr, got \"x\""},
+		{"    irq next 1 rel", TargetRP2350, "1:16: rel cannot be combined with prev or next"},
+		{".in 4 up\n    nop", TargetRP2350, "1:7: unexpected .in option \"up\""},
+		{".mov_status osr < 1\n    nop", TargetRP2350, "1:1: .mov_status expects"},
+	}
+	for _, tt := range tests {
+		_, err := assembleFor(t, tt.src, tt.target)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q (%s): expected error containing %q, got %v", tt.src, tt.target, tt.want, err)
+		}
+	}
+
+	// Version 0 directives and instructions are fine on either chip.
+	for _, target := range []Target{"", TargetRP2040, TargetRP2350} {
+		if _, err := assembleFor(t, ".fifo tx\n    wait 1 irq 2 rel", target); err != nil {
+			t.Errorf("%s: unexpected error: %v", target, err)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	if target, err := ParseTarget("RP2350"); err != nil || target != TargetRP2350 {
+		t.Fatalf("expected rp2350, got %q, %v", target, err)
+	}
+	if _, err := ParseTarget("rp2050"); err == nil {
+		t.Fatal("expected error for unknown target")
+	}
+}
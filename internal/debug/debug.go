@@ -0,0 +1,217 @@
+// Package debug runs a PIO program on a simulated state machine under
+// the control of a debugger: stepping, breakpoints, and changes to
+// registers, FIFOs and input pins between cycles.
+package debug
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+// Breakpoint stops Continue. Kind selects the condition and the field it
+// uses:
+//
+//	address   the state machine is about to start the instruction at Address
+//	label     the same, for the address of Label
+//	pin       GPIO Pin changes level
+//	irq       irq flag IRQ is raised
+//	tx_empty, tx_full, rx_empty, rx_full
+//	          the FIFO becomes empty or full
+//
+// Addresses are relative to the start of the program, as /api/validate
+// reports them. Conditions other than address and label stop when they
+// become true, not while they stay true.
+type Breakpoint struct {
+	Kind    string `json:"kind"`
+	Address int    `json:"address,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Pin     int    `json:"pin,omitempty"`
+	IRQ     int    `json:"irq,omitempty"`
+}
+
+// kinds are the breakpoint kinds, in the order they are listed in errors.
+var kinds = []string{"address", "label", "pin", "irq", "tx_empty", "tx_full", "rx_empty", "rx_full"}
+
+// Stop describes why Continue returned.
+type Stop struct {
+	Cycles     int         `json:"cycles"`               // cycles run
+	Breakpoint *Breakpoint `json:"breakpoint,omitempty"` // nil when the cycle limit was reached
+}
+
+// Session is a state machine being debugged.
+type Session struct {
+	prog        *asm.Program
+	cfg         sim.Config
+	offset      int
+	sm          *sim.StateMachine
+	pins        uint32
+	breakpoints []Breakpoint
+	addrs       []int // absolute address of each address or label breakpoint
+}
+
+// New starts a session running prog with cfg, loaded as sim.New loads
+// it.
+func New(prog *asm.Program, cfg sim.Config) (*Session, error) {
+	s := &Session{prog: prog, cfg: cfg, offset: max(prog.Origin, 0)}
+	if err := s.Reset(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Program returns the program being debugged.
+func (s *Session) Program() *asm.Program { return s.prog }
+
+// Config returns the state machine configuration.
+func (s *Session) Config() sim.Config { return s.cfg }
+
+// Offset returns the address the program is loaded at.
+func (s *Session) Offset() int { return s.offset }
+
+// Reset restarts the state machine with empty registers and FIFOs. The
+// breakpoints and input pin levels are kept.
+func (s *Session) Reset() error {
+	sm, err := sim.New(s.prog, s.cfg)
+	if err != nil {
+		return err
+	}
+	sm.GPIO().Input = s.pins
+	s.sm = sm
+	return nil
+}
+
+// State returns the current state of the state machine.
+func (s *Session) State() sim.State { return s.sm.State() }
+
+// NextPC returns the address of the instruction the next cycle starts,
+// or -1 when the next cycle counts down a delay, retries a stalled
+// instruction or runs one queued by out exec or mov exec.
+func (s *Session) NextPC() int {
+	if pc, ok := s.sm.NextPC(); ok {
+		return pc
+	}
+	return -1
+}
+
+// Pins returns the input pin levels.
+func (s *Session) Pins() uint32 { return s.pins }
+
+// SetPins drives the input pins to levels.
+func (s *Session) SetPins(levels uint32) {
+	s.pins = levels
+	s.sm.GPIO().Input = levels
+}
+
+// SetRegisters overwrites registers and FIFOs of the state machine.
+func (s *Session) SetRegisters(r sim.Registers) error {
+	return s.sm.SetRegisters(r)
+}
+
+// Breakpoints returns the breakpoints.
+func (s *Session) Breakpoints() []Breakpoint { return s.breakpoints }
+
+// SetBreakpoints replaces the breakpoints. Nothing is changed when one of
+// them is invalid.
+func (s *Session) SetBreakpoints(bps []Breakpoint) error {
+	addrs := make([]int, len(bps))
+	for i, bp := range bps {
+		addrs[i] = -1
+		switch bp.Kind {
+		case "address":
+			if bp.Address < 0 || bp.Address >= len(s.prog.Instructions) {
+				return fmt.Errorf("breakpoint %d: address %d outside program %s (0-%d)", i, bp.Address, s.prog.Name, len(s.prog.Instructions)-1)
+			}
+			addrs[i] = s.offset + bp.Address
+		case "label":
+			l := s.prog.Label(bp.Label)
+			if l == nil {
+				return fmt.Errorf("breakpoint %d: no label %q in program %s", i, bp.Label, s.prog.Name)
+			}
+			addrs[i] = s.offset + l.Address
+		case "pin":
+			if bp.Pin < 0 || bp.Pin > 31 {
+				return fmt.Errorf("breakpoint %d: pin %d out of range 0-31", i, bp.Pin)
+			}
+		case "irq":
+			if bp.IRQ < 0 || bp.IRQ > 7 {
+				return fmt.Errorf("breakpoint %d: irq %d out of range 0-7", i, bp.IRQ)
+			}
+		case "tx_empty", "tx_full", "rx_empty", "rx_full":
+		default:
+			return fmt.Errorf("breakpoint %d: unknown kind %q (want %s)", i, bp.Kind, strings.Join(kinds, ", "))
+		}
+	}
+	s.breakpoints, s.addrs = bps, addrs
+	return nil
+}
+
+// Step runs n cycles, ignoring breakpoints, and returns every state.
+func (s *Session) Step(n int) []sim.State {
+	return s.sm.Run(n)
+}
+
+// Continue runs until a breakpoint is hit, at most limit cycles. It
+// always runs at least one cycle, so that continuing from a breakpoint
+// moves past it.
+func (s *Session) Continue(limit int) (sim.State, Stop) {
+	var st sim.State
+	prev := s.watch()
+	for n := 1; n <= limit; n++ {
+		st = s.sm.Step()
+		now := s.watch()
+		for i := range s.breakpoints {
+			if s.hit(i, prev, now) {
+				return st, Stop{Cycles: n, Breakpoint: &s.breakpoints[i]}
+			}
+		}
+		prev = now
+	}
+	return st, Stop{Cycles: limit}
+}
+
+// watched is what breakpoint conditions look at between cycles.
+type watched struct {
+	next   int // NextPC
+	pins   uint32
+	irq    uint8
+	tx, rx int
+}
+
+func (s *Session) watch() watched {
+	st := s.sm.State()
+	return watched{
+		next: s.NextPC(),
+		pins: s.sm.GPIO().Level(),
+		irq:  st.IRQ,
+		tx:   len(st.TX),
+		rx:   len(st.RX),
+	}
+}
+
+// hit reports whether breakpoint i triggers between two cycles.
+func (s *Session) hit(i int, prev, now watched) bool {
+	bp := s.breakpoints[i]
+	became := func(was, is bool) bool { return is && !was }
+	switch bp.Kind {
+	case "address", "label":
+		return now.next == s.addrs[i]
+	case "pin":
+		return (prev.pins^now.pins)>>bp.Pin&1 == 1
+	case "irq":
+		return became(prev.irq>>bp.IRQ&1 == 1, now.irq>>bp.IRQ&1 == 1)
+	case "tx_empty":
+		return became(prev.tx == 0, now.tx == 0)
+	case "tx_full":
+		depth := s.sm.TXDepth()
+		return became(prev.tx >= depth, now.tx >= depth)
+	case "rx_empty":
+		return became(prev.rx == 0, now.rx == 0)
+	case "rx_full":
+		depth := s.sm.RXDepth()
+		return became(prev.rx >= depth, now.rx >= depth)
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package debug
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+func newSession(t *testing.T, src string) *Session {
+	t.Helper()
+	prog, err := asm.Assemble(src)
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	cfg := sim.DefaultConfig(prog)
+	cfg.SetCount, cfg.OutCount = 1, 1
+	s, err := New(prog, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+const echo = `.program echo
+.origin 4
+    pull
+    out pins, 1
+    set x, 3 [2]
+wait_low:
+    wait 0 pin 1
+    irq set 2
+    push`
+
+func TestContinue_AddressAndLabel(t *testing.T) {
+	s := newSession(t, echo)
+	if err := s.SetBreakpoints([]Breakpoint{{Kind: "address", Address: 2}, {Kind: "label", Label: "wait_low"}}); err != nil {
+		t.Fatal(err)
+	}
+	// pull stalls until the TX FIFO has a word.
+	if _, stop := s.Continue(5); stop.Breakpoint != nil || stop.Cycles != 5 {
+		t.Fatalf("expected to run into the limit while pull stalls, got %+v", stop)
+	}
+	if err := s.SetRegisters(sim.Registers{TX: []uint32{1}}); err != nil {
+		t.Fatal(err)
+	}
+	st, stop := s.Continue(100)
+	if stop.Breakpoint == nil || stop.Breakpoint.Kind != "address" || stop.Cycles != 2 || st.PC != 5 || s.NextPC() != 6 {
+		t.Fatalf("expected to stop before address 2 (absolute 6), got %+v %+v next %d", stop, st, s.NextPC())
+	}
+	// The delay of set x is not a new instruction at the label.
+	st, stop = s.Continue(100)
+	if stop.Breakpoint == nil || stop.Breakpoint.Label != "wait_low" || stop.Cycles != 3 || st.X != 3 {
+		t.Fatalf("expected to stop before wait_low after the delay, got %+v %+v", stop, st)
+	}
+}
+
+func TestContinue_PinIRQAndFIFO(t *testing.T) {
+	s := newSession(t, echo)
+	s.SetPins(1 << 1)
+	if err := s.SetBreakpoints([]Breakpoint{{Kind: "pin", Pin: 0}, {Kind: "tx_empty"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRegisters(sim.Registers{TX: []uint32{1}}); err != nil {
+		t.Fatal(err)
+	}
+	_, stop := s.Continue(100)
+	if stop.Breakpoint == nil || stop.Breakpoint.Kind != "tx_empty" || stop.Cycles != 1 {
+		t.Fatalf("expected pull to empty the TX FIFO, got %+v", stop)
+	}
+	// Pin 1 changing from outside between runs is not a change seen
+	// during a cycle, so only the irq breakpoint fires.
+	if err := s.SetBreakpoints([]Breakpoint{{Kind: "pin", Pin: 1}, {Kind: "irq", IRQ: 2}, {Kind: "rx_full"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, stop = s.Continue(10); stop.Breakpoint != nil {
+		t.Fatalf("expected wait 0 pin 1 to stall, got %+v", stop)
+	}
+	s.SetPins(0)
+	st, stop := s.Continue(10)
+	if stop.Breakpoint == nil || stop.Breakpoint.Kind != "irq" || st.IRQ != 1<<2 {
+		t.Fatalf("expected the irq breakpoint once pin 1 went low, got %+v %+v", stop, st)
+	}
+}
+
+func TestSetBreakpoints_Errors(t *testing.T) {
+	s := newSession(t, echo)
+	for _, tt := range []struct {
+		bp   Breakpoint
+		want string
+	}{
+		{Breakpoint{Kind: "address", Address: 6}, "outside program echo"},
+		{Breakpoint{Kind: "label", Label: "nope"}, `no label "nope"`},
+		{Breakpoint{Kind: "pin", Pin: 32}, "out of range"},
+		{Breakpoint{Kind: "irq", IRQ: 8}, "out of range"},
+		{Breakpoint{Kind: "watch"}, "unknown kind"},
+	} {
+		err := s.SetBreakpoints([]Breakpoint{{Kind: "tx_full"}, tt.bp})
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "breakpoint 1") {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.bp, tt.want, err)
+		}
+	}
+	if len(s.Breakpoints()) != 0 {
+		t.Fatalf("expected invalid breakpoints to leave none set, got %v", s.Breakpoints())
+	}
+}
+
+func TestResetAndSetRegisters(t *testing.T) {
+	s := newSession(t, echo)
+	x, pc := uint32(7), 6
+	if err := s.SetRegisters(sim.Registers{X: &x, PC: &pc, RX: []uint32{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.State(); st.X != 7 || len(st.RX) != 2 || s.NextPC() != 6 {
+		t.Fatalf("unexpected state after setting registers %+v", st)
+	}
+	if err := s.SetRegisters(sim.Registers{TX: make([]uint32, 5), X: &x}); err == nil || !strings.Contains(err.Error(), "TX FIFO of 4") {
+		t.Fatalf("expected TX FIFO overflow error, got %v", err)
+	}
+	s.SetPins(3)
+	if err := s.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.State(); st.X != 0 || len(st.RX) != 0 || s.NextPC() != 4 || s.Pins() != 3 {
+		t.Fatalf("expected reset to clear registers and keep pins, got %+v", st)
+	}
+}
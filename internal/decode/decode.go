@@ -0,0 +1,142 @@
+// Package decode reconstructs protocol frames from pin traces: UART,
+// SPI, I2C, WS2812 and I2S. Traces are wave.Waveforms, read from the
+// simulator, a VCD file or a sigrok CSV capture, with times in
+// picoseconds.
+package decode
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// Frame is one decoded unit: a byte, word, address, acknowledge, pixel
+// or sample, or a bus condition such as an I2C start.
+type Frame struct {
+	Start   int64  `json:"start_ps"`
+	End     int64  `json:"end_ps"`
+	Type    string `json:"type"`
+	Value   uint32 `json:"value"`
+	Channel string `json:"channel,omitempty"` // mosi/miso for SPI, left/right for I2S
+	Error   string `json:"error,omitempty"`
+}
+
+// Options configure a decoder. Channels maps the decoder's roles to
+// signal names in the trace; the other fields apply to the protocols
+// named in their comments.
+type Options struct {
+	Channels  map[string]string `json:"channels"`
+	Baud      float64           `json:"baud,omitempty"`         // uart
+	DataBits  int               `json:"data_bits,omitempty"`    // uart, default 8
+	Parity    string            `json:"parity,omitempty"`       // uart: none (default), even or odd
+	CPOL      int               `json:"cpol,omitempty"`         // spi clock idle level
+	CPHA      int               `json:"cpha,omitempty"`         // spi: 1 samples on the second clock edge
+	Bits      int               `json:"bits,omitempty"`         // spi word size (default 8), i2s sample size (default: the whole slot)
+	LSBFirst  bool              `json:"lsb_first,omitempty"`    // spi
+	Tolerance float64           `json:"tolerance_ns,omitempty"` // ws2812 timing tolerance, default 150 ns
+}
+
+// decoders are the supported protocols, by name.
+var decoders = map[string]func(*wave.Waveform, Options) ([]Frame, error){
+	"uart":   decodeUART,
+	"spi":    decodeSPI,
+	"i2c":    decodeI2C,
+	"ws2812": decodeWS2812,
+	"i2s":    decodeI2S,
+}
+
+// Protocols returns the names of the supported protocols.
+func Protocols() []string {
+	var names []string
+	for name := range decoders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Decode runs the named protocol decoder over a trace.
+func Decode(w *wave.Waveform, protocol string, opts Options) ([]Frame, error) {
+	dec, ok := decoders[strings.ToLower(protocol)]
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol %q (want %s)", protocol, strings.Join(Protocols(), ", "))
+	}
+	return dec(w, opts)
+}
+
+// line is the level history of one signal; any non-zero value is high.
+type line [][2]int64
+
+// channel looks up the signal mapped to role. Optional roles that are
+// not mapped return a nil line and no error.
+func channel(w *wave.Waveform, opts Options, role string, optional bool) (line, error) {
+	name, ok := opts.Channels[role]
+	if !ok {
+		if optional {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("channel %q is not mapped to a signal (have %s)", role, signalNames(w))
+	}
+	s := w.Signal(name)
+	if s == nil {
+		return nil, fmt.Errorf("channel %q: no signal %q in trace (have %s)", role, name, signalNames(w))
+	}
+	return line(s.Changes), nil
+}
+
+func signalNames(w *wave.Waveform) string {
+	var names []string
+	for _, s := range w.Signals {
+		names = append(names, s.Name)
+	}
+	if names == nil {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// at returns the level at time t. Before the first sample the line is
+// low.
+func (l line) at(t int64) uint32 {
+	i := sort.Search(len(l), func(i int) bool { return l[i][0] > t }) - 1
+	if i < 0 || l[i][1] == 0 {
+		return 0
+	}
+	return 1
+}
+
+// edge is a level change on one of several lines.
+type edge struct {
+	t    int64
+	line int
+	high bool
+}
+
+// edges merges the level changes of lines in time order. The first
+// sample of each line is its initial level, not an edge. Changes at the
+// same time keep the order of the lines.
+func edges(lines ...line) []edge {
+	var out []edge
+	for i, l := range lines {
+		level := l.initial()
+		for _, c := range l {
+			if high := c[1] != 0; high != level {
+				out = append(out, edge{c[0], i, high})
+				level = high
+			}
+		}
+	}
+	slices.SortStableFunc(out, func(a, b edge) int {
+		return cmp.Or(cmp.Compare(a.t, b.t), a.line-b.line)
+	})
+	return out
+}
+
+// initial reports whether the line starts high.
+func (l line) initial() bool {
+	return len(l) > 0 && l[0][1] != 0
+}
@@ -0,0 +1,232 @@
+package decode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// trace builds a waveform from strings of '0' and '1', one character per
+// step of the given length in picoseconds. All strings must be the same
+// length.
+func trace(step int64, levels map[string]string) *wave.Waveform {
+	w := &wave.Waveform{}
+	for name, bits := range levels {
+		s := &wave.Signal{Name: name, Width: 1}
+		for i, c := range bits {
+			v := int64(c - '0')
+			if n := len(s.Changes); n == 0 || s.Changes[n-1][1] != v {
+				s.Changes = append(s.Changes, [2]int64{int64(i) * step, v})
+			}
+		}
+		w.Signals = append(w.Signals, s)
+		w.Duration = int64(len(bits)) * step
+	}
+	return w
+}
+
+// uartBits returns the 8N1 frame of b, LSB first.
+func uartBits(b byte, parity string) string {
+	s := "0"
+	ones := 0
+	for i := range 8 {
+		bit := b >> i & 1
+		s += string('0' + bit)
+		ones += int(bit)
+	}
+	switch parity {
+	case "even":
+		s += string('0' + byte(ones%2))
+	case "odd":
+		s += string('1' - byte(ones%2))
+	}
+	return s + "1"
+}
+
+func values(frames []Frame, typ string) []uint32 {
+	var v []uint32
+	for _, f := range frames {
+		if f.Type == typ {
+			v = append(v, f.Value)
+		}
+	}
+	return v
+}
+
+func equal(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUART(t *testing.T) {
+	// 1 Mbaud: one bit per microsecond.
+	w := trace(1e6, map[string]string{"tx": "11" + uartBits('H', "") + uartBits('i', "") + "0000000000" + "11"})
+	frames, err := Decode(w, "uart", Options{Channels: map[string]string{"data": "tx"}, Baud: 1e6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 || frames[0].Value != 'H' || frames[1].Value != 'i' || frames[0].Start != 2e6 || frames[0].End != 12e6 {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+	if frames[0].Error != "" || frames[2].Error != "framing error" {
+		t.Fatalf("expected a framing error on the break only, got %+v", frames)
+	}
+
+	w = trace(1e6, map[string]string{"tx": "1" + uartBits(0x03, "odd") + "1"})
+	frames, err = Decode(w, "uart", Options{Channels: map[string]string{"data": "tx"}, Baud: 1e6, Parity: "even"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || frames[0].Value != 0x03 || frames[0].Error != "parity error" {
+		t.Fatalf("expected a parity error, got %+v", frames)
+	}
+}
+
+func TestSPI(t *testing.T) {
+	// Mode 0, MSB first: data changes while clk is low and is sampled on
+	// the rising edge. 0xA5 on mosi, 0x3C on miso.
+	mosi, miso, clk, cs := "", "", "", "1"
+	for i := range 8 {
+		m := string('0' + byte(0xA5>>(7-i)&1))
+		s := string('0' + byte(0x3C>>(7-i)&1))
+		mosi, miso, clk, cs = mosi+m+m, miso+s+s, clk+"01", cs+"00"
+	}
+	w := trace(1e6, map[string]string{"mosi": "0" + mosi + "0", "miso": "0" + miso + "0", "clk": "0" + clk + "0", "cs": cs + "1"})
+	frames, err := Decode(w, "spi", Options{Channels: map[string]string{"clk": "clk", "mosi": "mosi", "miso": "miso", "cs": "cs"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0].Channel != "mosi" || frames[0].Value != 0xA5 || frames[1].Value != 0x3C {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+
+	// The same bits read in mode 1 are shifted by half a clock.
+	frames, err = Decode(w, "spi", Options{Channels: map[string]string{"clk": "clk", "mosi": "mosi"}, CPHA: 1, Bits: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values(frames, "data"); !equal(got, []uint32{0xA, 0x5}) {
+		t.Fatalf("expected mode 1 words [10 5], got %v", got)
+	}
+}
+
+func TestI2C(t *testing.T) {
+	// Start, address 0x50 write, ack, data 0x0F, nack, stop. Each bit is
+	// four steps: sda settles while scl is low, then scl pulses high.
+	scl, sda := "11", "10"
+	bit := func(b byte) {
+		scl += "0110"
+		sda += strings.Repeat(string('0'+b), 4)
+	}
+	for _, b := range []byte{0x50 << 1, 0x0F} {
+		for i := range 8 {
+			bit(b >> (7 - i) & 1)
+		}
+		bit(b & 1) // ack for the address, nack for the data
+	}
+	scl, sda = scl+"011", sda+"001"
+	w := trace(1e6, map[string]string{"scl": scl, "sda": sda})
+	frames, err := Decode(w, "i2c", Options{Channels: map[string]string{"scl": "scl", "sda": "sda"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, f := range frames {
+		types = append(types, f.Type)
+	}
+	if got := strings.Join(types, " "); got != "start address_write ack data nack stop" {
+		t.Fatalf("unexpected frame types %q", got)
+	}
+	if frames[1].Value != 0x50 || frames[3].Value != 0x0F {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+}
+
+func TestWS2812(t *testing.T) {
+	// One pixel of 0xFF0000 (green) in 50 ns steps, then a reset.
+	data := strings.Repeat("0", 20)
+	for i := range 24 {
+		if i < 8 {
+			data += strings.Repeat("1", 16) + strings.Repeat("0", 9)
+		} else {
+			data += strings.Repeat("1", 8) + strings.Repeat("0", 17)
+		}
+	}
+	data += strings.Repeat("0", 1100)
+	w := trace(50_000, map[string]string{"din": data})
+	opts := Options{Channels: map[string]string{"data": "din"}}
+	frames, err := Decode(w, "ws2812", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0].Value != 0xFF0000 || frames[0].Error != "" || frames[1].Type != "reset" {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+
+	// A 600 ns high time is neither a 0 nor a 1 within 100 ns.
+	data = "0" + strings.Repeat("1", 12) + strings.Repeat("0", 1100)
+	opts.Tolerance = 100
+	frames, err = Decode(trace(50_000, map[string]string{"din": data}), "ws2812", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || !strings.Contains(frames[0].Error, "bit 0 high time 600 ns, want 400±100 ns") || !strings.Contains(frames[0].Error, "incomplete pixel") {
+		t.Fatalf("expected timing errors, got %+v", frames)
+	}
+}
+
+func TestI2S(t *testing.T) {
+	// 4-bit slots: right 0x0, left 0x9, right 0x6, left 0xF. The first
+	// slot may have started before the trace, so it is skipped. Data
+	// changes on the falling bit clock.
+	ws := "0" + "1111" + "0000" + "1111" + "0000" + "1"
+	bits := "0" + "0000" + "1001" + "0110" + "1111" + "0"
+	var bclk, lrclk, data string
+	for i := range ws {
+		bclk += "01"
+		lrclk += strings.Repeat(ws[i:i+1], 2)
+		data += strings.Repeat(bits[i:i+1], 2)
+	}
+	// lrclk leads the data by one bit clock.
+	lrclk = lrclk[2:] + "11"
+	w := trace(1e6, map[string]string{"bclk": bclk, "lrclk": lrclk, "sd": data})
+	frames, err := Decode(w, "i2s", Options{Channels: map[string]string{"bclk": "bclk", "lrclk": "lrclk", "data": "sd"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values(frames, "sample"); !equal(got, []uint32{0x9, 0x6, 0xF}) {
+		t.Fatalf("expected samples [9 6 15], got %+v", frames)
+	}
+	if frames[0].Channel != "left" || frames[1].Channel != "right" {
+		t.Fatalf("unexpected channels %+v", frames)
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	w := trace(1e6, map[string]string{"D0": "01"})
+	if _, err := Decode(w, "can", Options{}); err == nil || !strings.Contains(err.Error(), "i2c, i2s, spi, uart, ws2812") {
+		t.Fatalf("expected unknown protocol error, got %v", err)
+	}
+	if _, err := Decode(w, "uart", Options{Baud: 9600}); err == nil || !strings.Contains(err.Error(), `"data" is not mapped`) {
+		t.Fatalf("expected unmapped channel error, got %v", err)
+	}
+	if _, err := Decode(w, "uart", Options{Channels: map[string]string{"data": "D1"}, Baud: 9600}); err == nil || !strings.Contains(err.Error(), "have D0") {
+		t.Fatalf("expected missing signal error, got %v", err)
+	}
+	if _, err := Decode(w, "spi", Options{Channels: map[string]string{"clk": "D0"}}); err == nil {
+		t.Fatal("expected error for spi without data channels")
+	}
+	for _, bits := range []int{-1, 33} {
+		if _, err := Decode(w, "spi", Options{Channels: map[string]string{"clk": "D0", "mosi": "D0"}, Bits: bits}); err == nil || !strings.Contains(err.Error(), "out of range 1-32") {
+			t.Errorf("bits %d: expected a word size error, got %v", bits, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package decode
+
+import (
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// decodeI2C decodes I2C transfers on the "scl" and "sda" channels. It
+// reports start, repeated start and stop conditions, the address byte
+// after each start as an address_read or address_write frame holding the
+// 7-bit address, data bytes, and the ack or nack after every byte.
+func decodeI2C(w *wave.Waveform, opts Options) ([]Frame, error) {
+	scl, err := channel(w, opts, "scl", false)
+	if err != nil {
+		return nil, err
+	}
+	sda, err := channel(w, opts, "sda", false)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []Frame
+	active, address := false, false
+	var value uint32
+	n := 0
+	var start int64
+	sclHigh := scl.initial()
+	for _, e := range edges(scl, sda) {
+		if e.line == 0 {
+			sclHigh = e.high
+			if !e.high || !active {
+				continue
+			}
+			// Rising SCL: data is valid.
+			bit := sda.at(e.t)
+			if n == 0 {
+				start = e.t
+			}
+			if n < 8 {
+				value = value<<1 | bit
+				n++
+				continue
+			}
+			f := Frame{Start: start, End: e.t, Type: "data", Value: value}
+			if address {
+				f.Type, f.Value = "address_write", value>>1
+				if value&1 != 0 {
+					f.Type = "address_read"
+				}
+				address = false
+			}
+			ack := Frame{Start: e.t, End: e.t, Type: "ack"}
+			if bit != 0 {
+				ack.Type = "nack"
+			}
+			frames = append(frames, f, ack)
+			value, n = 0, 0
+			continue
+		}
+		if !sclHigh {
+			continue
+		}
+		// SDA changing while SCL is high is a start or a stop. The SCL
+		// rise before it was sampled as a bit, so a byte was only cut
+		// short if more bits came before that.
+		if !e.high {
+			f := Frame{Start: e.t, End: e.t, Type: "start"}
+			if active {
+				f.Type = "repeated_start"
+			}
+			if n > 1 {
+				frames = append(frames, Frame{Start: start, End: e.t, Type: "data", Value: value, Error: "interrupted byte"})
+			}
+			frames = append(frames, f)
+			active, address, value, n = true, true, 0, 0
+		} else if active {
+			if n > 1 {
+				frames = append(frames, Frame{Start: start, End: e.t, Type: "data", Value: value, Error: "interrupted byte"})
+			}
+			frames = append(frames, Frame{Start: e.t, End: e.t, Type: "stop"})
+			active, value, n = false, 0, 0
+		}
+	}
+	return frames, nil
+}
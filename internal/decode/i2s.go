@@ -0,0 +1,83 @@
+package decode
+
+import (
+	"fmt"
+
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// decodeI2S decodes Philips I2S samples on the "data" channel, clocked
+// by the rising edge of "bclk" with "lrclk" low for the left channel. The
+// MSB of each sample follows one bit clock after lrclk changes. Samples
+// are the first Bits bits of each slot, or the whole slot when Bits is 0;
+// partial slots at the start and end of the trace are skipped.
+func decodeI2S(w *wave.Waveform, opts Options) ([]Frame, error) {
+	bclk, err := channel(w, opts, "bclk", false)
+	if err != nil {
+		return nil, err
+	}
+	lrclk, err := channel(w, opts, "lrclk", false)
+	if err != nil {
+		return nil, err
+	}
+	data, err := channel(w, opts, "data", false)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Bits < 0 || opts.Bits > 32 {
+		return nil, fmt.Errorf("i2s sample size %d out of range 1-32", opts.Bits)
+	}
+
+	var frames []Frame
+	var sample Frame
+	n := 0
+	synced := false
+	finish := func() {
+		if synced && n > 0 {
+			if opts.Bits > 0 && n < opts.Bits {
+				sample.Error = fmt.Sprintf("short slot (%d of %d bits)", n, opts.Bits)
+			}
+			frames = append(frames, sample)
+		}
+		n = 0
+	}
+	// ws is the word select level at the previous rising bit clock: the
+	// one-bit delay means it selects the channel of the current bit.
+	ws := uint32(2)
+	for _, e := range edges(bclk) {
+		if !e.high {
+			continue
+		}
+		slot := ws
+		ws = lrclk.at(e.t - 1)
+		if slot > 1 {
+			continue
+		}
+		if n > 0 && slot != sampleChannel(sample) {
+			finish()
+			synced = true
+		}
+		if n == 0 {
+			sample = Frame{Start: e.t, Type: "sample", Channel: "left"}
+			if slot == 1 {
+				sample.Channel = "right"
+			}
+		}
+		if opts.Bits == 0 || n < opts.Bits {
+			sample.Value = sample.Value<<1 | data.at(e.t-1)
+		}
+		sample.End = e.t
+		n++
+		if opts.Bits == 0 && n > 32 {
+			return nil, fmt.Errorf("i2s slot longer than 32 bits at %d ps; is lrclk mapped?", sample.Start)
+		}
+	}
+	return frames, nil
+}
+
+func sampleChannel(f Frame) uint32 {
+	if f.Channel == "right" {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package decode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// decodeSPI decodes words on the "mosi" and "miso" channels, clocked by
+// "clk". Data is sampled on the leading clock edge when CPHA is 0 and on
+// the trailing edge when it is 1; the leading edge leaves the idle level
+// set by CPOL. With a "cs" channel, only bits while it is low count and
+// raising it ends a word.
+func decodeSPI(w *wave.Waveform, opts Options) ([]Frame, error) {
+	clk, err := channel(w, opts, "clk", false)
+	if err != nil {
+		return nil, err
+	}
+	var data [2]line
+	for i, role := range []string{"mosi", "miso"} {
+		if data[i], err = channel(w, opts, role, true); err != nil {
+			return nil, err
+		}
+	}
+	if data[0] == nil && data[1] == nil {
+		return nil, errors.New("spi needs a mosi or miso channel")
+	}
+	cs, err := channel(w, opts, "cs", true)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CPOL&^1 != 0 || opts.CPHA&^1 != 0 {
+		return nil, fmt.Errorf("spi cpol and cpha must be 0 or 1")
+	}
+	bits := opts.Bits
+	if bits == 0 {
+		bits = 8
+	}
+	if bits < 1 || bits > 32 {
+		return nil, fmt.Errorf("spi word size %d out of range 1-32", bits)
+	}
+	// Modes 0 and 3 sample on the rising edge, modes 1 and 2 on the
+	// falling edge.
+	sampleHigh := opts.CPOL == opts.CPHA
+
+	var frames []Frame
+	var words [2]uint32
+	n := 0
+	var start int64
+	flush := func(end int64) {
+		for i, role := range []string{"mosi", "miso"} {
+			if data[i] == nil {
+				continue
+			}
+			f := Frame{Start: start, End: end, Type: "data", Value: words[i], Channel: role}
+			if n < bits {
+				f.Error = fmt.Sprintf("incomplete word (%d of %d bits)", n, bits)
+			}
+			frames = append(frames, f)
+		}
+		words, n = [2]uint32{}, 0
+	}
+	selected := cs == nil || !cs.initial()
+	for _, e := range edges(clk, cs) {
+		if e.line == 1 {
+			if selected = !e.high; e.high && n > 0 {
+				flush(e.t)
+			}
+			continue
+		}
+		if !selected || e.high != sampleHigh {
+			continue
+		}
+		if n == 0 {
+			start = e.t
+		}
+		for i := range data {
+			if data[i] == nil {
+				continue
+			}
+			// Sample just before the edge: the other side may change
+			// the data on the same edge in a simulated trace.
+			b := data[i].at(e.t - 1)
+			if opts.LSBFirst {
+				words[i] |= b << n
+			} else {
+				words[i] = words[i]<<1 | b
+			}
+		}
+		if n++; n == bits {
+			flush(e.t)
+		}
+	}
+	if n > 0 {
+		flush(w.Duration)
+	}
+	return frames, nil
+}
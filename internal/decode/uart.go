@@ -0,0 +1,74 @@
+package decode
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// decodeUART decodes asynchronous serial frames on the "data" channel:
+// a start bit, DataBits data bits LSB first, an optional parity bit and
+// a stop bit. Each bit is sampled at its centre, timed from the falling
+// edge of the start bit.
+func decodeUART(w *wave.Waveform, opts Options) ([]Frame, error) {
+	data, err := channel(w, opts, "data", false)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Baud <= 0 {
+		return nil, fmt.Errorf("uart needs a baud rate")
+	}
+	bits := opts.DataBits
+	if bits == 0 {
+		bits = 8
+	}
+	if bits < 5 || bits > 9 {
+		return nil, fmt.Errorf("uart data bits %d out of range 5-9", bits)
+	}
+	parityBits := 0
+	switch opts.Parity {
+	case "", "none":
+	case "even", "odd":
+		parityBits = 1
+	default:
+		return nil, fmt.Errorf("unknown parity %q (want none, even, odd)", opts.Parity)
+	}
+
+	period := 1e12 / opts.Baud
+	sample := func(start int64, bit float64) uint32 {
+		return data.at(start + int64(math.Round((bit+0.5)*period)))
+	}
+	var frames []Frame
+	resume := int64(math.MinInt64)
+	for _, e := range edges(data) {
+		if e.high || e.t < resume {
+			continue
+		}
+		if sample(e.t, 0) != 0 {
+			continue // a glitch, not a start bit
+		}
+		f := Frame{Start: e.t, Type: "data"}
+		ones := 0
+		for i := range bits {
+			b := sample(e.t, float64(1+i))
+			f.Value |= b << i
+			ones += int(b)
+		}
+		if parityBits == 1 {
+			p := int(sample(e.t, float64(1+bits)))
+			if (ones+p)%2 == 0 != (opts.Parity == "even") {
+				f.Error = "parity error"
+			}
+		}
+		stop := float64(1 + bits + parityBits)
+		if sample(e.t, stop) == 0 {
+			f.Error = "framing error"
+		}
+		f.End = e.t + int64(math.Round((stop+1)*period))
+		frames = append(frames, f)
+		// Look for the next start bit from the middle of the stop bit.
+		resume = e.t + int64(math.Round((stop+0.5)*period))
+	}
+	return frames, nil
+}
@@ -0,0 +1,102 @@
+package decode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/wave"
+)
+
+// WS2812 bit timings from the WS2812B datasheet, in picoseconds. A low
+// period of at least ws2812Reset latches the pixels.
+const (
+	ws2812T0H   = 400_000
+	ws2812T0L   = 850_000
+	ws2812T1H   = 800_000
+	ws2812T1L   = 450_000
+	ws2812Reset = 50_000_000
+)
+
+// decodeWS2812 decodes WS2812 pixels on the "data" channel. Each bit is a
+// high pulse, long for 1 and short for 0, and every 24 bits make a pixel
+// whose value is the GRB colour as sent. Bits whose high or low time is
+// more than the tolerance away from the datasheet timing are reported in
+// the pixel's error; the low time of the last bit before a reset is not
+// checked.
+func decodeWS2812(w *wave.Waveform, opts Options) ([]Frame, error) {
+	data, err := channel(w, opts, "data", false)
+	if err != nil {
+		return nil, err
+	}
+	tol := int64(opts.Tolerance * 1000)
+	if opts.Tolerance == 0 {
+		tol = 150_000
+	}
+	if tol < 0 {
+		return nil, fmt.Errorf("ws2812 tolerance %g ns is negative", opts.Tolerance)
+	}
+
+	var frames []Frame
+	var pixel Frame
+	var problems []string
+	n := 0
+	check := func(what string, got, want int64) {
+		if got < want-tol || got > want+tol {
+			problems = append(problems, fmt.Sprintf("bit %d %s time %d ns, want %d±%d ns", n, what, got/1000, want/1000, tol/1000))
+		}
+	}
+	finish := func(end int64) {
+		pixel.End = end
+		if n > 0 && n < 24 {
+			problems = append(problems, fmt.Sprintf("incomplete pixel (%d of 24 bits)", n))
+		}
+		if n > 0 {
+			pixel.Error = strings.Join(problems, "; ")
+			frames = append(frames, pixel)
+		}
+		problems, n = nil, 0
+	}
+
+	es := edges(data)
+	for i, e := range es {
+		if !e.high || i+1 >= len(es) {
+			continue
+		}
+		fall := es[i+1].t
+		next := w.Duration
+		if i+2 < len(es) {
+			next = es[i+2].t
+		}
+		high, low := fall-e.t, next-fall
+		if n == 0 {
+			pixel = Frame{Start: e.t, Type: "pixel"}
+		}
+		one := high > (ws2812T0H+ws2812T1H)/2
+		pixel.Value <<= 1
+		if one {
+			pixel.Value |= 1
+			check("high", high, ws2812T1H)
+		} else {
+			check("high", high, ws2812T0H)
+		}
+		reset := low >= ws2812Reset
+		if !reset && i+2 < len(es) {
+			if one {
+				check("low", low, ws2812T1L)
+			} else {
+				check("low", low, ws2812T0L)
+			}
+		}
+		if n++; n == 24 {
+			finish(fall)
+		}
+		if reset {
+			finish(fall)
+			frames = append(frames, Frame{Start: fall, End: next, Type: "reset"})
+		}
+	}
+	if n > 0 {
+		finish(w.Duration)
+	}
+	return frames, nil
+}
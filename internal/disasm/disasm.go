@@ -0,0 +1,339 @@
+// Package disasm turns PIO machine code back into assembly source that
+// internal/asm assembles to the same words. Jump targets get synthesized
+// labels, side-set and delay are decoded with the given .side_set
+// configuration, and encodings the assembler cannot express are kept as
+// .word directives.
+package disasm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// Options describe how the words were assembled. Machine code does not
+// record the side-set configuration or wrap, so they must be supplied to
+// decode bits 12:8 and to place .wrap_target and .wrap. Labels name
+// addresses, such as the public labels a header exports, in place of the
+// synthesized ones.
+type Options struct {
+	Name       string       `json:"name,omitempty"` // .program name; omitted when empty
+	SideSet    asm.SideSet  `json:"side_set"`
+	WrapTarget *int         `json:"wrap_target,omitempty"`
+	Wrap       *int         `json:"wrap,omitempty"`
+	Origin     *int         `json:"origin,omitempty"`
+	PIOVersion int          `json:"pio_version,omitempty"` // raised to 1 by an RP2350 instruction
+	Labels     []*asm.Label `json:"labels,omitempty"`
+}
+
+// Instruction is one disassembled word.
+type Instruction struct {
+	Address int    `json:"address"`
+	Word    uint16 `json:"word"`
+	Label   string `json:"label,omitempty"` // label at this address, named or synthesized
+	Text    string `json:"text"`            // e.g. "jmp x--, label_1 side 1 [2]"
+	Note    string `json:"note,omitempty"`  // why the word was kept as .word
+}
+
+// Listing is a disassembled program.
+type Listing struct {
+	Instructions []Instruction `json:"instructions"`
+	PIOVersion   int           `json:"pio_version"` // 1 when an RP2350 instruction was found
+	Source       string        `json:"source"`
+}
+
+var jmpConditions = [8]string{"", "!x", "x--", "!y", "y--", "x!=y", "pin", "!osre"}
+
+var waitSources = [4]string{"gpio", "pin", "irq", "jmppin"}
+
+// Register names by encoding; empty entries are reserved.
+var (
+	inSources       = [8]string{"pins", "x", "y", "null", "", "", "isr", "osr"}
+	outDestinations = [8]string{"pins", "x", "y", "null", "pindirs", "pc", "isr", "exec"}
+	movDestinations = [8]string{"pins", "x", "y", "pindirs", "exec", "pc", "isr", "osr"}
+	movSources      = [8]string{"pins", "x", "y", "null", "", "status", "isr", "osr"}
+	movOps          = [4]string{"", "!", "::", ""}
+	setDestinations = [8]string{"pins", "x", "y", "", "pindirs", "", "", ""}
+)
+
+// irqModes name bits 4:3 of an irq or wait irq index.
+var irqModes = [4]string{"", "prev ", "", "next "}
+
+// Disassemble decodes words into a listing.
+func Disassemble(words []uint16, opts Options) (*Listing, error) {
+	if len(words) == 0 {
+		return nil, errors.New("no instructions to disassemble")
+	}
+	if len(words) > asm.MaxInstructions {
+		return nil, fmt.Errorf("%d instructions exceed the %d of a PIO block", len(words), asm.MaxInstructions)
+	}
+	ss := opts.SideSet
+	if ss.Bits < 0 || ss.TotalBits() > 5 {
+		return nil, fmt.Errorf("%s needs %d bits but only 5 are shared with the delay", ss, ss.TotalBits())
+	}
+	if ss.Bits == 0 && ss.Opt {
+		return nil, fmt.Errorf("%s has an enable bit but no side-set bits", ss)
+	}
+	last := len(words) - 1
+	for _, w := range []struct {
+		name string
+		addr *int
+	}{{"wrap_target", opts.WrapTarget}, {"wrap", opts.Wrap}} {
+		if w.addr != nil && (*w.addr < 0 || *w.addr > last) {
+			return nil, fmt.Errorf("%s %d is outside the program (addresses 0-%d)", w.name, *w.addr, last)
+		}
+	}
+	if opts.Origin != nil && (*opts.Origin < 0 || *opts.Origin+len(words) > asm.MaxInstructions) {
+		return nil, fmt.Errorf("origin %d does not leave room for %d instructions", *opts.Origin, len(words))
+	}
+	if opts.PIOVersion < 0 || opts.PIOVersion > 1 {
+		return nil, fmt.Errorf("unknown PIO version %d (want 0 or 1)", opts.PIOVersion)
+	}
+
+	l := &Listing{PIOVersion: opts.PIOVersion}
+	labels := map[int]string{}
+	for _, lb := range opts.Labels {
+		if lb.Address < 0 || lb.Address > len(words) {
+			return nil, fmt.Errorf("label %s at %d is outside the program (addresses 0-%d)", lb.Name, lb.Address, len(words))
+		}
+		if labels[lb.Address] == "" {
+			labels[lb.Address] = lb.Name
+		}
+	}
+	for _, w := range words {
+		if target := int(w & 0x1f); w>>13 == 0 && target <= last && labels[target] == "" {
+			labels[target] = fmt.Sprintf("label_%d", target)
+		}
+	}
+	for addr, w := range words {
+		text, note, v1 := decode(w, ss, labels, last)
+		if note != "" {
+			text = fmt.Sprintf(".word 0x%04x", w)
+		}
+		if v1 {
+			l.PIOVersion = 1
+		}
+		l.Instructions = append(l.Instructions, Instruction{Address: addr, Word: w, Label: labels[addr], Text: text, Note: note})
+	}
+	l.Source = l.source(opts)
+	return l, nil
+}
+
+// Decode returns the assembly for a single word, with jmp targets as
+// addresses. Words without an assembly form are given as .word followed
+// by the reason.
+func Decode(w uint16, ss asm.SideSet) string {
+	text, note, _ := decode(w, ss, nil, asm.MaxInstructions-1)
+	if note != "" {
+		return fmt.Sprintf(".word 0x%04x %s", w, note)
+	}
+	return text
+}
+
+// DecodeLabels returns the assembly for a single word like Decode, with
+// jmp targets named by labels where it has one. A word without an
+// assembly form has an empty text and a note giving the reason.
+func DecodeLabels(w uint16, ss asm.SideSet, labels map[int]string) (text, note string) {
+	text, note, _ = decode(w, ss, labels, asm.MaxInstructions-1)
+	return text, note
+}
+
+// source writes the listing as assembly.
+func (l *Listing) source(opts Options) string {
+	var b strings.Builder
+	if opts.Name != "" {
+		fmt.Fprintf(&b, ".program %s\n", opts.Name)
+	}
+	if opts.SideSet.Bits > 0 {
+		fmt.Fprintf(&b, "%s\n", opts.SideSet)
+	}
+	if l.PIOVersion > 0 {
+		fmt.Fprintf(&b, ".pio_version %d\n", l.PIOVersion)
+	}
+	if opts.Origin != nil {
+		fmt.Fprintf(&b, ".origin %d\n", *opts.Origin)
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	width := 0
+	for _, inst := range l.Instructions {
+		width = max(width, len(inst.Text))
+	}
+	named := map[int][]*asm.Label{}
+	for _, lb := range opts.Labels {
+		named[lb.Address] = append(named[lb.Address], lb)
+	}
+	writeLabels := func(addr int, synthesized string) {
+		if len(named[addr]) == 0 && synthesized != "" {
+			fmt.Fprintf(&b, "%s:\n", synthesized)
+		}
+		for _, lb := range named[addr] {
+			if lb.Public {
+				b.WriteString("public ")
+			}
+			fmt.Fprintf(&b, "%s:\n", lb.Name)
+		}
+	}
+	for _, inst := range l.Instructions {
+		if opts.WrapTarget != nil && *opts.WrapTarget == inst.Address {
+			b.WriteString(".wrap_target\n")
+		}
+		writeLabels(inst.Address, inst.Label)
+		fmt.Fprintf(&b, "    %-*s ; %2d: %04x", width, inst.Text, inst.Address, inst.Word)
+		if inst.Note != "" {
+			fmt.Fprintf(&b, " %s", inst.Note)
+		}
+		b.WriteString("\n")
+		if opts.Wrap != nil && *opts.Wrap == inst.Address {
+			b.WriteString(".wrap\n")
+		}
+	}
+	writeLabels(len(l.Instructions), "")
+	return b.String()
+}
+
+// decode returns the assembly for one word. A non-empty note means the
+// word has no assembly form and explains why; v1 reports an RP2350
+// instruction.
+func decode(w uint16, ss asm.SideSet, labels map[int]string, last int) (text, note string, v1 bool) {
+	if field := int(w >> 8 & 0x1f); ss.Opt && field&0x10 == 0 && field>>ss.DelayBits() != 0 {
+		return "", "(side-set value without the side-set enable bit)", false
+	}
+	arg1, arg2 := w>>5&7, w&0x1f
+	switch w >> 13 {
+	case 0: // jmp
+		target := int(arg2)
+		if target > last {
+			return "", fmt.Sprintf("(jmp to %d, outside the program)", target), false
+		}
+		label, ok := labels[target]
+		if !ok {
+			label = fmt.Sprint(target)
+		}
+		text = "jmp " + label
+		if c := jmpConditions[arg1]; c != "" {
+			text = "jmp " + c + ", " + label
+		}
+	case 1: // wait
+		pol, src := w>>7&1, w>>5&3
+		switch src {
+		case 2:
+			if arg2&0x18 == 0x10 {
+				text = fmt.Sprintf("wait %d irq %d rel", pol, arg2&7)
+			} else {
+				text = fmt.Sprintf("wait %d irq %s%d", pol, irqModes[arg2>>3], arg2&7)
+				v1 = arg2&0x18 != 0
+			}
+		case 3:
+			if arg2 > 3 {
+				return "", "(wait jmppin offset out of range)", false
+			}
+			text, v1 = fmt.Sprintf("wait %d jmppin", pol), true
+			if arg2 > 0 {
+				text += fmt.Sprintf(" + %d", arg2)
+			}
+		default:
+			text = fmt.Sprintf("wait %d %s %d", pol, waitSources[src], arg2)
+		}
+	case 2, 3: // in, out
+		op, regs := "in", inSources
+		if w>>13 == 3 {
+			op, regs = "out", outDestinations
+		}
+		if regs[arg1] == "" {
+			return "", fmt.Sprintf("(reserved %s register %d)", op, arg1), false
+		}
+		n := arg2
+		if n == 0 {
+			n = 32
+		}
+		text = fmt.Sprintf("%s %s, %d", op, regs[arg1], n)
+	case 4: // push, pull, mov rxfifo
+		text, note, v1 = decodePushPull(w)
+		if note != "" {
+			return "", note, false
+		}
+	case 5: // mov
+		dst, op, src := arg1, arg2>>3&3, arg2&7
+		switch {
+		case w&0xff == 0x42: // mov y, y
+			text = "nop"
+		case movSources[src] == "" || op == 3:
+			return "", "(reserved mov source or operation)", false
+		default:
+			text = fmt.Sprintf("mov %s, %s%s", movDestinations[dst], movOps[op], movSources[src])
+			v1 = dst == 3
+		}
+	case 6: // irq
+		if w&0x80 != 0 {
+			return "", "(reserved irq bit 7)", false
+		}
+		mode := [4]string{"set", "wait", "clear", ""}[arg1&3]
+		if mode == "" {
+			return "", "(irq with both clear and wait)", false
+		}
+		if arg2&0x18 == 0x10 {
+			text = fmt.Sprintf("irq %s %d rel", mode, arg2&7)
+		} else {
+			text = fmt.Sprintf("irq %s%s %d", irqModes[arg2>>3], mode, arg2&7)
+			v1 = arg2&0x18 != 0
+		}
+	case 7: // set
+		if setDestinations[arg1] == "" {
+			return "", fmt.Sprintf("(reserved set destination %d)", arg1), false
+		}
+		text = fmt.Sprintf("set %s, %d", setDestinations[arg1], arg2)
+	}
+	return text + sideDelay(w, ss), "", v1
+}
+
+// decodePushPull decodes the push/pull opcode, which RP2350 shares with
+// mov rxfifo[index], isr and mov osr, rxfifo[index].
+func decodePushPull(w uint16) (text, note string, v1 bool) {
+	pull := w&0x80 != 0
+	if w&0x10 != 0 {
+		index := "y"
+		switch {
+		case w&0x6f == 0:
+		case w&0x6c == 0x08:
+			index = fmt.Sprint(w & 3)
+		default:
+			return "", "(reserved mov rxfifo encoding)", false
+		}
+		if pull {
+			return "mov osr, rxfifo[" + index + "]", "", true
+		}
+		return "mov rxfifo[" + index + "], isr", "", true
+	}
+	if w&0x0f != 0 {
+		return "", "(reserved push/pull bits)", false
+	}
+	text, cond := "push", "iffull"
+	if pull {
+		text, cond = "pull", "ifempty"
+	}
+	if w&0x40 != 0 {
+		text += " " + cond
+	}
+	if w&0x20 == 0 {
+		text += " noblock"
+	}
+	return text, "", false
+}
+
+// sideDelay returns the side-set and delay annotations of an
+// instruction.
+func sideDelay(w uint16, ss asm.SideSet) string {
+	field := int(w >> 8 & 0x1f)
+	var s string
+	if ss.Bits > 0 && (!ss.Opt || field&0x10 != 0) {
+		s = fmt.Sprintf(" side %d", field>>ss.DelayBits()&(1<<ss.Bits-1))
+	}
+	if delay := field & ss.MaxDelay(); delay > 0 {
+		s += fmt.Sprintf(" [%d]", delay)
+	}
+	return s
+}
@@ -0,0 +1,242 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// reassemble assembles a listing's source and checks it gives back the
+// words it was disassembled from.
+func reassemble(t *testing.T, words []uint16, l *Listing) *asm.Program {
+	t.Helper()
+	file, err := asm.Parse(l.Source)
+	if err != nil {
+		t.Fatalf("parse:\n%s\n%v", l.Source, err)
+	}
+	progs, err := asm.AssembleAll(file, asm.TargetRP2350)
+	if err != nil {
+		t.Fatalf("assemble:\n%s\n%v", l.Source, err)
+	}
+	got := progs[0].Instructions
+	if len(got) != len(words) {
+		t.Fatalf("expected %d words, got %d:\n%s", len(words), len(got), l.Source)
+	}
+	for i := range words {
+		if got[i] != words[i] {
+			t.Fatalf("word %d: expected %04x, got %04x:\n%s", i, words[i], got[i], l.Source)
+		}
+	}
+	return progs[0]
+}
+
+func TestDisassemble_WS2812(t *testing.T) {
+	words := []uint16{0x6221, 0x1123, 0x1400, 0xa442}
+	wt, wrap := 0, 3
+	l, err := Disassemble(words, Options{Name: "ws2812", SideSet: asm.SideSet{Bits: 1}, WrapTarget: &wt, Wrap: &wrap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `.program ws2812
+.side_set 1
+
+.wrap_target
+label_0:
+    out x, 1 side 0 [2]        ;  0: 6221
+    jmp !x, label_3 side 1 [1] ;  1: 1123
+    jmp label_0 side 1 [4]     ;  2: 1400
+label_3:
+    nop side 0 [4]             ;  3: a442
+.wrap
+`
+	if l.Source != want {
+		t.Fatalf("unexpected source:\n%s\nwant:\n%s", l.Source, want)
+	}
+	prog := reassemble(t, words, l)
+	if prog.Name != "ws2812" || prog.WrapTarget != 0 || prog.Wrap != 3 {
+		t.Fatalf("expected name and wrap to survive, got %q %d..%d", prog.Name, prog.WrapTarget, prog.Wrap)
+	}
+}
+
+func TestDisassemble_RoundTrip(t *testing.T) {
+	for _, src := range []string{
+		`.side_set 1 opt
+    pull       side 1 [7]
+    set x, 7   side 0 [7]
+loop:
+    out pins, 1
+    jmp x--, loop [6]`,
+		`.side_set 2 opt pindirs
+    wait 0 gpio 5 side 3
+    wait 1 pin 2
+    wait 1 irq 3 rel
+    in isr, 32
+    in null, 5 side 1
+    out exec, 16
+    out pc, 5
+    push iffull noblock
+    push
+    pull ifempty
+    pull noblock
+    mov pins, !x [1]
+    mov isr, ::osr
+    mov exec, status
+    mov pc, null
+    irq wait 1 rel
+    irq clear 7
+    irq set 0
+    set pindirs, 31
+    set y, 0
+    jmp !osre, 0
+    jmp pin, 1
+    jmp x!=y, 2
+    jmp !y, 3`,
+		`.pio_version 1
+    wait 1 jmppin + 2
+    wait 0 irq next 1
+    irq prev set 4
+    mov rxfifo[y], isr
+    mov osr, rxfifo[2]
+    mov pindirs, ~y`,
+	} {
+		prog, err := asm.AssembleFile(mustParse(t, src), asm.TargetRP2350)
+		if err != nil {
+			t.Fatalf("assemble:\n%s\n%v", src, err)
+		}
+		l, err := Disassemble(prog.Instructions, Options{SideSet: prog.SideSet})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reassemble(t, prog.Instructions, l)
+		if v1 := strings.Contains(src, ".pio_version 1"); v1 != (l.PIOVersion == 1) {
+			t.Errorf("expected version 1 instructions %t, got PIO version %d:\n%s", v1, l.PIOVersion, l.Source)
+		}
+	}
+}
+
+// TestDisassemble_EveryWord checks that every 16-bit word, under each
+// side-set configuration, disassembles to source that assembles back to
+// exactly that word, as an instruction or as .word.
+func TestDisassemble_EveryWord(t *testing.T) {
+	for _, ss := range []asm.SideSet{{}, {Bits: 1}, {Bits: 2, Opt: true}, {Bits: 4, Opt: true, PinDirs: true}, {Bits: 5}} {
+		for base := 0; base < 1<<16; base += asm.MaxInstructions {
+			words := make([]uint16, asm.MaxInstructions)
+			for i := range words {
+				words[i] = uint16(base + i)
+			}
+			l, err := Disassemble(words, Options{SideSet: ss, PIOVersion: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reassemble(t, words, l)
+		}
+	}
+	if l, _ := Disassemble([]uint16{0x4201, 0x4901}, Options{SideSet: asm.SideSet{Bits: 2, Opt: true}}); l.Instructions[1].Note == "" || l.Instructions[0].Note != "" {
+		t.Fatalf("expected side-set bits without the enable bit to be kept as .word, got %+v", l.Instructions)
+	}
+}
+
+func mustParse(t *testing.T, src string) *asm.File {
+	t.Helper()
+	file, err := asm.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDisassemble_Labels(t *testing.T) {
+	words := []uint16{0x6221, 0x1123, 0x1400, 0xa442}
+	l, err := Disassemble(words, Options{
+		SideSet:    asm.SideSet{Bits: 1},
+		PIOVersion: 1,
+		Labels:     []*asm.Label{{Name: "bitloop", Public: true}, {Name: "do_zero", Address: 3}, {Name: "end", Address: 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{".pio_version 1\n", "public bitloop:\n    out", "jmp !x, do_zero", "jmp bitloop", "do_zero:\n    nop", "\nend:\n"} {
+		if !strings.Contains(l.Source, want) {
+			t.Errorf("expected %q in:\n%s", want, l.Source)
+		}
+	}
+	if strings.Contains(l.Source, "label_") {
+		t.Errorf("expected no synthesized labels:\n%s", l.Source)
+	}
+	prog := reassemble(t, words, l)
+	if lb := prog.Label("bitloop"); lb == nil || !lb.Public {
+		t.Fatalf("expected a public bitloop label, got %+v", prog.Labels)
+	}
+}
+
+func TestDisassemble_RawWords(t *testing.T) {
+	words := []uint16{
+		0x0007, // jmp past the end
+		0x4081, // in with reserved source 4
+		0xc080, // irq with bit 7 set
+		0xe0a1, // set with reserved destination 5
+		0x8001, // push with reserved bits
+	}
+	l, err := Disassemble(words, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, inst := range l.Instructions {
+		if !strings.HasPrefix(inst.Text, ".word") || inst.Note == "" {
+			t.Errorf("word %d: expected a .word with a note, got %+v", i, inst)
+		}
+	}
+	reassemble(t, words, l)
+}
+
+func TestDisassemble_Errors(t *testing.T) {
+	three, origin := 3, 31
+	for _, tt := range []struct {
+		words []uint16
+		opts  Options
+		want  string
+	}{
+		{nil, Options{}, "no instructions"},
+		{make([]uint16, 33), Options{}, "exceed the 32"},
+		{[]uint16{0}, Options{SideSet: asm.SideSet{Bits: 5, Opt: true}}, "only 5"},
+		{[]uint16{0}, Options{SideSet: asm.SideSet{Opt: true}}, "no side-set bits"},
+		{[]uint16{0, 0}, Options{Wrap: &three}, "wrap 3 is outside"},
+		{[]uint16{0, 0}, Options{Origin: &origin}, "origin 31"},
+		{[]uint16{0, 0}, Options{PIOVersion: 2}, "PIO version 2"},
+		{[]uint16{0, 0}, Options{Labels: []*asm.Label{{Name: "far", Address: 3}}}, "label far at 3"},
+	} {
+		if _, err := Disassemble(tt.words, tt.opts); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.opts, tt.want, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	ss := asm.SideSet{Bits: 1, Opt: true}
+	for _, tt := range []struct {
+		word uint16
+		want string
+	}{
+		{0x0745, "jmp x--, 5 [7]"},
+		{0x9fa0, "pull side 1 [7]"},
+		{0xc080, ".word 0xc080 (reserved irq bit 7)"},
+	} {
+		if got := Decode(tt.word, ss); got != tt.want {
+			t.Errorf("%04x: expected %q, got %q", tt.word, tt.want, got)
+		}
+	}
+}
+
+func TestDecodeLabels(t *testing.T) {
+	labels := map[int]string{5: "loop"}
+	if text, note := DecodeLabels(0x0745, asm.SideSet{}, labels); text != "jmp x--, loop [7]" || note != "" {
+		t.Errorf("expected a named target, got %q %q", text, note)
+	}
+	if text, _ := DecodeLabels(0x0004, asm.SideSet{}, labels); text != "jmp 4" {
+		t.Errorf("expected an address without a label, got %q", text)
+	}
+	if text, note := DecodeLabels(0xc080, asm.SideSet{}, labels); text != "" || note != "(reserved irq bit 7)" {
+		t.Errorf("expected a note, got %q %q", text, note)
+	}
+}
@@ -0,0 +1,124 @@
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+var (
+	cArray   = regexp.MustCompile(`\buint16_t\s+(\w+)\s*\[\s*\w*\s*\]\s*=\s*\{([^}]*)\}`)
+	cDefine  = regexp.MustCompile(`(?m)^[ \t]*#[ \t]*define[ \t]+(\w+)[ \t]+(\(?-?[ \t]*\w+\)?)[ \t]*$`)
+	cOrigin  = regexp.MustCompile(`\b(\w+)_program\s*=\s*\{[^}]*?\.origin\s*=\s*(\(?-?\s*\w+\)?)`)
+	cConfig  = regexp.MustCompile(`\b(\w+)_program_get_default_config\s*\(`)
+	cSideSet = regexp.MustCompile(`\bsm_config_set_sideset\s*\(\s*&\s*\w+\s*,\s*(\w+)\s*,\s*(true|false)\s*,\s*(true|false)\s*\)`)
+)
+
+// C reads the programs of a C header in the layout of pioasm's c-sdk
+// output. Each uint16_t xxx_program_instructions[] array is a program
+// named xxx; its #define xxx_wrap_target, xxx_wrap, xxx_pio_version,
+// xxx_offset_label and xxx_NAME constants, the origin of its pio_program
+// and the side-set of xxx_program_get_default_config are read back.
+// Errors are an asm.ErrorList with line numbers.
+func C(src string) ([]*Program, error) {
+	src = blankComments(src)
+	var errs asm.ErrorList
+	var progs []*Program
+	var prefixes []string
+	for _, m := range cArray.FindAllStringSubmatchIndex(src, -1) {
+		array := src[m[2]:m[3]]
+		name := strings.TrimSuffix(array, "_program_instructions")
+		if name == array {
+			name = strings.TrimSuffix(array, "_instructions")
+		}
+		p := &Program{}
+		p.Options.Name = name
+		off := m[4]
+		for i, elem := range strings.Split(src[m[4]:m[5]], ",") {
+			if e := strings.TrimSpace(elem); e != "" {
+				w, ok := parseInt(e)
+				if !ok || w < 0 || w > 0xffff {
+					at := off + len(elem) - len(strings.TrimLeft(elem, " \t\r\n"))
+					errs = append(errs, &asm.Error{Line: lineOf(src, at), Msg: fmt.Sprintf("%s[%d]: %q is not a 16-bit instruction word", array, i, e)})
+				}
+				p.Words = append(p.Words, uint16(w))
+			}
+			off += len(elem) + 1
+		}
+		progs = append(progs, p)
+		prefixes = append(prefixes, name+"_")
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(progs) == 0 {
+		return nil, errors.New("no uint16_t xxx_program_instructions[] array")
+	}
+
+	var consts []constant
+	for _, m := range cDefine.FindAllStringSubmatch(src, -1) {
+		if v, ok := parseInt(m[2]); ok {
+			consts = append(consts, constant{m[1], v})
+		}
+	}
+	symbols(progs, prefixes, consts, func(rest string) (string, string) {
+		switch {
+		case rest == "wrap_target", rest == "wrap", rest == "pio_version":
+			return rest, ""
+		case strings.HasPrefix(rest, "offset_"):
+			return "label", strings.TrimPrefix(rest, "offset_")
+		}
+		return "define", rest
+	})
+
+	for _, m := range cOrigin.FindAllStringSubmatch(src, -1) {
+		if p := programNamed(progs, m[1]); p != nil {
+			if v, ok := parseInt(m[2]); ok && v >= 0 {
+				p.Options.Origin = &v
+			}
+		}
+	}
+	configs := cConfig.FindAllStringSubmatchIndex(src, -1)
+	for i, m := range configs {
+		end := len(src)
+		if i+1 < len(configs) {
+			end = configs[i+1][0]
+		}
+		p := programNamed(progs, src[m[2]:m[3]])
+		ss := cSideSet.FindStringSubmatch(src[m[1]:end])
+		if p == nil || ss == nil {
+			continue
+		}
+		bits, ok := parseInt(ss[1])
+		if !ok {
+			continue
+		}
+		p.Options.SideSet = sideSet(bits, ss[2] == "true", ss[3] == "true")
+	}
+	return progs, nil
+}
+
+// programNamed returns the program with the given name, or nil.
+func programNamed(progs []*Program, name string) *Program {
+	for _, p := range progs {
+		if p.Options.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// sideSet returns the side-set of the pico-sdk and tinygo-org/pio
+// parameters, whose bit count includes the enable bit.
+func sideSet(bits int, opt, pindirs bool) asm.SideSet {
+	if opt {
+		bits--
+	}
+	if bits <= 0 {
+		return asm.SideSet{}
+	}
+	return asm.SideSet{Bits: bits, Opt: opt, PinDirs: pindirs}
+}
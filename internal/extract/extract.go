@@ -0,0 +1,180 @@
+// Package extract recovers PIO programs from the machine code other tools
+// leave behind: pioasm's c-sdk headers, Go []uint16 literals such as
+// tinygo-org/pio's generated files, and hex dumps. The wrap, origin,
+// side-set, public labels and public defines recorded next to the words
+// are read back where the format has them, and Source turns the programs
+// into PIO source with internal/disasm.
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/disasm"
+)
+
+// Program is the machine code of one program and the configuration it
+// was assembled with.
+type Program struct {
+	Words   []uint16
+	Options disasm.Options
+	Defines []*asm.Define
+}
+
+// Source disassembles programs into PIO source. Jump targets without a
+// public label get a synthesized one and every instruction is annotated
+// with its address and word.
+func Source(progs []*Program) (string, error) {
+	var b strings.Builder
+	for i, p := range progs {
+		opts := p.Options
+		opts.Name = ""
+		l, err := disasm.Disassemble(p.Words, opts)
+		if err != nil {
+			if p.Options.Name != "" {
+				err = fmt.Errorf("%s: %w", p.Options.Name, err)
+			}
+			return "", err
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if p.Options.Name != "" {
+			fmt.Fprintf(&b, ".program %s\n", p.Options.Name)
+		}
+		for _, d := range p.Defines {
+			value := strconv.Itoa(d.Value)
+			if d.Value < 0 {
+				value = "(" + value + ")"
+			}
+			fmt.Fprintf(&b, ".define public %s %s\n", d.Name, value)
+		}
+		b.WriteString(l.Source)
+	}
+	return b.String(), nil
+}
+
+var (
+	comment      = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+	hexWord      = regexp.MustCompile(`\b0[xX]([0-9a-fA-F]+)\b`)
+	addressField = regexp.MustCompile(`(?m)^[ \t]*(?:0[xX])?[0-9a-fA-F]+:`)
+)
+
+// Hex reads the single program of a hex dump. Text with 0x literals, such
+// as a C array or a firmware dump, gives every literal outside comments;
+// otherwise the text is bare hex words separated by whitespace or commas,
+// as in pioasm's hex output. An address ending in a colon at the start of
+// a line is skipped.
+func Hex(text string) ([]*Program, error) {
+	words, err := Words(text)
+	if err != nil {
+		return nil, err
+	}
+	return []*Program{{Words: words}}, nil
+}
+
+// Words reads instruction words from hex text in the forms Hex accepts.
+func Words(text string) ([]uint16, error) {
+	text = comment.ReplaceAllString(text, "")
+	text = addressField.ReplaceAllString(text, "")
+	var fields []string
+	if m := hexWord.FindAllStringSubmatch(text, -1); m != nil {
+		for _, sub := range m {
+			fields = append(fields, sub[1])
+		}
+	} else {
+		fields = strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+		})
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no instruction words in hex")
+	}
+	words := make([]uint16, 0, len(fields))
+	for _, f := range fields {
+		w, err := strconv.ParseUint(f, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid instruction word %q: want up to 4 hex digits", f)
+		}
+		words = append(words, uint16(w))
+	}
+	return words, nil
+}
+
+// symbols assigns the named constants of a file to the programs whose
+// prefix they start with, the longest prefix winning, and sets the wrap,
+// origin, version, labels and defines they describe. setting maps the
+// rest of a name to its key, and to the label or define name for those
+// keys.
+func symbols(progs []*Program, prefixes []string, consts []constant, setting func(rest string) (key, label string)) {
+	for _, c := range consts {
+		best := -1
+		for i, prefix := range prefixes {
+			if strings.HasPrefix(c.name, prefix) && len(c.name) > len(prefix) && (best < 0 || len(prefix) > len(prefixes[best])) {
+				best = i
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		p := progs[best]
+		v := c.value
+		switch key, label := setting(c.name[len(prefixes[best]):]); key {
+		case "wrap_target":
+			p.Options.WrapTarget = &v
+		case "wrap":
+			p.Options.Wrap = &v
+		case "origin":
+			if v >= 0 {
+				p.Options.Origin = &v
+			}
+		case "pio_version":
+			p.Options.PIOVersion = v
+		case "label":
+			p.Options.Labels = append(p.Options.Labels, &asm.Label{Name: label, Address: v, Public: true})
+		case "define":
+			p.Defines = append(p.Defines, &asm.Define{Name: label, Value: v, Public: true})
+		}
+	}
+}
+
+// constant is an integer constant declared in a file.
+type constant struct {
+	name  string
+	value int
+}
+
+// parseInt reads an integer literal in C or Go syntax, with an optional
+// sign, parentheses and C integer suffix.
+func parseInt(s string) (int, bool) {
+	s = strings.Join(strings.Fields(s), "")
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.TrimRight(s, "uUlL")
+	v, err := strconv.ParseInt(s, 0, 32)
+	return int(v), err == nil
+}
+
+// lineOf returns the 1-based line of a byte offset.
+func lineOf(src string, off int) int {
+	return strings.Count(src[:off], "\n") + 1
+}
+
+// blankComments replaces comments with spaces, keeping newlines, so
+// offsets and lines still match the source.
+func blankComments(src string) string {
+	return comment.ReplaceAllStringFunc(src, func(c string) string {
+		b := []byte(c)
+		for i := range b {
+			if b[i] != '\n' {
+				b[i] = ' '
+			}
+		}
+		return string(b)
+	})
+}
@@ -0,0 +1,289 @@
+package extract
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// assemble assembles source and returns its programs.
+func assemble(t *testing.T, source string) []*asm.Program {
+	t.Helper()
+	file, err := asm.Parse(source)
+	if err != nil {
+		t.Fatalf("parse:\n%s\n%v", source, err)
+	}
+	progs, err := asm.AssembleAll(file, asm.TargetRP2350)
+	if err != nil {
+		t.Fatalf("assemble:\n%s\n%v", source, err)
+	}
+	return progs
+}
+
+const ws2812Header = `// -------------------------------------------------- //
+// This file is autogenerated by pioasm; do not edit! //
+// -------------------------------------------------- //
+
+#pragma once
+
+#if !PICO_NO_HARDWARE
+#include "hardware/pio.h"
+#endif
+
+// ------ //
+// ws2812 //
+// ------ //
+
+#define ws2812_wrap_target 0
+#define ws2812_wrap 3
+#define ws2812_pio_version 0
+
+#define ws2812_offset_bitloop 0u
+#define ws2812_T1 2
+#define ws2812_T3 -3
+
+static const uint16_t ws2812_program_instructions[] = {
+            //     .wrap_target
+    0x6221, //  0: out    x, 1            side 0 [2]
+    0x1123, //  1: jmp    !x, 3           side 1 [1]
+    0x1400, //  2: jmp    0               side 1 [4]
+    0xa442, //  3: nop                    side 0 [4]
+            //     .wrap
+};
+
+#if !PICO_NO_HARDWARE
+static const struct pio_program ws2812_program = {
+    .instructions = ws2812_program_instructions,
+    .length = 4,
+    .origin = -1,
+    .pio_version = ws2812_pio_version,
+#if PICO_PIO_VERSION > 0
+    .used_gpio_ranges = 0x0
+#endif
+};
+
+static inline pio_sm_config ws2812_program_get_default_config(uint offset) {
+    pio_sm_config c = pio_get_default_sm_config();
+    sm_config_set_wrap(&c, offset + ws2812_wrap_target, offset + ws2812_wrap);
+    sm_config_set_sideset(&c, 1, false, false);
+    return c;
+}
+#endif
+
+// --------- //
+// ws2812_tx //
+// --------- //
+
+#define ws2812_tx_wrap_target 1
+#define ws2812_tx_wrap 1
+
+static const uint16_t ws2812_tx_program_instructions[] = {
+    0x80a0, //  0: pull   block           side 0
+            //     .wrap_target
+    0x7001, //  1: out    pins, 1         side 1
+            //     .wrap
+};
+
+static const struct pio_program ws2812_tx_program = {
+    .instructions = ws2812_tx_program_instructions,
+    .length = 2,
+    .origin = 8,
+};
+
+static inline pio_sm_config ws2812_tx_program_get_default_config(uint offset) {
+    pio_sm_config c = pio_get_default_sm_config();
+    sm_config_set_wrap(&c, offset + ws2812_tx_wrap_target, offset + ws2812_tx_wrap);
+    sm_config_set_sideset(&c, 2, true, false);
+    return c;
+}
+`
+
+func TestC(t *testing.T) {
+	progs, err := C(ws2812Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progs) != 2 {
+		t.Fatalf("expected 2 programs, got %d", len(progs))
+	}
+	ws, tx := progs[0], progs[1]
+	if ws.Options.Name != "ws2812" || len(ws.Words) != 4 || ws.Options.SideSet != (asm.SideSet{Bits: 1}) || ws.Options.Origin != nil {
+		t.Fatalf("unexpected ws2812 program: %+v", ws)
+	}
+	if len(ws.Defines) != 2 || ws.Defines[1].Name != "T3" || ws.Defines[1].Value != -3 {
+		t.Fatalf("expected the T1 and T3 defines, got %+v", ws.Defines)
+	}
+	// ws2812_tx_wrap belongs to ws2812_tx, not to ws2812 as a define.
+	if tx.Options.Name != "ws2812_tx" || *tx.Options.WrapTarget != 1 || *tx.Options.Origin != 8 || tx.Options.SideSet != (asm.SideSet{Bits: 1, Opt: true}) {
+		t.Fatalf("unexpected ws2812_tx program: %+v", tx.Options)
+	}
+
+	source, err := Source(progs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		".program ws2812\n.define public T1 2\n.define public T3 (-3)\n.side_set 1\n",
+		"public bitloop:\n    out x, 1 side 0 [2]",
+		"jmp !x, label_3 side 1 [1]",
+		".program ws2812_tx\n.side_set 1 opt\n.origin 8\n",
+	} {
+		if !strings.Contains(source, want) {
+			t.Errorf("expected %q in:\n%s", want, source)
+		}
+	}
+	got := assemble(t, source)
+	for i, p := range progs {
+		if !slices.Equal(got[i].Instructions, p.Words) {
+			t.Errorf("%s: reassembled to %04x, want %04x", p.Options.Name, got[i].Instructions, p.Words)
+		}
+	}
+	if got[0].Wrap != 3 || got[1].WrapTarget != 1 || got[1].Origin != 8 {
+		t.Errorf("expected the wrap and origin to survive, got %+v %+v", got[0], got[1])
+	}
+}
+
+func TestC_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		src, want string
+	}{
+		{"#define x_wrap 3\n", "no uint16_t"},
+		{"static const uint16_t x_program_instructions[] = {\n    0x6221,\n    0x10000,\n};", `line 3: x_program_instructions[1]: "0x10000" is not a 16-bit instruction word`},
+		{"uint16_t x_program_instructions[] = { SET_X, 0 };", `"SET_X"`},
+	} {
+		if _, err := C(tt.src); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.src, tt.want, err)
+		}
+	}
+}
+
+func TestGo(t *testing.T) {
+	src := `// Code generated by pioasm; DO NOT EDIT.
+
+//go:build rp2040
+
+package piolib
+
+import (
+	pio "github.com/tinygo-org/pio/rp2-pio"
+)
+
+// ws2812b_led
+
+const ws2812b_ledWrapTarget = 0
+const ws2812b_ledWrap = 3
+
+var ws2812b_ledInstructions = []uint16{
+		//     .wrap_target
+		0x6221, //  0: out    x, 1            side 0 [2]
+		0x1123, //  1: jmp    !x, 3           side 1 [1]
+		0x1400, //  2: jmp    0               side 1 [4]
+		0xa442, //  3: nop                    side 0 [4]
+		//     .wrap
+}
+const ws2812b_ledOrigin = -1
+func ws2812b_ledProgramDefaultConfig(offset uint8) pio.StateMachineConfig {
+	cfg := pio.DefaultStateMachineConfig()
+	cfg.SetWrap(offset+ws2812b_ledWrapTarget, offset+ws2812b_ledWrap)
+	cfg.SetSidesetParams(1, false, false)
+	return cfg;
+}
+`
+	progs, err := Go(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progs) != 1 || progs[0].Options.Name != "ws2812b_led" || progs[0].Options.SideSet != (asm.SideSet{Bits: 1}) || *progs[0].Options.Wrap != 3 || progs[0].Options.Origin != nil {
+		t.Fatalf("unexpected programs: %+v", progs[0])
+	}
+	source, err := Source(progs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := assemble(t, source); !slices.Equal(got[0].Instructions, progs[0].Words) {
+		t.Fatalf("reassembled to %04x:\n%s", got[0].Instructions, source)
+	}
+
+	// An exported fragment, as tinypio writes the constants.
+	progs, err = Go("const (\n\tBlinkOffsetStart = 1\n\tBlinkDelay = 0b1_1111\n)\nvar BlinkInstructions = [...]uint16{0xe001, 0xff00}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := progs[0]
+	if p.Options.Name != "blink" || p.Options.Labels[0].Name != "start" || p.Defines[0].Name != "Delay" || p.Defines[0].Value != 31 {
+		t.Fatalf("unexpected program: %+v %+v %+v", p, p.Options.Labels, p.Defines)
+	}
+}
+
+func TestGo_Literals(t *testing.T) {
+	progs, err := Go("var x = []uint16{0x0000, 0xa042}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progs) != 1 || progs[0].Options.Name != "" || !slices.Equal(progs[0].Words, []uint16{0x0000, 0xa042}) {
+		t.Fatalf("expected one unnamed program, got %+v", progs)
+	}
+
+	// Literals in functions and struct fields, named when there are several.
+	progs, err = Go(`package drivers
+
+type program struct{ code []uint16 }
+
+var blink = program{code: []uint16{0xe001, 0xe000}}
+
+func load() [2]uint16 {
+	empty := []uint16{}
+	_ = empty
+	return [...]uint16{0x80a0, 0x6001}
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progs) != 2 || progs[0].Options.Name != "program_0" || progs[1].Options.Name != "program_1" || !slices.Equal(progs[1].Words, []uint16{0x80a0, 0x6001}) {
+		t.Fatalf("expected two named programs, got %+v %+v", progs[0], progs[1])
+	}
+	source, err := Source(progs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := assemble(t, source); len(got) != 2 || !slices.Equal(got[0].Instructions, progs[0].Words) {
+		t.Fatalf("reassembled to %+v:\n%s", got, source)
+	}
+}
+
+func TestGo_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		src, want string
+	}{
+		{"var x = 1", "no []uint16 literal"},
+		{"func f() {\n\t_ = []uint16{0x0000, x}\n}", "line 2:23: []uint16 literal[1] is not an integer literal"},
+		{"var x = []uint16{", "line 1:"},
+		{"var AInstructions = []uint16{\n\tasm.Nop().Encode(),\n}", "line 2:2: AInstructions[0] is not an integer literal"},
+	} {
+		if _, err := Go(tt.src); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.src, tt.want, err)
+		}
+	}
+}
+
+func TestHex(t *testing.T) {
+	for _, text := range []string{
+		"6221\n1123\n1400\na442\n",
+		"0000: 6221 1123\n0002: 1400 a442\n",
+		"0x6221, 0x1123, // jmp\n0x1400, 0xa442",
+	} {
+		progs, err := Hex(text)
+		if err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		if !slices.Equal(progs[0].Words, []uint16{0x6221, 0x1123, 0x1400, 0xa442}) {
+			t.Errorf("%q: got %04x", text, progs[0].Words)
+		}
+	}
+	if _, err := Hex("e081\nzz"); err == nil || !strings.Contains(err.Error(), `invalid instruction word "zz"`) {
+		t.Fatalf("expected an invalid word error, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+var goPackage = regexp.MustCompile(`(?m)^\s*package\s`)
+
+// Go reads the programs of a Go file holding []uint16 literals, such as
+// the files pioasm's go output generates for tinygo-org/pio. Each
+// xxxInstructions variable is a program named xxx, with a lower case
+// first letter; its xxxWrapTarget, xxxWrap, xxxOrigin, xxxOffsetLabel and
+// xxxNAME constants and the SetSidesetParams call of
+// xxxProgramDefaultConfig are read back. Without any xxxInstructions
+// variable, every non-empty []uint16 literal in the file, in functions and
+// struct fields too, is a program: one is left unnamed and several are
+// named program_0, program_1 and so on. The elements must be integer
+// literals, so instructions built with pio.AssemblerV0 calls are an
+// error. A fragment without a package clause is accepted. Errors are an
+// asm.ErrorList with line numbers.
+func Go(src string) ([]*Program, error) {
+	if !goPackage.MatchString(blankComments(src)) {
+		// On the first line, so line numbers are unchanged.
+		src = "package p; " + src
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		var list scanner.ErrorList
+		if !errors.As(err, &list) {
+			return nil, err
+		}
+		var errs asm.ErrorList
+		for _, e := range list {
+			errs = append(errs, &asm.Error{Line: e.Pos.Line, Col: e.Pos.Column, Msg: e.Msg})
+		}
+		return nil, errs
+	}
+
+	var errs asm.ErrorList
+	var progs []*Program
+	var prefixes []string
+	var consts []constant
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			for i, ident := range vs.Names {
+				if i >= len(vs.Values) {
+					break
+				}
+				lit, ok := vs.Values[i].(*ast.CompositeLit)
+				if !ok {
+					if v, ok := goInt(vs.Values[i]); ok {
+						consts = append(consts, constant{ident.Name, v})
+					}
+					continue
+				}
+				prefix, ok := strings.CutSuffix(ident.Name, "Instructions")
+				if !ok || !isUint16Array(lit.Type) {
+					continue
+				}
+				p := &Program{Words: goWords(fset, ident.Name, lit, &errs)}
+				p.Options.Name = lowerFirst(prefix)
+				progs = append(progs, p)
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	if len(progs) == 0 && len(errs) == 0 {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if lit, ok := n.(*ast.CompositeLit); ok && isUint16Array(lit.Type) && len(lit.Elts) > 0 {
+				progs = append(progs, &Program{Words: goWords(fset, "[]uint16 literal", lit, &errs)})
+			}
+			return true
+		})
+		if len(progs) > 1 {
+			for i, p := range progs {
+				p.Options.Name = fmt.Sprintf("program_%d", i)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(progs) == 0 {
+		return nil, errors.New("no []uint16 literal of instruction words")
+	}
+
+	symbols(progs, prefixes, consts, func(rest string) (string, string) {
+		switch rest {
+		case "WrapTarget":
+			return "wrap_target", ""
+		case "Wrap":
+			return "wrap", ""
+		case "Origin":
+			return "origin", ""
+		case "PioVersion", "PIOVersion":
+			return "pio_version", ""
+		}
+		if label, ok := strings.CutPrefix(rest, "Offset"); ok && label != "" {
+			return "label", lowerFirst(label)
+		}
+		return "define", rest
+	})
+
+	for _, decl := range file.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		prefix, ok := strings.CutSuffix(fd.Name.Name, "ProgramDefaultConfig")
+		p := programNamed(progs, lowerFirst(prefix))
+		if !ok || p == nil {
+			continue
+		}
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 3 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "SetSidesetParams" {
+				return true
+			}
+			bits, ok := goInt(call.Args[0])
+			opt, ok1 := goBool(call.Args[1])
+			pindirs, ok2 := goBool(call.Args[2])
+			if ok && ok1 && ok2 {
+				p.Options.SideSet = sideSet(bits, opt, pindirs)
+			}
+			return false
+		})
+	}
+	return progs, nil
+}
+
+// goWords returns the elements of a []uint16 literal, adding an error
+// for each one that is not an instruction word.
+func goWords(fset *token.FileSet, name string, lit *ast.CompositeLit, errs *asm.ErrorList) []uint16 {
+	var words []uint16
+	for j, elt := range lit.Elts {
+		w, ok := goInt(elt)
+		if !ok || w < 0 || w > 0xffff {
+			pos := fset.Position(elt.Pos())
+			*errs = append(*errs, &asm.Error{Line: pos.Line, Col: pos.Column, Msg: fmt.Sprintf("%s[%d] is not an integer literal of an instruction word", name, j)})
+		}
+		words = append(words, uint16(w))
+	}
+	return words
+}
+
+// isUint16Array reports whether a composite literal type is a slice or
+// array of uint16.
+func isUint16Array(t ast.Expr) bool {
+	at, ok := t.(*ast.ArrayType)
+	if !ok {
+		return false
+	}
+	elt, ok := at.Elt.(*ast.Ident)
+	return ok && elt.Name == "uint16"
+}
+
+// goInt returns the value of an integer literal, possibly negated or in
+// parentheses.
+func goInt(e ast.Expr) (int, bool) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.INT {
+			return parseInt(e.Value)
+		}
+	case *ast.ParenExpr:
+		return goInt(e.X)
+	case *ast.UnaryExpr:
+		if v, ok := goInt(e.X); ok && e.Op == token.SUB {
+			return -v, true
+		}
+	}
+	return 0, false
+}
+
+func goBool(e ast.Expr) (bool, bool) {
+	if id, ok := e.(*ast.Ident); ok && (id.Name == "true" || id.Name == "false") {
+		return id.Name == "true", true
+	}
+	return false, false
+}
+
+// lowerFirst lower cases the first letter of a Go name, so Ws2812 gives
+// back the program name ws2812.
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[n:]
+}
@@ -0,0 +1,342 @@
+// Package format rewrites PIO source in a canonical layout. It works
+// from the internal/asm parse tree: directives and labels start at
+// column 0, instructions are indented four spaces, operands and
+// expressions are spaced consistently, and the side, delay and comment
+// columns of the instructions in each block are aligned. Comments, code
+// blocks and single blank lines are kept.
+package format
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// Indent is the indentation of instructions.
+const Indent = "    "
+
+// Source formats PIO source. Source with syntax errors is returned
+// unchanged with the parser's asm.ErrorList. As a safety net the result
+// is assembled and compared with the input, so formatting never changes
+// the machine code.
+func Source(src string) (string, error) {
+	file, err := asm.Parse(src)
+	if err != nil {
+		return src, err
+	}
+	f := &formatter{src: src, srcLines: strings.Split(src, "\n")}
+	for _, tok := range asm.Lex(src) {
+		if tok.Kind == asm.Comment {
+			f.comments = append(f.comments, tok)
+		}
+	}
+	out := f.format(file)
+	if err := sameProgram(src, out); err != nil {
+		return src, err
+	}
+	return out, nil
+}
+
+// line is one output line. Instruction lines are split into cells that
+// are aligned with the other instructions of their block.
+type line struct {
+	text  string
+	cells []string // code, side, delay and comment of an instruction
+	blank bool     // a blank line, which also ends an alignment block
+}
+
+type formatter struct {
+	src      string
+	srcLines []string
+	comments []asm.Token
+	lines    []line
+}
+
+func (f *formatter) format(file *asm.File) string {
+	stmts := file.Statements
+	prevEnd := 0 // line of the end of the previous statement
+	for i, stmt := range stmts {
+		start := stmt.Pos().Line
+		if i > 0 && f.blankBetween(prevEnd, start) {
+			f.lines = append(f.lines, line{blank: true})
+		}
+		switch s := stmt.(type) {
+		case *asm.DirectiveStmt:
+			if s.Name == ".program" && len(f.lines) > 0 && !f.lines[len(f.lines)-1].blank && !isComment(stmts[i-1]) {
+				f.lines = append(f.lines, line{blank: true})
+			}
+			f.lines = append(f.lines, line{text: f.directive(s)})
+		case *asm.LabelStmt:
+			text := s.Name + ":"
+			if s.Public {
+				text = "public " + text
+			}
+			// A comment after the label on its line stays there.
+			if i+1 < len(stmts) {
+				if c, ok := stmts[i+1].(*asm.CommentStmt); ok && c.Span.Start.Line == s.Span.End.Line {
+					text += " " + f.text(c.Span)
+				}
+			}
+			f.lines = append(f.lines, line{text: text})
+		case *asm.InstructionStmt:
+			f.lines = append(f.lines, line{cells: f.instruction(s)})
+		case *asm.CommentStmt:
+			if i > 0 {
+				if l, ok := stmts[i-1].(*asm.LabelStmt); ok && l.Span.End.Line == start {
+					break // written with the label
+				}
+			}
+			indent := ""
+			if next := nextCode(stmts[i+1:]); next != nil {
+				if _, ok := next.(*asm.InstructionStmt); ok {
+					indent = Indent
+				}
+			}
+			f.lines = append(f.lines, line{text: indent + f.text(s.Span)})
+		case *asm.CodeBlockStmt:
+			f.lines = append(f.lines, line{text: f.text(s.Span)})
+		}
+		prevEnd = f.endLine(stmt)
+	}
+	return f.render()
+}
+
+// blankBetween reports whether a blank line separates source lines a and
+// b (1-based).
+func (f *formatter) blankBetween(a, b int) bool {
+	for n := a + 1; n < b && n <= len(f.srcLines); n++ {
+		if strings.TrimSpace(f.srcLines[n-1]) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// endLine returns the last source line of a statement, including a
+// trailing comment.
+func (f *formatter) endLine(stmt asm.Statement) int {
+	var end asm.Pos
+	switch s := stmt.(type) {
+	case *asm.DirectiveStmt:
+		end = s.Span.End
+	case *asm.LabelStmt:
+		end = s.Span.End
+	case *asm.InstructionStmt:
+		end = s.Span.End
+	case *asm.CommentStmt:
+		return s.Span.End.Line
+	case *asm.CodeBlockStmt:
+		return s.Span.End.Line
+	}
+	if c, ok := f.trailingComment(end); ok {
+		return c.Span.End.Line
+	}
+	return end.Line
+}
+
+// trailingComment returns the comment token that follows end on its line.
+func (f *formatter) trailingComment(end asm.Pos) (asm.Token, bool) {
+	for _, c := range f.comments {
+		if c.Span.Start.Offset >= end.Offset && c.Span.Start.Line == end.Line {
+			return c, true
+		}
+	}
+	return asm.Token{}, false
+}
+
+// text returns the source of a span without trailing whitespace.
+func (f *formatter) text(s asm.Span) string {
+	return strings.TrimRight(f.src[s.Start.Offset:s.End.Offset], " \t\r")
+}
+
+func (f *formatter) directive(d *asm.DirectiveStmt) string {
+	text := d.Name
+	if d.Raw != "" {
+		text += " " + d.Raw
+	} else if len(d.Args) > 0 {
+		text += " " + f.operands(d.Args)
+	}
+	if c, ok := f.trailingComment(d.Span.End); ok {
+		text += " " + f.text(c.Span)
+	}
+	return text
+}
+
+func (f *formatter) instruction(inst *asm.InstructionStmt) []string {
+	cells := make([]string, 4)
+	cells[0] = inst.Op
+	if len(inst.Operands) > 0 {
+		cells[0] += " " + f.operands(inst.Operands)
+	}
+	if inst.Side != nil {
+		cells[1] = "side " + Operand(inst.Side.Text)
+	}
+	if inst.Delay != nil {
+		cells[2] = "[" + Operand(inst.Delay.Text) + "]"
+	}
+	if c, ok := f.trailingComment(inst.Span.End); ok {
+		cells[3] = f.text(c.Span)
+	}
+	return cells
+}
+
+// operands joins operands, keeping a comma where the source has one.
+func (f *formatter) operands(ops []*asm.Operand) string {
+	var b strings.Builder
+	for i, op := range ops {
+		if i > 0 {
+			if strings.Contains(f.src[ops[i-1].Span.End.Offset:op.Span.Start.Offset], ",") {
+				b.WriteString(",")
+			}
+			b.WriteString(" ")
+		}
+		b.WriteString(Operand(op.Text))
+	}
+	return b.String()
+}
+
+// Operand respaces an operand expression: binary +, -, * and / get a
+// space on each side while unary operators, --, != and brackets do not,
+// giving for example "x--", "!osre", "x!=y", "::osr" and "T1 - 1".
+func Operand(text string) string {
+	var b strings.Builder
+	prev := asm.EOF
+	for _, tok := range asm.Lex(text) {
+		switch tok.Kind {
+		case asm.EOF, asm.Newline:
+			continue
+		case asm.Plus, asm.Minus, asm.Star, asm.Slash:
+			if endsValue(prev) {
+				b.WriteString(" " + tok.Text + " ")
+				prev = tok.Kind
+				continue
+			}
+		}
+		if (tok.Kind == asm.Ident || tok.Kind == asm.Int) && (prev == asm.Ident || prev == asm.Int) {
+			b.WriteString(" ")
+		}
+		b.WriteString(tok.Text)
+		prev = tok.Kind
+	}
+	return b.String()
+}
+
+// endsValue reports whether a token can end an operand, making a
+// following + or - binary.
+func endsValue(k asm.TokenKind) bool {
+	switch k {
+	case asm.Ident, asm.Int, asm.RParen, asm.RBracket, asm.Decrement:
+		return true
+	}
+	return false
+}
+
+// render aligns the instruction cells of each block, a run of lines
+// between blank lines, and joins the lines.
+func (f *formatter) render() string {
+	var b strings.Builder
+	for start := 0; start < len(f.lines); {
+		end := start
+		for end < len(f.lines) && !f.lines[end].blank {
+			end++
+		}
+		f.renderBlock(&b, f.lines[start:end])
+		if end < len(f.lines) {
+			b.WriteString("\n")
+		}
+		start = end + 1
+	}
+	return b.String()
+}
+
+func (f *formatter) renderBlock(b *strings.Builder, lines []line) {
+	var widths [3]int
+	for _, l := range lines {
+		last := lastCell(l.cells)
+		for j := 0; j < last && j < len(widths); j++ {
+			widths[j] = max(widths[j], len(l.cells[j]))
+		}
+	}
+	for _, l := range lines {
+		if l.cells == nil {
+			b.WriteString(l.text + "\n")
+			continue
+		}
+		b.WriteString(Indent)
+		last := lastCell(l.cells)
+		for j := 0; j < last; j++ {
+			if widths[j] > 0 {
+				fmt.Fprintf(b, "%-*s ", widths[j], l.cells[j])
+			}
+		}
+		b.WriteString(l.cells[last] + "\n")
+	}
+}
+
+// lastCell returns the index of the last non-empty cell.
+func lastCell(cells []string) int {
+	last := 0
+	for j, c := range cells {
+		if c != "" {
+			last = j
+		}
+	}
+	return last
+}
+
+func isComment(s asm.Statement) bool {
+	_, ok := s.(*asm.CommentStmt)
+	return ok
+}
+
+// nextCode returns the first statement that is not a comment.
+func nextCode(stmts []asm.Statement) asm.Statement {
+	for _, s := range stmts {
+		if !isComment(s) {
+			return s
+		}
+	}
+	return nil
+}
+
+// sameProgram checks that formatted source parses and, when the
+// original assembles, assembles to the same programs.
+func sameProgram(src, out string) error {
+	file, err := asm.Parse(out)
+	if err != nil {
+		return fmt.Errorf("formatted source does not parse: %v", err)
+	}
+	want, err := assemble(src)
+	if err != nil {
+		return nil // nothing to compare with
+	}
+	got, err := asm.AssembleAll(file, asm.TargetRP2350)
+	if err != nil {
+		return fmt.Errorf("formatted source does not assemble: %v", err)
+	}
+	if len(got) != len(want) {
+		return errors.New("formatting changed the number of programs")
+	}
+	for i := range want {
+		if !reflect.DeepEqual(summary(want[i]), summary(got[i])) {
+			return fmt.Errorf("formatting changed the machine code of program %q", want[i].Name)
+		}
+	}
+	return nil
+}
+
+func assemble(src string) ([]*asm.Program, error) {
+	file, err := asm.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return asm.AssembleAll(file, asm.TargetRP2350)
+}
+
+// summary is the part of a program that formatting must preserve.
+func summary(p *asm.Program) []any {
+	return []any{p.Name, p.Instructions, p.Origin, p.WrapTarget, p.Wrap, p.SideSet, p.PIOVersion}
+}
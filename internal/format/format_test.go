@@ -0,0 +1,161 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+const messy = `; WS2812 driver
+
+
+.PROGRAM ws2812
+.side_set 1
+.define public T1 2
+.define T2 5
+.define T3 3
+.lang_opt python sideset_init = pico.PIO.OUT_HIGH
+.wrap_target
+bitloop:   ; the loop
+  OUT x,1   side 0 [T3-1] ; Side-set still takes place when instruction stalls
+    jmp !x do_zero    SIDE 1 [ T1 - 1 ] // Branch on the bit we shifted out.
+do_one: JMP  bitloop side 1 [T2-1]
+do_zero:
+        nop side 0 [T2 - 1]    ; Or drive low, for a short pulse
+.wrap
+.program other
+    mov isr , :: osr
+    mov x, ~ y
+    jmp x != y, 0
+    jmp x --, 0
+    set pins, 1 + 0x1e
+  /* a block
+     comment */
+    pull noblock
+
+
+% c-sdk {
+  static inline void foo() {}
+%}
+`
+
+const tidy = `; WS2812 driver
+
+.program ws2812
+.side_set 1
+.define public T1 2
+.define T2 5
+.define T3 3
+.lang_opt python sideset_init = pico.PIO.OUT_HIGH
+.wrap_target
+bitloop: ; the loop
+    out x, 1       side 0 [T3 - 1] ; Side-set still takes place when instruction stalls
+    jmp !x do_zero side 1 [T1 - 1] // Branch on the bit we shifted out.
+do_one:
+    jmp bitloop    side 1 [T2 - 1]
+do_zero:
+    nop            side 0 [T2 - 1] ; Or drive low, for a short pulse
+.wrap
+
+.program other
+    mov isr, ::osr
+    mov x, ~y
+    jmp x!=y, 0
+    jmp x--, 0
+    set pins, 1 + 0x1e
+    /* a block
+     comment */
+    pull noblock
+
+% c-sdk {
+  static inline void foo() {}
+%}
+`
+
+func TestSource(t *testing.T) {
+	got, err := Source(messy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != tidy {
+		t.Fatalf("unexpected layout:\n%s\nwant:\n%s", got, tidy)
+	}
+	again, err := Source(got)
+	if err != nil || again != got {
+		t.Fatalf("formatting is not idempotent (%v):\n%s", err, again)
+	}
+	if want, got := words(t, messy), words(t, tidy); !reflect.DeepEqual(want, got) {
+		t.Fatalf("formatting changed the machine code: %v -> %v", want, got)
+	}
+}
+
+func TestSource_Columns(t *testing.T) {
+	// Only instructions that have a later cell are padded, and empty
+	// columns take no space.
+	src := `.side_set 1 opt
+pull block
+out pins, 1 [3]
+set x, 31 side 1 ; count
+nop ; one
+
+jmp 0 side 0
+`
+	want := `.side_set 1 opt
+    pull block
+    out pins, 1        [3]
+    set x, 31   side 1 ; count
+    nop                ; one
+
+    jmp 0 side 0
+`
+	got, err := Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("unexpected layout:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSource_SyntaxError(t *testing.T) {
+	src := "set x, (1\n"
+	got, err := Source(src)
+	if _, ok := err.(asm.ErrorList); !ok || got != src {
+		t.Fatalf("expected the source back with a parse error, got %q, %v", got, err)
+	}
+}
+
+func TestOperand(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"x --", "x--"},
+		{"! osre", "!osre"},
+		{"x != y", "x!=y"},
+		{"T1-1", "T1 - 1"},
+		{"-1", "-1"},
+		{"(T1+2)*-3", "(T1 + 2) * -3"},
+		{"rxfifo [ y ]", "rxfifo[y]"},
+		{"jmppin+2", "jmppin + 2"},
+	} {
+		if got := Operand(tt.in); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func words(t *testing.T, src string) [][]uint16 {
+	t.Helper()
+	file, err := asm.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progs, err := asm.AssembleAll(file, asm.TargetRP2350)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var words [][]uint16
+	for _, p := range progs {
+		words = append(words, p.Instructions)
+	}
+	return words
+}
@@ -0,0 +1,153 @@
+package micropython
+
+import "strings"
+
+type tokenKind int
+
+const (
+	tName tokenKind = iota
+	tNumber
+	tString
+	tOp
+	tComment
+	tNewline
+)
+
+// token is a Python token. Columns are 0-based byte offsets in the line.
+type token struct {
+	kind     tokenKind
+	text     string
+	line     int
+	col      int
+	off, end int // byte range in the source
+}
+
+// twoCharOps are the operators lexed as one token.
+var twoCharOps = []string{"//", "**", "<<", ">>", "==", "!=", "<=", ">=", "->"}
+
+// lex splits Python source into tokens. A newline token ends each
+// logical line, so newlines inside brackets and escaped line ends are
+// skipped. Strings keep their quotes.
+func lex(src string) []token {
+	var toks []token
+	line, lineStart, depth := 1, 0, 0
+	emit := func(kind tokenKind, start, end int) {
+		toks = append(toks, token{kind: kind, text: src[start:end], line: line, col: start - lineStart, off: start, end: end})
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			if depth == 0 && len(toks) > 0 && toks[len(toks)-1].kind != tNewline {
+				emit(tNewline, i, i)
+			}
+			i++
+			line, lineStart = line+1, i
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			i += 2
+			line, lineStart = line+1, i
+		case c == '#':
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			emit(tComment, i, i+end)
+			i += end
+		case c == '"' || c == '\'':
+			start, startLine, startCol := i, line, i-lineStart
+			quote := src[i : i+1]
+			if strings.HasPrefix(src[i:], strings.Repeat(quote, 3)) {
+				quote = strings.Repeat(quote, 3)
+			}
+			i += len(quote)
+			for i < len(src) && !strings.HasPrefix(src[i:], quote) {
+				if src[i] == '\\' {
+					i++
+				} else if src[i] == '\n' {
+					if len(quote) == 1 {
+						break // unterminated
+					}
+					line, lineStart = line+1, i+1
+				}
+				i++
+			}
+			i = min(i+len(quote), len(src))
+			toks = append(toks, token{kind: tString, text: src[start:i], line: startLine, col: startCol, off: start, end: i})
+		case isDigit(c):
+			start := i
+			for i < len(src) && (isIdent(src[i]) || src[i] == '.') {
+				i++
+			}
+			emit(tNumber, start, i)
+		case isIdent(c):
+			start := i
+			for i < len(src) && isIdent(src[i]) {
+				i++
+			}
+			emit(tName, start, i)
+		default:
+			n := 1
+			for _, op := range twoCharOps {
+				if strings.HasPrefix(src[i:], op) {
+					n = 2
+				}
+			}
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				depth = max(0, depth-1)
+			}
+			emit(tOp, i, i+n)
+			i += n
+		}
+	}
+	if len(toks) > 0 && toks[len(toks)-1].kind != tNewline {
+		toks = append(toks, token{kind: tNewline, line: line, off: len(src), end: len(src)})
+	}
+	return toks
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isIdent(c byte) bool {
+	return c == '_' || isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+// stmt is a logical line: its code tokens and the text of its comments.
+type stmt struct {
+	line, indent int
+	toks         []token
+	comment      string
+}
+
+// statements groups tokens into logical lines. A line holding only a
+// comment has no tokens.
+func statements(toks []token) []stmt {
+	var stmts []stmt
+	var cur *stmt
+	for _, tok := range toks {
+		if tok.kind == tNewline {
+			if cur != nil {
+				stmts = append(stmts, *cur)
+			}
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			cur = &stmt{line: tok.line, indent: tok.col}
+		}
+		if tok.kind == tComment {
+			text := strings.TrimSpace(strings.TrimPrefix(tok.text, "#"))
+			if cur.comment != "" {
+				text = cur.comment + " " + text
+			}
+			cur.comment = text
+			continue
+		}
+		cur.toks = append(cur.toks, tok)
+	}
+	return stmts
+}
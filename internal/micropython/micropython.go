@@ -0,0 +1,801 @@
+// Package micropython converts the PIO programs of a MicroPython source,
+// functions decorated with @rp2.asm_pio, into PIO assembly for
+// internal/asm. Each function becomes a .program: its calls become
+// instructions, labels and wrap directives, sideset_init and side_pindir
+// give the .side_set directive, and the decorator arguments are kept as
+// .lang_opt python options. For RP2350, whose programs are PIO version 1,
+// the shift and pin count arguments become .in, .out and .set directives
+// instead. Module level integer constants the functions use become
+// .define directives.
+package micropython
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/format"
+)
+
+// Operand names of rp2.asm_pio calls and their PIO spelling.
+var (
+	jmpConds = map[string]string{"not_x": "!x", "x_dec": "x--", "not_y": "!y", "y_dec": "y--", "x_not_y": "x!=y", "pin": "pin", "not_osre": "!osre"}
+	waitSrcs = map[string]string{"gpio": "gpio", "pin": "pin", "irq": "irq"}
+	inSrcs   = map[string]string{"pins": "pins", "x": "x", "y": "y", "null": "null", "isr": "isr", "osr": "osr"}
+	outDests = map[string]string{"pins": "pins", "x": "x", "y": "y", "null": "null", "pindirs": "pindirs", "pc": "pc", "isr": "isr", "exec": "exec"}
+	movDests = map[string]string{"pins": "pins", "x": "x", "y": "y", "exec": "exec", "pc": "pc", "isr": "isr", "osr": "osr"}
+	movSrcs  = map[string]string{"pins": "pins", "x": "x", "y": "y", "null": "null", "status": "status", "isr": "isr", "osr": "osr"}
+	setDests = map[string]string{"pins": "pins", "x": "x", "y": "y", "pindirs": "pindirs"}
+	irqModes = map[string]string{"block": "wait", "noblock": "nowait", "clear": "clear"}
+)
+
+// Import returns PIO source for the @rp2.asm_pio functions of a
+// MicroPython source, in the canonical layout of internal/format, for
+// the given target. Errors are an asm.ErrorList with Python line
+// numbers.
+func Import(src string, target asm.Target) (string, error) {
+	im := &importer{used: make(map[string]bool), directives: target == asm.TargetRP2350}
+	stmts := statements(lex(src))
+	found := false
+	for i := 0; i < len(stmts); i++ {
+		s := stmts[i]
+		switch {
+		case isDecorator(s):
+			kwargs := im.decorator(s)
+			j := i + 1
+			for j < len(stmts) && (len(stmts[j].toks) == 0 || stmts[j].toks[0].text == "@") {
+				j++
+			}
+			if j == len(stmts) || !isDef(stmts[j]) {
+				im.errorf(s.toks[0], "@rp2.asm_pio must decorate a function")
+				continue
+			}
+			def := stmts[j]
+			end := j + 1
+			for end < len(stmts) && (len(stmts[end].toks) == 0 || stmts[end].indent > def.indent) {
+				end++
+			}
+			for end > j+1 && len(stmts[end-1].toks) == 0 && stmts[end-1].indent <= def.indent {
+				end--
+			}
+			im.function(def, kwargs, stmts[j+1:end])
+			found = true
+			i = end - 1
+		case s.indent == 0 && len(s.toks) > 2 && s.toks[0].kind == tName && s.toks[1].text == "=":
+			im.consts = append(im.consts, constant{name: s.toks[0].text, value: s.toks[2:]})
+		}
+	}
+	if !found && len(im.errs) == 0 {
+		im.errs = append(im.errs, &asm.Error{Msg: "no @rp2.asm_pio functions found"})
+	}
+	if len(im.errs) > 0 {
+		return "", im.errs
+	}
+
+	var b strings.Builder
+	b.WriteString(im.defines())
+	b.WriteString(im.programs.String())
+	out, err := format.Source(b.String())
+	if err != nil {
+		// The assembler reports the problem when the source is used.
+		return b.String(), nil
+	}
+	return out, nil
+}
+
+type importer struct {
+	consts     []constant
+	used       map[string]bool // names used in expressions
+	directives bool            // write .in, .out and .set directives
+	programs   strings.Builder
+	errs       asm.ErrorList
+}
+
+// constant is a module level assignment.
+type constant struct {
+	name  string
+	value []token
+}
+
+// kwarg is a decorator argument.
+type kwarg struct {
+	name  string
+	value []token
+}
+
+func (im *importer) errorf(tok token, format string, args ...any) {
+	im.errs = append(im.errs, &asm.Error{Line: tok.line, Col: tok.col + 1, Msg: fmt.Sprintf(format, args...)})
+}
+
+func isDecorator(s stmt) bool {
+	if len(s.toks) < 2 || s.toks[0].text != "@" {
+		return false
+	}
+	// @asm_pio or @rp2.asm_pio, with or without arguments.
+	for _, tok := range s.toks[1:] {
+		if tok.text == "(" {
+			break
+		}
+		if tok.text == "asm_pio" {
+			return true
+		}
+	}
+	return false
+}
+
+func isDef(s stmt) bool {
+	return len(s.toks) > 1 && s.toks[0].text == "def" && s.toks[1].kind == tName
+}
+
+// decorator returns the keyword arguments of an @rp2.asm_pio line.
+func (im *importer) decorator(s stmt) []kwarg {
+	open := slices.IndexFunc(s.toks, func(t token) bool { return t.text == "(" })
+	if open < 0 {
+		return nil
+	}
+	args, _ := splitArgs(s.toks, open)
+	var kwargs []kwarg
+	for _, arg := range args {
+		if len(arg) < 3 || arg[0].kind != tName || arg[1].text != "=" {
+			im.errorf(s.toks[open], "rp2.asm_pio takes keyword arguments only")
+			continue
+		}
+		kwargs = append(kwargs, kwarg{name: arg[0].text, value: arg[2:]})
+	}
+	return kwargs
+}
+
+// splitArgs splits the bracketed argument list opening at toks[open] at
+// its top level commas, and returns the index after the closing bracket.
+func splitArgs(toks []token, open int) ([][]token, int) {
+	var args [][]token
+	depth, start := 0, open+1
+	for i := open; i < len(toks); i++ {
+		switch toks[i].text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				if i > start {
+					args = append(args, toks[start:i])
+				}
+				return args, i + 1
+			}
+		case ",":
+			if depth == 1 {
+				args = append(args, toks[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if len(toks) > start {
+		args = append(args, toks[start:])
+	}
+	return args, len(toks)
+}
+
+// line is a translated line of a function body.
+type line struct {
+	text  string
+	instr bool // an instruction, as MicroPython counts them
+	side  bool // sets side
+	// The side and delay expressions of a word, which are added to its
+	// value once the side-set configuration is known.
+	wordSide, wordDelay string
+}
+
+// function writes the .program for one decorated function.
+func (im *importer) function(def stmt, kwargs []kwarg, body []stmt) {
+	var lines []line
+	instrs, sides := 0, 0
+	for _, s := range body {
+		l := im.statement(s)
+		if l.instr {
+			instrs++
+		}
+		if l.side {
+			sides++
+		}
+		if s.comment != "" {
+			l.text = strings.TrimSpace(l.text + " ; " + s.comment)
+		}
+		if l.text != "" {
+			lines = append(lines, l)
+		}
+	}
+
+	fmt.Fprintf(&im.programs, "\n.program %s\n", def.toks[1].text)
+	pindirs := false
+	for _, kw := range kwargs {
+		if kw.name == "side_pindir" {
+			pindirs = source(kw.value) == "True" || source(kw.value) == "1"
+		}
+	}
+	// MicroPython makes side-set optional when an instruction has no
+	// side.
+	bits, opt := 0, sides < instrs
+	for _, kw := range kwargs {
+		if kw.name != "sideset_init" || source(kw.value) == "None" {
+			continue
+		}
+		n, ok := pinCount(kw.value)
+		if !ok {
+			im.errorf(kw.value[0], "cannot count the pins of sideset_init %s", source(kw.value))
+			continue
+		}
+		bits = n
+		fmt.Fprintf(&im.programs, ".side_set %d", n)
+		if opt {
+			im.programs.WriteString(" opt")
+		}
+		if pindirs {
+			im.programs.WriteString(" pindirs")
+		}
+		im.programs.WriteString("\n")
+	}
+	if im.directives {
+		kwargs = im.configDirectives(kwargs)
+	}
+	for _, kw := range kwargs {
+		fmt.Fprintf(&im.programs, ".lang_opt python %s = %s\n", kw.name, source(kw.value))
+	}
+	for _, l := range lines {
+		text := l.text
+		if l.wordSide != "" || l.wordDelay != "" {
+			// Place side and delay in bits 12:8 as MicroPython does.
+			value, comment, _ := strings.Cut(text, " ; ")
+			value = ".word (" + strings.TrimPrefix(value, ".word ") + ")"
+			if l.wordSide != "" {
+				total := bits
+				if opt {
+					total++
+					value += " + 0x1000"
+				}
+				value += fmt.Sprintf(" + (%s) * %d", l.wordSide, 1<<(13-total))
+			}
+			if l.wordDelay != "" {
+				value += fmt.Sprintf(" + (%s) * 256", l.wordDelay)
+			}
+			text = value
+			if comment != "" {
+				text += " ; " + comment
+			}
+		}
+		im.programs.WriteString(text + "\n")
+	}
+}
+
+// configDirectives writes the .in, .out and .set directives of the
+// decorator arguments that configure them and returns the arguments left
+// as .lang_opt python options: those of a directive whose arguments are
+// not all plain values, and *_init arguments with levels other than the
+// OUT_LOW that the python output writes. MicroPython shifts left by
+// default and has no in pin count, so .in counts all 32 pins.
+func (im *importer) configDirectives(kwargs []kwarg) []kwarg {
+	args := make(map[string][]token)
+	for _, kw := range kwargs {
+		args[kw.name] = kw.value
+	}
+	done := make(map[string]bool)
+	// shift writes .in or .out from its count and the arguments named
+	// dir, auto and thresh, when the count comes from init or any of those
+	// are given.
+	shift := func(directive, init string, count int, dir, auto, thresh string) {
+		if args[init] == nil && args[dir] == nil && args[auto] == nil && args[thresh] == nil {
+			return
+		}
+		parts := []string{directive, strconv.Itoa(count), "left"}
+		if v := args[dir]; v != nil {
+			right, ok := shiftRight(v)
+			if !ok {
+				return
+			}
+			if right {
+				parts[2] = "right"
+			}
+		}
+		if v := args[auto]; v != nil {
+			on, ok := boolValue(v)
+			if !ok {
+				return
+			}
+			if on {
+				parts = append(parts, "auto")
+			}
+		}
+		if v := args[thresh]; v != nil {
+			if len(v) != 1 || v[0].kind != tNumber {
+				return
+			}
+			parts = append(parts, im.expr(v[0], v))
+		}
+		fmt.Fprintln(&im.programs, strings.Join(parts, " "))
+		done[dir], done[auto], done[thresh] = true, true, true
+		done[init] = lowPins(args[init], count)
+	}
+
+	shift(".in", "", 32, "in_shiftdir", "autopush", "push_thresh")
+	outCount, ok := 0, true
+	if v := args["out_init"]; v != nil {
+		outCount, ok = pinCount(v)
+	}
+	if ok {
+		shift(".out", "out_init", outCount, "out_shiftdir", "autopull", "pull_thresh")
+	}
+	if v := args["set_init"]; v != nil {
+		if n, ok := pinCount(v); ok {
+			fmt.Fprintf(&im.programs, ".set %d\n", n)
+			done["set_init"] = lowPins(v, n)
+		}
+	}
+
+	var rest []kwarg
+	for _, kw := range kwargs {
+		if !done[kw.name] {
+			rest = append(rest, kw)
+		}
+	}
+	return rest
+}
+
+// shiftRight reads an in_shiftdir or out_shiftdir value.
+func shiftRight(toks []token) (right, ok bool) {
+	switch s := source(toks); s[strings.LastIndex(s, ".")+1:] {
+	case "SHIFT_LEFT", "0":
+		return false, true
+	case "SHIFT_RIGHT", "1":
+		return true, true
+	}
+	return false, false
+}
+
+// boolValue reads an autopush or autopull value.
+func boolValue(toks []token) (value, ok bool) {
+	switch source(toks) {
+	case "False", "0":
+		return false, true
+	case "True", "1":
+		return true, true
+	}
+	return false, false
+}
+
+// lowPins reports whether a *_init argument is missing or is the n low
+// pins the python output writes.
+func lowPins(toks []token, n int) bool {
+	switch source(toks) {
+	case "":
+		return true
+	case "rp2.PIO.OUT_LOW":
+		return n == 1
+	case fmt.Sprintf("(rp2.PIO.OUT_LOW,) * %d", n):
+		return true
+	}
+	return false
+}
+
+// statement translates one line of a function body.
+func (im *importer) statement(s stmt) line {
+	toks := s.toks
+	switch {
+	case len(toks) == 0:
+		return line{}
+	case len(toks) == 1 && toks[0].text == "pass":
+		return line{}
+	case len(toks) == 1 && toks[0].kind == tString:
+		// A docstring.
+		var lines []string
+		for _, l := range strings.Split(unquote(toks[0].text), "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				lines = append(lines, "; "+l)
+			}
+		}
+		return line{text: strings.Join(lines, "\n")}
+	case len(toks) > 2 && toks[0].kind == tName && toks[1].text == "=":
+		return line{text: fmt.Sprintf(".define %s %s", toks[0].text, im.expr(toks[1], toks[2:]))}
+	}
+
+	name, args, next, ok := im.call(toks, 0)
+	if !ok {
+		return line{}
+	}
+	var side, delay string
+	sides, delays := 0, 0
+	for next < len(toks) {
+		tok := toks[next]
+		switch {
+		case tok.text == "." && next+1 < len(toks) && (toks[next+1].text == "side" || toks[next+1].text == "delay"):
+			mod, margs, n, ok := im.call(toks, next+1)
+			if !ok {
+				return line{}
+			}
+			if len(margs) != 1 {
+				im.errorf(toks[next+1], "%s takes one argument", mod)
+				return line{}
+			}
+			if mod == "side" {
+				sides++
+				side = im.expr(toks[next+1], margs[0])
+			} else {
+				delays++
+				delay = im.expr(toks[next+1], margs[0])
+			}
+			next = n
+		case tok.text == "[":
+			margs, n := splitArgs(toks, next)
+			if len(margs) != 1 {
+				im.errorf(tok, "a delay takes one value")
+				return line{}
+			}
+			delays++
+			delay = im.expr(tok, margs[0])
+			next = n
+		default:
+			im.errorf(tok, "unexpected %s", tok.text)
+			return line{}
+		}
+	}
+	if sides > 1 {
+		im.errorf(toks[0], "side is set more than once")
+	}
+	if delays > 1 {
+		im.errorf(toks[0], "delay is set more than once")
+	}
+
+	text, instr := im.instruction(toks[0], name, args)
+	if text == "" {
+		return line{}
+	}
+	l := line{text: text, instr: instr, side: side != ""}
+	switch {
+	case side == "" && delay == "":
+	case !instr:
+		im.errorf(toks[0], "%s cannot have side or delay", name)
+		return line{}
+	case name == "word":
+		l.wordSide, l.wordDelay = side, delay
+	default:
+		if side != "" {
+			l.text += " side " + side
+		}
+		if delay != "" {
+			l.text += " [" + delay + "]"
+		}
+	}
+	return l
+}
+
+// call parses name(args) at toks[i] and returns the index after it.
+func (im *importer) call(toks []token, i int) (name string, args [][]token, next int, ok bool) {
+	if toks[i].kind != tName || i+1 >= len(toks) || toks[i+1].text != "(" {
+		im.errorf(toks[i], "expected a call, found %s", toks[i].text)
+		return "", nil, 0, false
+	}
+	args, next = splitArgs(toks, i+1)
+	for _, arg := range args {
+		if len(arg) == 0 {
+			im.errorf(toks[i], "missing argument to %s", toks[i].text)
+			return "", nil, 0, false
+		}
+		if len(arg) > 1 && arg[0].kind == tName && arg[1].text == "=" {
+			im.errorf(arg[0], "keyword arguments are not supported")
+			return "", nil, 0, false
+		}
+	}
+	return toks[i].text, args, next, true
+}
+
+// instruction translates a call to a PIO instruction or directive, and
+// reports whether it is an instruction.
+func (im *importer) instruction(at token, name string, args [][]token) (string, bool) {
+	nargs := func(min, max int) bool {
+		if len(args) < min || len(args) > max {
+			if min == max {
+				im.errorf(at, "%s takes %d arguments", name, min)
+			} else {
+				im.errorf(at, "%s takes %d to %d arguments", name, min, max)
+			}
+			return false
+		}
+		return true
+	}
+	switch name {
+	case "wrap_target", "wrap":
+		if nargs(0, 0) {
+			return "." + name, false
+		}
+	case "label":
+		if nargs(1, 1) {
+			if label, ok := im.label(args[0]); ok {
+				return label + ":", false
+			}
+		}
+	case "nop":
+		if nargs(0, 0) {
+			return "nop", true
+		}
+	case "jmp":
+		if !nargs(1, 2) {
+			break
+		}
+		target, ok := im.label(args[len(args)-1])
+		if !ok {
+			break
+		}
+		if len(args) == 1 {
+			return "jmp " + target, true
+		}
+		if cond, ok := im.operand(args[0], jmpConds, "jmp condition"); ok {
+			return "jmp " + cond + ", " + target, true
+		}
+	case "wait":
+		if !nargs(3, 3) {
+			break
+		}
+		src, ok := im.operand(args[1], waitSrcs, "wait source")
+		if !ok {
+			break
+		}
+		index := im.index(at, args[2])
+		if strings.HasSuffix(index, " rel") && src != "irq" {
+			im.errorf(at, "rel only applies to irq")
+		}
+		return fmt.Sprintf("wait %s %s %s", im.expr(at, args[0]), src, index), true
+	case "in_", "out":
+		if !nargs(2, 2) {
+			break
+		}
+		op, names := "in", inSrcs
+		if name == "out" {
+			op, names = "out", outDests
+		}
+		if reg, ok := im.operand(args[0], names, op+" operand"); ok {
+			return fmt.Sprintf("%s %s, %s", op, reg, im.expr(at, args[1])), true
+		}
+	case "push", "pull":
+		if !nargs(0, 2) {
+			break
+		}
+		text := name
+		for _, arg := range args {
+			mode, ok := im.operand(arg, map[string]string{"iffull": "iffull", "ifempty": "ifempty", "block": "block", "noblock": "noblock"}, name+" option")
+			if !ok {
+				return "", false
+			}
+			if mode == "iffull" || mode == "ifempty" {
+				mode = map[string]string{"push": "iffull", "pull": "ifempty"}[name]
+			}
+			text += " " + mode
+		}
+		return text, true
+	case "mov":
+		if !nargs(2, 2) {
+			break
+		}
+		dst, ok := im.operand(args[0], movDests, "mov destination")
+		if !ok {
+			break
+		}
+		op, src := "", args[1]
+		if len(src) > 3 && src[0].kind == tName && src[1].text == "(" {
+			switch src[0].text {
+			case "invert":
+				op = "~"
+			case "reverse":
+				op = "::"
+			default:
+				im.errorf(src[0], "unknown mov operation %s", src[0].text)
+				return "", false
+			}
+			inner, _ := splitArgs(src, 1)
+			if len(inner) != 1 {
+				im.errorf(src[0], "%s takes one argument", src[0].text)
+				return "", false
+			}
+			src = inner[0]
+		}
+		if reg, ok := im.operand(src, movSrcs, "mov source"); ok {
+			return fmt.Sprintf("mov %s, %s%s", dst, op, reg), true
+		}
+	case "irq":
+		if !nargs(1, 2) {
+			break
+		}
+		text := "irq "
+		if len(args) == 2 {
+			mode, ok := im.operand(args[0], irqModes, "irq mode")
+			if !ok {
+				break
+			}
+			text += mode + " "
+		}
+		return text + im.index(at, args[len(args)-1]), true
+	case "set":
+		if !nargs(2, 2) {
+			break
+		}
+		if dst, ok := im.operand(args[0], setDests, "set destination"); ok {
+			return fmt.Sprintf("set %s, %s", dst, im.expr(at, args[1])), true
+		}
+	case "word":
+		if len(args) == 2 {
+			im.errorf(at, "word with a label is not supported")
+			break
+		}
+		if nargs(1, 1) {
+			return ".word " + im.expr(at, args[0]), true
+		}
+	default:
+		im.errorf(at, "unknown rp2.asm_pio function %s", name)
+	}
+	return "", false
+}
+
+// label returns the jump target or label name of a string argument, or
+// the expression of a numeric one.
+func (im *importer) label(arg []token) (string, bool) {
+	if len(arg) == 1 && arg[0].kind == tString {
+		return unquote(arg[0].text), true
+	}
+	if len(arg) > 0 && arg[0].kind == tString {
+		im.errorf(arg[0], "unexpected %s after label", arg[1].text)
+		return "", false
+	}
+	return im.expr(arg[0], arg), true
+}
+
+// index returns an irq or wait index, where rel(n) becomes "n rel".
+func (im *importer) index(at token, arg []token) string {
+	if len(arg) > 3 && arg[0].text == "rel" && arg[1].text == "(" {
+		inner, _ := splitArgs(arg, 1)
+		if len(inner) == 1 {
+			return im.expr(at, inner[0]) + " rel"
+		}
+	}
+	return im.expr(at, arg)
+}
+
+// operand returns the PIO spelling of a name argument.
+func (im *importer) operand(arg []token, names map[string]string, what string) (string, bool) {
+	if len(arg) == 1 && arg[0].kind == tName {
+		if s, ok := names[arg[0].text]; ok {
+			return s, true
+		}
+	}
+	keys := make([]string, 0, len(names))
+	for k := range names {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	im.errorf(arg[0], "unknown %s %s (want %s)", what, source(arg), strings.Join(keys, ", "))
+	return "", false
+}
+
+// expr converts a Python integer expression to a PIO expression,
+// recording the names it uses.
+func (im *importer) expr(at token, toks []token) string {
+	if len(toks) == 0 {
+		im.errorf(at, "missing value")
+		return "0"
+	}
+	var parts []string
+	for i, tok := range toks {
+		switch tok.kind {
+		case tName:
+			if i+1 < len(toks) && toks[i+1].text == "." {
+				im.errorf(tok, "attribute %s is not supported in PIO expressions", source(toks[i:]))
+				return "0"
+			}
+			im.used[tok.text] = true
+			parts = append(parts, tok.text)
+		case tNumber:
+			text := strings.ReplaceAll(tok.text, "_", "")
+			n, err := strconv.ParseInt(text, 0, 64)
+			if err != nil {
+				im.errorf(tok, "%s is not an integer", tok.text)
+				return "0"
+			}
+			if lower := strings.ToLower(text); !strings.HasPrefix(lower, "0x") && !strings.HasPrefix(lower, "0b") {
+				text = strconv.FormatInt(n, 10)
+			}
+			parts = append(parts, text)
+		case tOp:
+			switch tok.text {
+			case "+", "-", "*", "(", ")":
+				parts = append(parts, tok.text)
+			case "//":
+				parts = append(parts, "/")
+			default:
+				im.errorf(tok, "operator %s is not supported in PIO expressions", tok.text)
+				return "0"
+			}
+		default:
+			im.errorf(tok, "unexpected %s in expression", tok.text)
+			return "0"
+		}
+	}
+	return format.Operand(strings.Join(parts, " "))
+}
+
+// defines returns .define directives for the module constants used by
+// the functions, and the constants those use, in source order.
+func (im *importer) defines() string {
+	var b strings.Builder
+	for i := len(im.consts) - 1; i >= 0; i-- {
+		c := im.consts[i]
+		if !im.used[c.name] {
+			continue
+		}
+		// Mark the names of the value as used before earlier constants
+		// are visited.
+		save := len(im.errs)
+		im.expr(c.value[0], c.value)
+		im.errs = im.errs[:save]
+	}
+	for _, c := range im.consts {
+		if !im.used[c.name] {
+			continue
+		}
+		save := len(im.errs)
+		value := im.expr(c.value[0], c.value)
+		if len(im.errs) > save {
+			// Not an integer constant, so leave it undefined.
+			im.errs = im.errs[:save]
+			continue
+		}
+		fmt.Fprintf(&b, ".define %s %s\n", c.name, value)
+	}
+	return b.String()
+}
+
+// pinCount returns the number of pins of a *_init argument: a single
+// value, a tuple or list, or a tuple repeated with * n.
+func pinCount(toks []token) (int, bool) {
+	if len(toks) == 0 {
+		return 0, false
+	}
+	if toks[0].text != "(" && toks[0].text != "[" {
+		for _, tok := range toks {
+			if tok.text == "," || tok.text == "*" {
+				return 0, false
+			}
+		}
+		return 1, true
+	}
+	elems, next := splitArgs(toks, 0)
+	n := len(elems)
+	switch {
+	case next == len(toks):
+		return n, n > 0
+	case next+2 == len(toks) && toks[next].text == "*" && toks[next+1].kind == tNumber:
+		times, err := strconv.Atoi(toks[next+1].text)
+		return n * times, err == nil && n*times > 0
+	}
+	return 0, false
+}
+
+// source returns the text of tokens as written, with line breaks
+// collapsed.
+func source(toks []token) string {
+	var b strings.Builder
+	for i, tok := range toks {
+		if i > 0 && tok.off > toks[i-1].end {
+			b.WriteString(" ")
+		}
+		b.WriteString(tok.text)
+	}
+	return b.String()
+}
+
+// unquote returns the contents of a Python string literal.
+func unquote(s string) string {
+	for _, q := range []string{`"""`, `'''`, `"`, `'`} {
+		if len(s) >= 2*len(q) && strings.HasPrefix(s, q) && strings.HasSuffix(s, q) {
+			return s[len(q) : len(s)-len(q)]
+		}
+	}
+	return s
+}
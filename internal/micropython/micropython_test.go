@@ -0,0 +1,216 @@
+package micropython
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+const ws2812 = `import rp2
+from machine import Pin
+
+T1 = 2
+T2 = 5
+T3 = 3
+UNUSED = 1.5
+
+@rp2.asm_pio(sideset_init=rp2.PIO.OUT_LOW, out_shiftdir=rp2.PIO.SHIFT_LEFT,
+             autopull=True, pull_thresh=24)
+def ws2812():
+    """WS2812 bit timing."""
+    wrap_target()
+    label("bitloop")
+    out(x, 1)               .side(0)    [T3 - 1]
+    jmp(not_x, "do_zero")   .side(1)    [T1 - 1]
+    jmp("bitloop")          .side(1)    [T2 - 1]
+    label("do_zero")
+    nop()                   .side(0)    [T2 - 1]
+    wrap()
+
+@asm_pio(set_init=rp2.PIO.OUT_LOW)
+def blink():
+    # Cycles: 1 + 7 + 32 * (30 + 1) = 1000
+    set(pins, 1)
+    set(x, 31)                  [6]
+    label("delay_high")
+    nop()                       [29]
+    jmp(x_dec, "delay_high")
+    set(pins, 0).delay(0)       # low
+    irq(block, rel(0))
+
+sm = rp2.StateMachine(0, ws2812, freq=8_000_000, sideset_base=Pin(22))
+`
+
+const ws2812Want = `.define T1 2
+.define T2 5
+.define T3 3
+
+.program ws2812
+.side_set 1
+.lang_opt python sideset_init = rp2.PIO.OUT_LOW
+.lang_opt python out_shiftdir = rp2.PIO.SHIFT_LEFT
+.lang_opt python autopull = True
+.lang_opt python pull_thresh = 24
+; WS2812 bit timing.
+.wrap_target
+bitloop:
+    out x, 1        side 0 [T3 - 1]
+    jmp !x, do_zero side 1 [T1 - 1]
+    jmp bitloop     side 1 [T2 - 1]
+do_zero:
+    nop             side 0 [T2 - 1]
+.wrap
+
+.program blink
+.lang_opt python set_init = rp2.PIO.OUT_LOW
+    ; Cycles: 1 + 7 + 32 * (30 + 1) = 1000
+    set pins, 1
+    set x, 31   [6]
+delay_high:
+    nop         [29]
+    jmp x--, delay_high
+    set pins, 0 [0] ; low
+    irq wait 0 rel
+`
+
+func TestImport(t *testing.T) {
+	got, err := Import(ws2812, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != ws2812Want {
+		t.Fatalf("unexpected source:\n%s\nwant:\n%s", got, ws2812Want)
+	}
+	progs := assemble(t, got)
+	if want := []uint16{0x6221, 0x1123, 0x1400, 0xa442}; !reflect.DeepEqual(progs[0].Instructions, want) {
+		t.Fatalf("ws2812: expected %04x, got %04x", want, progs[0].Instructions)
+	}
+}
+
+func TestImport_SideSet(t *testing.T) {
+	// Side-set is optional when an instruction has no side, and words
+	// get their side and delay added as MicroPython does.
+	src := `@rp2.asm_pio(sideset_init=(rp2.PIO.OUT_LOW, rp2.PIO.OUT_HIGH), side_pindir=True)
+def p():
+    word(0xe001).side(2)[1]
+    nop()
+    pull(noblock)            .side(1)
+`
+	got, err := Import(src, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, ".side_set 2 opt pindirs\n") {
+		t.Fatalf("expected an optional side-set, got:\n%s", got)
+	}
+	progs := assemble(t, got)
+	if want := []uint16{0xf901, 0xa042, 0x9480}; !reflect.DeepEqual(progs[0].Instructions, want) {
+		t.Fatalf("expected %04x, got %04x", want, progs[0].Instructions)
+	}
+}
+
+func TestImport_Directives(t *testing.T) {
+	// For RP2350 the shift and pin count arguments become directives;
+	// set_init keeps its levels, and a shift direction the importer
+	// cannot read stays an option.
+	src := `@rp2.asm_pio(out_init=(rp2.PIO.OUT_LOW,) * 2, out_shiftdir=rp2.PIO.SHIFT_RIGHT, autopull=True, pull_thresh=24,
+             in_shiftdir=rp2.PIO.SHIFT_LEFT, push_thresh=8, set_init=rp2.PIO.OUT_HIGH)
+def p():
+    out(pins, 2)
+
+@rp2.asm_pio(in_shiftdir=DIR)
+def q():
+    in_(pins, 1)
+`
+	got, err := Import(src, asm.TargetRP2350)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		".in 32 left 8\n.out 2 right auto 24\n.set 1\n.lang_opt python set_init = rp2.PIO.OUT_HIGH\n",
+		".program q\n.lang_opt python in_shiftdir = DIR\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in:\n%s", want, got)
+		}
+	}
+	file, err := asm.Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progs, err := asm.AssembleAll(file, asm.TargetRP2350)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := progs[0].Out; out == nil || out.Count != 2 || !out.Right || !out.Auto || out.Threshold != 24 || progs[0].SetCount != 1 {
+		t.Fatalf("unexpected configuration %+v %+v", progs[0].Out, progs[0])
+	}
+
+	// RP2040 has no such directives, so the arguments stay options.
+	got, err = Import(src, asm.TargetRP2040)
+	if err != nil || strings.Contains(got, ".out") || !strings.Contains(got, ".lang_opt python pull_thresh = 24") {
+		t.Fatalf("expected options only (%v):\n%s", err, got)
+	}
+}
+
+func TestImport_Errors(t *testing.T) {
+	for _, tt := range []struct{ src, want string }{
+		{"x = 1\n", "no @rp2.asm_pio functions found"},
+		{"@rp2.asm_pio()\nx = 1\n", "line 1:1: @rp2.asm_pio must decorate a function"},
+		{"@rp2.asm_pio(1)\ndef p():\n    nop()\n", "line 1:13: rp2.asm_pio takes keyword arguments only"},
+		{"@rp2.asm_pio()\ndef p():\n    mov(x, swap(y))\n", "line 3:12: unknown mov operation swap"},
+		{"@rp2.asm_pio()\ndef p():\n    set(osr, 1)\n", "line 3:9: unknown set destination osr (want pindirs, pins, x, y)"},
+		{"@rp2.asm_pio()\ndef p():\n    out(x, 1 << 2)\n", "line 3:14: operator << is not supported in PIO expressions"},
+		{"@rp2.asm_pio()\ndef p():\n    label(\"a\").side(1)\n", "line 3:5: label cannot have side or delay"},
+		{"@rp2.asm_pio()\ndef p():\n    nop()[1][2]\n", "line 3:5: delay is set more than once"},
+		{"@rp2.asm_pio()\ndef p():\n    in_(x)\n", "line 3:5: in_ takes 2 arguments"},
+		{"@rp2.asm_pio()\ndef p():\n    push(x)\n", "unknown push option x"},
+		{"@rp2.asm_pio()\ndef p():\n    set(x, rp2.PIO.OUT_LOW)\n", "attribute rp2.PIO.OUT_LOW is not supported"},
+		{"@rp2.asm_pio()\ndef p():\n    blink()\n", "line 3:5: unknown rp2.asm_pio function blink"},
+		{"@rp2.asm_pio(sideset_init=pins)\ndef p():\n    nop().side(0)\n", ""},
+		{"@rp2.asm_pio(sideset_init=(a, b) * n)\ndef p():\n    nop()\n", "cannot count the pins of sideset_init (a, b) * n"},
+	} {
+		_, err := Import(tt.src, "")
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tt.src, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%q: expected error %q, got %v", tt.src, tt.want, err)
+		}
+	}
+}
+
+func TestPinCount(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want int
+	}{
+		{"rp2.PIO.OUT_LOW", 1},
+		{"(rp2.PIO.OUT_LOW, rp2.PIO.OUT_HIGH)", 2},
+		{"[rp2.PIO.OUT_LOW] * 4", 4},
+		{"(rp2.PIO.OUT_LOW,) * 3", 3},
+		{"(rp2.PIO.OUT_LOW,)", 1},
+		{"()", 0},
+	} {
+		toks := lex(tt.src)
+		got, ok := pinCount(toks[:len(toks)-1])
+		if got != tt.want || ok != (tt.want > 0) {
+			t.Errorf("%s: expected %d, got %d (%t)", tt.src, tt.want, got, ok)
+		}
+	}
+}
+
+func assemble(t *testing.T, src string) []*asm.Program {
+	t.Helper()
+	file, err := asm.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progs, err := asm.AssembleAll(file, asm.TargetRP2040)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return progs
+}
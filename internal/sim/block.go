@@ -0,0 +1,144 @@
+package sim
+
+import (
+	"fmt"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// NumStateMachines is the number of state machines in a PIO block.
+const NumStateMachines = 4
+
+// Block is a PIO block: four state machines sharing 32 words of
+// instruction memory, eight IRQ flags and the GPIOs. Enabled state
+// machines run in lockstep, one cycle each per Step, whatever their
+// clock dividers.
+//
+// Each cycle every state machine sees the pin levels and irq flags as
+// they were at the start of the cycle. When several state machines
+// write the same pin in one cycle the highest numbered one wins, and a
+// pin keeps the last level written to it.
+type Block struct {
+	mem     [asm.MaxInstructions]uint16
+	used    uint32 // instruction memory slots taken by loaded programs
+	irq     uint8
+	gpio    GPIO
+	sms     [NumStateMachines]*StateMachine
+	enabled [NumStateMachines]bool
+	cycle   int
+}
+
+// BlockState is a snapshot of a block after a clock cycle. SMs holds the
+// state of each enabled state machine and nil for the others; their
+// pins, pindirs and irq are those of the whole block after the cycle.
+type BlockState struct {
+	Cycle   int                      `json:"cycle"`
+	SMs     [NumStateMachines]*State `json:"sms"`
+	Pins    uint32                   `json:"pins"`
+	PinDirs uint32                   `json:"pindirs"`
+	IRQ     uint8                    `json:"irq"`
+}
+
+// NewBlock returns a block with empty instruction memory and every state
+// machine disabled.
+func NewBlock() *Block {
+	b := &Block{}
+	for i := range b.sms {
+		b.sms[i] = &StateMachine{num: i, mem: &b.mem, irq: &b.irq, gpio: &b.gpio}
+	}
+	return b
+}
+
+// AddProgram loads prog into instruction memory and returns its offset.
+// A program with an .origin is loaded there; otherwise it goes at
+// offset, or when offset is negative at the highest free address, as
+// the SDK's pio_add_program does.
+func (b *Block) AddProgram(prog *asm.Program, offset int) (int, error) {
+	n := len(prog.Instructions)
+	if prog.Origin >= 0 {
+		if offset >= 0 && offset != prog.Origin {
+			return 0, fmt.Errorf("program %s has .origin %d and cannot load at offset %d", prog.Name, prog.Origin, offset)
+		}
+		offset = prog.Origin
+	}
+	span := mask(n)
+	if offset < 0 {
+		for at := asm.MaxInstructions - n; at >= 0; at-- {
+			if b.used&(span<<at) == 0 {
+				offset = at
+				break
+			}
+		}
+		if offset < 0 {
+			return 0, fmt.Errorf("no room for program %s (%d instructions) in instruction memory", prog.Name, n)
+		}
+	}
+	if offset+n > asm.MaxInstructions {
+		return 0, fmt.Errorf("program %s of %d instructions does not fit at offset %d", prog.Name, n, offset)
+	}
+	if b.used&(span<<offset) != 0 {
+		return 0, fmt.Errorf("program %s at offset %d overlaps a loaded program", prog.Name, offset)
+	}
+	if err := Load(&b.mem, prog.Instructions, offset); err != nil {
+		return 0, err
+	}
+	b.used |= span << offset
+	return offset, nil
+}
+
+// SM returns state machine n, which may be used to fill and drain its
+// FIFOs.
+func (b *Block) SM(n int) *StateMachine { return b.sms[n] }
+
+// GPIO returns the pins shared by the block's state machines.
+func (b *Block) GPIO() *GPIO { return &b.gpio }
+
+// Start configures state machine n for the program loaded at offset,
+// restarts it and enables it. The config must pass Check.
+func (b *Block) Start(n, offset int, cfg Config) error {
+	if n < 0 || n >= NumStateMachines {
+		return fmt.Errorf("state machine %d out of range 0-%d", n, NumStateMachines-1)
+	}
+	if offset < 0 || offset >= asm.MaxInstructions || b.used>>offset&1 == 0 {
+		return fmt.Errorf("state machine %d: no program loaded at offset %d", n, offset)
+	}
+	if err := cfg.Check(); err != nil {
+		return fmt.Errorf("state machine %d: %w", n, err)
+	}
+	if offset+max(cfg.WrapTarget, cfg.Wrap) >= asm.MaxInstructions {
+		return fmt.Errorf("state machine %d: wrap past the end of instruction memory", n)
+	}
+	b.sms[n].Init(offset, cfg)
+	b.enabled[n] = true
+	return nil
+}
+
+// Step runs one clock cycle of every enabled state machine, in order of
+// their numbers, and returns the state after it.
+func (b *Block) Step() BlockState {
+	b.cycle++
+	inputs, flags := b.gpio.Level(), b.irq
+	st := BlockState{Cycle: b.cycle}
+	for i, sm := range b.sms {
+		if b.enabled[i] {
+			s := sm.step(inputs, flags)
+			st.SMs[i] = &s
+		}
+	}
+	st.Pins, st.PinDirs, st.IRQ = b.gpio.Out, b.gpio.Dir, b.irq
+	for _, s := range st.SMs {
+		if s != nil {
+			s.Pins, s.PinDirs, s.IRQ = st.Pins, st.PinDirs, st.IRQ
+		}
+	}
+	return st
+}
+
+// Run steps the block for n cycles and returns every state.
+func (b *Block) Run(n int) []BlockState {
+	trace := make([]BlockState, 0, n)
+	for range n {
+		trace = append(trace, b.Step())
+	}
+	return trace
+}
@@ -0,0 +1,127 @@
+package sim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// newBlock loads each source into a block and starts state machine i on
+// the i-th program with its default config and one set pin at set_base
+// i.
+func newBlock(t *testing.T, srcs ...string) *Block {
+	t.Helper()
+	b := NewBlock()
+	for i, src := range srcs {
+		prog, err := asm.Assemble(src)
+		if err != nil {
+			t.Fatalf("assemble program %d: %v", i, err)
+		}
+		offset, err := b.AddProgram(prog, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := DefaultConfig(prog)
+		cfg.SetBase, cfg.SetCount, cfg.OutCount = i, 1, 0
+		if err := b.Start(i, offset, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.GPIO().Dir = 0xf
+	return b
+}
+
+func TestBlock_IRQHandshake(t *testing.T) {
+	b := newBlock(t, `    irq wait 0
+    set pins, 1`, `    wait 1 irq 0
+    set pins, 1`)
+	trace := b.Run(4)
+	// Cycle 1: sm0 raises flag 0, which sm1 only sees next cycle.
+	if !trace[0].SMs[0].Stalled || !trace[0].SMs[1].Stalled || trace[0].IRQ != 1 {
+		t.Fatalf("cycle 1: expected both to stall with flag 0 set, got %+v %+v", *trace[0].SMs[0], *trace[0].SMs[1])
+	}
+	// Cycle 2: sm1 sees the flag and clears it; sm0 still saw it set.
+	if !trace[1].SMs[0].Stalled || trace[1].SMs[1].Stalled || trace[1].IRQ != 0 {
+		t.Fatalf("cycle 2: expected sm1 to clear the flag, got %+v", trace[1])
+	}
+	// Cycle 3: sm0's irq wait completes while sm1 sets its pin.
+	if trace[2].SMs[0].Stalled || trace[2].Pins != 0b10 {
+		t.Fatalf("cycle 3: expected sm0 to finish waiting and pin 1 high, got %+v", trace[2])
+	}
+	if trace[3].Pins != 0b11 {
+		t.Fatalf("cycle 4: expected both pins high, got %b", trace[3].Pins)
+	}
+	if trace[3].SMs[2] != nil {
+		t.Fatal("expected no state for a disabled state machine")
+	}
+}
+
+func TestBlock_RelativeIRQ(t *testing.T) {
+	b := newBlock(t, `    nop`, `    nop`, `    irq set 1 rel`)
+	if st := b.Step(); st.IRQ != 1<<3 {
+		t.Fatalf("expected sm2 irq 1 rel to set flag 3, got %08b", st.IRQ)
+	}
+}
+
+func TestBlock_OutputPriority(t *testing.T) {
+	b := newBlock(t, `    set pins, 1`, `    nop`, `    set pins, 0`)
+	b.SM(2).cfg.SetBase = 0 // sm2 drives sm0's pin
+	b.GPIO().Out = 1 << 2
+	st := b.Step()
+	if st.Pins != 1<<2 {
+		t.Fatalf("expected sm2 to win pin 0 and pin 2 to keep its level, got %b", st.Pins)
+	}
+	if st.SMs[0].Pins != st.Pins {
+		t.Fatalf("expected every state machine to report the block's pins, got %b", st.SMs[0].Pins)
+	}
+}
+
+func TestBlock_WaitGPIOAndPin(t *testing.T) {
+	b := newBlock(t, `    set pins, 1`, `    wait 1 gpio 0
+    wait 1 pin 2
+    set pins, 1`)
+	b.SM(1).cfg.InBase = 3 // pin 2 is GPIO 5
+	trace := b.Run(3)
+	if !trace[0].SMs[1].Stalled || trace[1].SMs[1].Stalled {
+		t.Fatalf("expected wait gpio to see sm0's pin one cycle later, got %+v %+v", *trace[0].SMs[1], *trace[1].SMs[1])
+	}
+	if !trace[2].SMs[1].Stalled {
+		t.Fatalf("expected wait pin to stall on GPIO 5, got %+v", *trace[2].SMs[1])
+	}
+	b.GPIO().Input = 1 << 5
+	if st := b.Step(); st.SMs[1].Stalled {
+		t.Fatalf("expected wait pin to finish once GPIO 5 is high, got %+v", *st.SMs[1])
+	}
+}
+
+func TestBlock_AddProgram(t *testing.T) {
+	b := NewBlock()
+	three := &asm.Program{Name: "three", Origin: -1, Instructions: make([]uint16, 3)}
+	if offset, err := b.AddProgram(three, -1); err != nil || offset != 29 {
+		t.Fatalf("expected the first program at the top of memory, got %d, %v", offset, err)
+	}
+	if offset, err := b.AddProgram(three, -1); err != nil || offset != 26 {
+		t.Fatalf("expected the second program below the first, got %d, %v", offset, err)
+	}
+	if _, err := b.AddProgram(three, 25); err == nil || !strings.Contains(err.Error(), "overlaps") {
+		t.Fatalf("expected overlap error, got %v", err)
+	}
+	fixed := &asm.Program{Name: "fixed", Origin: 4, Instructions: make([]uint16, 2)}
+	if _, err := b.AddProgram(fixed, 0); err == nil || !strings.Contains(err.Error(), ".origin 4") {
+		t.Fatalf("expected origin conflict error, got %v", err)
+	}
+	if offset, err := b.AddProgram(fixed, -1); err != nil || offset != 4 {
+		t.Fatalf("expected the program at its origin, got %d, %v", offset, err)
+	}
+	big := &asm.Program{Name: "big", Origin: -1, Instructions: make([]uint16, 24)}
+	if _, err := b.AddProgram(big, -1); err == nil || !strings.Contains(err.Error(), "no room") {
+		t.Fatalf("expected no room error, got %v", err)
+	}
+	if err := b.Start(0, 0, Config{}); err == nil {
+		t.Fatal("expected error starting a state machine at an empty address")
+	}
+	if err := b.Start(4, 4, Config{}); err == nil {
+		t.Fatal("expected error starting state machine 4")
+	}
+}
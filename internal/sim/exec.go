@@ -0,0 +1,351 @@
+package sim
+
+import "math/bits"
+
+// result is the outcome of executing one instruction.
+type result struct {
+	stall bool // the instruction must run again next cycle
+	jump  int  // new PC, or -1 to continue in sequence
+	exec  bool // the instruction queued another one with out/mov exec
+}
+
+var (
+	done  = result{jump: -1}
+	stall = result{stall: true, jump: -1}
+)
+
+func jumpTo(addr uint32) result {
+	return result{jump: int(addr % 32)}
+}
+
+// execute runs instr for one cycle. Field layouts follow the RP2040 and
+// RP2350 datasheets' instruction encoding tables.
+func (sm *StateMachine) execute(instr uint16) result {
+	arg1 := instr >> 5 & 7
+	arg2 := uint32(instr & 0x1f)
+	switch instr >> 13 {
+	case 0:
+		return sm.jmp(arg1, arg2)
+	case 1:
+		return sm.wait(instr>>7&1 == 1, arg1&3, arg2)
+	case 2:
+		return sm.in(arg1, bitCount(arg2))
+	case 3:
+		return sm.out(arg1, bitCount(arg2))
+	case 4:
+		switch {
+		case instr&0x10 != 0:
+			return sm.movRx(instr)
+		case instr&0x80 != 0:
+			return sm.pull(instr&0x40 != 0, instr&0x20 != 0)
+		}
+		return sm.push(instr&0x40 != 0, instr&0x20 != 0)
+	case 5:
+		return sm.mov(arg1, instr>>3&3, instr&7)
+	case 6:
+		return sm.irqOp(instr&0x40 != 0, instr&0x20 != 0, arg2)
+	}
+	return sm.set(arg1, arg2)
+}
+
+// bitCount decodes an in/out bit count, where 0 means 32.
+func bitCount(n uint32) int {
+	if n == 0 {
+		return 32
+	}
+	return int(n)
+}
+
+func mask(n int) uint32 {
+	if n >= 32 {
+		return ^uint32(0)
+	}
+	return 1<<n - 1
+}
+
+// pin returns the level of a GPIO.
+func (sm *StateMachine) pin(n int) bool {
+	return sm.inputs>>(uint(n)%32)&1 == 1
+}
+
+// inPins returns the GPIO levels rotated so that in_base is bit 0.
+func (sm *StateMachine) inPins() uint32 {
+	return bits.RotateLeft32(sm.inputs, -sm.cfg.InBase)
+}
+
+func (sm *StateMachine) jmp(cond uint16, addr uint32) result {
+	var take bool
+	switch cond {
+	case 0:
+		take = true
+	case 1:
+		take = sm.x == 0
+	case 2:
+		take = sm.x != 0
+		sm.x--
+	case 3:
+		take = sm.y == 0
+	case 4:
+		take = sm.y != 0
+		sm.y--
+	case 5:
+		take = sm.x != sm.y
+	case 6:
+		take = sm.pin(sm.cfg.JmpPin)
+	case 7:
+		take = sm.osrCount < sm.cfg.PullThreshold
+	}
+	if take {
+		return jumpTo(addr)
+	}
+	return done
+}
+
+func (sm *StateMachine) wait(pol bool, src uint16, idx uint32) result {
+	var level bool
+	switch src {
+	case 0:
+		level = sm.pin(int(idx))
+	case 1:
+		level = sm.pin(sm.cfg.InBase + int(idx))
+	case 2:
+		flag := sm.irqNumber(idx)
+		level = sm.flags>>flag&1 == 1
+		if level && pol {
+			*sm.irq &^= 1 << flag
+		}
+	case 3:
+		level = sm.pin(sm.cfg.JmpPin + int(idx&3))
+	}
+	if level != pol {
+		return stall
+	}
+	return done
+}
+
+// irqNumber resolves an irq index, applying rel to the state machine
+// number. prev and next address the neighbouring PIO blocks on RP2350,
+// which are not modelled, so they use this block's flags.
+func (sm *StateMachine) irqNumber(idx uint32) uint {
+	n := idx & 7
+	if idx>>3&3 == 2 {
+		n = n&4 | (n+uint32(sm.num))&3
+	}
+	return uint(n)
+}
+
+func (sm *StateMachine) in(src uint16, n int) result {
+	var data uint32
+	switch src {
+	case 0:
+		data = sm.inPins()
+	case 1:
+		data = sm.x
+	case 2:
+		data = sm.y
+	case 6:
+		data = sm.isr
+	case 7:
+		data = sm.osr
+	}
+	data &= mask(n)
+	isr := sm.isr<<n | data
+	if !sm.cfg.InShiftLeft {
+		isr = sm.isr>>n | data<<(32-n)
+	}
+	count := min(sm.isrCount+n, 32)
+	if sm.cfg.Autopush && count >= sm.cfg.PushThreshold {
+		if len(sm.rx) >= sm.RXDepth() {
+			return stall
+		}
+		sm.rx = append(sm.rx, isr)
+		isr, count = 0, 0
+	}
+	sm.isr, sm.isrCount = isr, count
+	return done
+}
+
+func (sm *StateMachine) out(dst uint16, n int) result {
+	if sm.cfg.Autopull && sm.osrCount >= sm.cfg.PullThreshold {
+		if len(sm.tx) == 0 {
+			return stall
+		}
+		sm.osr, sm.osrCount = sm.tx[0], 0
+		sm.tx = sm.tx[1:]
+	}
+	var data uint32
+	if sm.cfg.OutShiftLeft {
+		data = sm.osr >> (32 - n)
+		sm.osr <<= n
+	} else {
+		data = sm.osr & mask(n)
+		sm.osr >>= n
+	}
+	sm.osrCount = min(sm.osrCount+n, 32)
+	switch dst {
+	case 0:
+		write(&sm.gpio.Out, sm.cfg.OutBase, sm.cfg.OutCount, data)
+	case 1:
+		sm.x = data
+	case 2:
+		sm.y = data
+	case 4:
+		write(&sm.gpio.Dir, sm.cfg.OutBase, sm.cfg.OutCount, data)
+	case 5:
+		return jumpTo(data)
+	case 6:
+		sm.isr, sm.isrCount = data, n
+	case 7:
+		return sm.queue(data)
+	}
+	return done
+}
+
+// queue makes data the next instruction, for out exec and mov exec.
+func (sm *StateMachine) queue(data uint32) result {
+	sm.pending, sm.hasPending = uint16(data), true
+	return result{jump: -1, exec: true}
+}
+
+func (sm *StateMachine) push(ifFull, block bool) result {
+	if ifFull && sm.isrCount < sm.cfg.PushThreshold {
+		return done
+	}
+	if len(sm.rx) >= sm.RXDepth() {
+		if block {
+			return stall
+		}
+	} else {
+		sm.rx = append(sm.rx, sm.isr)
+	}
+	sm.isr, sm.isrCount = 0, 0
+	return done
+}
+
+func (sm *StateMachine) pull(ifEmpty, block bool) result {
+	full := sm.osrCount < sm.cfg.PullThreshold
+	if full && (ifEmpty || sm.cfg.Autopull) {
+		return done
+	}
+	if len(sm.tx) == 0 {
+		if block {
+			return stall
+		}
+		sm.osr = sm.x
+	} else {
+		sm.osr = sm.tx[0]
+		sm.tx = sm.tx[1:]
+	}
+	sm.osrCount = 0
+	return done
+}
+
+// movRx runs the RP2350 mov rxfifo[i], isr and mov osr, rxfifo[i], which
+// use the RX FIFO entries as random access storage.
+func (sm *StateMachine) movRx(instr uint16) result {
+	idx := sm.y & 3
+	if instr&0x08 != 0 {
+		idx = uint32(instr & 3)
+	}
+	if instr&0x80 != 0 {
+		sm.osr, sm.osrCount = sm.rxSlots[idx], 0
+	} else {
+		sm.rxSlots[idx] = sm.isr
+	}
+	return done
+}
+
+func (sm *StateMachine) mov(dst, op, src uint16) result {
+	var data uint32
+	switch src {
+	case 0:
+		data = sm.inPins()
+	case 1:
+		data = sm.x
+	case 2:
+		data = sm.y
+	case 5:
+		data = sm.status()
+	case 6:
+		data = sm.isr
+	case 7:
+		data = sm.osr
+	}
+	switch op {
+	case 1:
+		data = ^data
+	case 2:
+		data = bits.Reverse32(data)
+	}
+	switch dst {
+	case 0:
+		write(&sm.gpio.Out, sm.cfg.OutBase, sm.cfg.OutCount, data)
+	case 1:
+		sm.x = data
+	case 2:
+		sm.y = data
+	case 3:
+		write(&sm.gpio.Dir, sm.cfg.OutBase, sm.cfg.OutCount, data)
+	case 4:
+		return sm.queue(data)
+	case 5:
+		return jumpTo(data)
+	case 6:
+		sm.isr, sm.isrCount = data, 0
+	case 7:
+		sm.osr, sm.osrCount = data, 0
+	}
+	return done
+}
+
+// status is the value mov reads from status: all ones when the selected
+// FIFO level is below StatusN or the selected irq flag is set.
+func (sm *StateMachine) status() uint32 {
+	var ok bool
+	switch sm.cfg.StatusSel {
+	case "rxfifo":
+		ok = len(sm.rx) < sm.cfg.StatusN
+	case "irq":
+		ok = sm.flags>>(uint(sm.cfg.StatusN)&7)&1 == 1
+	default:
+		ok = len(sm.tx) < sm.cfg.StatusN
+	}
+	if ok {
+		return ^uint32(0)
+	}
+	return 0
+}
+
+func (sm *StateMachine) irqOp(clear, wait bool, idx uint32) result {
+	flag := sm.irqNumber(idx)
+	switch {
+	case clear:
+		*sm.irq &^= 1 << flag
+	case sm.irqWaiting:
+		if sm.flags>>flag&1 == 1 {
+			return stall
+		}
+		sm.irqWaiting = false
+	default:
+		*sm.irq |= 1 << flag
+		if wait {
+			sm.irqWaiting = true
+			return stall
+		}
+	}
+	return done
+}
+
+func (sm *StateMachine) set(dst uint16, data uint32) result {
+	switch dst {
+	case 0:
+		write(&sm.gpio.Out, sm.cfg.SetBase, sm.cfg.SetCount, data)
+	case 1:
+		sm.x = data
+	case 2:
+		sm.y = data
+	case 4:
+		write(&sm.gpio.Dir, sm.cfg.SetBase, sm.cfg.SetCount, data)
+	}
+	return done
+}
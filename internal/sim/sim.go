@@ -0,0 +1,476 @@
+// Package sim is a cycle-accurate model of an RP2040/RP2350 PIO state
+// machine. It executes assembled programs one state machine clock cycle
+// at a time, with the hardware's FIFO stalls, autopush and autopull,
+// delays, side-set and wrap behaviour. A Block runs up to four state
+// machines together on shared instruction memory, IRQ flags and GPIOs.
+package sim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// fifoDepth is the depth of each FIFO; joining gives one FIFO twice the
+// depth.
+const fifoDepth = 4
+
+// Config is the state machine configuration, mirroring the SDK's
+// sm_config. Wrap points are relative to the start of the program.
+type Config struct {
+	InBase        int         `json:"in_base"`
+	OutBase       int         `json:"out_base"`
+	OutCount      int         `json:"out_count"`
+	SetBase       int         `json:"set_base"`
+	SetCount      int         `json:"set_count"`
+	SideSetBase   int         `json:"sideset_base"`
+	SideSet       asm.SideSet `json:"side_set"`
+	JmpPin        int         `json:"jmp_pin"`
+	WrapTarget    int         `json:"wrap_target"`
+	Wrap          int         `json:"wrap"`
+	InShiftLeft   bool        `json:"in_shift_left,omitempty"`
+	OutShiftLeft  bool        `json:"out_shift_left,omitempty"`
+	Autopush      bool        `json:"autopush,omitempty"`
+	Autopull      bool        `json:"autopull,omitempty"`
+	PushThreshold int         `json:"push_threshold"`
+	PullThreshold int         `json:"pull_threshold"`
+	FifoJoin      string      `json:"fifo_join,omitempty"`  // "tx", "rx", or an RP2350 put/get mode
+	StatusSel     string      `json:"status_sel,omitempty"` // "txfifo" (default), "rxfifo" or "irq"
+	StatusN       int         `json:"status_n"`
+	ClockDiv      float64     `json:"clock_div"` // system clocks per state machine cycle
+}
+
+// DefaultConfig returns the SDK default configuration combined with the
+// settings the program declares: side-set, wrap, and on RP2350 the
+// .clock_div, .in, .out, .set, .fifo and .mov_status directives.
+func DefaultConfig(prog *asm.Program) Config {
+	cfg := Config{
+		OutCount:      32,
+		SetCount:      5,
+		SideSet:       prog.SideSet,
+		WrapTarget:    prog.WrapTarget,
+		Wrap:          prog.Wrap,
+		PushThreshold: 32,
+		PullThreshold: 32,
+		ClockDiv:      1,
+	}
+	if prog.ClockDiv > 0 {
+		cfg.ClockDiv = prog.ClockDiv
+	}
+	if in := prog.In; in != nil {
+		cfg.InShiftLeft, cfg.Autopush, cfg.PushThreshold = !in.Right, in.Auto, in.Threshold
+	}
+	if out := prog.Out; out != nil {
+		cfg.OutCount, cfg.OutShiftLeft = out.Count, !out.Right
+		cfg.Autopull, cfg.PullThreshold = out.Auto, out.Threshold
+	}
+	if prog.SetCount > 0 {
+		cfg.SetCount = prog.SetCount
+	}
+	if prog.Fifo != "txrx" {
+		cfg.FifoJoin = prog.Fifo
+	}
+	if f := strings.Fields(prog.MovStatus); len(f) > 0 {
+		cfg.StatusSel = f[0]
+		cfg.StatusN, _ = strconv.Atoi(f[len(f)-1])
+	}
+	return cfg
+}
+
+// Check reports a configuration the hardware cannot hold: side-set wider
+// than the 5 bits it shares with the delay, pins outside 0-31, pin counts
+// beyond the register widths and wrap points outside instruction memory.
+func (c Config) Check() error {
+	ss := c.SideSet
+	if ss.Bits < 0 || ss.TotalBits() > 5 {
+		return fmt.Errorf("side_set of %s does not fit in 5 bits", ss)
+	}
+	for _, f := range []struct {
+		name       string
+		value, max int
+	}{
+		{"in_base", c.InBase, 31},
+		{"out_base", c.OutBase, 31},
+		{"out_count", c.OutCount, 32},
+		{"set_base", c.SetBase, 31},
+		{"set_count", c.SetCount, 5},
+		{"sideset_base", c.SideSetBase, 31},
+		{"jmp_pin", c.JmpPin, 31},
+		{"wrap_target", c.WrapTarget, asm.MaxInstructions - 1},
+		{"wrap", c.Wrap, asm.MaxInstructions - 1},
+	} {
+		if f.value < 0 || f.value > f.max {
+			return fmt.Errorf("%s %d out of range 0-%d", f.name, f.value, f.max)
+		}
+	}
+	return nil
+}
+
+// GPIO is the pin state shared by the state machines of a PIO block.
+type GPIO struct {
+	Out   uint32 // levels driven by the state machines
+	Dir   uint32 // output enables, 1 is output
+	Input uint32 // levels driven onto the pins from outside
+}
+
+// Level returns the level of every pin: the driven level for outputs and
+// the external level for inputs.
+func (g *GPIO) Level() uint32 {
+	return g.Out&g.Dir | g.Input&^g.Dir
+}
+
+// write sets count pins starting at base, wrapping past pin 31, from the
+// low bits of value.
+func write(reg *uint32, base, count int, value uint32) {
+	for i := range count {
+		pin := uint((base + i) % 32)
+		if value>>i&1 == 1 {
+			*reg |= 1 << pin
+		} else {
+			*reg &^= 1 << pin
+		}
+	}
+}
+
+// State is a snapshot of a state machine after a clock cycle.
+type State struct {
+	Cycle       int      `json:"cycle"`
+	PC          int      `json:"pc"`          // address of the instruction this cycle
+	Instruction uint16   `json:"instruction"` // the instruction executed or delayed
+	Stalled     bool     `json:"stalled,omitempty"`
+	Delay       bool     `json:"delay,omitempty"` // the cycle was a delay cycle
+	X           uint32   `json:"x"`
+	Y           uint32   `json:"y"`
+	ISR         uint32   `json:"isr"`
+	ISRCount    int      `json:"isr_count"`
+	OSR         uint32   `json:"osr"`
+	OSRCount    int      `json:"osr_count"`
+	TX          []uint32 `json:"tx"`
+	RX          []uint32 `json:"rx"`
+	Pins        uint32   `json:"pins"`
+	PinDirs     uint32   `json:"pindirs"`
+	IRQ         uint8    `json:"irq"`
+}
+
+// StateMachine is a single PIO state machine. Instruction memory, IRQ
+// flags and GPIO belong to the PIO block and may be shared with other
+// state machines.
+type StateMachine struct {
+	cfg    Config
+	num    int // index within the block, for relative irq numbers
+	offset int // load address of the program
+	mem    *[asm.MaxInstructions]uint16
+	irq    *uint8
+	gpio   *GPIO
+
+	pc         int
+	x, y       uint32
+	isr, osr   uint32
+	isrCount   int
+	osrCount   int
+	tx, rx     []uint32
+	rxSlots    [fifoDepth]uint32 // RX FIFO used as storage by RP2350 put/get modes
+	delay      int
+	pending    uint16 // instruction queued by out exec or mov exec
+	hasPending bool
+	irqWaiting bool   // an irq wait has raised its flag and waits for it to clear
+	inputs     uint32 // pin levels sampled at the start of the cycle
+	flags      uint8  // irq flags sampled at the start of the cycle
+	cycle      int
+	last       State
+}
+
+// New returns a state machine running prog on a PIO block of its own.
+// The program is loaded at its .origin, or at address 0 when it is
+// relocatable. The config must pass Check with wrap points inside the
+// program.
+func New(prog *asm.Program, cfg Config) (*StateMachine, error) {
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+	if n := len(prog.Instructions); cfg.WrapTarget >= n || cfg.Wrap >= n {
+		return nil, fmt.Errorf("wrap_target %d and wrap %d must be within the program of %d instructions", cfg.WrapTarget, cfg.Wrap, n)
+	}
+	sm := &StateMachine{
+		mem:  new([asm.MaxInstructions]uint16),
+		irq:  new(uint8),
+		gpio: &GPIO{},
+	}
+	offset := max(prog.Origin, 0)
+	if err := Load(sm.mem, prog.Instructions, offset); err != nil {
+		return nil, err
+	}
+	sm.Init(offset, cfg)
+	return sm, nil
+}
+
+// Load copies a program into instruction memory at offset, relocating
+// jmp targets the way the SDK's pio_add_program does.
+func Load(mem *[asm.MaxInstructions]uint16, words []uint16, offset int) error {
+	if offset < 0 || offset+len(words) > len(mem) {
+		return fmt.Errorf("program of %d instructions does not fit at offset %d", len(words), offset)
+	}
+	for i, w := range words {
+		if w>>13 == 0 {
+			w = w&^0x1f | (w+uint16(offset))&0x1f
+		}
+		mem[offset+i] = w
+	}
+	return nil
+}
+
+// Init configures the state machine for a program loaded at offset and
+// restarts it.
+func (sm *StateMachine) Init(offset int, cfg Config) {
+	if cfg.PushThreshold <= 0 || cfg.PushThreshold > 32 {
+		cfg.PushThreshold = 32
+	}
+	if cfg.PullThreshold <= 0 || cfg.PullThreshold > 32 {
+		cfg.PullThreshold = 32
+	}
+	if cfg.ClockDiv < 1 {
+		cfg.ClockDiv = 1
+	}
+	sm.cfg, sm.offset = cfg, offset
+	sm.Restart()
+}
+
+// Restart clears the state machine's internal state, as SM_RESTART
+// does, and jumps to the start of the program. The OSR starts empty so
+// that the first out with autopull pulls from the TX FIFO.
+func (sm *StateMachine) Restart() {
+	sm.pc = sm.offset
+	sm.x, sm.y, sm.isr, sm.osr = 0, 0, 0, 0
+	sm.isrCount, sm.osrCount = 0, 32
+	sm.tx, sm.rx = nil, nil
+	sm.delay, sm.hasPending, sm.irqWaiting = 0, false, false
+	sm.cycle = 0
+	sm.last = State{PC: sm.pc}
+}
+
+// GPIO returns the pins the state machine drives and reads.
+func (sm *StateMachine) GPIO() *GPIO { return sm.gpio }
+
+// Put writes a word to the TX FIFO, reporting false when it is full.
+func (sm *StateMachine) Put(w uint32) bool {
+	if len(sm.tx) >= sm.TXDepth() {
+		return false
+	}
+	sm.tx = append(sm.tx, w)
+	return true
+}
+
+// Get reads a word from the RX FIFO, reporting false when it is empty.
+func (sm *StateMachine) Get() (uint32, bool) {
+	if len(sm.rx) == 0 {
+		return 0, false
+	}
+	w := sm.rx[0]
+	sm.rx = sm.rx[1:]
+	return w, true
+}
+
+// State returns the registers, FIFOs and pins as they are now, with the
+// cycle, pc and instruction of the last cycle run.
+func (sm *StateMachine) State() State {
+	last := sm.last
+	return sm.snapshot(last.PC, last.Instruction, last.Stalled, last.Delay)
+}
+
+// NextPC returns the address of the instruction the next cycle starts.
+// It reports false when the next cycle instead counts down a delay,
+// retries a stalled instruction or runs one queued by out exec or mov
+// exec.
+func (sm *StateMachine) NextPC() (int, bool) {
+	return sm.pc, sm.delay == 0 && !sm.hasPending && !sm.last.Stalled
+}
+
+// Registers are register and FIFO contents to overwrite, as a debugger
+// does. Nil fields are left unchanged; an empty FIFO slice clears it.
+type Registers struct {
+	PC       *int     `json:"pc,omitempty"` // absolute address; drops any delay or queued exec
+	X        *uint32  `json:"x,omitempty"`
+	Y        *uint32  `json:"y,omitempty"`
+	ISR      *uint32  `json:"isr,omitempty"`
+	ISRCount *int     `json:"isr_count,omitempty"`
+	OSR      *uint32  `json:"osr,omitempty"`
+	OSRCount *int     `json:"osr_count,omitempty"`
+	TX       []uint32 `json:"tx,omitempty"`
+	RX       []uint32 `json:"rx,omitempty"`
+}
+
+// SetRegisters overwrites the given registers. Nothing is changed when a
+// value is out of range or a FIFO is given more words than it holds.
+func (sm *StateMachine) SetRegisters(r Registers) error {
+	if r.PC != nil && (*r.PC < 0 || *r.PC >= asm.MaxInstructions) {
+		return fmt.Errorf("pc %d out of range 0-%d", *r.PC, asm.MaxInstructions-1)
+	}
+	for _, c := range []struct {
+		name  string
+		count *int
+	}{{"isr_count", r.ISRCount}, {"osr_count", r.OSRCount}} {
+		if c.count != nil && (*c.count < 0 || *c.count > 32) {
+			return fmt.Errorf("%s %d out of range 0-32", c.name, *c.count)
+		}
+	}
+	if len(r.TX) > sm.TXDepth() {
+		return fmt.Errorf("%d words do not fit the TX FIFO of %d", len(r.TX), sm.TXDepth())
+	}
+	if len(r.RX) > sm.RXDepth() {
+		return fmt.Errorf("%d words do not fit the RX FIFO of %d", len(r.RX), sm.RXDepth())
+	}
+
+	if r.PC != nil {
+		sm.pc, sm.delay, sm.hasPending, sm.irqWaiting = *r.PC, 0, false, false
+		sm.last.Stalled = false
+	}
+	set := func(reg *uint32, v *uint32) {
+		if v != nil {
+			*reg = *v
+		}
+	}
+	set(&sm.x, r.X)
+	set(&sm.y, r.Y)
+	set(&sm.isr, r.ISR)
+	set(&sm.osr, r.OSR)
+	if r.ISRCount != nil {
+		sm.isrCount = *r.ISRCount
+	}
+	if r.OSRCount != nil {
+		sm.osrCount = *r.OSRCount
+	}
+	if r.TX != nil {
+		sm.tx = append([]uint32{}, r.TX...)
+	}
+	if r.RX != nil {
+		sm.rx = append([]uint32{}, r.RX...)
+	}
+	return nil
+}
+
+// TXDepth returns the capacity of the TX FIFO under the FIFO join.
+func (sm *StateMachine) TXDepth() int {
+	switch sm.cfg.FifoJoin {
+	case "tx":
+		return 2 * fifoDepth
+	case "rx":
+		return 0
+	}
+	return fifoDepth
+}
+
+// RXDepth returns the capacity of the RX FIFO under the FIFO join.
+func (sm *StateMachine) RXDepth() int {
+	switch sm.cfg.FifoJoin {
+	case "rx":
+		return 2 * fifoDepth
+	case "", "txrx":
+		return fifoDepth
+	}
+	return 0 // joined into TX, or used as put/get storage
+}
+
+// Step runs one clock cycle and returns the state after it.
+func (sm *StateMachine) Step() State {
+	return sm.step(sm.gpio.Level(), *sm.irq)
+}
+
+// step runs one clock cycle on pin levels and irq flags sampled at its
+// start, so that state machines of a block stepped one after another
+// see what the others did in the previous cycle, not this one.
+func (sm *StateMachine) step(inputs uint32, flags uint8) State {
+	sm.cycle++
+	sm.inputs, sm.flags = inputs, flags
+	if sm.delay > 0 {
+		sm.delay--
+		return sm.snapshot(sm.last.PC, sm.last.Instruction, false, true)
+	}
+
+	addr, instr := sm.pc, sm.mem[sm.pc]
+	fromExec := sm.hasPending
+	if fromExec {
+		instr = sm.pending
+	}
+	sm.hasPending = false
+	r := sm.execute(instr)
+	// Side-set is applied after the instruction's own pin writes, since
+	// it takes priority on pins both drive.
+	delay := sm.sideSet(instr)
+	if r.stall {
+		// A stalled instruction runs again next cycle.
+		sm.hasPending = fromExec
+		return sm.snapshot(addr, instr, true, false)
+	}
+	switch {
+	case r.jump >= 0:
+		sm.pc = r.jump
+	case !fromExec:
+		sm.pc = sm.next(addr)
+	}
+	if !r.exec {
+		// Delays on out exec and mov exec are ignored; the executed
+		// instruction may add its own.
+		sm.delay = delay
+	}
+	return sm.snapshot(addr, instr, false, false)
+}
+
+// Run steps the state machine for n cycles and returns every state.
+func (sm *StateMachine) Run(n int) []State {
+	trace := make([]State, 0, n)
+	for range n {
+		trace = append(trace, sm.Step())
+	}
+	return trace
+}
+
+// next returns the address after addr, following the wrap.
+func (sm *StateMachine) next(addr int) int {
+	if addr == sm.offset+sm.cfg.Wrap {
+		return sm.offset + sm.cfg.WrapTarget
+	}
+	return (addr + 1) % asm.MaxInstructions
+}
+
+// sideSet drives the side-set pins of instr, which happens even while
+// the instruction stalls, and returns its delay.
+func (sm *StateMachine) sideSet(instr uint16) int {
+	ss := sm.cfg.SideSet
+	field := int(instr>>8) & 0x1f
+	delayBits := ss.DelayBits()
+	delay := field & (1<<delayBits - 1)
+	if ss.Bits == 0 || (ss.Opt && field&0x10 == 0) {
+		return delay
+	}
+	value := uint32(field>>delayBits) & (1<<ss.Bits - 1)
+	if ss.PinDirs {
+		write(&sm.gpio.Dir, sm.cfg.SideSetBase, ss.Bits, value)
+	} else {
+		write(&sm.gpio.Out, sm.cfg.SideSetBase, ss.Bits, value)
+	}
+	return delay
+}
+
+func (sm *StateMachine) snapshot(pc int, instr uint16, stalled, delay bool) State {
+	sm.last = State{
+		Cycle:       sm.cycle,
+		PC:          pc,
+		Instruction: instr,
+		Stalled:     stalled,
+		Delay:       delay,
+		X:           sm.x,
+		Y:           sm.y,
+		ISR:         sm.isr,
+		ISRCount:    sm.isrCount,
+		OSR:         sm.osr,
+		OSRCount:    sm.osrCount,
+		TX:          append([]uint32{}, sm.tx...),
+		RX:          append([]uint32{}, sm.rx...),
+		Pins:        sm.gpio.Out,
+		PinDirs:     sm.gpio.Dir,
+		IRQ:         *sm.irq,
+	}
+	return sm.last
+}
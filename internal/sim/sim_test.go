@@ -0,0 +1,222 @@
+package sim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+func newSM(t *testing.T, src string, configure func(*Config)) *StateMachine {
+	t.Helper()
+	prog, err := asm.Assemble(src)
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	cfg := DefaultConfig(prog)
+	if configure != nil {
+		configure(&cfg)
+	}
+	sm, err := New(prog, cfg)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	return sm
+}
+
+func TestStep_SquarewaveTiming(t *testing.T) {
+	sm := newSM(t, `.program squarewave
+    set pindirs, 1
+again:
+    set pins, 1 [1]
+    set pins, 0
+    jmp again`, func(c *Config) { c.SetCount = 1 })
+
+	trace := sm.Run(9)
+	want := []uint32{0, 1, 1, 0, 0, 1, 1, 0, 0}
+	for i, st := range trace {
+		if st.Pins != want[i] {
+			t.Errorf("cycle %d: expected pin %d, got %d", st.Cycle, want[i], st.Pins)
+		}
+	}
+	if !trace[2].Delay || trace[2].PC != 1 {
+		t.Fatalf("expected cycle 3 to be the delay of address 1, got %+v", trace[2])
+	}
+	if trace[0].PinDirs != 1 {
+		t.Fatalf("expected pin 0 to be an output, got dirs %b", trace[0].PinDirs)
+	}
+}
+
+func TestStep_MovPindirs(t *testing.T) {
+	// The RP2350 mov pindirs writes the out pins' directions.
+	sm := newSM(t, `.pio_version 1
+    set x, 0b101
+    mov pindirs, ~x`, func(c *Config) { c.OutBase, c.OutCount = 2, 3 })
+
+	trace := sm.Run(2)
+	if trace[1].PinDirs != 0b01000 {
+		t.Fatalf("expected dirs 01000, got %05b", trace[1].PinDirs)
+	}
+}
+
+func TestStep_AutopullStallsOnEmptyFIFO(t *testing.T) {
+	sm := newSM(t, `    out pins, 8`, func(c *Config) {
+		c.Autopull, c.PullThreshold, c.OutCount = true, 16, 8
+	})
+	if st := sm.Step(); !st.Stalled {
+		t.Fatalf("expected out to stall with an empty TX FIFO, got %+v", st)
+	}
+	sm.Put(0xbeef)
+	for i, want := range []uint32{0xef, 0xbe} {
+		st := sm.Step()
+		if st.Stalled || st.Pins != want {
+			t.Fatalf("out %d: expected pins %#x, got %+v", i, want, st)
+		}
+	}
+	if st := sm.Step(); !st.Stalled || st.OSRCount != 16 {
+		t.Fatalf("expected stall once the threshold is reached, got %+v", st)
+	}
+}
+
+func TestStep_AutopushShiftLeft(t *testing.T) {
+	sm := newSM(t, `    set x, 5
+    in x, 4
+    in null, 4`, func(c *Config) {
+		c.Autopush, c.PushThreshold, c.InShiftLeft = true, 8, true
+	})
+	trace := sm.Run(3)
+	if got := trace[1]; got.ISR != 5 || got.ISRCount != 4 {
+		t.Fatalf("expected ISR 5 with 4 bits, got %+v", got)
+	}
+	if got := trace[2]; got.ISRCount != 0 || len(got.RX) != 1 || got.RX[0] != 0x50 {
+		t.Fatalf("expected autopush of 0x50, got %+v", got)
+	}
+}
+
+func TestStep_PushPullBlocking(t *testing.T) {
+	sm := newSM(t, `    pull noblock
+    mov isr, osr
+    push
+    push
+    push
+    push
+    push`, nil)
+	trace := sm.Run(8)
+	if trace[5].Stalled || !trace[6].Stalled || !trace[7].Stalled {
+		t.Fatalf("expected the fifth push to stall on a full RX FIFO: %+v", trace[4:])
+	}
+	if len(trace[7].RX) != 4 {
+		t.Fatalf("expected 4 words in RX, got %v", trace[7].RX)
+	}
+	sm.Get()
+	if st := sm.Step(); st.Stalled {
+		t.Fatalf("expected push to complete once RX has room, got %+v", st)
+	}
+}
+
+func TestStep_JmpLoopAndWrap(t *testing.T) {
+	sm := newSM(t, `    set x, 2
+loop:
+    jmp x-- loop
+.wrap_target
+    set y, 1
+.wrap`, nil)
+	trace := sm.Run(6)
+	pcs := []int{0, 1, 1, 1, 2, 2}
+	for i, st := range trace {
+		if st.PC != pcs[i] {
+			t.Errorf("cycle %d: expected pc %d, got %d", st.Cycle, pcs[i], st.PC)
+		}
+	}
+	if trace[3].X != 0xffffffff {
+		t.Fatalf("expected x to wrap to 0xffffffff after the last decrement, got %#x", trace[3].X)
+	}
+}
+
+func TestStep_SideSetAppliesWhileStalled(t *testing.T) {
+	sm := newSM(t, `.side_set 1 opt
+    pull side 1
+    nop side 0 [2]
+    nop`, func(c *Config) { c.SideSetBase = 3 })
+	if st := sm.Step(); !st.Stalled || st.Pins != 1<<3 {
+		t.Fatalf("expected side-set during the stall, got %+v", st)
+	}
+	sm.Put(1)
+	trace := sm.Run(5)
+	if trace[1].Pins != 0 || !trace[2].Delay || !trace[3].Delay || trace[4].PC != 2 {
+		t.Fatalf("unexpected side-set/delay sequence %+v", trace)
+	}
+}
+
+func TestStep_IRQWaitAndOutExec(t *testing.T) {
+	sm := newSM(t, `    irq wait 1 rel
+    out exec, 16
+    nop`, func(c *Config) { c.Autopull = true })
+	if st := sm.Step(); !st.Stalled || st.IRQ != 1<<1 {
+		t.Fatalf("expected irq wait to raise flag 1 and stall, got %+v", st)
+	}
+	*sm.irq = 0
+	if st := sm.Step(); st.Stalled {
+		t.Fatalf("expected irq wait to finish once cleared, got %+v", st)
+	}
+	sm.Put(0xe03f) // set x, 31
+	sm.Step()
+	if st := sm.Step(); st.X != 31 || st.Instruction != 0xe03f {
+		t.Fatalf("expected out exec to run set x, 31, got %+v", st)
+	}
+	if st := sm.Step(); st.PC != 2 {
+		t.Fatalf("expected execution to continue after out exec, got %+v", st)
+	}
+}
+
+func TestLoad_RelocatesJumps(t *testing.T) {
+	var mem [asm.MaxInstructions]uint16
+	if err := Load(&mem, []uint16{0xe001, 0x0000}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if mem[11] != 0x000a {
+		t.Fatalf("expected jmp 0 relocated to 10, got %04x", mem[11])
+	}
+	if err := Load(&mem, make([]uint16, 4), 30); err == nil {
+		t.Fatal("expected error loading past the end of instruction memory")
+	}
+}
+
+func TestNew_RejectsBadConfig(t *testing.T) {
+	prog, err := asm.Assemble("    out pins, 1\n    nop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		configure func(*Config)
+		want      string
+	}{
+		{func(c *Config) { c.SideSet.Bits = 10 }, "does not fit in 5 bits"},
+		{func(c *Config) { c.SideSet = asm.SideSet{Bits: 5, Opt: true} }, "does not fit in 5 bits"},
+		{func(c *Config) { c.SideSet.Bits = -1 }, "does not fit in 5 bits"},
+		{func(c *Config) { c.OutCount = 1e9 }, "out_count 1000000000 out of range 0-32"},
+		{func(c *Config) { c.OutBase = -1 }, "out_base -1 out of range 0-31"},
+		{func(c *Config) { c.SetCount = 6 }, "set_count"},
+		{func(c *Config) { c.SideSetBase = 32 }, "sideset_base"},
+		{func(c *Config) { c.JmpPin = 40 }, "jmp_pin"},
+		{func(c *Config) { c.Wrap = -3 }, "wrap -3 out of range"},
+		{func(c *Config) { c.Wrap = 5 }, "within the program of 2 instructions"},
+	} {
+		cfg := DefaultConfig(prog)
+		tt.configure(&cfg)
+		if _, err := New(prog, cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", cfg, tt.want, err)
+		}
+	}
+
+	b := NewBlock()
+	offset, err := b.AddProgram(prog, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig(prog)
+	cfg.Wrap = 5
+	if err := b.Start(0, offset, cfg); err == nil || !strings.Contains(err.Error(), "wrap past the end") {
+		t.Fatalf("expected a wrap error, got %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+// Package stream runs a simulated state machine in real time and turns
+// its progress into events: pin, pc and stall changes and FIFO traffic.
+// A Streamer is driven by ticks and control messages, so the same model
+// serves a WebSocket and tests.
+package stream
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+// Limits on the options.
+const (
+	DefaultRate     = 100 // cycles per second
+	MaxRate         = 1_000_000
+	DefaultInterval = 50 * time.Millisecond
+	MinInterval     = 10 * time.Millisecond
+	DefaultMaxBatch = 500
+	MaxStep         = 10_000 // cycles one step control may run
+	MaxTX           = 1024   // words queued for the TX FIFO
+)
+
+// EventTypes are the events a Streamer sends:
+//
+//	pc     the executed instruction's address changed
+//	pins   the driven pin levels or directions changed
+//	stall  an instruction started stalling
+//	push   the state machine pushed Word into the RX FIFO
+//	pull   the state machine pulled Word from the TX FIFO
+//	rx     the host read Word from the RX FIFO, with drain_rx
+//	status the stream was started, paused, resumed or finished
+//	dropped Count events were left out of a batch over max_batch
+//	error  a control message was rejected; always sent
+var EventTypes = []string{"pc", "pins", "stall", "push", "pull", "rx", "status", "dropped"}
+
+// Event is one change in a running state machine. Fields that do not
+// apply to its type are left out.
+type Event struct {
+	Type    string  `json:"type"`
+	Cycle   int     `json:"cycle"`
+	PC      *int    `json:"pc,omitempty"`
+	Pins    *uint32 `json:"pins,omitempty"`
+	PinDirs *uint32 `json:"pindirs,omitempty"`
+	Word    *uint32 `json:"word,omitempty"`
+	State   string  `json:"state,omitempty"` // running, paused or done, for status
+	Count   int     `json:"count,omitempty"` // for dropped
+	Error   string  `json:"error,omitempty"` // for error
+}
+
+// Options control the pace and content of a stream.
+type Options struct {
+	Rate      float64  `json:"rate,omitempty"`        // cycles per second, default 100
+	Interval  int      `json:"interval_ms,omitempty"` // milliseconds between batches, default 50
+	MaxBatch  int      `json:"max_batch,omitempty"`   // events per batch, default 500
+	MaxCycles int      `json:"max_cycles,omitempty"`  // finish after this many cycles; 0 runs until stopped
+	Events    []string `json:"events,omitempty"`      // event types to send, default all
+	DrainRX   bool     `json:"drain_rx,omitempty"`    // read the RX FIFO every cycle
+	Paused    bool     `json:"paused,omitempty"`      // start paused
+}
+
+// Control is a message from the client.
+type Control struct {
+	Type  string  `json:"type"`            // pause, resume, step, tx, pin, pins, rate or stop
+	Word  uint32  `json:"word,omitempty"`  // tx: word queued for the TX FIFO
+	Pin   int     `json:"pin,omitempty"`   // pin: GPIO to drive
+	Level int     `json:"level,omitempty"` // pin: 0 or 1
+	Pins  uint32  `json:"pins,omitempty"`  // pins: all input levels
+	Rate  float64 `json:"rate,omitempty"`  // rate: new cycles per second
+	Count int     `json:"count,omitempty"` // step: cycles to run while paused, default 1, at most MaxStep
+}
+
+// Streamer runs a state machine a tick at a time and reports events.
+type Streamer struct {
+	sm      *sim.StateMachine
+	opts    Options
+	send    map[string]bool
+	tx      []uint32 // words waiting for room in the TX FIFO
+	paused  bool
+	done    bool
+	carry   float64 // fraction of a cycle owed from earlier ticks
+	cycle   int
+	prev    sim.State
+	stalled bool
+}
+
+// New returns a streamer for sm, checking and defaulting the options.
+func New(sm *sim.StateMachine, opts Options) (*Streamer, error) {
+	if opts.Rate == 0 {
+		opts.Rate = DefaultRate
+	}
+	if opts.Rate < 0 || opts.Rate > MaxRate {
+		return nil, fmt.Errorf("rate %g out of range 0-%d cycles per second", opts.Rate, MaxRate)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = int(DefaultInterval / time.Millisecond)
+	}
+	if time.Duration(opts.Interval)*time.Millisecond < MinInterval {
+		return nil, fmt.Errorf("interval %d ms is below the minimum of %d ms", opts.Interval, MinInterval/time.Millisecond)
+	}
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = DefaultMaxBatch
+	}
+	send := map[string]bool{}
+	for _, t := range opts.Events {
+		if !slices.Contains(EventTypes, t) {
+			return nil, fmt.Errorf("unknown event type %q (want %s)", t, strings.Join(EventTypes, ", "))
+		}
+		send[t] = true
+	}
+	if len(opts.Events) == 0 {
+		for _, t := range EventTypes {
+			send[t] = true
+		}
+	}
+	return &Streamer{sm: sm, opts: opts, send: send, paused: opts.Paused, prev: sm.State()}, nil
+}
+
+// Interval returns the time between batches.
+func (s *Streamer) Interval() time.Duration {
+	return time.Duration(s.opts.Interval) * time.Millisecond
+}
+
+// Done reports whether the stream has finished.
+func (s *Streamer) Done() bool { return s.done }
+
+// Start returns the events that open a stream: its status and the
+// initial pins.
+func (s *Streamer) Start() []Event {
+	var events []Event
+	events = s.add(events, s.status())
+	pins, dirs := s.prev.Pins, s.prev.PinDirs
+	return s.add(events, Event{Type: "pins", Cycle: s.cycle, Pins: &pins, PinDirs: &dirs})
+}
+
+// Tick runs the cycles due after dt of real time and returns their
+// events. Nothing runs while paused. A tick runs at most two intervals'
+// worth of cycles: after a late tick the cycles beyond that are dropped,
+// not owed, so the stream does not burst to catch up.
+func (s *Streamer) Tick(dt time.Duration) []Event {
+	if s.paused || s.done {
+		return nil
+	}
+	s.carry += s.opts.Rate * dt.Seconds()
+	n := int(s.carry + 1e-9) // tolerate rounding in the running sum
+	s.carry -= float64(n)
+	if limit := max(int(s.opts.Rate*2*s.Interval().Seconds()), 1); n > limit {
+		n, s.carry = limit, 0
+	}
+	return s.run(n)
+}
+
+// Control applies a client message and returns any events it caused.
+func (s *Streamer) Control(c Control) ([]Event, error) {
+	switch c.Type {
+	case "pause", "resume":
+		if s.done || s.paused == (c.Type == "pause") {
+			return nil, nil
+		}
+		s.paused, s.carry = c.Type == "pause", 0
+		return s.add(nil, s.status()), nil
+	case "step":
+		if !s.paused {
+			return nil, fmt.Errorf("step needs the stream to be paused")
+		}
+		if c.Count > MaxStep {
+			return nil, fmt.Errorf("step count %d exceeds the limit of %d", c.Count, MaxStep)
+		}
+		return s.run(max(c.Count, 1)), nil
+	case "tx":
+		if len(s.tx) >= MaxTX {
+			return nil, fmt.Errorf("%d words are already queued for the TX FIFO", MaxTX)
+		}
+		s.tx = append(s.tx, c.Word)
+	case "pin":
+		if c.Pin < 0 || c.Pin > 31 {
+			return nil, fmt.Errorf("pin %d out of range 0-31", c.Pin)
+		}
+		g := s.sm.GPIO()
+		if c.Level != 0 {
+			g.Input |= 1 << c.Pin
+		} else {
+			g.Input &^= 1 << c.Pin
+		}
+	case "pins":
+		s.sm.GPIO().Input = c.Pins
+	case "rate":
+		if c.Rate <= 0 || c.Rate > MaxRate {
+			return nil, fmt.Errorf("rate %g out of range 0-%d cycles per second", c.Rate, MaxRate)
+		}
+		s.opts.Rate, s.carry = c.Rate, 0
+	case "stop":
+		return s.finish(nil), nil
+	default:
+		return nil, fmt.Errorf("unknown control %q (want pause, resume, step, tx, pin, pins, rate, stop)", c.Type)
+	}
+	return nil, nil
+}
+
+// run steps n cycles and collects their events, counting those past
+// max_batch as dropped.
+func (s *Streamer) run(n int) []Event {
+	var events []Event
+	dropped := 0
+	for range n {
+		if s.done {
+			break
+		}
+		for len(s.tx) > 0 && s.sm.Put(s.tx[0]) {
+			s.tx = s.tx[1:]
+		}
+		// Words queued before the step are the ones the program can
+		// pull this cycle.
+		before := s.sm.State()
+		st := s.sm.Step()
+		s.cycle++
+		events = s.changes(events, before, st)
+		for s.opts.DrainRX {
+			w, ok := s.sm.Get()
+			if !ok {
+				break
+			}
+			events = s.add(events, Event{Type: "rx", Cycle: s.cycle, Word: &w})
+		}
+		s.prev = s.sm.State()
+		if s.opts.MaxCycles > 0 && s.cycle >= s.opts.MaxCycles {
+			events = s.finish(events)
+		}
+		if len(events) > s.opts.MaxBatch {
+			dropped += len(events) - s.opts.MaxBatch
+			events = events[:s.opts.MaxBatch]
+		}
+	}
+	if dropped > 0 {
+		events = append(events, Event{Type: "dropped", Cycle: s.cycle, Count: dropped})
+	}
+	return events
+}
+
+// changes appends the events of one cycle, from the state before it to
+// the state after it.
+func (s *Streamer) changes(events []Event, before, st sim.State) []Event {
+	if st.PC != s.prev.PC || s.cycle == 1 {
+		pc := st.PC
+		events = s.add(events, Event{Type: "pc", Cycle: s.cycle, PC: &pc})
+	}
+	if st.Pins != s.prev.Pins || st.PinDirs != s.prev.PinDirs {
+		pins, dirs := st.Pins, st.PinDirs
+		events = s.add(events, Event{Type: "pins", Cycle: s.cycle, Pins: &pins, PinDirs: &dirs})
+	}
+	if st.Stalled && !s.stalled {
+		pc := st.PC
+		events = s.add(events, Event{Type: "stall", Cycle: s.cycle, PC: &pc})
+	}
+	s.stalled = st.Stalled
+	// The state machine only takes from the front of TX and adds to the
+	// back of RX, so length changes within a cycle are its own.
+	if len(st.TX) < len(before.TX) {
+		w := before.TX[0]
+		events = s.add(events, Event{Type: "pull", Cycle: s.cycle, Word: &w})
+	}
+	if len(st.RX) > len(before.RX) {
+		w := st.RX[len(st.RX)-1]
+		events = s.add(events, Event{Type: "push", Cycle: s.cycle, Word: &w})
+	}
+	return events
+}
+
+func (s *Streamer) finish(events []Event) []Event {
+	if s.done {
+		return events
+	}
+	s.done = true
+	return s.add(events, s.status())
+}
+
+func (s *Streamer) status() Event {
+	state := "running"
+	switch {
+	case s.done:
+		state = "done"
+	case s.paused:
+		state = "paused"
+	}
+	return Event{Type: "status", Cycle: s.cycle, State: state}
+}
+
+// add appends e when its type is selected.
+func (s *Streamer) add(events []Event, e Event) []Event {
+	if !s.send[e.Type] {
+		return events
+	}
+	return append(events, e)
+}
+
+// Run drives a streamer in real time: it sends the opening events, a
+// batch every interval, and the result of each control message, until
+// the stream finishes, controls is closed or ctx is done. A rejected
+// control is sent as an error event and does not end the stream.
+func Run(ctx context.Context, s *Streamer, controls <-chan Control, send func([]Event) error) error {
+	if err := send(s.Start()); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.Interval())
+	defer ticker.Stop()
+	last := time.Now()
+	for !s.Done() {
+		var events []Event
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case c, ok := <-controls:
+			if !ok {
+				return nil
+			}
+			var err error
+			if events, err = s.Control(c); err != nil {
+				events = []Event{{Type: "error", Cycle: s.cycle, Error: err.Error()}}
+			}
+			if c.Type == "resume" || c.Type == "rate" {
+				last = time.Now()
+			}
+		case now := <-ticker.C:
+			events = s.Tick(now.Sub(last))
+			last = now
+		}
+		if len(events) > 0 {
+			if err := send(events); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package stream
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+func newStreamer(t *testing.T, src string, opts Options) *Streamer {
+	t.Helper()
+	prog, err := asm.Assemble(src)
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	cfg := sim.DefaultConfig(prog)
+	cfg.OutCount = 1
+	sm, err := sim.New(prog, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(sm, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+const echo = `.program echo
+    pull
+    out pins, 1
+    in pins, 1
+    push`
+
+func types(events []Event) string {
+	var b []string
+	for _, e := range events {
+		b = append(b, e.Type)
+	}
+	return strings.Join(b, " ")
+}
+
+func TestTick_Events(t *testing.T) {
+	s := newStreamer(t, echo, Options{Rate: 1000, Events: []string{"pins", "stall", "push", "pull", "status"}})
+	if got := types(s.Start()); got != "status pins" {
+		t.Fatalf("expected status and pins to open the stream, got %q", got)
+	}
+	// 2 ms at 1000 cycles per second is 2 cycles: pull stalls on an
+	// empty FIFO.
+	if got := types(s.Tick(2 * time.Millisecond)); got != "stall" {
+		t.Fatalf("expected a single stall event, got %q", got)
+	}
+	if _, err := s.Control(Control{Type: "tx", Word: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Control(Control{Type: "pin", Pin: 0, Level: 1}); err != nil {
+		t.Fatal(err)
+	}
+	events := s.Tick(4 * time.Millisecond)
+	if got := types(events); got != "pull pins push" {
+		t.Fatalf("expected pull, pins and push, got %q", got)
+	}
+	// in shifts right by default, so pin 0 lands in bit 31.
+	if *events[0].Word != 1 || *events[1].Pins != 1 || *events[2].Word != 1<<31 {
+		t.Fatalf("unexpected event values %d %d %d", *events[0].Word, *events[1].Pins, *events[2].Word)
+	}
+}
+
+func TestTick_RateCarriesFractions(t *testing.T) {
+	s := newStreamer(t, echo, Options{Rate: 10, Events: []string{"pc"}, DrainRX: true})
+	var n int
+	for range 10 {
+		s.Tick(30 * time.Millisecond)
+		n = s.cycle
+	}
+	if n != 3 {
+		t.Fatalf("expected 300 ms at 10 cycles per second to run 3 cycles, got %d", n)
+	}
+}
+
+func TestTick_LongDelayIsCapped(t *testing.T) {
+	// 1000 cycles per second with 50 ms batches runs at most 100 cycles
+	// a tick, however late it is, and owes nothing afterwards.
+	s := newStreamer(t, echo, Options{Rate: 1000, DrainRX: true})
+	s.Tick(time.Hour)
+	if s.cycle != 100 {
+		t.Fatalf("expected a late tick to run 100 cycles, got %d", s.cycle)
+	}
+	s.Tick(10 * time.Millisecond)
+	if s.cycle != 110 {
+		t.Fatalf("expected the next tick to run 10 cycles, got %d", s.cycle-100)
+	}
+}
+
+func TestControl_PauseStepStop(t *testing.T) {
+	s := newStreamer(t, echo, Options{Rate: 1000, MaxCycles: 10})
+	if ev, _ := s.Control(Control{Type: "pause"}); len(ev) != 1 || ev[0].State != "paused" {
+		t.Fatalf("expected a paused status, got %+v", ev)
+	}
+	if ev := s.Tick(time.Second); ev != nil {
+		t.Fatalf("expected nothing to run while paused, got %+v", ev)
+	}
+	ev, err := s.Control(Control{Type: "step", Count: 3})
+	if err != nil || s.cycle != 3 || types(ev) != "pc stall" {
+		t.Fatalf("expected 3 cycles stepped, got cycle %d %q %v", s.cycle, types(ev), err)
+	}
+	s.Control(Control{Type: "resume"})
+	if ev := s.Tick(time.Second); types(ev) != "status" || ev[0].State != "done" || s.cycle != 10 || !s.Done() {
+		t.Fatalf("expected max_cycles to finish the stream, got %+v at cycle %d", ev, s.cycle)
+	}
+	for _, tt := range []struct {
+		c    Control
+		want string
+	}{
+		{Control{Type: "pin", Pin: 40}, "out of range"},
+		{Control{Type: "rate", Rate: -1}, "out of range"},
+		{Control{Type: "jump"}, "unknown control"},
+	} {
+		if _, err := s.Control(tt.c); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.c, tt.want, err)
+		}
+	}
+}
+
+func TestMaxBatch(t *testing.T) {
+	src := `.program toggle
+    set pins, 1
+    set pins, 0`
+	prog, _ := asm.Assemble(src)
+	cfg := sim.DefaultConfig(prog)
+	cfg.SetCount = 1
+	sm, _ := sim.New(prog, cfg)
+	s, err := New(sm, Options{Rate: 1000, MaxBatch: 5, Events: []string{"pins"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := s.Tick(20 * time.Millisecond)
+	if len(ev) != 6 || ev[5].Type != "dropped" || ev[5].Count != 15 {
+		t.Fatalf("expected 5 events and 15 dropped, got %d events, last %+v", len(ev), ev[len(ev)-1])
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s := newStreamer(t, ".program toggle\n    set pins, 1\n    set pins, 0", Options{Paused: true, MaxBatch: 10, Events: []string{"pins"}})
+	if _, err := s.Control(Control{Type: "step", Count: 2e9}); err == nil || !strings.Contains(err.Error(), "exceeds the limit of 10000") {
+		t.Fatalf("expected a step limit error, got %v", err)
+	}
+	ev, err := s.Control(Control{Type: "step", Count: MaxStep})
+	if err != nil || s.cycle != MaxStep || len(ev) != 11 || ev[10].Type != "dropped" || ev[10].Count != MaxStep-10 {
+		t.Fatalf("expected a full step with events capped at 10, got cycle %d, %d events, %v", s.cycle, len(ev), err)
+	}
+	for range MaxTX {
+		if _, err := s.Control(Control{Type: "tx", Word: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Control(Control{Type: "tx", Word: 1}); err == nil || !strings.Contains(err.Error(), "already queued") {
+		t.Fatalf("expected a full TX queue error, got %v", err)
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	prog, _ := asm.Assemble(echo)
+	sm, _ := sim.New(prog, sim.DefaultConfig(prog))
+	for _, tt := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{Rate: 2e6}, "rate"},
+		{Options{Interval: 1}, "interval"},
+		{Options{Events: []string{"pc", "beep"}}, `unknown event type "beep"`},
+	} {
+		if _, err := New(sm, tt.opts); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.opts, tt.want, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := newStreamer(t, echo, Options{Rate: 1e5, Interval: 10, MaxCycles: 50, Paused: true})
+	controls := make(chan Control, 2)
+	controls <- Control{Type: "bogus"}
+	controls <- Control{Type: "resume"}
+	var all []Event
+	err := Run(context.Background(), s, controls, func(ev []Event) error {
+		all = append(all, ev...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := types(all)
+	if !strings.HasPrefix(got, "status pins error status") || !strings.HasSuffix(got, "status") || all[len(all)-1].State != "done" {
+		t.Fatalf("unexpected event sequence %q", got)
+	}
+}
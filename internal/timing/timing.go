@@ -0,0 +1,246 @@
+// Package timing works out how long a PIO program takes: the cycles of
+// every loop through its control flow, and the 16.8 fractional clock
+// divider that brings a state machine closest to a target frequency.
+// Cycle counts assume no stalls; the instructions that can stall are
+// listed with each loop.
+package timing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// Loop is one way around a program: a cycle through its instructions
+// that returns to where it started, taking each branch at most once.
+type Loop struct {
+	Start       int     `json:"start"`
+	Label       string  `json:"label,omitempty"` // label at the start address
+	Addresses   []int   `json:"addresses"`
+	Cycles      int     `json:"cycles"`           // one per instruction plus its delay
+	Stalls      []int   `json:"stalls,omitempty"` // addresses that can stall: wait, blocking push and pull, irq wait
+	Nanoseconds float64 `json:"ns,omitempty"`     // time per iteration at a divider, set by Time
+}
+
+// maxLoops bounds the loops listed for a program and maxSteps the search
+// for them; a program with more branches is summarized by the first
+// loops found.
+const (
+	maxLoops = 64
+	maxSteps = 100000
+)
+
+// Loops returns every loop of a program, in order of their addresses. Execution follows jumps and the wrap, so a jmp whose condition
+// can go either way gives both paths. Instructions that write the program
+// counter or EXEC have no static successor and are reported in the
+// warnings, as is a search cut short.
+func Loops(prog *asm.Program) ([]Loop, []string) {
+	n := len(prog.Instructions)
+	var warnings []string
+	succ := make([][]int, n)
+	for i, w := range prog.Instructions {
+		next := i + 1
+		if i == prog.Wrap {
+			next = prog.WrapTarget
+		}
+		var s []int
+		switch op, arg1 := w>>13, w>>5&7; {
+		case op == 0:
+			s = append(s, int(w&0x1f))
+			if arg1 != 0 && int(w&0x1f) != next {
+				s = append(s, next)
+			}
+		case op == 3 && (arg1 == 5 || arg1 == 7), op == 5 && (arg1 == 4 || arg1 == 5):
+			warnings = append(warnings, fmt.Sprintf("instruction %d writes the program counter or EXEC, so loops through it are not listed", i))
+		default:
+			s = append(s, next)
+		}
+		for _, j := range s {
+			if j < n {
+				succ[i] = append(succ[i], j)
+			}
+		}
+	}
+
+	// Each loop is found once, from its lowest address, by a search that
+	// only visits higher addresses.
+	var loops []Loop
+	steps := 0
+	for start := range n {
+		path := []int{start}
+		on := make([]bool, n)
+		var visit func(i int) bool
+		visit = func(i int) bool {
+			for _, j := range succ[i] {
+				if steps++; steps > maxSteps {
+					return false
+				}
+				switch {
+				case j == start:
+					if len(loops) == maxLoops {
+						return false
+					}
+					loops = append(loops, loop(prog, path))
+				case j > start && !on[j]:
+					on[j] = true
+					path = append(path, j)
+					if !visit(j) {
+						return false
+					}
+					path = path[:len(path)-1]
+					on[j] = false
+				}
+			}
+			return true
+		}
+		if !visit(start) {
+			warnings = append(warnings, fmt.Sprintf("only the first %d loops are listed", len(loops)))
+			break
+		}
+	}
+	slices.SortStableFunc(loops, func(a, b Loop) int { return slices.Compare(a.Addresses, b.Addresses) })
+	return loops, warnings
+}
+
+// loop returns the loop along the addresses of path.
+func loop(prog *asm.Program, path []int) Loop {
+	l := Loop{Start: path[0], Addresses: append([]int(nil), path...)}
+	for _, lb := range prog.Labels {
+		if lb.Address == l.Start {
+			l.Label = lb.Name
+			break
+		}
+	}
+	for _, addr := range path {
+		w := prog.Instructions[addr]
+		l.Cycles += 1 + int(w>>8&0x1f)&prog.SideSet.MaxDelay()
+		if stalls(w) {
+			l.Stalls = append(l.Stalls, addr)
+		}
+	}
+	return l
+}
+
+// stalls reports whether an instruction can wait for something outside
+// the state machine. Autopush and autopull can also stall in and out, but
+// depend on the state machine config.
+func stalls(w uint16) bool {
+	switch w >> 13 {
+	case 1: // wait
+		return true
+	case 4: // push or pull, not mov rxfifo
+		return w&0x10 == 0 && w&0x20 != 0
+	case 6: // irq wait
+		return w>>5&3 == 1
+	}
+	return false
+}
+
+// Shortest returns the fewest cycles of any loop, or 0 without loops.
+func Shortest(loops []Loop) int {
+	least := 0
+	for _, l := range loops {
+		if least == 0 || l.Cycles < least {
+			least = l.Cycles
+		}
+	}
+	return least
+}
+
+// Time sets the time per iteration of each loop at a divider.
+func Time(loops []Loop, d Divider) {
+	for i := range loops {
+		loops[i].Nanoseconds = float64(loops[i].Cycles) * 1e9 / d.Frequency
+	}
+}
+
+// Divider is a state machine clock divider: an integer part of 1 to
+// 65535 and a fractional part in 256ths, or 65536 with an integer part of
+// 0, as the hardware encodes it.
+type Divider struct {
+	Int       int     `json:"int"` // 0 for 65536
+	Frac      int     `json:"frac"`
+	Value     float64 `json:"value"`
+	Frequency float64 `json:"frequency_hz"`  // state machine clock
+	Error     float64 `json:"error_percent"` // from the target, 0 without one
+	// A fractional divider spaces state machine cycles Int or Int+1
+	// system clocks apart, so each edge can be up to one system clock
+	// period from its ideal time.
+	Jitter float64 `json:"jitter_ns"`
+}
+
+// maxUnits is the slowest divider, 65536, in 256ths.
+const maxUnits = 65536 << 8
+
+// divider returns the divider of units 256ths at a system clock, with the
+// error from target when it is not 0.
+func divider(sysClock float64, units int, target float64) Divider {
+	d := Divider{Int: units >> 8 & 0xffff, Frac: units & 0xff, Value: float64(units) / 256}
+	d.Frequency = sysClock / d.Value
+	if target > 0 {
+		d.Error = (d.Frequency - target) / target * 100
+	}
+	if d.Frac != 0 {
+		d.Jitter = 1e9 / sysClock
+	}
+	return d
+}
+
+// checkTarget reports a target frequency no divider can reach.
+func checkTarget(sysClock, target float64) error {
+	switch {
+	case sysClock <= 0:
+		return errors.New("the system clock must be positive")
+	case target <= 0:
+		return errors.New("the target frequency must be positive")
+	case target > sysClock:
+		return fmt.Errorf("%g Hz is above the %g Hz system clock", target, sysClock)
+	case target < sysClock*256/maxUnits:
+		return fmt.Errorf("%g Hz is below the slowest state machine clock, %g Hz", target, sysClock*256/maxUnits)
+	}
+	return nil
+}
+
+// Best returns the 16.8 divider that brings the state machine clock
+// closest to target.
+func Best(sysClock, target float64) (Divider, error) {
+	if err := checkTarget(sysClock, target); err != nil {
+		return Divider{}, err
+	}
+	exact := sysClock / target * 256
+	best := divider(sysClock, min(max(int(math.Floor(exact)), 256), maxUnits), target)
+	if up := divider(sysClock, min(int(math.Ceil(exact)), maxUnits), target); math.Abs(up.Error) < math.Abs(best.Error) {
+		best = up
+	}
+	return best, nil
+}
+
+// BestInteger returns the integer divider, free of jitter, that brings
+// the state machine clock closest to target.
+func BestInteger(sysClock, target float64) (Divider, error) {
+	if err := checkTarget(sysClock, target); err != nil {
+		return Divider{}, err
+	}
+	exact := sysClock / target
+	best := divider(sysClock, min(max(int(math.Floor(exact)), 1), 65536)<<8, target)
+	if up := divider(sysClock, min(int(math.Ceil(exact)), 65536)<<8, target); math.Abs(up.Error) < math.Abs(best.Error) {
+		best = up
+	}
+	return best, nil
+}
+
+// Fixed returns a divider given as a number, such as a .clock_div
+// directive, rounded to 256ths. The range is the assembler's: 1 to 65536.
+func Fixed(sysClock, div float64) (Divider, error) {
+	if sysClock <= 0 {
+		return Divider{}, errors.New("the system clock must be positive")
+	}
+	units := int(math.Round(div * 256))
+	if units < 256 || units > maxUnits {
+		return Divider{}, fmt.Errorf("clock divider %g is outside 1 to 65536", div)
+	}
+	return divider(sysClock, units, 0), nil
+}
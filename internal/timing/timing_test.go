@@ -0,0 +1,165 @@
+package timing
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+)
+
+// assemble assembles the single program of source.
+func assemble(t *testing.T, source string) *asm.Program {
+	t.Helper()
+	file, err := asm.Parse(source)
+	if err != nil {
+		t.Fatalf("parse:\n%s\n%v", source, err)
+	}
+	progs, err := asm.AssembleAll(file, asm.TargetRP2040)
+	if err != nil {
+		t.Fatalf("assemble:\n%s\n%v", source, err)
+	}
+	return progs[0]
+}
+
+func TestLoops(t *testing.T) {
+	for _, tt := range []struct {
+		name, source string
+		want         []Loop
+	}{
+		{"ws2812", `.program ws2812
+.side_set 1
+bitloop:
+    out x, 1        side 0 [2]
+    jmp !x, do_zero side 1 [1]
+    jmp bitloop     side 1 [4]
+do_zero:
+    nop             side 0 [4]`, []Loop{
+			{Start: 0, Label: "bitloop", Addresses: []int{0, 1, 2}, Cycles: 10},
+			{Start: 0, Label: "bitloop", Addresses: []int{0, 1, 3}, Cycles: 10},
+		}},
+		{"uart_tx", `.program uart_tx
+.side_set 1 opt
+    pull       side 1 [7]
+    set x, 7   side 0 [7]
+bitloop:
+    out pins, 1
+    jmp x--, bitloop [6]
+    nop        side 1 [6]`, []Loop{
+			{Start: 0, Addresses: []int{0, 1, 2, 3, 4}, Cycles: 31, Stalls: []int{0}},
+			{Start: 2, Label: "bitloop", Addresses: []int{2, 3}, Cycles: 8},
+		}},
+		{"wrap", `.program wrap
+    pull
+.wrap_target
+    wait 1 pin 0
+    irq wait 0 [3]
+.wrap
+    nop`, []Loop{
+			{Start: 1, Addresses: []int{1, 2}, Cycles: 5, Stalls: []int{1, 2}},
+		}},
+	} {
+		loops, warnings := Loops(assemble(t, tt.source))
+		if len(warnings) != 0 {
+			t.Errorf("%s: unexpected warnings %q", tt.name, warnings)
+		}
+		if !slices.EqualFunc(loops, tt.want, func(a, b Loop) bool {
+			return a.Start == b.Start && a.Label == b.Label && a.Cycles == b.Cycles &&
+				slices.Equal(a.Addresses, b.Addresses) && slices.Equal(a.Stalls, b.Stalls)
+		}) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, loops, tt.want)
+		}
+		if got, want := Shortest(loops), Shortest(tt.want); got != want {
+			t.Errorf("%s: shortest loop %d, want %d", tt.name, got, want)
+		}
+	}
+
+	loops, warnings := Loops(assemble(t, ".program p\n    out pc, 5\n    nop"))
+	if len(loops) != 0 || len(warnings) != 1 || !strings.Contains(warnings[0], "instruction 0 writes the program counter") {
+		t.Errorf("expected no loops and an out pc warning, got %+v %q", loops, warnings)
+	}
+}
+
+func TestBest(t *testing.T) {
+	for _, tt := range []struct {
+		sys, target float64
+		int, frac   int
+		err         float64
+	}{
+		{125e6, 8e6, 15, 160, 0},
+		{125e6, 125e6, 1, 0, 0},
+		{150e6, 1e6, 150, 0, 0},
+		{125e6, 115200 * 8, 135, 162, 0.00064},
+		{125e6, 2000, 62500, 0, 0},
+		{125e6, 125e6 / 65536, 0, 0, 0},
+	} {
+		d, err := Best(tt.sys, tt.target)
+		if err != nil {
+			t.Fatalf("%g/%g: %v", tt.sys, tt.target, err)
+		}
+		if d.Int != tt.int || d.Frac != tt.frac || math.Abs(d.Error-tt.err) > 0.0001 {
+			t.Errorf("%g/%g: got %+v, want %d %d/256 with %g%% error", tt.sys, tt.target, d, tt.int, tt.frac, tt.err)
+		}
+		want := float64(tt.int) + float64(tt.frac)/256
+		if tt.int == 0 {
+			want = 65536
+		}
+		if d.Value != want || d.Frequency != tt.sys/want {
+			t.Errorf("%g/%g: value %g at %g Hz, want %g", tt.sys, tt.target, d.Value, d.Frequency, want)
+		}
+		if (d.Jitter != 0) != (tt.frac != 0) {
+			t.Errorf("%g/%g: jitter %g ns with frac %d", tt.sys, tt.target, d.Jitter, d.Frac)
+		}
+	}
+	if d, _ := Best(125e6, 8e6); d.Jitter != 8 {
+		t.Errorf("expected 8 ns of jitter at 125 MHz, got %g", d.Jitter)
+	}
+
+	d, err := BestInteger(125e6, 8e6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Int != 16 || d.Frac != 0 || d.Jitter != 0 || math.Abs(d.Error+2.34375) > 1e-9 {
+		t.Errorf("expected an integer divider of 16 at -2.34%%, got %+v", d)
+	}
+
+	for _, tt := range []struct {
+		sys, target float64
+		want        string
+	}{
+		{0, 1e6, "system clock must be positive"},
+		{125e6, 0, "target frequency must be positive"},
+		{125e6, 200e6, "above the 1.25e+08 Hz system clock"},
+		{125e6, 1000, "below the slowest state machine clock"},
+	} {
+		if _, err := Best(tt.sys, tt.target); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%g/%g: expected error containing %q, got %v", tt.sys, tt.target, tt.want, err)
+		}
+	}
+}
+
+func TestFixed(t *testing.T) {
+	d, err := Fixed(125e6, 2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Int != 2 || d.Frac != 128 || d.Frequency != 50e6 || d.Error != 0 || d.Jitter != 8 {
+		t.Errorf("unexpected divider %+v", d)
+	}
+	// The largest divider the assembler accepts is encoded with int 0.
+	if d, err := Fixed(125e6, 65536); err != nil || d.Int != 0 || d.Frac != 0 || d.Value != 65536 || d.Jitter != 0 {
+		t.Errorf("expected 65536 as int 0, got %+v %v", d, err)
+	}
+	for _, div := range []float64{0.5, 65536.5} {
+		if _, err := Fixed(125e6, div); err == nil || !strings.Contains(err.Error(), "outside 1 to 65536") {
+			t.Errorf("%g: expected a range error, got %v", div, err)
+		}
+	}
+
+	loops := []Loop{{Cycles: 10}}
+	Time(loops, d)
+	if loops[0].Nanoseconds != 200 {
+		t.Errorf("expected 10 cycles at 50 MHz to take 200 ns, got %g", loops[0].Nanoseconds)
+	}
+}
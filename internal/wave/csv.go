@@ -0,0 +1,111 @@
+package wave
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ReadCSV parses a sigrok CSV export: optional "; " comment lines, a
+// header line naming the channels, then one row of 0/1 levels per
+// sample. A leading "Time" column gives sample times in seconds;
+// otherwise samples are spaced by the sample rate, taken from sampleRate
+// or from the "; Samplerate:" comment sigrok writes.
+func ReadCSV(r io.Reader, sampleRate float64) (*Waveform, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	w := &Waveform{}
+	timed := false
+	line := 0
+	var samples, last int64
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(text, ";"); ok {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(rest), "Samplerate:"); ok && sampleRate <= 0 {
+				rate, err := parseRate(v)
+				if err != nil {
+					return nil, fmt.Errorf("csv: line %d: %v", line, err)
+				}
+				sampleRate = rate
+			}
+			continue
+		}
+		fields := strings.Split(text, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if w.Signals == nil {
+			timed = strings.HasPrefix(strings.ToLower(fields[0]), "time")
+			if timed {
+				fields = fields[1:]
+			}
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("csv: line %d: no channels", line)
+			}
+			for _, name := range fields {
+				w.Signals = append(w.Signals, &Signal{Name: name, Width: 1})
+			}
+			if !timed && sampleRate <= 0 {
+				return nil, errors.New("csv: no time column or sample rate; set the sample rate")
+			}
+			continue
+		}
+
+		t := int64(math.Round(float64(samples) * 1e12 / sampleRate))
+		if timed {
+			sec, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("csv: line %d: bad time %q", line, fields[0])
+			}
+			t, fields = int64(math.Round(sec*1e12)), fields[1:]
+		}
+		if len(fields) != len(w.Signals) {
+			return nil, fmt.Errorf("csv: line %d: expected %d values, got %d", line, len(w.Signals), len(fields))
+		}
+		for i, f := range fields {
+			if f != "0" && f != "1" {
+				return nil, fmt.Errorf("csv: line %d: bad level %q for %s", line, f, w.Signals[i].Name)
+			}
+			w.Signals[i].record(t, uint32(f[0]-'0'))
+		}
+		samples, last = samples+1, t
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if w.Signals == nil {
+		return nil, errors.New("csv: no header line")
+	}
+	w.Duration = last
+	return w, nil
+}
+
+// rateUnits scale a sigrok sample rate such as "24 MHz".
+var rateUnits = map[string]float64{"hz": 1, "khz": 1e3, "mhz": 1e6, "ghz": 1e9}
+
+func parseRate(s string) (float64, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 || len(f) > 2 {
+		return 0, fmt.Errorf("bad sample rate %q", s)
+	}
+	rate, err := strconv.ParseFloat(f[0], 64)
+	unit := 1.0
+	if len(f) == 2 {
+		var ok bool
+		if unit, ok = rateUnits[strings.ToLower(f[1])]; !ok {
+			err = fmt.Errorf("unknown unit %q", f[1])
+		}
+	}
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("bad sample rate %q", s)
+	}
+	return rate * unit, nil
+}
@@ -0,0 +1,251 @@
+package wave
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WriteVCD writes the waveform as a Value Change Dump with a 1 ps
+// timescale. Signals live in a "pio" scope; pc and side_set are vectors.
+func (w *Waveform) WriteVCD(out io.Writer) error {
+	b := bufio.NewWriter(out)
+	fmt.Fprintln(b, "$version tinypio $end")
+	fmt.Fprintf(b, "$comment sys_clock_hz=%g clock_div=%g $end\n", w.SysClock, w.ClockDiv)
+	fmt.Fprintln(b, "$timescale 1ps $end")
+	fmt.Fprintln(b, "$scope module pio $end")
+	ids := make([]string, len(w.Signals))
+	for i, s := range w.Signals {
+		ids[i] = vcdID(i)
+		fmt.Fprintf(b, "$var wire %d %s %s $end\n", s.Width, ids[i], s.Name)
+	}
+	fmt.Fprintln(b, "$upscope $end")
+	fmt.Fprintln(b, "$enddefinitions $end")
+
+	type event struct {
+		t      int64
+		signal int
+		value  int64
+	}
+	var events []event
+	for i, s := range w.Signals {
+		for _, c := range s.Changes {
+			events = append(events, event{c[0], i, c[1]})
+		}
+	}
+	slices.SortStableFunc(events, func(x, y event) int { return cmp.Compare(x.t, y.t) })
+
+	last := int64(-1)
+	for _, e := range events {
+		if e.t != last {
+			if last < 0 && e.t == 0 {
+				fmt.Fprintln(b, "#0\n$dumpvars")
+			} else {
+				if last == 0 {
+					fmt.Fprintln(b, "$end")
+				}
+				fmt.Fprintf(b, "#%d\n", e.t)
+			}
+			last = e.t
+		}
+		if s := w.Signals[e.signal]; s.Width == 1 {
+			fmt.Fprintf(b, "%d%s\n", e.value, ids[e.signal])
+		} else {
+			fmt.Fprintf(b, "b%s %s\n", strconv.FormatInt(e.value, 2), ids[e.signal])
+		}
+	}
+	if last == 0 {
+		fmt.Fprintln(b, "$end")
+	}
+	if w.Duration > last {
+		fmt.Fprintf(b, "#%d\n", w.Duration)
+	}
+	return b.Flush()
+}
+
+// vcdID returns the short identifier code of the i-th signal, using the
+// printable characters '!' to '~'.
+func vcdID(i int) string {
+	const first, n = '!', '~' - '!' + 1
+	id := []byte{byte(first + i%n)}
+	for i /= n; i > 0; i /= n {
+		id = append(id, byte(first+i%n))
+	}
+	return string(id)
+}
+
+// ReadVCD parses a Value Change Dump, such as one written by WriteVCD, a
+// logic analyser or an HDL simulator. Signals are named by their
+// reference, prefixed with the scope when two share a name. Times are
+// converted to picoseconds; an x value reads as 0 and z as 1, the level
+// of a pulled-up line. Real and string variables are skipped.
+func ReadVCD(r io.Reader) (*Waveform, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	sc.Split(bufio.ScanWords)
+	next := func() (string, bool) {
+		if !sc.Scan() {
+			return "", false
+		}
+		return sc.Text(), true
+	}
+	// section returns the words up to the next $end.
+	section := func(kw string) ([]string, error) {
+		var words []string
+		for {
+			w, ok := next()
+			if !ok {
+				return nil, fmt.Errorf("vcd: %s without $end", kw)
+			}
+			if w == "$end" {
+				return words, nil
+			}
+			words = append(words, w)
+		}
+	}
+
+	w := &Waveform{}
+	ids := map[string][]*Signal{}
+	names := map[string]int{}
+	var scope []string
+	scale := int64(1)
+	now := int64(0)
+	body := false
+	for {
+		tok, ok := next()
+		if !ok {
+			break
+		}
+		switch {
+		case tok == "$dumpvars" || tok == "$dumpall" || tok == "$dumpon" || tok == "$dumpoff" || tok == "$end":
+			// Value changes inside these blocks are read like any other.
+		case tok == "$scope":
+			words, err := section(tok)
+			if err != nil {
+				return nil, err
+			}
+			if len(words) < 2 {
+				return nil, errors.New("vcd: $scope needs a type and a name")
+			}
+			scope = append(scope, words[1])
+		case tok == "$upscope":
+			if _, err := section(tok); err != nil {
+				return nil, err
+			}
+			if len(scope) > 0 {
+				scope = scope[:len(scope)-1]
+			}
+		case tok == "$timescale":
+			words, err := section(tok)
+			if err != nil {
+				return nil, err
+			}
+			if scale, err = timescale(strings.Join(words, "")); err != nil {
+				return nil, err
+			}
+		case tok == "$var":
+			words, err := section(tok)
+			if err != nil {
+				return nil, err
+			}
+			if len(words) < 4 {
+				return nil, fmt.Errorf("vcd: malformed $var %q", strings.Join(words, " "))
+			}
+			if words[0] == "real" || words[0] == "string" {
+				continue
+			}
+			width, err := strconv.Atoi(words[1])
+			if err != nil || width < 1 || width > 63 {
+				return nil, fmt.Errorf("vcd: unsupported width %q for %s", words[1], words[3])
+			}
+			name := words[3]
+			if names[name]++; names[name] > 1 {
+				name = strings.Join(append(slices.Clone(scope), name), ".")
+			}
+			s := &Signal{Name: name, Width: width}
+			w.Signals = append(w.Signals, s)
+			ids[words[2]] = append(ids[words[2]], s)
+		case tok == "$enddefinitions":
+			if _, err := section(tok); err != nil {
+				return nil, err
+			}
+			body = true
+		case strings.HasPrefix(tok, "$"):
+			if _, err := section(tok); err != nil {
+				return nil, err
+			}
+		case !body:
+			return nil, fmt.Errorf("vcd: unexpected %q before $enddefinitions", tok)
+		case tok[0] == '#':
+			t, err := strconv.ParseInt(tok[1:], 10, 64)
+			if err != nil || t < now {
+				return nil, fmt.Errorf("vcd: bad timestamp %q", tok)
+			}
+			now = t
+		case tok[0] == 'b' || tok[0] == 'B' || tok[0] == 'r' || tok[0] == 'R':
+			id, ok := next()
+			if !ok {
+				return nil, fmt.Errorf("vcd: value %q without identifier", tok)
+			}
+			if tok[0] == 'r' || tok[0] == 'R' {
+				continue
+			}
+			var v uint32
+			for _, c := range tok[1:] {
+				v = v<<1 | bitValue(c)
+			}
+			if err := change(ids, id, now*scale, v); err != nil {
+				return nil, err
+			}
+		default:
+			if err := change(ids, tok[1:], now*scale, bitValue(rune(tok[0]))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !body {
+		return nil, errors.New("vcd: missing $enddefinitions")
+	}
+	w.Duration = now * scale
+	return w, nil
+}
+
+func change(ids map[string][]*Signal, id string, t int64, v uint32) error {
+	signals, ok := ids[id]
+	if !ok {
+		return fmt.Errorf("vcd: unknown identifier %q", id)
+	}
+	for _, s := range signals {
+		s.record(t, v)
+	}
+	return nil
+}
+
+func bitValue(c rune) uint32 {
+	if c == '1' || c == 'z' || c == 'Z' {
+		return 1
+	}
+	return 0
+}
+
+// timescales are the VCD time units in picoseconds. Units below a
+// picosecond are not supported.
+var timescales = map[string]int64{"ps": 1, "ns": 1e3, "us": 1e6, "ms": 1e9, "s": 1e12}
+
+func timescale(s string) (int64, error) {
+	n := strings.TrimRightFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	unit, ok := timescales[s[len(n):]]
+	m, err := strconv.ParseInt(n, 10, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("vcd: unsupported timescale %q", s)
+	}
+	return m * unit, nil
+}
@@ -0,0 +1,140 @@
+// Package wave turns simulator traces into pin waveforms with real
+// timestamps, and exports them as Value Change Dump files that GTKWave,
+// sigrok/PulseView and most logic analyser tools can open. Captures can
+// be read back from VCD and sigrok CSV files.
+package wave
+
+import (
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+// DefaultSysClock is the RP2040 default system clock in Hz. The RP2350
+// defaults to 150 MHz, which callers pass to Record themselves.
+const DefaultSysClock = 125_000_000
+
+// Waveform is a compact set of signal changes. Times are in picoseconds.
+type Waveform struct {
+	SysClock float64   `json:"sys_clock_hz"`
+	ClockDiv float64   `json:"clock_div"`
+	Period   float64   `json:"cycle_ps"` // average state machine cycle length
+	Duration int64     `json:"duration_ps"`
+	Signals  []*Signal `json:"signals"`
+}
+
+// Signal is one traced value with the times at which it changed. Each
+// change is a [time_ps, value] pair; the first is the value at time 0.
+type Signal struct {
+	Name    string     `json:"name"`
+	Width   int        `json:"width"`
+	Changes [][2]int64 `json:"changes"`
+}
+
+// Signal returns the signal called name, or nil.
+func (w *Waveform) Signal(name string) *Signal {
+	for _, s := range w.Signals {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *Signal) record(t int64, v uint32) {
+	n := len(s.Changes)
+	if n > 0 && s.Changes[n-1][0] == t {
+		// A later sample at the same time replaces the earlier one.
+		s.Changes, n = s.Changes[:n-1], n-1
+	}
+	if n > 0 && s.Changes[n-1][1] == int64(v) {
+		return
+	}
+	s.Changes = append(s.Changes, [2]int64{t, int64(v)})
+}
+
+// Pins returns the GPIOs the configuration maps to out, set and side-set
+// pins, in ascending order.
+func Pins(cfg sim.Config) []int {
+	var pins []int
+	add := func(base, count int) {
+		for i := range count {
+			pins = append(pins, (base+i)%32)
+		}
+	}
+	add(cfg.OutBase, cfg.OutCount)
+	add(cfg.SetBase, cfg.SetCount)
+	add(cfg.SideSetBase, cfg.SideSet.Bits)
+	slices.Sort(pins)
+	return slices.Compact(pins)
+}
+
+// Record builds a waveform from a simulator trace. Every pin gets a
+// level and a direction signal; when side-set is configured a side_set
+// vector shows the value on the side-set pins, and pc tracks the program
+// counter. Pin states are timestamped at the end of their cycle, which
+// is when the state machine's outputs change; pc changes at the start of
+// the cycle that executes it.
+//
+// With a fractional divider the state machine clock is enabled on whole
+// system clock edges, so cycle n ends at floor(n * div) system clocks.
+func Record(trace []sim.State, cfg sim.Config, pins []int, sysClock float64) *Waveform {
+	if sysClock <= 0 {
+		sysClock = DefaultSysClock
+	}
+	div := max(cfg.ClockDiv, 1)
+	w := &Waveform{SysClock: sysClock, ClockDiv: div, Period: div * 1e12 / sysClock}
+	at := func(cycle int) int64 {
+		ticks := math.Floor(float64(cycle) * div)
+		return int64(math.Round(ticks * 1e12 / sysClock))
+	}
+
+	level := make([]*Signal, len(pins))
+	dir := make([]*Signal, len(pins))
+	for i, pin := range pins {
+		level[i] = &Signal{Name: "pin" + strconv.Itoa(pin), Width: 1}
+		dir[i] = &Signal{Name: "pindir" + strconv.Itoa(pin), Width: 1}
+	}
+	var side *Signal
+	if cfg.SideSet.Bits > 0 {
+		side = &Signal{Name: "side_set", Width: cfg.SideSet.Bits}
+	}
+	pc := &Signal{Name: "pc", Width: 5}
+
+	sample := func(t int64, st sim.State) {
+		pc.record(max(at(st.Cycle-1), 0), uint32(st.PC))
+		for i, pin := range pins {
+			level[i].record(t, st.Pins>>pin&1)
+			dir[i].record(t, st.PinDirs>>pin&1)
+		}
+		if side != nil {
+			reg := st.Pins
+			if cfg.SideSet.PinDirs {
+				reg = st.PinDirs
+			}
+			var v uint32
+			for i := range cfg.SideSet.Bits {
+				v |= (reg >> ((cfg.SideSetBase + i) % 32) & 1) << i
+			}
+			side.record(t, v)
+		}
+	}
+
+	sample(0, sim.State{})
+	for _, st := range trace {
+		sample(at(st.Cycle), st)
+	}
+	if n := len(trace); n > 0 {
+		w.Duration = at(trace[n-1].Cycle)
+	}
+
+	w.Signals = append(w.Signals, level...)
+	w.Signals = append(w.Signals, dir...)
+	if side != nil {
+		w.Signals = append(w.Signals, side)
+	}
+	w.Signals = append(w.Signals, pc)
+	return w
+}
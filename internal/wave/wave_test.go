@@ -0,0 +1,204 @@
+package wave
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joeblew999/plat-tinypio/internal/asm"
+	"github.com/joeblew999/plat-tinypio/internal/sim"
+)
+
+func squarewave(t *testing.T, div float64) *Waveform {
+	t.Helper()
+	prog, err := asm.Assemble(`.program squarewave
+.side_set 1 opt
+    set pindirs, 1
+again:
+    set pins, 1 side 1 [1]
+    set pins, 0 side 0
+    jmp again`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := sim.DefaultConfig(prog)
+	cfg.OutCount, cfg.SetCount, cfg.SideSetBase, cfg.ClockDiv = 0, 1, 2, div
+	sm, err := sim.New(prog, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Record(sm.Run(8), cfg, Pins(cfg), DefaultSysClock)
+}
+
+func signal(t *testing.T, w *Waveform, name string) *Signal {
+	t.Helper()
+	for _, s := range w.Signals {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no signal %q", name)
+	return nil
+}
+
+func TestRecord_Timestamps(t *testing.T) {
+	w := squarewave(t, 2.5)
+	// 125 MHz is 8000 ps per system clock. With a divider of 2.5, cycle n
+	// ends after floor(2.5n) system clocks.
+	want := [][2]int64{{0, 0}, {5 * 8000, 1}, {10 * 8000, 0}, {15 * 8000, 1}, {20 * 8000, 0}}
+	got := signal(t, w, "pin0").Changes
+	if len(got) != len(want) {
+		t.Fatalf("expected changes %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("change %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+	if side := signal(t, w, "side_set").Changes; len(side) != 5 || side[1] != [2]int64{40000, 1} {
+		t.Fatalf("unexpected side-set changes %v", side)
+	}
+	if w.Period != 20000 || w.Duration != 20*8000 {
+		t.Fatalf("unexpected period %v or duration %d", w.Period, w.Duration)
+	}
+}
+
+func TestWriteVCD(t *testing.T) {
+	var b strings.Builder
+	if err := squarewave(t, 1).WriteVCD(&b); err != nil {
+		t.Fatal(err)
+	}
+	vcd := b.String()
+	for _, want := range []string{
+		"$timescale 1ps $end",
+		"$var wire 1 ! pin0 $end",
+		"$var wire 1 \" pin2 $end",
+		"$var wire 1 # pindir0 $end",
+		"$var wire 1 % side_set $end",
+		"$var wire 5 & pc $end",
+		"#0\n$dumpvars\n0!",
+		"#8000\n1#\nb1 &\n",
+		"#16000\n1!\n1\"\n1%\n",
+		"#64000\n",
+	} {
+		if !strings.Contains(vcd, want) {
+			t.Errorf("expected VCD to contain %q:\n%s", want, vcd)
+		}
+	}
+}
+
+func TestVCDIdentifiers(t *testing.T) {
+	seen := map[string]bool{}
+	for i := range 1000 {
+		id := vcdID(i)
+		if seen[id] || strings.ContainsAny(id, " \t\n") {
+			t.Fatalf("identifier %d: %q is not unique or not printable", i, id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestReadVCD_RoundTrip(t *testing.T) {
+	w := squarewave(t, 2.5)
+	var b strings.Builder
+	if err := w.WriteVCD(&b); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadVCD(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Duration != w.Duration || len(got.Signals) != len(w.Signals) {
+		t.Fatalf("expected %d signals over %d ps, got %d over %d", len(w.Signals), w.Duration, len(got.Signals), got.Duration)
+	}
+	for i, s := range w.Signals {
+		g := got.Signals[i]
+		if g.Name != s.Name || g.Width != s.Width || len(g.Changes) != len(s.Changes) {
+			t.Fatalf("signal %d: expected %+v, got %+v", i, s, g)
+		}
+		for j := range s.Changes {
+			if g.Changes[j] != s.Changes[j] {
+				t.Fatalf("%s change %d: expected %v, got %v", s.Name, j, s.Changes[j], g.Changes[j])
+			}
+		}
+	}
+}
+
+func TestReadVCD_Timescale(t *testing.T) {
+	w, err := ReadVCD(strings.NewReader(`$timescale 10 ns $end
+$scope module top $end
+$var wire 1 ! tx $end
+$scope module inner $end
+$var wire 1 " tx $end
+$upscope $end
+$upscope $end
+$enddefinitions $end
+#0
+$dumpvars
+1!
+x"
+$end
+#5
+0!
+z"
+#12
+1!
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := w.Signal("tx")
+	if tx == nil || len(tx.Changes) != 3 || tx.Changes[1] != [2]int64{50000, 0} || tx.Changes[2] != [2]int64{120000, 1} {
+		t.Fatalf("unexpected tx signal %+v", tx)
+	}
+	if inner := w.Signal("top.inner.tx"); inner == nil || len(inner.Changes) != 2 {
+		t.Fatalf("expected scoped duplicate signal, got %+v", w.Signals)
+	}
+	if w.Duration != 120000 {
+		t.Fatalf("expected duration 120000, got %d", w.Duration)
+	}
+
+	for _, bad := range []string{
+		"$timescale 1 fs $end $enddefinitions $end",
+		"$var wire 1 ! tx $end $enddefinitions $end #0 1?",
+		"$var wire 1 ! tx $end",
+	} {
+		if _, err := ReadVCD(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	w, err := ReadCSV(strings.NewReader(`; CSV, generated by libsigrok
+; Samplerate: 1 MHz
+; Channels (2/8)
+scl,sda
+1,1
+1,0
+0,0
+`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sda := w.Signal("sda"); sda == nil || len(sda.Changes) != 2 || sda.Changes[1] != [2]int64{1e6, 0} {
+		t.Fatalf("unexpected sda signal %+v", sda)
+	}
+	if w.Duration != 2e6 {
+		t.Fatalf("expected duration 2000000, got %d", w.Duration)
+	}
+
+	w, err = ReadCSV(strings.NewReader("Time [s],D0\n0.0,0\n0.5e-6,1\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d0 := w.Signal("D0"); d0 == nil || d0.Changes[1] != [2]int64{500000, 1} {
+		t.Fatalf("unexpected D0 signal %+v", d0)
+	}
+
+	if _, err := ReadCSV(strings.NewReader("D0\n0\n"), 0); err == nil || !strings.Contains(err.Error(), "sample rate") {
+		t.Fatalf("expected sample rate error, got %v", err)
+	}
+	if _, err := ReadCSV(strings.NewReader("D0,D1\n0\n"), 1e6); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected line 2 error, got %v", err)
+	}
+}
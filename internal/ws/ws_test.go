@@ -0,0 +1,145 @@
+package ws
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// dial opens a WebSocket to srv by hand and returns the connection with
+// the handshake response read.
+func dial(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake response %d %v", resp.StatusCode, resp.Header)
+	}
+	return conn, r
+}
+
+// frame encodes a masked client frame.
+func frame(fin bool, op byte, payload string) []byte {
+	b := []byte{op, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	if fin {
+		b[0] |= 0x80
+	}
+	for i := range len(payload) {
+		b = append(b, payload[i]^b[2+i%4])
+	}
+	return b
+}
+
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, string) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 || head[1] >= 126 {
+		t.Fatalf("unexpected frame header %x", head)
+	}
+	payload := make([]byte, head[1])
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0], string(payload)
+}
+
+func TestEcho(t *testing.T) {
+	done := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+		for {
+			msg, err := c.Read()
+			if err != nil {
+				done <- err
+				return
+			}
+			c.Write([]byte("echo " + string(msg)))
+		}
+	}))
+	defer srv.Close()
+
+	conn, r := dial(t, srv)
+	conn.Write(frame(true, opText, "hello"))
+	if op, msg := readServerFrame(t, r); op != 0x80|opText || msg != "echo hello" {
+		t.Fatalf("expected echo hello, got %x %q", op, msg)
+	}
+	// A fragmented message with a ping in the middle.
+	conn.Write(frame(false, opText, "frag"))
+	conn.Write(frame(true, opPing, "p"))
+	conn.Write(frame(true, opContinuation, "ment"))
+	if op, msg := readServerFrame(t, r); op != 0x80|opPong || msg != "p" {
+		t.Fatalf("expected pong, got %x %q", op, msg)
+	}
+	if _, msg := readServerFrame(t, r); msg != "echo fragment" {
+		t.Fatalf("expected echo fragment, got %q", msg)
+	}
+	conn.Write(frame(true, opClose, "\x03\xe8"))
+	if op, _ := readServerFrame(t, r); op != 0x80|opClose {
+		t.Fatalf("expected close echo, got %x", op)
+	}
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestUpgrade_Rejects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(w, r)
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("expected 426 for a plain GET, got %d", resp.StatusCode)
+	}
+
+	for _, tt := range []struct {
+		origin string
+		want   int
+	}{
+		{"http://evil.example", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+		{srv.URL, http.StatusSwitchingProtocols},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Origin", tt.origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("origin %q: expected %d, got %d", tt.origin, tt.want, resp.StatusCode)
+		}
+	}
+}